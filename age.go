@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// isAgeArmored reports whether s is an ASCII-armored age file.
+func isAgeArmored(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), armor.Header)
+}
+
+// parseAgeIdentities parses an age identity file: AGE-SECRET-KEY-1... lines, with
+// blank lines and # comments ignored.
+func parseAgeIdentities(data []byte) ([]age.Identity, error) {
+	return age.ParseIdentities(bytes.NewReader(data))
+}
+
+// ageDecryptArmored decrypts an ASCII-armored age file with one of identities.
+func ageDecryptArmored(armored string, identities []age.Identity) ([]byte, error) {
+	r, err := age.Decrypt(armor.NewReader(strings.NewReader(strings.TrimSpace(armored))), identities...)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("malformed age file: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// validateAllowlist checks the allowlists' IDs, and that every channel the config
+// itself posts to is allowed.
+func validateAllowlist(config *Config) error {
+	for _, id := range append(append([]string{}, config.AllowedGuildIDs...), config.AllowedChannelIDs...) {
+		if !snowflakePattern.MatchString(id) {
+			return fmt.Errorf("allowed_guild_ids/allowed_channel_ids: %q is not a Discord ID", id)
+		}
+	}
+	if len(config.AllowedChannelIDs) == 0 {
+		return nil
+	}
+	channels := checkChannels(config, nil)
+	aliases := make([]string, 0, len(config.Channels))
+	for alias := range config.Channels {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		channels = append(channels, config.Channels[alias])
+	}
+	for _, id := range channels {
+		if !slices.Contains(config.AllowedChannelIDs, id) {
+			return fmt.Errorf("channel %s is configured but not in allowed_channel_ids", id)
+		}
+	}
+	return nil
+}
+
+// channelAllowed returns an error unless requests may be posted to channelID, which
+// is in guildID ("" for a DM channel). With allowed_guild_ids, DM channels are
+// refused: a caller could otherwise name its own DM channel with the bot.
+func (c *Config) channelAllowed(channelID, guildID string) error {
+	if len(c.AllowedChannelIDs) > 0 && !slices.Contains(c.AllowedChannelIDs, channelID) {
+		return fmt.Errorf("channel %s is not in allowed_channel_ids", channelID)
+	}
+	if len(c.AllowedGuildIDs) > 0 && !slices.Contains(c.AllowedGuildIDs, guildID) {
+		if guildID == "" {
+			return fmt.Errorf("channel %s is not in a server in allowed_guild_ids", channelID)
+		}
+		return fmt.Errorf("channel %s is in server %s, which is not in allowed_guild_ids", channelID, guildID)
+	}
+	return nil
+}
+
+// checkAllowedChannel is channelAllowed for a channel whose server is looked up.
+func (c *Config) checkAllowedChannel(dg *discordgo.Session, channelID string) error {
+	if len(c.AllowedGuildIDs) == 0 {
+		return c.channelAllowed(channelID, "")
+	}
+	ch, err := retryDiscord(func() (*discordgo.Channel, error) {
+		return dg.Channel(channelID, discordRetryOptions...)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to look up channel %s: %w", channelID, err)
+	}
+	return c.channelAllowed(channelID, ch.GuildID)
+}
+
+// interactionAllowed reports whether an interaction in channelID of guildID is
+// considered. With allowed_channel_ids, the channel must be one of them or one the
+// request was posted to (requestChannelIDs), such as the thread of a forum post.
+// Interactions in DMs are only considered in the DMs the request was sent to with --dm,
+// or in any DM when no allowlist is configured.
+func (c *Config) interactionAllowed(guildID, channelID string, requestChannelIDs []string) bool {
+	if guildID == "" {
+		return slices.Contains(requestChannelIDs, channelID) || len(c.AllowedGuildIDs) == 0 && len(c.AllowedChannelIDs) == 0
+	}
+	if len(c.AllowedGuildIDs) > 0 && !slices.Contains(c.AllowedGuildIDs, guildID) {
+		return false
+	}
+	return len(c.AllowedChannelIDs) == 0 || slices.Contains(c.AllowedChannelIDs, channelID) || slices.Contains(requestChannelIDs, channelID)
+}
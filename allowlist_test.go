@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func TestValidateAllowlist(t *testing.T) {
+	config := &Config{
+		AllowedChannelIDs: []string{"1", "2", "3"},
+		Channels:          map[string]string{"ops": "1"},
+		Policies:          []Policy{{ChannelID: "2"}},
+		AuditChannelID:    "3",
+	}
+	if err := validateAllowlist(config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	config.Escalation = &EscalationConfig{ChannelID: "4"}
+	if err := validateAllowlist(config); err == nil {
+		t.Error("expected error for an escalation channel outside the allowlist")
+	}
+	if err := validateAllowlist(&Config{AllowedGuildIDs: []string{"guild"}}); err == nil {
+		t.Error("expected error for a malformed guild ID")
+	}
+}
+
+func TestChannelAllowed(t *testing.T) {
+	for name, tc := range map[string]struct {
+		config         Config
+		channel, guild string
+		allowed        bool
+	}{
+		"no allowlist":      {Config{}, "9", "", true},
+		"allowed channel":   {Config{AllowedChannelIDs: []string{"1"}}, "1", "", true},
+		"other channel":     {Config{AllowedChannelIDs: []string{"1"}}, "9", "", false},
+		"allowed guild":     {Config{AllowedGuildIDs: []string{"100"}}, "9", "100", true},
+		"other guild":       {Config{AllowedGuildIDs: []string{"100"}}, "9", "666", false},
+		"dm with guilds":    {Config{AllowedGuildIDs: []string{"100"}}, "9", "", false},
+		"both, guild wrong": {Config{AllowedGuildIDs: []string{"100"}, AllowedChannelIDs: []string{"1"}}, "1", "666", false},
+	} {
+		if err := tc.config.channelAllowed(tc.channel, tc.guild); (err == nil) != tc.allowed {
+			t.Errorf("%s: err = %v, want allowed = %v", name, err, tc.allowed)
+		}
+	}
+}
+
+func TestInteractionAllowed(t *testing.T) {
+	for name, tc := range map[string]struct {
+		config         Config
+		guild, channel string
+		allowed        bool
+	}{
+		"no allowlist":           {Config{}, "666", "9", true},
+		"allowed guild":          {Config{AllowedGuildIDs: []string{"100"}}, "100", "9", true},
+		"other guild":            {Config{AllowedGuildIDs: []string{"100"}}, "666", "9", false},
+		"request dm":             {Config{AllowedGuildIDs: []string{"100"}, AllowedChannelIDs: []string{"1"}}, "", "5", true},
+		"other dm":               {Config{AllowedGuildIDs: []string{"100"}, AllowedChannelIDs: []string{"1"}}, "", "9", false},
+		"other dm, channels":     {Config{AllowedChannelIDs: []string{"1"}}, "", "9", false},
+		"dm, no allowlist":       {Config{}, "", "9", true},
+		"allowed channel":        {Config{AllowedChannelIDs: []string{"1"}}, "100", "1", true},
+		"other channel":          {Config{AllowedChannelIDs: []string{"1"}}, "100", "9", false},
+		"request thread":         {Config{AllowedChannelIDs: []string{"1"}}, "100", "5", true},
+		"allowed channel, guild": {Config{AllowedGuildIDs: []string{"100"}, AllowedChannelIDs: []string{"1"}}, "666", "1", false},
+	} {
+		if got := tc.config.interactionAllowed(tc.guild, tc.channel, []string{"5"}); got != tc.allowed {
+			t.Errorf("%s: interactionAllowed = %v, want %v", name, got, tc.allowed)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultAnsiblePlayMinutes is how long an approved play may run its privileged tasks
+// on a host when the config has no ansible_play_minutes.
+const defaultAnsiblePlayMinutes = 60
+
+// ansiblePlay is the play an Ansible task belongs to, as passed by the become command.
+type ansiblePlay struct {
+	Name  string
+	Hosts []string
+	Tags  []string
+}
+
+// ansiblePlayKey identifies the approval of a play's task in the approval cache. The
+// play comes from the caller, so it only scopes the key: it also binds the uid of the
+// user who ran sudo, the identity the task runs as, the exact command and the contents
+// of its executable.
+func ansiblePlayKey(play ansiblePlay, uid int, runAs string, commandArgs []string, binary *binaryFingerprint) string {
+	data, _ := json.Marshal(struct {
+		Play             string   `json:"ansible_play"`
+		Hosts            []string `json:"hosts"`
+		Tags             []string `json:"tags"`
+		UID              int      `json:"uid"`
+		RunAs            string   `json:"run_as,omitempty"`
+		Command          []string `json:"command"`
+		Executable       string   `json:"executable"`
+		ExecutableSHA256 string   `json:"executable_sha256"`
+	}{play.Name, play.Hosts, play.Tags, uid, runAs, commandArgs, binary.path, binary.sha256})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// format describes the play in the request message, and what approving it allows.
+func (p ansiblePlay) format(window time.Duration) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n📜 **Ansible play:** `%s`", inlineCode(p.Name))
+	if len(p.Hosts) > 0 {
+		fmt.Fprintf(&b, "\n**Hosts:** `%s`", inlineCode(strings.Join(p.Hosts, ", ")))
+	}
+	if len(p.Tags) > 0 {
+		fmt.Fprintf(&b, "\n**Tags:** `%s`", inlineCode(strings.Join(p.Tags, ", ")))
+	}
+	fmt.Fprintf(&b, "\nApproving lets the play run this same task on this host again for up to %s without asking.", window)
+	return b.String()
+}
+
+// runBecome is called by Ansible's sudo become plugin with become_exe set to
+// `sudo prompt-sudo-discord become ...`. It accepts the flags the plugin passes to sudo,
+// and asks for approval of the task's play, which later tasks of the play reuse.
+func runBecome(args []string) int {
+	fs := flag.NewFlagSet("become", flag.ContinueOnError)
+	play := fs.String("play", "", "Name of the play, e.g. {{ ansible_play_name }}")
+	hosts := fs.String("hosts", "", "Comma-separated hosts of the play, e.g. {{ ansible_play_hosts_all | join(',') }}")
+	tags := fs.String("tags", "", "Comma-separated tags the play runs with, e.g. {{ ansible_run_tags | join(',') }}")
+	channelID := fs.String("channel", "", "Discord channel ID or alias to post the play's approval request to (default: default_channel from config)")
+	// The flags of sudo the become plugin passes
+	fs.Bool("H", false, "Ignored, as passed by become_flags")
+	fs.Bool("S", false, "Ignored, as passed by become_flags")
+	fs.Bool("n", false, "Ignored, as passed by become_flags")
+	fs.String("p", "", "Ignored: no password is asked for")
+	becomeUser := fs.String("u", "", "Run the task as this user (default: root)")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *play == "" || fs.NArg() == 0 {
+		fmt.Fprintln(fs.Output(), "Usage: prompt-sudo-discord become --play NAME [--hosts HOSTS] [--tags TAGS] [SUDO FLAGS] COMMAND [ARGS...]")
+		return exitConfigError
+	}
+
+	requestArgs := []string{"--ansible-play", *play, "--ansible-hosts", *hosts, "--ansible-tags", *tags}
+	if *channelID != "" {
+		requestArgs = append(requestArgs, "--channel", *channelID)
+	}
+	if *becomeUser != "" && *becomeUser != "root" {
+		requestArgs = append(requestArgs, "--user", *becomeUser)
+	}
+	requestArgs = append(append(requestArgs, "--"), fs.Args()...)
+
+	// The request replaces this process, keeping the task's stdin and stdout for Ansible
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitInternalError
+	}
+	err = syscall.Exec(self, append([]string{self, "request"}, requestArgs...), os.Environ())
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	return exitInternalError
+}
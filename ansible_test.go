@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnsiblePlayKey(t *testing.T) {
+	play := ansiblePlay{Name: "Deploy web", Hosts: []string{"web1", "web2"}, Tags: []string{"deploy"}}
+	command := []string{"/bin/sh", "-c", "true"}
+	sh := &binaryFingerprint{path: "/bin/sh", sha256: "00"}
+	key := ansiblePlayKey(play, 1000, "", command, sh)
+	if key != ansiblePlayKey(play, 1000, "", command, sh) {
+		t.Error("key is not stable")
+	}
+	for name, other := range map[string]string{
+		"other play":       ansiblePlayKey(ansiblePlay{Name: "Reboot", Hosts: play.Hosts, Tags: play.Tags}, 1000, "", command, sh),
+		"other hosts":      ansiblePlayKey(ansiblePlay{Name: play.Name, Hosts: []string{"web1"}, Tags: play.Tags}, 1000, "", command, sh),
+		"other tags":       ansiblePlayKey(ansiblePlay{Name: play.Name, Hosts: play.Hosts}, 1000, "", command, sh),
+		"other user":       ansiblePlayKey(play, 1001, "", command, sh),
+		"run as":           ansiblePlayKey(play, 1000, "postgres:postgres", command, sh),
+		"other command":    ansiblePlayKey(play, 1000, "", []string{"/bin/sh", "-c", "rm -rf /"}, sh),
+		"other executable": ansiblePlayKey(play, 1000, "", command, &binaryFingerprint{path: "/bin/sh", sha256: "01"}),
+		"command key":      approvalCacheKey("", command, "/", nil, "", "1000:alice", sh),
+	} {
+		if other == key {
+			t.Errorf("%s: same key as the play", name)
+		}
+	}
+}
+
+func TestAnsiblePlayFormat(t *testing.T) {
+	got := ansiblePlay{Name: "Deploy `web`", Hosts: []string{"web1", "web2"}, Tags: []string{"deploy"}}.format(time.Hour)
+	for _, want := range []string{"**Ansible play:** `Deploy 'web'`", "**Hosts:** `web1, web2`", "**Tags:** `deploy`", "for up to 1h0m0s"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("format = %q, want it to contain %q", got, want)
+		}
+	}
+	if got := (ansiblePlay{Name: "Reboot"}).format(time.Hour); strings.Contains(got, "**Hosts:**") || strings.Contains(got, "**Tags:**") {
+		t.Errorf("format = %q, want no hosts or tags", got)
+	}
+}
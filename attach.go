@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// defaultMaxOutputAttachmentKB bounds each captured output stream; Discord rejects
+// large uploads, and only the end of a long output is usually of interest.
+const defaultMaxOutputAttachmentKB = 1024
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	max     int
+	buf     []byte
+	dropped int64
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.max; over > 0 {
+		b.dropped += int64(over)
+		b.buf = b.buf[over:]
+		// Don't let the discarded head pin an ever-growing array
+		if cap(b.buf) > 2*b.max {
+			b.buf = append([]byte(nil), b.buf...)
+		}
+	}
+	return len(p), nil
+}
+
+// Bytes returns the kept output, noting how much was dropped before it.
+func (b *tailBuffer) Bytes() []byte {
+	if b.dropped == 0 {
+		return b.buf
+	}
+	return append([]byte(fmt.Sprintf("[... %d bytes truncated ...]\n", b.dropped)), b.buf...)
+}
+
+// outputCapture keeps the output of a supervised command for attaching it to the
+// request message.
+type outputCapture struct {
+	stdout, stderr *tailBuffer
+	// combined is set when stdout and stderr were merged, as on a pseudo-terminal
+	combined bool
+}
+
+func newOutputCapture(maxKB int) *outputCapture {
+	return &outputCapture{
+		stdout: &tailBuffer{max: maxKB * 1024},
+		stderr: &tailBuffer{max: maxKB * 1024},
+	}
+}
+
+// files returns the non-empty captured streams as named text files, with secrets
+// masked.
+func (c *outputCapture) files(patterns []*regexp.Regexp) map[string][]byte {
+	files := map[string][]byte{}
+	add := func(name string, b *tailBuffer) {
+		if data := b.Bytes(); len(data) > 0 {
+			files[name] = []byte(redact(string(data), patterns))
+		}
+	}
+	if c.combined {
+		add("output.txt", c.stdout)
+	} else {
+		add("stdout.txt", c.stdout)
+		add("stderr.txt", c.stderr)
+	}
+	return files
+}
+
+// uploadOutput attaches the captured output to a message.
+func uploadOutput(dg *discordgo.Session, channelID, messageID string, c *outputCapture, patterns []*regexp.Regexp) error {
+	files := c.files(patterns)
+	if len(files) == 0 {
+		return nil
+	}
+	_, err := retryDiscord(func() (*discordgo.Message, error) {
+		// Readers are consumed by each attempt, so every retry gets fresh ones
+		edit := &discordgo.MessageEdit{ID: messageID, Channel: channelID}
+		for _, name := range []string{"output.txt", "stdout.txt", "stderr.txt"} {
+			if data, ok := files[name]; ok {
+				edit.Files = append(edit.Files, &discordgo.File{Name: name, ContentType: "text/plain", Reader: bytes.NewReader(data)})
+			}
+		}
+		return dg.ChannelMessageEditComplex(edit, discordRetryOptions...)
+	})
+	return err
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{max: 8}
+	b.Write([]byte("0123"))
+	if got := string(b.Bytes()); got != "0123" {
+		t.Errorf("Bytes() = %q, want 0123", got)
+	}
+	for i := 0; i < 100; i++ {
+		b.Write([]byte("abcdefghij"))
+	}
+	got := string(b.Bytes())
+	if !strings.HasSuffix(got, "\ncdefghij") {
+		t.Errorf("Bytes() = %q, want the last 8 bytes", got)
+	}
+	if !strings.HasPrefix(got, "[... 996 bytes truncated ...]") {
+		t.Errorf("Bytes() = %q, want the truncation noted", got)
+	}
+	if cap(b.buf) > 2*b.max+10 {
+		t.Errorf("buffer capacity %d keeps growing", cap(b.buf))
+	}
+}
+
+func TestOutputCaptureFiles(t *testing.T) {
+	c := newOutputCapture(1)
+	c.stdout.Write([]byte("token=hunter2\n"))
+	files := c.files([]*regexp.Regexp{regexp.MustCompile(`hunter2`)})
+	if len(files) != 1 {
+		t.Fatalf("files = %v, want only stdout.txt", files)
+	}
+	if got := string(files["stdout.txt"]); strings.Contains(got, "hunter2") {
+		t.Errorf("stdout.txt = %q, want secrets masked", got)
+	}
+
+	c.combined = true
+	if _, ok := c.files(nil)["output.txt"]; !ok {
+		t.Error("want output.txt for combined output")
+	}
+	if len(newOutputCapture(1).files(nil)) != 0 {
+		t.Error("want no files without output")
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// machineIDPaths hold the host's machine ID, which survives renames unlike the host
+// name; tests override them.
+var machineIDPaths = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// machineID returns the host's machine ID, or "" if it has none.
+func machineID() string {
+	for _, path := range machineIDPaths {
+		if data, err := os.ReadFile(path); err == nil {
+			if id := strings.TrimSpace(string(data)); id != "" {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// requesterUID returns the UID of the user who ran sudo, or the current one.
+func requesterUID() int {
+	if uid, err := strconv.Atoi(os.Getenv("SUDO_UID")); err == nil {
+		return uid
+	}
+	return os.Getuid()
+}
+
+// auditSigningTimeout bounds audit_signing_command, which is run for every record.
+const auditSigningTimeout = 10 * time.Second
+
+// auditSigner signs audit records with a host-local Ed25519 key.
+type auditSigner struct {
+	key ed25519.PrivateKey
+	// command signs instead of key, e.g. with a key held by an HSM or TPM; its
+	// signatures are checked against pub
+	command []string
+	pub     ed25519.PublicKey
+	// keyID identifies the public key, so records of many hosts can be told apart
+	keyID string
+}
+
+// newAuditSigner returns the signer configured by audit_signing_key_file or
+// audit_signing_command, or nil if records are not signed.
+func (c *Config) newAuditSigner() (*auditSigner, error) {
+	if len(c.AuditSigningCommand) > 0 {
+		pub, err := readAuditPublicKey(c.AuditSigningPublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &auditSigner{command: c.AuditSigningCommand, pub: pub, keyID: publicKeyID(pub)}, nil
+	}
+	if c.AuditSigningKeyFile != "" {
+		return loadAuditSigner(c.AuditSigningKeyFile)
+	}
+	return nil, nil
+}
+
+// auditPublicKeyFile returns where the public key verifying audit records is, or ""
+// if records are not signed.
+func (c *Config) auditPublicKeyFile() string {
+	if len(c.AuditSigningCommand) > 0 {
+		return c.AuditSigningPublicKeyFile
+	}
+	if c.AuditSigningKeyFile != "" {
+		return c.AuditSigningKeyFile + ".pub"
+	}
+	return ""
+}
+
+func validateAuditSigning(c *Config) error {
+	if len(c.AuditSigningCommand) > 0 {
+		if c.AuditSigningKeyFile != "" {
+			return fmt.Errorf("audit_signing_key_file and audit_signing_command are mutually exclusive")
+		}
+		if c.AuditSigningPublicKeyFile == "" {
+			return fmt.Errorf("audit_signing_command requires audit_signing_public_key_file")
+		}
+	}
+	if c.AuditSigningKeyFile != "" && !filepath.IsAbs(c.AuditSigningKeyFile) {
+		return fmt.Errorf("audit_signing_key_file must be an absolute path")
+	}
+	return nil
+}
+
+// readAuditPublicKey reads a PKIX Ed25519 public key in PEM.
+func readAuditPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s is not a PEM public key", path)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse audit public key: %w", err)
+	}
+	pub, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 key", path)
+	}
+	return pub, nil
+}
+
+// publicKeyID returns the ID of a public key: the start of its SHA-256.
+func publicKeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// loadAuditSigner reads the PKCS #8 key at path, creating it if it does not exist,
+// along with its public key at path.pub for verifiers.
+func loadAuditSigner(path string) (*auditSigner, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return createAuditSigner(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s is not a PEM private key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse audit signing key: %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 key", path)
+	}
+	return &auditSigner{key: key, keyID: publicKeyID(key.Public().(ed25519.PublicKey))}, nil
+}
+
+func createAuditSigner(path string) (*auditSigner, error) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit signing key directory: %w", err)
+	}
+	// O_EXCL: a concurrent request may be creating the key as well
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return loadAuditSigner(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create audit signing key: %w", err)
+	}
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write audit signing key: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write audit signing key: %w", err)
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	if err := os.WriteFile(path+".pub", pubPEM, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write audit public key: %w", err)
+	}
+	return &auditSigner{key: key, keyID: publicKeyID(pub)}, nil
+}
+
+// sign returns the base64 Ed25519 signature of data. audit_signing_command is given
+// data on stdin, and prints the signature in base64.
+func (s *auditSigner) sign(data []byte) (string, error) {
+	if s.command == nil {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, data)), nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), auditSigningTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, s.command[0], s.command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("audit_signing_command failed: %w: %s", err, msg)
+		}
+		return "", fmt.Errorf("audit_signing_command failed: %w", err)
+	}
+	sig := strings.TrimSpace(string(out))
+	// A wrong key or mangled output would only be noticed when verifying
+	if !verifySignature(s.pub, data, sig) {
+		return "", errors.New("audit_signing_command printed a signature that does not match audit_signing_public_key_file")
+	}
+	return sig, nil
+}
+
+// verifySignature checks a base64 Ed25519 signature of data.
+func verifySignature(pub ed25519.PublicKey, data []byte, sig string) bool {
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	return err == nil && ed25519.Verify(pub, data, raw)
+}
+
+// attestation binds who asked for a command and who decided it into one signed
+// statement, demonstrating dual control.
+type attestation struct {
+	RequestID     string `json:"request_id"`
+	CommandSHA256 string `json:"command_sha256"`
+	Host          string `json:"host"`
+	MachineID     string `json:"machine_id"`
+	Requester     string `json:"requester"`
+	SudoUser      string `json:"sudo_user"`
+	RequesterUID  int    `json:"requester_uid"`
+	// ApproverIDs are the Discord users who decided the request
+	ApproverIDs []string  `json:"approver_ids"`
+	Decision    string    `json:"decision"`
+	DecidedAt   time.Time `json:"decided_at"`
+	KeyID       string    `json:"key_id"`
+	// Signature signs the attestation as JSON without it, with fields in this order
+	Signature string `json:"signature,omitempty"`
+}
+
+// attest returns the signed attestation of a decision record.
+func (s *auditSigner) attest(rec AuditRecord) (*attestation, error) {
+	a := &attestation{
+		RequestID:     rec.RequestID,
+		CommandSHA256: rec.CommandSHA256,
+		Host:          rec.Host,
+		MachineID:     rec.MachineID,
+		Requester:     rec.Requester,
+		SudoUser:      rec.SudoUser,
+		RequesterUID:  rec.RequesterUID,
+		ApproverIDs:   rec.ApproverIDs,
+		Decision:      rec.Decision,
+		DecidedAt:     rec.Time,
+		KeyID:         s.keyID,
+	}
+	data, _ := json.Marshal(a)
+	sig, err := s.sign(data)
+	if err != nil {
+		return nil, err
+	}
+	a.Signature = sig
+	return a, nil
+}
+
+// verify checks the signature of the attestation with pub.
+func (a attestation) verify(pub ed25519.PublicKey) bool {
+	sig := a.Signature
+	a.Signature = ""
+	data, _ := json.Marshal(a)
+	return verifySignature(pub, data, sig)
+}
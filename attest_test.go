@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditSigner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "audit.key")
+	signer, err := loadAuditSigner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected a root-only key: %v %v", info, err)
+	}
+	// The same key is loaded again, and its public key is published next to it
+	again, err := loadAuditSigner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.keyID != signer.keyID {
+		t.Errorf("key ID changed from %s to %s", signer.keyID, again.keyID)
+	}
+	data, err := os.ReadFile(path + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := parsed.(ed25519.PublicKey)
+	if publicKeyID(pub) != signer.keyID {
+		t.Errorf("public key ID %s, want %s", publicKeyID(pub), signer.keyID)
+	}
+
+	rec := AuditRecord{
+		Time:          time.Now(),
+		RequestID:     "abc",
+		CommandSHA256: commandSHA256([]string{"reboot"}),
+		Requester:     "alice",
+		SudoUser:      "alice",
+		RequesterUID:  1000,
+		Host:          "web1",
+		MachineID:     "0123",
+		Decision:      auditDecisionApproved,
+		ApproverIDs:   []string{"111"},
+	}
+	a, err := signer.attest(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.verify(pub) {
+		t.Fatal("expected the attestation to verify")
+	}
+	forged := *a
+	forged.ApproverIDs = []string{"222"}
+	if forged.verify(pub) {
+		t.Error("expected a changed approver to fail verification")
+	}
+	forged = *a
+	forged.RequesterUID = 0
+	if forged.verify(pub) {
+		t.Error("expected a changed requester to fail verification")
+	}
+}
+
+func TestLoadAuditSignerRejectsOtherKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.key")
+	if err := os.WriteFile(path, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadAuditSigner(path); err == nil {
+		t.Error("expected an invalid key to be rejected")
+	}
+}
+
+func TestMachineID(t *testing.T) {
+	dir := t.TempDir()
+	orig := machineIDPaths
+	defer func() { machineIDPaths = orig }()
+	machineIDPaths = []string{filepath.Join(dir, "missing"), filepath.Join(dir, "machine-id")}
+	if id := machineID(); id != "" {
+		t.Errorf("machineID = %q without a file", id)
+	}
+	if err := os.WriteFile(machineIDPaths[1], []byte("0123abcd\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if id := machineID(); id != "0123abcd" {
+		t.Errorf("machineID = %q", id)
+	}
+}
+
+func TestRequesterUID(t *testing.T) {
+	t.Setenv("SUDO_UID", "4242")
+	if uid := requesterUID(); uid != 4242 {
+		t.Errorf("requesterUID = %d", uid)
+	}
+	t.Setenv("SUDO_UID", "")
+	if uid := requesterUID(); uid != os.Getuid() {
+		t.Errorf("requesterUID = %d without SUDO_UID", uid)
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+const defaultAuditLogPath = "/var/log/prompt-sudo-discord/audit.jsonl"
+
+// defaultAuditAnchorMinutes is how often the head of the audit log's hash chain is
+// posted to the audit channel.
+const defaultAuditAnchorMinutes = 60
+
+// Audit events
+const (
+	// auditEventDecision is written once per request, when it is decided
+	auditEventDecision = "decision"
+	// auditEventExit is written when an approved command run as a child process exits
+	auditEventExit = "exit"
+)
+
+// Audit decisions
+const (
+	auditDecisionApproved     = "approved"
+	auditDecisionDenied       = "denied"
+	auditDecisionTimeout      = "timeout"
+	auditDecisionInterrupted  = "interrupted"
+	auditDecisionCancelled    = "cancelled"
+	auditDecisionAutoApproved = "auto_approved"
+	auditDecisionPolicyDenied = "policy_denied"
+	auditDecisionCached       = "cached_approval"
+	// auditDecisionPreapproved runs a command under a token from `/psd preapprove`
+	auditDecisionPreapproved = "preapproved"
+	// auditDecisionBreakGlass runs a command with --break-glass, without approval
+	auditDecisionBreakGlass = "break_glass"
+)
+
+// AuditRecord is a single line of the JSON-lines audit log.
+type AuditRecord struct {
+	Time              time.Time  `json:"time"`
+	Event             string     `json:"event"`
+	RequestID         string     `json:"request_id"`
+	Command           []string   `json:"command,omitempty"`
+	CommandSHA256     string     `json:"command_sha256"`
+	Executable        string     `json:"executable,omitempty"`
+	ExecutableSHA256  string     `json:"executable_sha256,omitempty"`
+	RunAs             string     `json:"run_as,omitempty"`
+	Session           string     `json:"session,omitempty"`
+	Requester         string     `json:"requester,omitempty"`
+	SudoUser          string     `json:"sudo_user,omitempty"`
+	RequesterUID      int        `json:"requester_uid"`
+	MachineID         string     `json:"machine_id,omitempty"`
+	Host              string     `json:"host"`
+	CWD               string     `json:"cwd"`
+	Policy            string     `json:"policy,omitempty"`
+	MaintenanceWindow string     `json:"maintenance_window,omitempty"`
+	Decision          string     `json:"decision,omitempty"`
+	ApproverIDs       []string   `json:"approver_ids,omitempty"`
+	RequestedAt       time.Time  `json:"requested_at"`
+	DecidedAt         *time.Time `json:"decided_at,omitempty"`
+	ExitCode          *int       `json:"exit_code,omitempty"`
+	// CI is the CI job reported by the environment of the request
+	CI *ciContext `json:"ci,omitempty"`
+	// Git is the git working tree the request was made from
+	Git *gitRepoContext `json:"git,omitempty"`
+	// Preapproval is the pre-approval a command ran under
+	Preapproval *preapproval `json:"preapproval,omitempty"`
+	// BreakGlass is the reason a command ran with --break-glass, and AnnounceError why
+	// its run could not be announced in Discord
+	BreakGlass    string `json:"break_glass,omitempty"`
+	AnnounceError string `json:"announce_error,omitempty"`
+	// CommandLine replaces Command in records sent anywhere but the audit log, see
+	// redacted
+	CommandLine string `json:"command_line,omitempty"`
+	// Attestation binds the requester and the approvers of a decision, signed with the
+	// host's key
+	Attestation *attestation `json:"attestation,omitempty"`
+	// Seq numbers the chained records of the audit log, and PrevHash is the SHA-256 of
+	// the line before, so removed or edited ones are noticed
+	Seq      uint64 `json:"seq,omitempty"`
+	PrevHash string `json:"prev_hash,omitempty"`
+	KeyID    string `json:"key_id,omitempty"`
+	// Signature signs the record as written without it, so it must stay the last field
+	Signature string `json:"signature,omitempty"`
+}
+
+// commandSHA256 hashes the exact argument vector, so records can be matched
+// against a command without comparing display strings.
+func commandSHA256(args []string) string {
+	data, _ := json.Marshal(args)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// redacted returns rec as it may leave the audit log: Command gives way to
+// CommandLine, the command as displayed with patterns masked. CommandSHA256 still
+// identifies the exact command.
+func (rec AuditRecord) redacted(patterns []*regexp.Regexp) AuditRecord {
+	if rec.Command != nil {
+		rec.CommandLine = redactCommand(rec.Command, patterns)
+		rec.Command = nil
+	}
+	return rec
+}
+
+// auditor writes the audit records of a single request.
+type auditor struct {
+	path string
+	base AuditRecord
+	// redactions mask secrets in the command of records mirrored anywhere: every sink,
+	// the audit channel, webhooks and callbacks get the record redacted once in write
+	redactions []*regexp.Regexp
+	// sink optionally mirrors records to syslog or journald
+	sink *AuditSinkConfig
+	// siem forwards records to Splunk or Elasticsearch, buffering them in stateDir
+	// while it is unreachable
+	siem     []SIEMConfig
+	stateDir string
+	// channel optionally mirrors decisions to the Discord audit channel
+	channel *auditChannel
+	// tracer follows the records to end the request's trace
+	tracer *tracer
+	// result prints the decision for --output json
+	result *resultPrinter
+	// webhooks receive every record as an event, with the link of the request message
+	// once it is posted
+	webhooks []WebhookConfig
+	link     string
+	// callbacks run the --on-approve or --on-deny script on the decision
+	callbacks *decisionCallbacks
+	// decided is the decision record, once written
+	decided AuditRecord
+	// signer signs records and attests decisions with the host's key, if configured
+	signer *auditSigner
+	// chain numbers and hash-chains records without signing them, and anchorEvery is
+	// how often the head of the chain is posted to the audit channel
+	chain       bool
+	anchorEvery time.Duration
+}
+
+// write appends rec to the audit log and mirrors it, redacted, to the sink and audit
+// channel. Only the audit log is authoritative: a mirroring failure is reported but
+// not returned.
+func (a *auditor) write(rec AuditRecord) error {
+	if a.signer != nil || a.chain {
+		chained, anchor, err := appendChainedAuditRecord(a.path, a.signer, rec, a.anchorEvery)
+		if err != nil {
+			return err
+		}
+		rec = chained
+		if anchor != nil && a.channel != nil {
+			if err := a.channel.anchor(rec.Host, *anchor); err != nil {
+				slog.Warn("failed to anchor audit log", "err", err)
+			} else if err := markAuditAnchored(a.path, *anchor); err != nil {
+				slog.Warn("failed to record audit log anchor", "err", err)
+			}
+		}
+	} else if err := writeAuditRecord(a.path, rec); err != nil {
+		return err
+	}
+	shown := rec.redacted(a.redactions)
+	if a.sink != nil {
+		if err := a.sink.send(shown); err != nil {
+			slog.Warn("failed to mirror audit record", "err", err)
+		}
+	}
+	for i := range a.siem {
+		if err := a.siem[i].forward(a.stateDir, shown); err != nil {
+			slog.Warn("failed to forward audit record to SIEM", "url", a.siem[i].URL, "err", err)
+		}
+	}
+	if a.channel != nil {
+		if err := a.channel.post(shown); err != nil {
+			slog.Warn("failed to post to audit channel", "err", err)
+		}
+	}
+	a.notify(newWebhookEvent(shown, a.link))
+	a.tracer.record(shown)
+	if a.result != nil && rec.Event == auditEventDecision {
+		if err := a.result.print(shown); err != nil {
+			slog.Warn("failed to print result", "err", err)
+		}
+	}
+	if a.callbacks != nil && rec.Event == auditEventDecision {
+		a.callbacks.run(shown, a.link)
+	}
+	return nil
+}
+
+// created tells webhooks that the request was posted to Discord at link. It is not an
+// audit record.
+func (a *auditor) created(link string) {
+	a.link = link
+	rec := a.base.redacted(a.redactions)
+	rec.Time = time.Now()
+	event := newWebhookEvent(rec, link)
+	event.Event = webhookEventCreated
+	a.notify(event)
+}
+
+// notify posts event to the webhooks subscribed to it. Like mirroring, a failure is
+// only reported.
+func (a *auditor) notify(event webhookEvent) {
+	for i := range a.webhooks {
+		w := &a.webhooks[i]
+		if !w.wants(event.Event) {
+			continue
+		}
+		if err := w.post(event, time.Now()); err != nil {
+			slog.Warn("failed to post webhook event", "event", event.Event, "url", w.URL, "err", err)
+		}
+	}
+}
+
+// decision records how the request was decided and by whom.
+func (a *auditor) decision(decision string, approverIDs []string) error {
+	rec := a.base
+	rec.Time = time.Now()
+	rec.Event = auditEventDecision
+	rec.Decision = decision
+	rec.ApproverIDs = approverIDs
+	rec.DecidedAt = &rec.Time
+	if a.signer != nil {
+		att, err := a.signer.attest(rec)
+		if err != nil {
+			return err
+		}
+		rec.Attestation = att
+	}
+	a.decided = rec
+	return a.write(rec)
+}
+
+// exit records the exit code of an approved command run as a child process.
+func (a *auditor) exit(exitCode int) error {
+	rec := a.base
+	rec.Time = time.Now()
+	rec.Event = auditEventExit
+	rec.ExitCode = &exitCode
+	return a.write(rec)
+}
+
+// writeAuditRecord appends rec to the audit log at path, creating it root-only if needed.
+func writeAuditRecord(path string, rec AuditRecord) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+	return nil
+}
+
+// auditChain is the state of the audit log's numbering and hash chain, kept next to it.
+type auditChain struct {
+	Last uint64 `json:"last"`
+	// Head is the hash of the last record
+	Head       string    `json:"head"`
+	AnchoredAt time.Time `json:"anchored_at"`
+}
+
+// auditAnchor is a head of the hash chain, published so later removals show.
+type auditAnchor struct {
+	seq  uint64
+	head string
+	// at is the time of the anchored record
+	at time.Time
+}
+
+// auditLineHash returns the hash chaining the record after a line of the audit log.
+func auditLineHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// appendChainedAuditRecord numbers rec, chains it to the previous record, signs it if
+// signer is set, and appends it to the audit log at path. Numbering and appending
+// under one lock keeps the records in order. If the head was last anchored longer
+// than anchorEvery ago, it returns the new head to anchor, which only counts as
+// anchored once markAuditAnchored records it; zero never anchors.
+func appendChainedAuditRecord(path string, signer *auditSigner, rec AuditRecord, anchorEvery time.Duration) (AuditRecord, *auditAnchor, error) {
+	var chain auditChain
+	var anchor *auditAnchor
+	var writeErr error
+	err := updateStateFile(filepath.Dir(path), filepath.Base(path)+".seq", &chain, func() bool {
+		rec.Seq = chain.Last + 1
+		rec.PrevHash = chain.Head
+		rec.Signature = ""
+		if signer != nil {
+			rec.KeyID = signer.keyID
+			data, err := json.Marshal(rec)
+			if err != nil {
+				writeErr = fmt.Errorf("failed to encode audit record: %w", err)
+				return false
+			}
+			if rec.Signature, writeErr = signer.sign(data); writeErr != nil {
+				return false
+			}
+		}
+		line, err := json.Marshal(rec)
+		if err != nil {
+			writeErr = fmt.Errorf("failed to encode audit record: %w", err)
+			return false
+		}
+		if writeErr = writeAuditRecord(path, rec); writeErr != nil {
+			return false
+		}
+		chain.Last = rec.Seq
+		chain.Head = auditLineHash(line)
+		if anchorEvery > 0 && rec.Time.Sub(chain.AnchoredAt) >= anchorEvery {
+			anchor = &auditAnchor{seq: chain.Last, head: chain.Head, at: rec.Time}
+		}
+		return true
+	})
+	if err == nil {
+		err = writeErr
+	}
+	return rec, anchor, err
+}
+
+// markAuditAnchored records that a has been posted, so the next anchor is due
+// anchorEvery after it. Until then, every record is anchored.
+func markAuditAnchored(path string, a auditAnchor) error {
+	var chain auditChain
+	return updateStateFile(filepath.Dir(path), filepath.Base(path)+".seq", &chain, func() bool {
+		if !a.at.After(chain.AnchoredAt) {
+			return false
+		}
+		chain.AnchoredAt = a.at
+		return true
+	})
+}
+
+// newRequestID returns a random identifier for a single approval request.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteAuditRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	for _, id := range []string{"a", "b"} {
+		err := writeAuditRecord(path, AuditRecord{
+			Time:      time.Now(),
+			RequestID: id,
+			Command:   []string{"echo", "hello"},
+			Decision:  auditDecisionAutoApproved,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("audit log mode = %o, want 600", perm)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("invalid audit line %q: %v", scanner.Text(), err)
+		}
+		ids = append(ids, rec.RequestID)
+	}
+	if len(ids) != 2 || ids[0] != "a" || ids[1] != "b" {
+		t.Errorf("request IDs = %v, want [a b]", ids)
+	}
+}
+
+func TestAuditor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	a := &auditor{path: path, base: AuditRecord{
+		RequestID:     "req",
+		Command:       []string{"echo", "hello"},
+		CommandSHA256: commandSHA256([]string{"echo", "hello"}),
+	}}
+	if err := a.decision(auditDecisionApproved, []string{"123"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.exit(3); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recs []AuditRecord
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var rec AuditRecord
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("got %d records, want 2", len(recs))
+	}
+	if recs[0].Event != auditEventDecision || recs[0].Decision != auditDecisionApproved || recs[0].DecidedAt == nil || len(recs[0].ApproverIDs) != 1 {
+		t.Errorf("unexpected decision record: %+v", recs[0])
+	}
+	if recs[1].Event != auditEventExit || recs[1].ExitCode == nil || *recs[1].ExitCode != 3 || recs[1].DecidedAt != nil {
+		t.Errorf("unexpected exit record: %+v", recs[1])
+	}
+	if recs[1].RequestID != "req" {
+		t.Errorf("exit record request ID = %q, want req", recs[1].RequestID)
+	}
+}
+
+func TestCommandSHA256(t *testing.T) {
+	// Argument boundaries are part of the hash
+	if commandSHA256([]string{"rm", "-rf /"}) == commandSHA256([]string{"rm", "-rf", "/"}) {
+		t.Error("different argument vectors hashed equal")
+	}
+	if commandSHA256([]string{"ls"}) != commandSHA256([]string{"ls"}) {
+		t.Error("hash is not deterministic")
+	}
+}
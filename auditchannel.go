@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxAuditSummaryCommand keeps audit channel summaries compact.
+const maxAuditSummaryCommand = 300
+
+var auditDecisionLabels = map[string]string{
+	auditDecisionApproved:     "✅ **Approved**",
+	auditDecisionDenied:       "❌ **Denied**",
+	auditDecisionTimeout:      "⏰ **Timed out**",
+	auditDecisionInterrupted:  "⚠️ **Cancelled**",
+	auditDecisionCancelled:    "🚫 **Withdrawn**",
+	auditDecisionAutoApproved: "✅ **Auto-approved**",
+	auditDecisionPolicyDenied: "⛔ **Denied by policy**",
+	auditDecisionCached:       "✅ **Approved (grace window)**",
+	auditDecisionPreapproved:  "✅ **Pre-approved**",
+	auditDecisionBreakGlass:   "🚨 **BREAK-GLASS** (no approval)",
+}
+
+// auditChannel posts a button-less summary of a request's decision to the audit
+// channel, and adds the exit code to it once the command exits.
+type auditChannel struct {
+	dg        *discordgo.Session
+	channelID string
+	summary   string
+	msgID     string
+}
+
+// post mirrors an audit record: decisions are posted, exits edit the decision's summary.
+func (c *auditChannel) post(rec AuditRecord) error {
+	switch rec.Event {
+	case auditEventDecision:
+		c.summary = formatAuditSummary(rec)
+		msg, err := sendMessage(c.dg, c.channelID, &discordgo.MessageSend{
+			Content: c.summary,
+			// Approvers are listed, not pinged
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+		if err != nil {
+			return err
+		}
+		c.msgID = msg.ID
+	case auditEventExit:
+		if c.msgID == "" || rec.ExitCode == nil {
+			return nil
+		}
+		content := fmt.Sprintf("%s\n**Exit code:** `%d`", c.summary, *rec.ExitCode)
+		_, err := editMessage(c.dg, &discordgo.MessageEdit{
+			ID:              c.msgID,
+			Channel:         c.channelID,
+			Content:         &content,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+		return err
+	}
+	return nil
+}
+
+// anchor posts the head of the audit log's hash chain, so records removed from the
+// log afterwards show when it is verified against the post.
+func (c *auditChannel) anchor(host string, a auditAnchor) error {
+	_, err := sendMessage(c.dg, c.channelID, &discordgo.MessageSend{
+		Content:         formatAuditAnchor(host, a),
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	return err
+}
+
+func formatAuditAnchor(host string, a auditAnchor) string {
+	return fmt.Sprintf("⚓ **Audit log anchor** on `%s`: record `%d`, hash `%s`\n-# Check with `prompt-sudo-discord verify-audit --anchor %d:%s`", host, a.seq, a.head, a.seq, a.head)
+}
+
+// formatAuditSummary renders the compact audit channel summary of a decision, a record
+// redacted for mirroring.
+func formatAuditSummary(rec AuditRecord) string {
+	command := rec.CommandLine
+	label, ok := auditDecisionLabels[rec.Decision]
+	if !ok {
+		label = fmt.Sprintf("**%s**", rec.Decision)
+	}
+	if len(command) > maxAuditSummaryCommand {
+		command = command[:maxAuditSummaryCommand] + "…"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s on `%s`\n```\n%s\n```\n", label, rec.Host, command)
+	details := []string{}
+	if rec.Requester != "" {
+		details = append(details, fmt.Sprintf("**Requester:** `%s`", rec.Requester))
+	}
+	if len(rec.ApproverIDs) > 0 {
+		var approvers []string
+		for _, id := range rec.ApproverIDs {
+			approvers = append(approvers, fmt.Sprintf("<@%s>", id))
+		}
+		by := "**Approved by:** "
+		switch rec.Decision {
+		case auditDecisionDenied:
+			by = "**Denied by:** "
+		case auditDecisionCancelled:
+			by = "**Withdrawn by:** "
+		case auditDecisionTimeout, auditDecisionInterrupted:
+			// Earlier stages of a multi-stage request may have been approved
+			by = "**Approved stages by:** "
+		}
+		details = append(details, by+strings.Join(approvers, ", "))
+	}
+	if rec.Policy != "" {
+		details = append(details, fmt.Sprintf("**Policy:** `%s`", rec.Policy))
+	}
+	if rec.BreakGlass != "" {
+		details = append(details, fmt.Sprintf("**Reason:** `%s`", inlineCode(rec.BreakGlass)))
+	}
+	if len(details) > 0 {
+		b.WriteString(strings.Join(details, " · ") + "\n")
+	}
+	fmt.Fprintf(&b, "-# Request ID: `%s`", rec.RequestID)
+	return b.String()
+}
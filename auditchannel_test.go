@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatAuditSummary(t *testing.T) {
+	rec := AuditRecord{
+		RequestID:   "abc",
+		Host:        "web1",
+		Requester:   "alice",
+		Policy:      "prod",
+		Decision:    auditDecisionApproved,
+		ApproverIDs: []string{"1", "2"},
+		CommandLine: "systemctl restart nginx",
+	}
+	got := formatAuditSummary(rec)
+	for _, want := range []string{
+		"✅ **Approved** on `web1`",
+		"```\nsystemctl restart nginx\n```",
+		"**Requester:** `alice` · **Approved by:** <@1>, <@2> · **Policy:** `prod`",
+		"-# Request ID: `abc`",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("summary %q does not contain %q", got, want)
+		}
+	}
+
+	rec.Decision = auditDecisionDenied
+	rec.ApproverIDs = []string{"3"}
+	if got := formatAuditSummary(rec); !strings.Contains(got, "**Denied by:** <@3>") {
+		t.Errorf("unexpected denied summary: %q", got)
+	}
+	rec.Decision = auditDecisionCancelled
+	if got := formatAuditSummary(rec); !strings.Contains(got, "🚫 **Withdrawn**") || !strings.Contains(got, "**Withdrawn by:** <@3>") {
+		t.Errorf("unexpected withdrawn summary: %q", got)
+	}
+	rec.Decision = auditDecisionDenied
+
+	long := strings.Repeat("x", maxAuditSummaryCommand+10)
+	rec.CommandLine = long
+	if got := formatAuditSummary(rec); strings.Contains(got, long) {
+		t.Error("expected long command to be truncated")
+	}
+}
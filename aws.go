@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// awsRequestTimeout bounds reading a secret, finding credentials and region included
+const awsRequestTimeout = 10 * time.Second
+
+// loadAWSConfig loads the SDK's default config to read id with: credentials from the
+// environment, the shared config files, the ECS task role or the EC2 instance role,
+// and the region of id if it is an ARN, else that of the environment or the instance.
+func loadAWSConfig(ctx context.Context, id string) (aws.Config, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithEC2IMDSRegion())
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load the AWS config: %w", err)
+	}
+	if parsed, err := arn.Parse(id); err == nil && parsed.Region != "" {
+		cfg.Region = parsed.Region
+	}
+	if cfg.Region == "" {
+		return aws.Config{}, errors.New("failed to find the AWS region")
+	}
+	return cfg, nil
+}
+
+// getAWSSecret returns a Secrets Manager secret. With a "#key" suffix, the secret is
+// read as a JSON object and the value of key is returned.
+func getAWSSecret(ref string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), awsRequestTimeout)
+	defer cancel()
+	secretID, key, hasKey := strings.Cut(ref, "#")
+	cfg, err := loadAWSConfig(ctx, secretID)
+	if err != nil {
+		return "", err
+	}
+	out, err := secretsmanager.NewFromConfig(cfg).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(secretID)})
+	if err != nil {
+		return "", err
+	}
+	value := aws.ToString(out.SecretString)
+	if value == "" {
+		value = string(out.SecretBinary)
+	}
+	if !hasKey {
+		return value, nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object", secretID)
+	}
+	s, ok := fields[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s has no string key %q", secretID, key)
+	}
+	return s, nil
+}
+
+// getAWSParameter returns an SSM parameter, decrypting SecureString parameters.
+func getAWSParameter(name string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), awsRequestTimeout)
+	defer cancel()
+	cfg, err := loadAWSConfig(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	out, err := ssm.NewFromConfig(cfg).GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String(name), WithDecryption: aws.Bool(true)})
+	if err != nil {
+		return "", err
+	}
+	if out.Parameter == nil {
+		return "", fmt.Errorf("parameter %s has no value", name)
+	}
+	return aws.ToString(out.Parameter.Value), nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// isolateAWS points the SDK at nothing but the variables the test sets.
+func isolateAWS(t *testing.T) {
+	t.Helper()
+	missing := filepath.Join(t.TempDir(), "missing")
+	t.Setenv("AWS_CONFIG_FILE", missing)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", missing)
+	for _, name := range []string{"AWS_PROFILE", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_REGION", "AWS_DEFAULT_REGION",
+		"AWS_ENDPOINT_URL", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_CREDENTIALS_FULL_URI", "AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+}
+
+// fakeAWS serves the instance metadata service and the Secrets Manager and SSM APIs,
+// and points the SDK at them.
+func fakeAWS(t *testing.T) {
+	t.Helper()
+	isolateAWS(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "300")
+		io.WriteString(w, "imds-token")
+	})
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	meta := map[string]string{
+		"/latest/dynamic/instance-identity/document":          `{"region":"eu-west-1"}`,
+		"/latest/meta-data/iam/security-credentials/":         "psd-role\n",
+		"/latest/meta-data/iam/security-credentials/psd-role": `{"Code":"Success","AccessKeyId":"ASIA","SecretAccessKey":"secret","Token":"session","Expiration":"` + expires + `"}`,
+	}
+	mux.HandleFunc("GET /latest/", func(w http.ResponseWriter, r *http.Request) {
+		value, ok := meta[r.URL.Path]
+		if !ok || r.Header.Get("X-aws-ec2-metadata-token") != "imds-token" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, value)
+	})
+	mux.HandleFunc("POST /", func(w http.ResponseWriter, r *http.Request) {
+		var in map[string]any
+		json.NewDecoder(r.Body).Decode(&in)
+		service, region := "ssm", "eu-west-1"
+		if r.Header.Get("X-Amz-Target") == "secretsmanager.GetSecretValue" {
+			service = "secretsmanager"
+		}
+		if id, _ := in["SecretId"].(string); strings.HasPrefix(id, "arn:") {
+			region = "us-west-2"
+		}
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=ASIA/") || !strings.Contains(auth, "/"+region+"/"+service+"/") ||
+			r.Header.Get("X-Amz-Security-Token") != "session" {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `{"__type":"AccessDeniedException","message":"bad signature"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch r.Header.Get("X-Amz-Target") {
+		case "secretsmanager.GetSecretValue":
+			switch in["SecretId"] {
+			case "psd/token", "arn:aws:secretsmanager:us-west-2:123456789012:secret:psd":
+				io.WriteString(w, `{"SecretString":"raw-token"}`)
+			case "psd/json":
+				io.WriteString(w, `{"SecretString":"{\"token\":\"json-token\"}"}`)
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, `{"__type":"ResourceNotFoundException","message":"Secrets Manager can't find the specified secret."}`)
+			}
+		case "AmazonSSM.GetParameter":
+			if in["WithDecryption"] != true {
+				t.Error("parameter requested without decryption")
+			}
+			io.WriteString(w, `{"Parameter":{"Name":"/psd/token","Value":"Bot ssm-token"}}`)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "")
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", srv.URL)
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+}
+
+func TestAWSGetSecret(t *testing.T) {
+	fakeAWS(t)
+	for ref, want := range map[string]string{
+		"psd/token":      "raw-token",
+		"psd/json#token": "json-token",
+		"arn:aws:secretsmanager:us-west-2:123456789012:secret:psd": "raw-token",
+	} {
+		got, err := getAWSSecret(ref)
+		if err != nil || got != want {
+			t.Errorf("%s: got %q, %v; want %q", ref, got, err, want)
+		}
+	}
+	for _, ref := range []string{"missing", "psd/json#other", "psd/token#token"} {
+		if _, err := getAWSSecret(ref); err == nil {
+			t.Errorf("%s: expected error", ref)
+		}
+	}
+}
+
+func TestAWSGetParameter(t *testing.T) {
+	fakeAWS(t)
+	if got, err := getAWSParameter("/psd/token"); err != nil || got != "Bot ssm-token" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
+
+func TestLoadAWSConfigFromEnv(t *testing.T) {
+	isolateAWS(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "ap-northeast-1")
+	ctx := context.Background()
+	cfg, err := loadAWSConfig(ctx, "psd/token")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Region != "ap-northeast-1" {
+		t.Errorf("region = %s", cfg.Region)
+	}
+	if creds, err := cfg.Credentials.Retrieve(ctx); err != nil || creds.AccessKeyID != "AKID" || creds.SecretAccessKey != "secret" {
+		t.Errorf("creds = %+v, %v", creds, err)
+	}
+	if cfg, _ := loadAWSConfig(ctx, "arn:aws:secretsmanager:us-west-2:123456789012:secret:psd"); cfg.Region != "us-west-2" {
+		t.Errorf("ARN region = %s", cfg.Region)
+	}
+
+	t.Setenv("AWS_REGION", "")
+	if _, err := loadAWSConfig(ctx, "psd/token"); err == nil {
+		t.Error("expected error without a region")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+)
+
+// azureRequestTimeout bounds reading a secret, getting the access token included
+const azureRequestTimeout = 10 * time.Second
+
+// azureClient reads secrets from Azure Key Vault with a workload identity (a
+// federated token, as on AKS) or the VM's managed identity.
+type azureClient struct {
+	credential azcore.TokenCredential
+	options    *azsecrets.ClientOptions
+	// vaultURL returns the URL of a vault by name
+	vaultURL func(vault string) string
+}
+
+// azureCredential returns the workload identity when AZURE_FEDERATED_TOKEN_FILE is
+// set, and the managed identity otherwise, the user-assigned one AZURE_CLIENT_ID
+// names if set.
+func azureCredential() (azcore.TokenCredential, error) {
+	if os.Getenv("AZURE_FEDERATED_TOKEN_FILE") != "" {
+		return azidentity.NewWorkloadIdentityCredential(nil)
+	}
+	options := &azidentity.ManagedIdentityCredentialOptions{}
+	if clientID := os.Getenv("AZURE_CLIENT_ID"); clientID != "" {
+		options.ID = azidentity.ClientID(clientID)
+	}
+	return azidentity.NewManagedIdentityCredential(options)
+}
+
+// getAzureSecret returns a Key Vault secret; ref is VAULT/SECRET[/VERSION].
+func getAzureSecret(ref string) (string, error) {
+	credential, err := azureCredential()
+	if err != nil {
+		return "", fmt.Errorf("failed to set up the Azure identity: %w", err)
+	}
+	c := &azureClient{
+		credential: credential,
+		vaultURL: func(vault string) string {
+			return "https://" + vault + ".vault.azure.net"
+		},
+	}
+	return c.getSecret(ref)
+}
+
+func (c *azureClient) getSecret(ref string) (string, error) {
+	vault, secret, ok := strings.Cut(ref, "/")
+	if !ok || vault == "" || secret == "" {
+		return "", fmt.Errorf("azure key vault secret must be VAULT/SECRET[/VERSION]")
+	}
+	name, version, _ := strings.Cut(secret, "/")
+	client, err := azsecrets.NewClient(c.vaultURL(vault), c.credential, c.options)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), azureRequestTimeout)
+	defer cancel()
+	resp, err := client.GetSecret(ctx, name, version, nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.Value == nil {
+		return "", fmt.Errorf("secret %s has no value", secret)
+	}
+	return *resp.Value, nil
+}
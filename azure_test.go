@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+)
+
+// fakeAzureCredential hands out a fixed token for Key Vault.
+type fakeAzureCredential struct{}
+
+func (fakeAzureCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if !slices.Equal(opts.Scopes, []string{"https://vault.azure.net/.default"}) {
+		return azcore.AccessToken{}, io.ErrUnexpectedEOF
+	}
+	return azcore.AccessToken{Token: "vault-token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// fakeAzure serves the Key Vault API, for a vault named ops holding psd-token.
+func fakeAzure(t *testing.T) *azureClient {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{vault}/secrets/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer authorization="https://login.microsoftonline.com/tenant", resource="https://vault.azure.net"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		secret := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"+r.PathValue("vault")+"/secrets/"), "/")
+		if r.PathValue("vault") != "ops" || (secret != "psd-token" && secret != "psd-token/v1") || r.URL.Query().Get("api-version") == "" {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"error":{"code":"SecretNotFound","message":"A secret with (name/id) psd was not found in this key vault."}}`)
+			return
+		}
+		io.WriteString(w, `{"value":"Bot vault-token","id":"https://ops.vault.azure.net/secrets/psd-token/v1"}`)
+	})
+	srv := httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+	return &azureClient{
+		credential: fakeAzureCredential{},
+		options: &azsecrets.ClientOptions{
+			ClientOptions:                        azcore.ClientOptions{Transport: srv.Client(), Retry: policy.RetryOptions{MaxRetries: -1}},
+			DisableChallengeResourceVerification: true,
+		},
+		vaultURL: func(vault string) string { return srv.URL + "/" + vault },
+	}
+}
+
+func TestAzureGetSecret(t *testing.T) {
+	c := fakeAzure(t)
+	for _, ref := range []string{"ops/psd-token", "ops/psd-token/v1"} {
+		if got, err := c.getSecret(ref); err != nil || got != "Bot vault-token" {
+			t.Errorf("%s: got %q, %v", ref, got, err)
+		}
+	}
+	for _, ref := range []string{"other/psd-token", "ops/missing", "ops", "/psd-token"} {
+		if _, err := c.getSecret(ref); err == nil {
+			t.Errorf("%s: expected error", ref)
+		}
+	}
+}
+
+func TestAzureCredential(t *testing.T) {
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", "")
+	t.Setenv("AZURE_CLIENT_ID", "client")
+	t.Setenv("AZURE_TENANT_ID", "tenant")
+	if cred, err := azureCredential(); err != nil {
+		t.Errorf("managed identity: unexpected error: %v", err)
+	} else if _, ok := cred.(*azidentity.ManagedIdentityCredential); !ok {
+		t.Errorf("managed identity: credential = %T", cred)
+	}
+
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", "/var/run/secrets/azure/tokens/azure-identity-token")
+	if cred, err := azureCredential(); err != nil {
+		t.Errorf("workload identity: unexpected error: %v", err)
+	} else if _, ok := cred.(*azidentity.WorkloadIdentityCredential); !ok {
+		t.Errorf("workload identity: credential = %T", cred)
+	}
+}
@@ -0,0 +1,12 @@
+package main
+
+// backendDiscord is the default backend; it is implemented in main.
+const backendDiscord = "discord"
+
+// backendDecider decides a request outside Discord, returning the audit decision and
+// who made it.
+type backendDecider func(req fallbackRequest) (decision, approver string, err error)
+
+// backends are the alternative backends selectable with --backend. They are only
+// registered in builds that include them (see mock.go).
+var backends = map[string]backendDecider{}
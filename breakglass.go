@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BreakGlassConfig enables --break-glass, which runs a command at once during an
+// incident instead of waiting for approval, and tells everyone about it.
+type BreakGlassConfig struct {
+	// RoleIDs are pinged by the announcement of every break-glass run
+	RoleIDs []string `json:"role_ids"`
+	// SecretHashFile holds the bcrypt hash of a sealed secret, which has to be typed on
+	// the terminal to break glass
+	SecretHashFile string `json:"secret_hash_file"`
+}
+
+func validateBreakGlass(b *BreakGlassConfig) error {
+	for i, id := range b.RoleIDs {
+		if id == "" {
+			return fmt.Errorf("break_glass.role_ids[%d] is empty", i)
+		}
+	}
+	if b.SecretHashFile != "" && !filepath.IsAbs(b.SecretHashFile) {
+		return fmt.Errorf("break_glass.secret_hash_file must be an absolute path")
+	}
+	return nil
+}
+
+// errWrongSecret is returned when the typed secret does not match the sealed one.
+var errWrongSecret = errors.New("wrong break-glass secret")
+
+// checkSecret asks for the sealed secret on the terminal, if one is configured.
+func (b *BreakGlassConfig) checkSecret() error {
+	if b.SecretHashFile == "" {
+		return nil
+	}
+	hash, err := os.ReadFile(b.SecretHashFile)
+	if err != nil {
+		return fmt.Errorf("failed to read break-glass secret hash: %w", err)
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("the break-glass secret needs a terminal: %w", err)
+	}
+	defer tty.Close()
+	restore, err := makeRaw(tty)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(tty, "Break-glass secret: ")
+	secret, err := readSecret(tty)
+	restore()
+	fmt.Fprintln(tty)
+	if err != nil {
+		return err
+	}
+	return compareSecret(hash, secret)
+}
+
+// compareSecret checks secret against the contents of the hash file.
+func compareSecret(hash, secret []byte) error {
+	err := bcrypt.CompareHashAndPassword(bytes.TrimSpace(hash), secret)
+	switch {
+	case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+		return errWrongSecret
+	case err != nil:
+		return fmt.Errorf("invalid break-glass secret hash: %w", err)
+	}
+	return nil
+}
+
+// readSecret reads a line typed on a terminal in raw mode, so it is not echoed.
+// Backspace erases, and Ctrl-C or Ctrl-D abort.
+func readSecret(r io.Reader) ([]byte, error) {
+	var secret []byte
+	br := bufio.NewReader(r)
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch c {
+		case '\r', '\n':
+			return secret, nil
+		case 0x03, 0x04:
+			return nil, errors.New("interrupted")
+		case 0x7f, 0x08:
+			if len(secret) > 0 {
+				secret = secret[:len(secret)-1]
+			}
+		default:
+			secret = append(secret, c)
+		}
+	}
+}
+
+// formatBreakGlass renders the announcement heading a break-glass run. It names the
+// account sudo was invoked by, which --requester cannot override.
+func formatBreakGlass(roleIDs []string, reason, sudoUser string, uid int) string {
+	who := fmt.Sprintf("UID %d", uid)
+	if sudoUser != "" {
+		who = fmt.Sprintf("**%s** (UID %d)", sudoUser, uid)
+	}
+	banner := fmt.Sprintf("🚨 **BREAK-GLASS** by %s: running without approval\n**Reason:** `%s`\n", who, inlineCode(reason))
+	if mentions := formatMentions(nil, roleIDs); mentions != "" {
+		banner = mentions + "\n" + banner
+	}
+	return banner
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestReadSecret(t *testing.T) {
+	for name, tc := range map[string]struct {
+		input, want string
+		err         bool
+	}{
+		"line":         {"hunter2\r", "hunter2", false},
+		"newline":      {"hunter2\n", "hunter2", false},
+		"backspace":    {"hunx\x7fter2\r", "hunter2", false},
+		"ctrl-c":       {"hun\x03", "", true},
+		"no line end":  {"hunter2", "", true},
+		"empty secret": {"\r", "", false},
+	} {
+		got, err := readSecret(strings.NewReader(tc.input))
+		if (err != nil) != tc.err || string(got) != tc.want {
+			t.Errorf("%s: readSecret = %q, %v", name, got, err)
+		}
+	}
+}
+
+func TestCompareSecret(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Files usually end with a newline
+	hash = append(hash, '\n')
+	if err := compareSecret(hash, []byte("hunter2")); err != nil {
+		t.Errorf("expected the secret to match: %v", err)
+	}
+	if err := compareSecret(hash, []byte("hunter3")); !errors.Is(err, errWrongSecret) {
+		t.Errorf("expected a wrong secret, got %v", err)
+	}
+	if err := compareSecret([]byte("not a hash"), []byte("hunter2")); err == nil || errors.Is(err, errWrongSecret) {
+		t.Errorf("expected an invalid hash, got %v", err)
+	}
+}
+
+func TestValidateBreakGlass(t *testing.T) {
+	for name, tc := range map[string]struct {
+		config BreakGlassConfig
+		valid  bool
+	}{
+		"roles":         {BreakGlassConfig{RoleIDs: []string{"1"}}, true},
+		"secret":        {BreakGlassConfig{SecretHashFile: "/etc/psd/secret"}, true},
+		"empty role":    {BreakGlassConfig{RoleIDs: []string{""}}, false},
+		"relative file": {BreakGlassConfig{SecretHashFile: "secret"}, false},
+	} {
+		if err := validateBreakGlass(&tc.config); (err == nil) != tc.valid {
+			t.Errorf("%s: validateBreakGlass = %v", name, err)
+		}
+	}
+}
+
+func TestFormatBreakGlass(t *testing.T) {
+	banner := formatBreakGlass([]string{"42"}, "INC-1: `db` down", "alice", 1000)
+	for _, want := range []string{"<@&42>", "**alice** (UID 1000)", "INC-1: 'db' down"} {
+		if !strings.Contains(banner, want) {
+			t.Errorf("banner does not contain %q: %s", want, banner)
+		}
+	}
+	if banner := formatBreakGlass(nil, "INC-1", "", 0); !strings.Contains(banner, "by UID 0:") {
+		t.Errorf("banner without SUDO_USER: %s", banner)
+	}
+}
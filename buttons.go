@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// buttonEscalateID is the custom ID of the optional button escalating a request right away.
+const buttonEscalateID = "psd_escalate"
+
+// ButtonsConfig customizes the buttons of request messages. Unset buttons and fields
+// keep their defaults.
+type ButtonsConfig struct {
+	Approve *ButtonConfig `json:"approve"`
+	Deny    *ButtonConfig `json:"deny"`
+	Extend  *ButtonConfig `json:"extend"`
+	// Escalate adds a button escalating the request before escalation.after_fraction
+	Escalate *ButtonConfig `json:"escalate"`
+	// Locales override the buttons by the preferred locale of the server a request is
+	// posted to, e.g. "ja" or "pt-BR"
+	Locales map[string]ButtonsConfig `json:"locales"`
+}
+
+// ButtonConfig customizes one button.
+type ButtonConfig struct {
+	Label string `json:"label"`
+	// Emoji is a Unicode emoji or a custom one as <:name:id>
+	Emoji string `json:"emoji"`
+	// Style is primary, secondary, success or danger
+	Style string `json:"style"`
+}
+
+var buttonStyles = map[string]discordgo.ButtonStyle{
+	"primary":   discordgo.PrimaryButton,
+	"secondary": discordgo.SecondaryButton,
+	"success":   discordgo.SuccessButton,
+	"danger":    discordgo.DangerButton,
+}
+
+// customEmojiPattern matches a custom emoji as Discord renders it in messages.
+var customEmojiPattern = regexp.MustCompile(`^<(a?):(\w+):(\d+)>$`)
+
+func validateButtons(b *ButtonsConfig, escalation bool) error {
+	if err := b.validate("buttons"); err != nil {
+		return err
+	}
+	if b.Escalate != nil && !escalation {
+		return fmt.Errorf("buttons.escalate requires escalation")
+	}
+	for locale, l := range b.Locales {
+		path := fmt.Sprintf("buttons.locales[%s]", locale)
+		if len(l.Locales) > 0 {
+			return fmt.Errorf("%s: locales cannot be nested", path)
+		}
+		if l.Escalate != nil && b.Escalate == nil {
+			return fmt.Errorf("%s.escalate requires buttons.escalate", path)
+		}
+		if err := l.validate(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *ButtonsConfig) validate(path string) error {
+	for _, named := range []struct {
+		name   string
+		button *ButtonConfig
+	}{{"approve", b.Approve}, {"deny", b.Deny}, {"extend", b.Extend}, {"escalate", b.Escalate}} {
+		name, button := named.name, named.button
+		if button == nil {
+			continue
+		}
+		if _, ok := buttonStyles[button.Style]; button.Style != "" && !ok {
+			return fmt.Errorf("%s.%s.style must be primary, secondary, success or danger", path, name)
+		}
+		if utf8.RuneCountInString(button.Label) > 80 {
+			return fmt.Errorf("%s.%s.label must be at most 80 characters", path, name)
+		}
+		if strings.HasPrefix(button.Emoji, "<") && !customEmojiPattern.MatchString(button.Emoji) {
+			return fmt.Errorf("%s.%s.emoji: custom emoji must look like <:name:id>", path, name)
+		}
+	}
+	return nil
+}
+
+// requestButtons returns the row of buttons of a request message bound to nonce,
+// customized by b (which may be nil) for locale. The Escalate button is only included
+// with escalate.
+func (b *ButtonsConfig) requestButtons(locale string, extendMinutes int, escalate bool, nonce string) discordgo.ActionsRow {
+	type spec struct {
+		id       string
+		defaults ButtonConfig
+		custom   func(*ButtonsConfig) *ButtonConfig
+	}
+	specs := []spec{
+		{buttonApproveID, ButtonConfig{"Approve", "✅", "success"}, func(b *ButtonsConfig) *ButtonConfig { return b.Approve }},
+		{buttonDenyID, ButtonConfig{"Deny", "❌", "danger"}, func(b *ButtonsConfig) *ButtonConfig { return b.Deny }},
+		{buttonExtendID, ButtonConfig{fmt.Sprintf("Extend %dm", extendMinutes), "⏳", "secondary"}, func(b *ButtonsConfig) *ButtonConfig { return b.Extend }},
+	}
+	if escalate && b != nil && b.Escalate != nil {
+		specs = append(specs, spec{buttonEscalateID, ButtonConfig{"Escalate", "🚨", "primary"}, func(b *ButtonsConfig) *ButtonConfig { return b.Escalate }})
+	}
+
+	var layers []*ButtonsConfig
+	if b != nil {
+		layers = append(layers, b)
+		if l, ok := b.locale(locale); ok {
+			layers = append(layers, &l)
+		}
+	}
+	var row discordgo.ActionsRow
+	for _, s := range specs {
+		button := s.defaults
+		for _, layer := range layers {
+			button = button.merge(s.custom(layer))
+		}
+		row.Components = append(row.Components, discordgo.Button{
+			Label:    button.Label,
+			Style:    buttonStyles[button.Style],
+			CustomID: componentID(s.id, nonce),
+			Emoji:    componentEmoji(button.Emoji),
+		})
+	}
+	return row
+}
+
+// locale returns the overrides for a Discord locale, falling back from a regional
+// locale such as "en-US" to its language.
+func (b *ButtonsConfig) locale(locale string) (ButtonsConfig, bool) {
+	if locale == "" {
+		return ButtonsConfig{}, false
+	}
+	if l, ok := b.Locales[locale]; ok {
+		return l, true
+	}
+	language, _, _ := strings.Cut(locale, "-")
+	l, ok := b.Locales[language]
+	return l, ok
+}
+
+// merge returns c with the fields set in override replaced.
+func (c ButtonConfig) merge(override *ButtonConfig) ButtonConfig {
+	if override == nil {
+		return c
+	}
+	if override.Label != "" {
+		c.Label = override.Label
+	}
+	if override.Emoji != "" {
+		c.Emoji = override.Emoji
+	}
+	if override.Style != "" {
+		c.Style = override.Style
+	}
+	return c
+}
+
+func componentEmoji(emoji string) *discordgo.ComponentEmoji {
+	if m := customEmojiPattern.FindStringSubmatch(emoji); m != nil {
+		return &discordgo.ComponentEmoji{Name: m[2], ID: m[3], Animated: m[1] == "a"}
+	}
+	return &discordgo.ComponentEmoji{Name: emoji}
+}
+
+// guildLocale returns the preferred locale of the server channelID is in, or "" for
+// direct messages and when it cannot be looked up.
+func guildLocale(dg *discordgo.Session, channelID string) string {
+	ch, err := retryDiscord(func() (*discordgo.Channel, error) {
+		return dg.Channel(channelID, discordRetryOptions...)
+	})
+	if err != nil || ch.GuildID == "" {
+		return ""
+	}
+	guild, err := retryDiscord(func() (*discordgo.Guild, error) {
+		return dg.Guild(ch.GuildID, discordRetryOptions...)
+	})
+	if err != nil {
+		return ""
+	}
+	return string(guild.PreferredLocale)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestValidateButtons(t *testing.T) {
+	for name, tc := range map[string]struct {
+		buttons    ButtonsConfig
+		escalation bool
+		valid      bool
+	}{
+		"labels":           {ButtonsConfig{Approve: &ButtonConfig{Label: "LGTM"}, Deny: &ButtonConfig{Label: "Block", Style: "secondary"}}, false, true},
+		"custom emoji":     {ButtonsConfig{Approve: &ButtonConfig{Emoji: "<:shipit:123456789012345678>"}}, false, true},
+		"bad emoji":        {ButtonsConfig{Approve: &ButtonConfig{Emoji: "<:shipit>"}}, false, false},
+		"bad style":        {ButtonsConfig{Deny: &ButtonConfig{Style: "red"}}, false, false},
+		"escalate":         {ButtonsConfig{Escalate: &ButtonConfig{}}, true, true},
+		"escalate without": {ButtonsConfig{Escalate: &ButtonConfig{}}, false, false},
+		"locale":           {ButtonsConfig{Locales: map[string]ButtonsConfig{"ja": {Approve: &ButtonConfig{Label: "承認"}}}}, false, true},
+		"locale bad style": {ButtonsConfig{Locales: map[string]ButtonsConfig{"ja": {Deny: &ButtonConfig{Style: "red"}}}}, false, false},
+		"locale escalate":  {ButtonsConfig{Locales: map[string]ButtonsConfig{"ja": {Escalate: &ButtonConfig{Label: "エスカレート"}}}}, true, false},
+		"nested locales":   {ButtonsConfig{Locales: map[string]ButtonsConfig{"ja": {Locales: map[string]ButtonsConfig{"ja": {}}}}}, false, false},
+	} {
+		if err := validateButtons(&tc.buttons, tc.escalation); (err == nil) != tc.valid {
+			t.Errorf("%s: err = %v, want valid = %v", name, err, tc.valid)
+		}
+	}
+}
+
+func TestRequestButtons(t *testing.T) {
+	labels := func(row discordgo.ActionsRow) []string {
+		var labels []string
+		for _, c := range row.Components {
+			b := c.(discordgo.Button)
+			labels = append(labels, b.Emoji.Name+" "+b.Label)
+		}
+		return labels
+	}
+
+	var defaults *ButtonsConfig
+	row := defaults.requestButtons("ja", 5, true, "n")
+	if got := labels(row); !reflect.DeepEqual(got, []string{"✅ Approve", "❌ Deny", "⏳ Extend 5m"}) {
+		t.Errorf("default buttons = %q", got)
+	}
+	if b := row.Components[0].(discordgo.Button); b.Style != discordgo.SuccessButton || b.CustomID != componentID(buttonApproveID, "n") {
+		t.Errorf("approve button = %+v", b)
+	}
+
+	custom := &ButtonsConfig{
+		Approve:  &ButtonConfig{Label: "LGTM", Emoji: "<a:shipit:42>"},
+		Deny:     &ButtonConfig{Label: "Block", Style: "secondary"},
+		Escalate: &ButtonConfig{Label: "Page on-call"},
+		Locales: map[string]ButtonsConfig{
+			"ja": {Approve: &ButtonConfig{Label: "承認"}, Escalate: &ButtonConfig{Label: "エスカレート"}},
+		},
+	}
+	for locale, want := range map[string][]string{
+		"":      {"shipit LGTM", "❌ Block", "⏳ Extend 5m", "🚨 Page on-call"},
+		"en-US": {"shipit LGTM", "❌ Block", "⏳ Extend 5m", "🚨 Page on-call"},
+		"ja":    {"shipit 承認", "❌ Block", "⏳ Extend 5m", "🚨 エスカレート"},
+	} {
+		if got := labels(custom.requestButtons(locale, 5, true, "n")); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: buttons = %q, want %q", locale, got, want)
+		}
+	}
+	row = custom.requestButtons("", 5, false, "n")
+	if len(row.Components) != 3 {
+		t.Errorf("expected no Escalate button once escalated, got %d buttons", len(row.Components))
+	}
+	approve := custom.requestButtons("", 5, true, "n").Components[0].(discordgo.Button)
+	if approve.Emoji.ID != "42" || !approve.Emoji.Animated {
+		t.Errorf("custom emoji = %+v", approve.Emoji)
+	}
+	if deny := custom.requestButtons("", 5, true, "n").Components[1].(discordgo.Button); deny.Style != discordgo.SecondaryButton {
+		t.Errorf("deny style = %v", deny.Style)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+const approvalCacheFile = "approval-cache.json"
+
+// approvalCache maps cache keys to the time their approval expires.
+type approvalCache struct {
+	Entries map[string]time.Time `json:"entries"`
+}
+
+// approvalCacheKey identifies an approval in the cache. The key always binds the exact
+// command, directory, shown stdin, the identity it runs as (empty for root), the
+// requester and the executable's resolved path and contents, so a caller-supplied scope
+// can narrow but never widen an approval, one user's approval never serves another, and
+// a replaced binary is prompted for again.
+func approvalCacheKey(scope string, commandArgs []string, cwd string, stdinData []byte, runAs, requester string, binary *binaryFingerprint) string {
+	stdinSum := sha256.Sum256(stdinData)
+	data, _ := json.Marshal(struct {
+		Scope            string   `json:"scope"`
+		Command          []string `json:"command"`
+		CWD              string   `json:"cwd"`
+		Stdin            string   `json:"stdin"`
+		RunAs            string   `json:"run_as,omitempty"`
+		Requester        string   `json:"requester"`
+		Executable       string   `json:"executable"`
+		ExecutableSHA256 string   `json:"executable_sha256"`
+	}{scope, commandArgs, cwd, hex.EncodeToString(stdinSum[:]), runAs, requester, binary.path, binary.sha256})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// withApprovalCache runs fn with the cache loaded under an exclusive lock, saving it
+// afterwards if fn reports a modification.
+func withApprovalCache(stateDir string, fn func(cache *approvalCache) bool) error {
+	var cache approvalCache
+	return updateStateFile(stateDir, approvalCacheFile, &cache, func() bool {
+		if cache.Entries == nil {
+			cache.Entries = map[string]time.Time{}
+		}
+		return fn(&cache)
+	})
+}
+
+// lookupApproval reports whether any of keys has an approval that has not expired.
+func lookupApproval(stateDir string, keys []string, now time.Time) (bool, error) {
+	found := false
+	err := withApprovalCache(stateDir, func(cache *approvalCache) bool {
+		for _, key := range keys {
+			expires, ok := cache.Entries[key]
+			found = found || ok && now.Before(expires)
+		}
+		return false
+	})
+	return found, err
+}
+
+// storeApproval records an approval for key until expires, dropping expired entries.
+func storeApproval(stateDir, key string, expires time.Time) error {
+	return withApprovalCache(stateDir, func(cache *approvalCache) bool {
+		now := time.Now()
+		for k, e := range cache.Entries {
+			if !now.Before(e) {
+				delete(cache.Entries, k)
+			}
+		}
+		cache.Entries[key] = expires
+		return true
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestApprovalCacheKey(t *testing.T) {
+	systemctl := &binaryFingerprint{path: "/usr/bin/systemctl", sha256: "aa"}
+	base := approvalCacheKey("deploy", []string{"systemctl", "restart", "app"}, "/srv", nil, "", "1000:alice", systemctl)
+	if base != approvalCacheKey("deploy", []string{"systemctl", "restart", "app"}, "/srv", nil, "", "1000:alice", systemctl) {
+		t.Error("expected identical requests to share a key")
+	}
+	if base == approvalCacheKey("deploy", []string{"systemctl", "stop", "app"}, "/srv", nil, "", "1000:alice", systemctl) {
+		t.Error("expected different commands to have different keys")
+	}
+	if base == approvalCacheKey("other", []string{"systemctl", "restart", "app"}, "/srv", nil, "", "1000:alice", systemctl) {
+		t.Error("expected different scopes to have different keys")
+	}
+	if base == approvalCacheKey("deploy", []string{"systemctl", "restart", "app"}, "/srv", []byte("input"), "", "1000:alice", systemctl) {
+		t.Error("expected different stdin to have different keys")
+	}
+	if base == approvalCacheKey("deploy", []string{"systemctl", "restart", "app"}, "/srv", nil, "alice:alice", "1000:alice", systemctl) {
+		t.Error("key should depend on the identity the command runs as")
+	}
+	if base == approvalCacheKey("deploy", []string{"systemctl", "restart", "app"}, "/srv", nil, "", "1001:bob", systemctl) {
+		t.Error("key should depend on the requester")
+	}
+	if base == approvalCacheKey("deploy", []string{"systemctl", "restart", "app"}, "/srv", nil, "", "1000:alice", &binaryFingerprint{path: "/usr/bin/systemctl", sha256: "bb"}) {
+		t.Error("key should depend on the executable's contents")
+	}
+	if base == approvalCacheKey("deploy", []string{"systemctl", "restart", "app"}, "/srv", nil, "", "1000:alice", &binaryFingerprint{path: "/usr/local/bin/systemctl", sha256: "aa"}) {
+		t.Error("key should depend on the executable's path")
+	}
+	if approvalCacheKey("", []string{"a b"}, "/", nil, "", "1000:alice", systemctl) == approvalCacheKey("", []string{"a", "b"}, "/", nil, "", "1000:alice", systemctl) {
+		t.Error("expected argument boundaries to be part of the key")
+	}
+}
+
+func TestApprovalCache(t *testing.T) {
+	stateDir := filepath.Join(t.TempDir(), "state")
+	now := time.Now()
+
+	found, err := lookupApproval(stateDir, []string{"k"}, now)
+	if err != nil || found {
+		t.Fatalf("lookup on empty cache = %v, %v", found, err)
+	}
+
+	if err := storeApproval(stateDir, "k", now.Add(time.Minute)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if found, err := lookupApproval(stateDir, []string{"other", "k"}, now); err != nil || !found {
+		t.Errorf("lookup within grace window = %v, %v", found, err)
+	}
+	if found, err := lookupApproval(stateDir, []string{"k"}, now.Add(2*time.Minute)); err != nil || found {
+		t.Errorf("lookup after grace window = %v, %v", found, err)
+	}
+
+	info, err := os.Stat(filepath.Join(stateDir, approvalCacheFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("cache file mode = %o, want 600", perm)
+	}
+}
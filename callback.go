@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// callbackTimeout bounds a decision callback, which the request waits for.
+const callbackTimeout = 60 * time.Second
+
+// decisionCallbacks are the scripts given with --on-approve and --on-deny, run once a
+// request is decided.
+type decisionCallbacks struct {
+	onApprove, onDeny string
+	// runAs is the user who ran sudo, whom the scripts run as, so they gain nothing
+	// from the request; nil runs them as the current user
+	runAs *runAsIdentity
+}
+
+// newDecisionCallbacks checks that the scripts can be found, and resolves who they run as.
+func newDecisionCallbacks(onApprove, onDeny string) (*decisionCallbacks, error) {
+	for _, script := range []string{onApprove, onDeny} {
+		if script == "" {
+			continue
+		}
+		if _, err := exec.LookPath(script); err != nil {
+			return nil, err
+		}
+	}
+	c := &decisionCallbacks{onApprove: onApprove, onDeny: onDeny}
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" && os.Geteuid() == 0 {
+		id, err := resolveRunAs(sudoUser, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up %s: %w", sudoUser, err)
+		}
+		c.runAs = id
+	}
+	return c, nil
+}
+
+// approves reports whether a decision lets the command run.
+func approves(decision string) bool {
+	switch decision {
+	case auditDecisionApproved, auditDecisionAutoApproved, auditDecisionCached, auditDecisionPreapproved, auditDecisionBreakGlass:
+		return true
+	}
+	return false
+}
+
+// script returns the callback for a decision: --on-deny for every decision that does
+// not approve, including timeouts and withdrawals.
+func (c *decisionCallbacks) script(decision string) string {
+	if approves(decision) {
+		return c.onApprove
+	}
+	return c.onDeny
+}
+
+// callbackEnv returns the variables describing the decision to a callback.
+func callbackEnv(res requestResult, command string) []string {
+	return []string{
+		"PSD_REQUEST_ID=" + res.RequestID,
+		"PSD_DECISION=" + res.Decision,
+		"PSD_APPROVER_IDS=" + strings.Join(res.ApproverIDs, ","),
+		"PSD_MESSAGE_LINK=" + res.MessageLink,
+		"PSD_COMMAND=" + command,
+	}
+}
+
+// run runs the callback for the decision record rec, if there is one, with the result
+// as JSON on stdin, as --output json prints it. A failing callback is only reported.
+func (c *decisionCallbacks) run(rec AuditRecord, link string) {
+	script := c.script(rec.Decision)
+	if script == "" {
+		return
+	}
+	res := newRequestResult(rec, link)
+	data, err := json.Marshal(res)
+	if err != nil {
+		slog.Warn("failed to encode decision for callback", "err", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), callbackTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Stdin = bytes.NewReader(append(data, '\n'))
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	env := append(os.Environ(), callbackEnv(res, rec.CommandLine)...)
+	if c.runAs != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: c.runAs.credential()}
+		env = c.runAs.env(env)
+	}
+	cmd.Env = env
+	if err := cmd.Run(); err != nil {
+		slog.Warn("decision callback failed", "script", script, "decision", rec.Decision, "err", err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecisionCallbacks(t *testing.T) {
+	t.Setenv("SUDO_USER", "")
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "callback.sh")
+	body := "#!/bin/sh\n{ echo \"$PSD_DECISION $PSD_REQUEST_ID $PSD_APPROVER_IDS $PSD_COMMAND\"; cat; } > " + out + "\n"
+	if err := os.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	c, err := newDecisionCallbacks("", script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	rec := AuditRecord{RequestID: "0123456789abcdef", Event: auditEventDecision, Decision: auditDecisionApproved, Time: now, RequestedAt: now, CommandLine: "systemctl restart nginx"}
+	c.run(rec, "")
+	if _, err := os.Stat(out); err == nil {
+		t.Fatal("--on-deny ran on an approval")
+	}
+
+	rec.Decision = auditDecisionTimeout
+	rec.ApproverIDs = []string{"111"}
+	c.run(rec, "https://discord.com/channels/1/2/3")
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+	if !strings.HasPrefix(got, "timeout 0123456789abcdef 111 systemctl restart nginx\n") {
+		t.Errorf("callback environment = %q", got)
+	}
+	if !strings.Contains(got, `"message_link":"https://discord.com/channels/1/2/3"`) {
+		t.Errorf("callback stdin = %q", got)
+	}
+
+	if _, err := newDecisionCallbacks(filepath.Join(dir, "missing"), ""); err == nil {
+		t.Error("expected error for a missing script")
+	}
+}
+
+func TestApproves(t *testing.T) {
+	for decision, want := range map[string]bool{
+		auditDecisionApproved: true, auditDecisionAutoApproved: true, auditDecisionCached: true,
+		auditDecisionDenied: false, auditDecisionPolicyDenied: false, auditDecisionTimeout: false, auditDecisionCancelled: false,
+	} {
+		if got := approves(decision); got != want {
+			t.Errorf("approves(%q) = %v, want %v", decision, got, want)
+		}
+	}
+}
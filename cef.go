@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Audit sink formats of syslog messages
+const (
+	auditFormatRFC5424 = "rfc5424"
+	// auditFormatCEF is ArcSight's Common Event Format
+	auditFormatCEF = "cef"
+	// auditFormatLEEF is QRadar's Log Event Extended Format
+	auditFormatLEEF = "leef"
+)
+
+const auditVendor = "kyori19"
+
+// leefTimeFormat is the Java date format of devTime, which LEEF needs to parse it.
+const leefTimeFormat = "yyyy-MM-dd'T'HH:mm:ss.SSSXXX"
+
+// productVersion returns the module version the binary was built from.
+func productVersion() string {
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		return bi.Main.Version
+	}
+	return "devel"
+}
+
+// auditEventID is the event class of a record: the decision, or "exit".
+func auditEventID(rec AuditRecord) string {
+	if rec.Event == auditEventDecision {
+		return rec.Decision
+	}
+	return rec.Event
+}
+
+// auditSeverity rates a record from 1 to 10: commands run without approval rate highest,
+// and denials above approvals.
+func auditSeverity(rec AuditRecord) int {
+	switch auditEventID(rec) {
+	case auditDecisionBreakGlass:
+		return 9
+	case auditDecisionPolicyDenied, auditDecisionDenied:
+		return 6
+	case auditDecisionAutoApproved, auditDecisionPreapproved:
+		return 4
+	case auditEventExit:
+		return 1
+	}
+	return 3
+}
+
+// cefHeaderEscaper and cefValueEscaper escape CEF header fields and extension values.
+var (
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefValueEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+// formatCEF renders rec, a record redacted for mirroring, as a CEF line, mapping its
+// fields to CEF keys where one fits and to labeled custom strings otherwise.
+func formatCEF(rec AuditRecord) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|%s|%s|%s|%s|%s|%d|",
+		auditVendor, syslogAppName, cefHeaderEscaper.Replace(productVersion()),
+		cefHeaderEscaper.Replace(auditEventID(rec)), cefHeaderEscaper.Replace(auditEventName(rec)), auditSeverity(rec))
+	var ext []string
+	add := func(key, value string) {
+		if value != "" {
+			ext = append(ext, key+"="+cefValueEscaper.Replace(value))
+		}
+	}
+	custom := func(n int, label, value string) {
+		if value != "" {
+			add(fmt.Sprintf("cs%dLabel", n), label)
+			add(fmt.Sprintf("cs%d", n), value)
+		}
+	}
+	if !rec.Time.IsZero() {
+		add("rt", strconv.FormatInt(rec.Time.UnixMilli(), 10))
+	}
+	add("externalId", rec.RequestID)
+	add("dvchost", rec.Host)
+	add("suser", rec.Requester)
+	add("suid", strconv.Itoa(rec.RequesterUID))
+	add("duser", rec.RunAs)
+	add("act", rec.Decision)
+	add("outcome", auditOutcome(rec))
+	custom(1, "command", rec.CommandLine)
+	custom(2, "policy", rec.Policy)
+	custom(3, "approverIds", strings.Join(rec.ApproverIDs, ","))
+	custom(4, "sudoUser", rec.SudoUser)
+	custom(5, "commandSha256", rec.CommandSHA256)
+	custom(6, "cwd", rec.CWD)
+	if rec.ExitCode != nil {
+		add("cn1Label", "exitCode")
+		add("cn1", strconv.Itoa(*rec.ExitCode))
+	}
+	add("msg", auditSummary(rec))
+	b.WriteString(strings.Join(ext, " "))
+	return b.String()
+}
+
+// leefValueEscaper keeps values from breaking LEEF's tab-delimited attributes.
+var leefValueEscaper = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+// formatLEEF renders rec, a record redacted for mirroring, as a LEEF 1.0 line, with
+// QRadar's predefined keys where one fits.
+func formatLEEF(rec AuditRecord) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "LEEF:1.0|%s|%s|%s|%s|",
+		auditVendor, syslogAppName, cefHeaderEscaper.Replace(productVersion()),
+		cefHeaderEscaper.Replace(auditEventID(rec)))
+	var attrs []string
+	add := func(key, value string) {
+		if value != "" {
+			attrs = append(attrs, key+"="+leefValueEscaper.Replace(value))
+		}
+	}
+	if !rec.Time.IsZero() {
+		add("devTime", rec.Time.Format("2006-01-02T15:04:05.000Z07:00"))
+		add("devTimeFormat", leefTimeFormat)
+	}
+	add("cat", rec.Event)
+	add("sev", strconv.Itoa(auditSeverity(rec)))
+	add("identHostName", rec.Host)
+	add("usrName", rec.Requester)
+	add("requestId", rec.RequestID)
+	add("decision", rec.Decision)
+	add("command", rec.CommandLine)
+	add("commandSha256", rec.CommandSHA256)
+	add("sudoUser", rec.SudoUser)
+	add("requesterUid", strconv.Itoa(rec.RequesterUID))
+	add("runAs", rec.RunAs)
+	add("cwd", rec.CWD)
+	add("policy", rec.Policy)
+	add("approverIds", strings.Join(rec.ApproverIDs, ","))
+	if rec.ExitCode != nil {
+		add("exitCode", strconv.Itoa(*rec.ExitCode))
+	}
+	b.WriteString(strings.Join(attrs, "\t"))
+	return b.String()
+}
+
+// auditEventName is the short description of a record's event class.
+func auditEventName(rec AuditRecord) string {
+	if rec.Event == auditEventExit {
+		return "Approved command exited"
+	}
+	return "Request " + strings.ReplaceAll(rec.Decision, "_", " ")
+}
+
+// auditOutcome tells whether the command of a record was allowed to run or ran
+// successfully.
+func auditOutcome(rec AuditRecord) string {
+	switch auditEventID(rec) {
+	case auditEventExit:
+		if rec.ExitCode != nil && *rec.ExitCode == 0 {
+			return "success"
+		}
+		return "failure"
+	case auditDecisionApproved, auditDecisionAutoApproved, auditDecisionCached, auditDecisionPreapproved, auditDecisionBreakGlass:
+		return "success"
+	}
+	return "failure"
+}
+
+// formatSyslogEvent renders rec as an RFC 5424 message whose content is a CEF or LEEF
+// line instead of structured data.
+func formatSyslogEvent(rec AuditRecord, format string, now time.Time) []byte {
+	var b bytes.Buffer
+	writeSyslogHeader(&b, rec, now)
+	b.WriteString("- ")
+	if format == auditFormatLEEF {
+		b.WriteString(formatLEEF(rec))
+	} else {
+		b.WriteString(formatCEF(rec))
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatCEF(t *testing.T) {
+	rec := AuditRecord{
+		Time:        time.UnixMilli(1704164645123),
+		Event:       auditEventDecision,
+		RequestID:   "abc",
+		Command:     []string{"echo", "a=b\\c"},
+		Requester:   "alice",
+		Host:        "web1",
+		Decision:    auditDecisionBreakGlass,
+		ApproverIDs: []string{"1", "2"},
+	}.redacted(nil)
+	line := formatCEF(rec)
+	if !strings.HasPrefix(line, "CEF:0|kyori19|prompt-sudo-discord|") || !strings.Contains(line, "|break_glass|Request break glass|9|") {
+		t.Errorf("unexpected header: %s", line)
+	}
+	for _, want := range []string{
+		"rt=1704164645123 externalId=abc dvchost=web1 suser=alice suid=0 act=break_glass outcome=success",
+		`cs1Label=command cs1=echo 'a\=b\\c'`,
+		"cs3Label=approverIds cs3=1,2",
+		"msg=request abc break_glass: echo",
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("line %s does not contain %s", line, want)
+		}
+	}
+	if strings.Contains(line, "cs2Label") || strings.Contains(line, "cn1") {
+		t.Errorf("empty fields are not omitted: %s", line)
+	}
+
+	code := 1
+	exit := formatCEF(AuditRecord{Event: auditEventExit, RequestID: "abc", Command: []string{"false"}, ExitCode: &code}.redacted(nil))
+	if !strings.Contains(exit, "|exit|Approved command exited|1|") || !strings.Contains(exit, "outcome=failure cs1Label=command cs1=false cn1Label=exitCode cn1=1") {
+		t.Errorf("unexpected exit line: %s", exit)
+	}
+}
+
+func TestFormatLEEF(t *testing.T) {
+	rec := AuditRecord{
+		Time:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Event:     auditEventDecision,
+		RequestID: "abc",
+		Command:   []string{"id"},
+		Requester: "alice",
+		CWD:       "/tmp/a\tb",
+		Host:      "web1",
+		Decision:  auditDecisionDenied,
+	}.redacted(nil)
+	line := formatLEEF(rec)
+	if !strings.HasPrefix(line, "LEEF:1.0|kyori19|prompt-sudo-discord|") || !strings.Contains(line, "|denied|devTime=") {
+		t.Errorf("unexpected header: %s", line)
+	}
+	attrs := strings.Split(line[strings.Index(line, "|denied|")+len("|denied|"):], "\t")
+	want := []string{
+		"devTime=2024-01-02T03:04:05.000Z", "devTimeFormat=" + leefTimeFormat, "cat=decision", "sev=6", "identHostName=web1",
+		"usrName=alice", "requestId=abc", "decision=denied", "command=id", "requesterUid=0", "cwd=/tmp/a b",
+	}
+	if strings.Join(attrs, "\n") != strings.Join(want, "\n") {
+		t.Errorf("attributes = %q, want %q", attrs, want)
+	}
+}
+
+func TestFormatSyslogEvent(t *testing.T) {
+	rec := AuditRecord{Event: auditEventDecision, RequestID: "abc", Host: "web1", Decision: auditDecisionApproved}
+	msg := string(formatSyslogEvent(rec, auditFormatCEF, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)))
+	if !strings.HasPrefix(msg, "<86>1 2024-01-02T03:04:05Z web1 prompt-sudo-discord ") || !strings.Contains(msg, " decision - CEF:0|") {
+		t.Errorf("unexpected message: %s", msg)
+	}
+	if err := validateAuditSink(&AuditSinkConfig{Type: auditSinkSyslog, Format: "gelf"}); err == nil {
+		t.Error("expected error for unknown format")
+	}
+	if err := validateAuditSink(&AuditSinkConfig{Type: auditSinkJournald, Format: auditFormatCEF}); err == nil {
+		t.Error("expected error for journald format")
+	}
+}
+
+func TestSyslogEventRedacted(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	redactions, _ := compileRedactions(nil)
+	for format, want := range map[string]string{
+		auditFormatCEF:  "cs1=curl -H 'Authorization: Bearer ****'",
+		auditFormatLEEF: "command=curl -H 'Authorization: Bearer ****'",
+	} {
+		a := &auditor{
+			path:       filepath.Join(t.TempDir(), "audit.jsonl"),
+			base:       AuditRecord{RequestID: "abc", Command: []string{"curl", "-H", "Authorization: Bearer s3cr3t"}},
+			sink:       &AuditSinkConfig{Type: auditSinkSyslog, Network: "unixgram", Address: addr, Format: format},
+			redactions: redactions,
+		}
+		if err := a.decision(auditDecisionDenied, nil); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4096)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line := string(buf[:n]); !strings.Contains(line, want) || strings.Contains(line, "s3cr3t") {
+			t.Errorf("%s: line %q does not carry the redacted command %q", format, line, want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted.
+var cgroupRoot = "/sys/fs/cgroup"
+
+const (
+	// cgroupParent groups the cgroups of all approved commands
+	cgroupParent = "prompt-sudo-discord"
+	// cgroupCPUPeriod is the cpu.max period, in microseconds
+	cgroupCPUPeriod = 100000
+)
+
+// ResourceLimitsConfig caps approved commands through a cgroup v2.
+type ResourceLimitsConfig struct {
+	// CPUs is the CPU time the command may use, in cores (e.g. 0.5)
+	CPUs float64 `json:"cpus"`
+	// MemoryMax is the memory limit, in bytes or with a K, M or G suffix
+	MemoryMax string `json:"memory_max"`
+	PidsMax   int    `json:"pids_max"`
+	memoryMax int64
+}
+
+func validateResourceLimits(c *ResourceLimitsConfig) error {
+	if !cgroupsSupported {
+		return fmt.Errorf("resource_limits are only supported on Linux")
+	}
+	if c.CPUs < 0 || c.PidsMax < 0 {
+		return fmt.Errorf("resource_limits must not be negative")
+	}
+	if c.MemoryMax != "" {
+		n, err := parseByteSize(c.MemoryMax)
+		if err != nil {
+			return fmt.Errorf("resource_limits.memory_max: %w", err)
+		}
+		c.memoryMax = n
+	}
+	if c.CPUs == 0 && c.memoryMax == 0 && c.PidsMax == 0 {
+		return fmt.Errorf("resource_limits must set cpus, memory_max or pids_max")
+	}
+	return nil
+}
+
+// parseByteSize parses a size such as 512M (binary units).
+func parseByteSize(s string) (int64, error) {
+	mult := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+// controllers returns the cgroup controllers the limits need, and the files that
+// set them.
+func (c *ResourceLimitsConfig) controllers() ([]string, map[string]string) {
+	var controllers []string
+	files := map[string]string{}
+	if c.CPUs > 0 {
+		controllers = append(controllers, "cpu")
+		files["cpu.max"] = fmt.Sprintf("%d %d", int64(c.CPUs*cgroupCPUPeriod), cgroupCPUPeriod)
+	}
+	if c.memoryMax > 0 {
+		controllers = append(controllers, "memory")
+		files["memory.max"] = strconv.FormatInt(c.memoryMax, 10)
+		// Swapping would only slow a runaway command down instead of stopping it
+		files["memory.swap.max"] = "0"
+	}
+	if c.PidsMax > 0 {
+		controllers = append(controllers, "pids")
+		files["pids.max"] = strconv.Itoa(c.PidsMax)
+	}
+	return controllers, files
+}
+
+// cgroup is the cgroup an approved command runs in.
+type cgroup struct {
+	path string
+	dir  *os.File
+}
+
+// createCgroup creates a cgroup for a request with the given limits.
+func createCgroup(limits *ResourceLimitsConfig, requestID string) (*cgroup, error) {
+	controllers, files := limits.controllers()
+	enable := "+" + strings.Join(controllers, " +")
+	parent := filepath.Join(cgroupRoot, cgroupParent)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, err
+	}
+	// Controllers have to be enabled down the hierarchy for the leaf to use them
+	for _, dir := range []string{cgroupRoot, parent} {
+		if err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte(enable), 0644); err != nil {
+			return nil, fmt.Errorf("failed to enable controllers %s in %s: %w", enable, dir, err)
+		}
+	}
+	path := filepath.Join(parent, requestID)
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, err
+	}
+	c := &cgroup{path: path}
+	for name, value := range files {
+		// memory.swap.max is missing without swap accounting, which is fine
+		if err := os.WriteFile(filepath.Join(path, name), []byte(value), 0644); err != nil && name != "memory.swap.max" {
+			c.remove()
+			return nil, fmt.Errorf("failed to set %s: %w", name, err)
+		}
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		c.remove()
+		return nil, err
+	}
+	c.dir = dir
+	return c, nil
+}
+
+// remove kills whatever the command left running in the cgroup and removes it.
+func (c *cgroup) remove() {
+	if c == nil {
+		return
+	}
+	if c.dir != nil {
+		c.dir.Close()
+	}
+	os.WriteFile(filepath.Join(c.path, "cgroup.kill"), []byte("1"), 0644)
+	// Killed processes take a moment to leave the cgroup
+	for i := 0; i < 10; i++ {
+		if err := os.Remove(c.path); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
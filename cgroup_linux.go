@@ -0,0 +1,11 @@
+package main
+
+import "syscall"
+
+const cgroupsSupported = true
+
+// attach makes the child process start in the cgroup.
+func (c *cgroup) attach(attr *syscall.SysProcAttr) {
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(c.dir.Fd())
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "syscall"
+
+const cgroupsSupported = false
+
+func (c *cgroup) attach(attr *syscall.SysProcAttr) {}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]int64{"1024": 1024, "4K": 4096, "512M": 512 << 20, "2g": 2 << 30}
+	for s, want := range tests {
+		if got, err := parseByteSize(s); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"M", "-1", "1.5G", "lots"} {
+		if _, err := parseByteSize(s); err == nil {
+			t.Errorf("parseByteSize(%q): expected error", s)
+		}
+	}
+}
+
+func TestValidateResourceLimits(t *testing.T) {
+	if err := validateResourceLimits(&ResourceLimitsConfig{}); err == nil {
+		t.Error("expected error for empty limits")
+	}
+	if err := validateResourceLimits(&ResourceLimitsConfig{PidsMax: -1}); err == nil {
+		t.Error("expected error for negative limits")
+	}
+	if err := validateResourceLimits(&ResourceLimitsConfig{MemoryMax: "big"}); err == nil {
+		t.Error("expected error for invalid memory_max")
+	}
+}
+
+func TestCreateCgroup(t *testing.T) {
+	cgroupRoot = t.TempDir()
+	defer func() { cgroupRoot = "/sys/fs/cgroup" }()
+
+	limits := &ResourceLimitsConfig{CPUs: 1.5, MemoryMax: "256M", PidsMax: 64}
+	if err := validateResourceLimits(limits); err != nil {
+		t.Fatal(err)
+	}
+	cg, err := createCgroup(limits, "req1")
+	if err != nil {
+		t.Fatalf("createCgroup: %v", err)
+	}
+	want := map[string]string{
+		"cpu.max":    "150000 100000",
+		"memory.max": "268435456",
+		"pids.max":   "64",
+	}
+	for name, value := range want {
+		data, err := os.ReadFile(filepath.Join(cg.path, name))
+		if err != nil || string(data) != value {
+			t.Errorf("%s = %q, %v, want %q", name, data, err, value)
+		}
+	}
+	data, _ := os.ReadFile(filepath.Join(cgroupRoot, cgroupParent, "cgroup.subtree_control"))
+	if string(data) != "+cpu +memory +pids" {
+		t.Errorf("subtree_control = %q", data)
+	}
+
+	// Outside cgroupfs the directory is not empty and stays, but leftovers are killed
+	cg.remove()
+	if data, _ := os.ReadFile(filepath.Join(cg.path, "cgroup.kill")); string(data) != "1" {
+		t.Errorf("cgroup.kill = %q, want leftover processes killed", data)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// snowflakePattern matches Discord IDs.
+var snowflakePattern = regexp.MustCompile(`^[0-9]+$`)
+
+func validateChannels(config *Config) error {
+	for alias, id := range config.Channels {
+		if alias == "" || snowflakePattern.MatchString(alias) {
+			return fmt.Errorf("channels: alias %q must be a non-numeric name", alias)
+		}
+		if !snowflakePattern.MatchString(id) {
+			return fmt.Errorf("channels[%s]: %q is not a channel ID", alias, id)
+		}
+	}
+	if config.DefaultChannel != "" {
+		if _, err := config.resolveChannel(config.DefaultChannel); err != nil {
+			return fmt.Errorf("default_channel: %w", err)
+		}
+	}
+	return nil
+}
+
+// resolveChannels resolves a comma-separated list of channel aliases and IDs. An
+// empty list resolves to default_channel, if any.
+func (c *Config) resolveChannels(names string) ([]string, error) {
+	list := splitList(names)
+	if len(list) == 0 {
+		id, err := c.resolveChannel("")
+		if err != nil || id == "" {
+			return nil, err
+		}
+		return []string{id}, nil
+	}
+	var ids []string
+	for _, name := range list {
+		id, err := c.resolveChannel(name)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// resolveChannel returns the channel ID for a channel alias from the config or a raw
+// channel ID. An empty name resolves to default_channel, if any.
+func (c *Config) resolveChannel(name string) (string, error) {
+	if name == "" {
+		name = c.DefaultChannel
+	}
+	if id, ok := c.Channels[name]; ok {
+		return id, nil
+	}
+	if name == "" || snowflakePattern.MatchString(name) {
+		return name, nil
+	}
+	return "", fmt.Errorf("unknown channel alias %q", name)
+}
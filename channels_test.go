@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveChannel(t *testing.T) {
+	config := &Config{
+		Channels:       map[string]string{"prod": "123", "staging": "456"},
+		DefaultChannel: "staging",
+	}
+	tests := map[string]string{
+		"prod": "123",
+		"789":  "789",
+		"":     "456",
+	}
+	for name, want := range tests {
+		if got, err := config.resolveChannel(name); err != nil || got != want {
+			t.Errorf("resolveChannel(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := config.resolveChannel("qa"); err == nil {
+		t.Error("expected an unknown alias to be rejected")
+	}
+	if got, err := (&Config{}).resolveChannel(""); err != nil || got != "" {
+		t.Errorf("without a default = %q, %v", got, err)
+	}
+}
+
+func TestResolveChannels(t *testing.T) {
+	config := &Config{Channels: map[string]string{"team": "123", "oncall": "456"}, DefaultChannel: "team"}
+	got, err := config.resolveChannels("team, oncall,123,789")
+	if want := []string{"123", "456", "789"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveChannels = %v, %v, want %v", got, err, want)
+	}
+	if got, err := config.resolveChannels(""); err != nil || !reflect.DeepEqual(got, []string{"123"}) {
+		t.Errorf("default = %v, %v", got, err)
+	}
+	if got, err := (&Config{}).resolveChannels(""); err != nil || got != nil {
+		t.Errorf("without a default = %v, %v", got, err)
+	}
+	if _, err := config.resolveChannels("team,qa"); err == nil {
+		t.Error("expected an unknown alias to be rejected")
+	}
+}
+
+func TestValidateChannels(t *testing.T) {
+	valid := &Config{Channels: map[string]string{"prod": "123"}, DefaultChannel: "prod"}
+	if err := validateChannels(valid); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	invalid := []*Config{
+		{Channels: map[string]string{"prod": "#prod"}},
+		{Channels: map[string]string{"123": "456"}},
+		{DefaultChannel: "prod"},
+	}
+	for _, config := range invalid {
+		if err := validateChannels(config); err == nil {
+			t.Errorf("expected %+v to be rejected", config)
+		}
+	}
+}
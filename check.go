@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// requiredPermissions are the channel permissions the bot needs to post, update and
+// reply to requests. Editing its own messages needs no extra permission.
+var requiredPermissions = []struct {
+	name string
+	bit  int64
+}{
+	{"View Channel", discordgo.PermissionViewChannel},
+	{"Send Messages", discordgo.PermissionSendMessages},
+	{"Read Message History", discordgo.PermissionReadMessageHistory},
+	{"Attach Files", discordgo.PermissionAttachFiles},
+}
+
+// missingPermissions returns the names of the required permissions not in perms.
+// Forum posts additionally need to be replied to as threads.
+func missingPermissions(perms int64, forum bool) []string {
+	if perms&discordgo.PermissionAdministrator != 0 {
+		return nil
+	}
+	var missing []string
+	for _, p := range requiredPermissions {
+		if perms&p.bit == 0 {
+			missing = append(missing, p.name)
+		}
+	}
+	if forum && perms&discordgo.PermissionSendMessagesInThreads == 0 {
+		missing = append(missing, "Send Messages in Threads")
+	}
+	return missing
+}
+
+// discordErrorCode returns the JSON error code of a Discord API error, or 0.
+func discordErrorCode(err error) int {
+	var restErr *discordgo.RESTError
+	if errors.As(err, &restErr) && restErr.Message != nil {
+		return restErr.Message.Code
+	}
+	return 0
+}
+
+// explainDiscordError adds what to do about the common errors of posting to channelID,
+// whose messages ("Missing Access") do not say.
+func explainDiscordError(channelID string, err error) error {
+	switch discordErrorCode(err) {
+	case discordgo.ErrCodeUnknownChannel:
+		return fmt.Errorf("channel %s does not exist, or is in a server the bot is not in: %w", channelID, err)
+	case discordgo.ErrCodeMissingAccess:
+		return fmt.Errorf("the bot cannot see channel %s; give it the View Channel permission there: %w", channelID, err)
+	case discordgo.ErrCodeMissingPermissions:
+		return fmt.Errorf("the bot lacks permissions in channel %s; run `prompt-sudo-discord check --channel %s`: %w", channelID, channelID, err)
+	}
+	return err
+}
+
+// checkRequestChannel verifies, before a request is posted to channelID, that the bot
+// has the permissions it needs there and that approvers can see it. It returns the
+// approvers who cannot, and an error if the request cannot work in the channel. If
+// the check itself fails, e.g. on a network error, no error is returned, leaving it
+// to posting the request.
+func checkRequestChannel(dg *discordgo.Session, botID, channelID string, approverIDs []string) ([]string, error) {
+	ch, err := retryDiscord(func() (*discordgo.Channel, error) {
+		return dg.Channel(channelID, discordRetryOptions...)
+	})
+	if err != nil {
+		if code := discordErrorCode(err); code == discordgo.ErrCodeUnknownChannel || code == discordgo.ErrCodeMissingAccess {
+			return nil, explainDiscordError(channelID, err)
+		}
+		return nil, nil
+	}
+	if ch.GuildID == "" {
+		return nil, nil
+	}
+	perms, err := dg.UserChannelPermissions(botID, channelID, discordRetryOptions...)
+	if err != nil {
+		return nil, nil
+	}
+	if missing := missingPermissions(perms, ch.Type == discordgo.ChannelTypeGuildForum); len(missing) > 0 {
+		return nil, fmt.Errorf("the bot is missing permissions in #%s (%s): %s", ch.Name, channelID, strings.Join(missing, ", "))
+	}
+
+	var blind []string
+	for _, id := range approverIDs {
+		perms, err := dg.UserChannelPermissions(id, channelID, discordRetryOptions...)
+		switch code := discordErrorCode(err); {
+		case code == discordgo.ErrCodeUnknownMember || code == discordgo.ErrCodeUnknownUser:
+			blind = append(blind, id)
+		case err != nil:
+			// Unknown either way; the approver is given the benefit of the doubt
+		case !canView(perms):
+			blind = append(blind, id)
+		}
+	}
+	if len(approverIDs) > 0 && len(blind) == len(approverIDs) {
+		return blind, fmt.Errorf("no approver can see #%s (%s): add them to the server or give them the View Channel permission there", ch.Name, channelID)
+	}
+	return blind, nil
+}
+
+// canView reports whether perms let a member see a channel and its messages.
+func canView(perms int64) bool {
+	return perms&discordgo.PermissionAdministrator != 0 ||
+		perms&(discordgo.PermissionViewChannel|discordgo.PermissionReadMessageHistory) == discordgo.PermissionViewChannel|discordgo.PermissionReadMessageHistory
+}
+
+// checkChannels returns every channel requests may be posted to: the given ones and
+// those configured for host profiles, policies, escalation and auditing.
+func checkChannels(config *Config, channelIDs []string) []string {
+	var channels []string
+	seen := map[string]bool{}
+	add := func(id string) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			channels = append(channels, id)
+		}
+	}
+	for _, id := range channelIDs {
+		add(id)
+	}
+	for _, p := range config.Policies {
+		add(p.ChannelID)
+	}
+	// Map order is random; sort the host profiles so the report is stable
+	patterns := make([]string, 0, len(config.Hosts))
+	for pattern := range config.Hosts {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		profile := config.Hosts[pattern]
+		add(profile.ChannelID)
+		for _, p := range profile.Policies {
+			add(p.ChannelID)
+		}
+	}
+	if config.Escalation != nil {
+		add(config.Escalation.ChannelID)
+	}
+	add(config.AuditChannelID)
+	return channels
+}
+
+// checker reports the outcome of each self-test.
+type checker struct {
+	w      io.Writer
+	failed bool
+}
+
+func (c *checker) report(name string, err error, detail string) bool {
+	if err != nil {
+		c.failed = true
+		fmt.Fprintf(c.w, "❌ %s: %v\n", name, err)
+		return false
+	}
+	fmt.Fprintf(c.w, "✅ %s: %s\n", name, detail)
+	return true
+}
+
+// runCheck implements `prompt-sudo-discord check`: it validates the config, the bot
+// token and the bot's access to every channel requests may be posted to.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	channelID := fs.String("channel", "", "Comma-separated Discord channel IDs or aliases to check, in addition to those in the config")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	c := &checker{w: os.Stdout}
+	config, err := loadConfig(configPath)
+	if !c.report("config", err, configPath) {
+		return exitConfigError
+	}
+	// Without --channel, the default channel is checked
+	channelIDs, err := config.resolveChannels(*channelID)
+	if err != nil {
+		c.report("channel", err, "")
+		return exitConfigError
+	}
+
+	dg, err := discordgo.New(config.DiscordToken)
+	if err != nil {
+		c.report("token", err, "")
+		return exitConfigError
+	}
+	me, err := dg.User("@me")
+	if err != nil {
+		c.report("token", err, "")
+		return exitDiscordError
+	}
+	c.report("token", nil, fmt.Sprintf("authenticated as %s (%s)", me.Username, me.ID))
+
+	channels := checkChannels(config, channelIDs)
+	if len(channels) == 0 {
+		fmt.Fprintln(c.w, "ℹ️ no channel to check; pass --channel")
+	}
+	for _, id := range channels {
+		name := "channel " + id
+		ch, err := dg.Channel(id)
+		if err != nil {
+			c.report(name, err, "")
+			continue
+		}
+		if ch.GuildID == "" {
+			c.report(name, nil, "accessible (not in a server)")
+			continue
+		}
+		perms, err := dg.UserChannelPermissions(me.ID, id)
+		if err != nil {
+			c.report(name, fmt.Errorf("failed to read permissions: %w", err), "")
+			continue
+		}
+		forum := ch.Type == discordgo.ChannelTypeGuildForum
+		if missing := missingPermissions(perms, forum); len(missing) > 0 {
+			c.report(name, fmt.Errorf("#%s is missing permissions: %s", ch.Name, strings.Join(missing, ", ")), "")
+			continue
+		}
+		if forum {
+			c.report(name, nil, fmt.Sprintf("#%s (forum): can create posts, reply, edit and attach", ch.Name))
+			continue
+		}
+		c.report(name, nil, fmt.Sprintf("#%s: can view, send, edit and attach", ch.Name))
+	}
+
+	for _, id := range config.ApproverIDs {
+		_, err := dg.User(id)
+		c.report("approver "+id, err, "known to Discord")
+	}
+
+	if c.failed {
+		return exitDiscordError
+	}
+	return 0
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestMissingPermissions(t *testing.T) {
+	all := int64(discordgo.PermissionViewChannel | discordgo.PermissionSendMessages |
+		discordgo.PermissionReadMessageHistory | discordgo.PermissionAttachFiles)
+	if missing := missingPermissions(all, false); len(missing) != 0 {
+		t.Errorf("unexpected missing permissions: %v", missing)
+	}
+	if missing := missingPermissions(discordgo.PermissionAdministrator, true); len(missing) != 0 {
+		t.Errorf("administrator should have every permission, missing %v", missing)
+	}
+	got := missingPermissions(discordgo.PermissionViewChannel|discordgo.PermissionReadMessageHistory, false)
+	if want := []string{"Send Messages", "Attach Files"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("missing = %v, want %v", got, want)
+	}
+	if missing := missingPermissions(all, true); !reflect.DeepEqual(missing, []string{"Send Messages in Threads"}) {
+		t.Errorf("forum missing = %v", missing)
+	}
+}
+
+func TestCheckChannels(t *testing.T) {
+	config := &Config{
+		Policies: []Policy{{ChannelID: "2"}, {ChannelID: "1"}, {}},
+		Hosts: map[string]HostProfile{
+			"web-*": {ChannelID: "6"},
+			"db-*":  {ChannelID: "5", Policies: []Policy{{ChannelID: "2"}}},
+		},
+		Escalation:     &EscalationConfig{ChannelID: "3"},
+		AuditChannelID: "4",
+	}
+	if got, want := checkChannels(config, []string{"1"}), []string{"1", "2", "5", "6", "3", "4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("channels = %v, want %v", got, want)
+	}
+}
+
+func TestCheckerReport(t *testing.T) {
+	var buf bytes.Buffer
+	c := &checker{w: &buf}
+	c.report("config", nil, "ok")
+	if c.failed {
+		t.Fatal("success marked as failure")
+	}
+	c.report("token", errors.New("401 Unauthorized"), "")
+	if !c.failed || !strings.Contains(buf.String(), "❌ token: 401 Unauthorized") {
+		t.Errorf("unexpected report: %q", buf.String())
+	}
+}
+
+func TestExplainDiscordError(t *testing.T) {
+	apiErr := func(code int) error {
+		return &discordgo.RESTError{Message: &discordgo.APIErrorMessage{Code: code, Message: "Missing Access"}}
+	}
+	err := explainDiscordError("42", apiErr(discordgo.ErrCodeMissingAccess))
+	if !strings.Contains(err.Error(), "cannot see channel 42") || discordErrorCode(err) != discordgo.ErrCodeMissingAccess {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := explainDiscordError("42", apiErr(discordgo.ErrCodeMissingPermissions)); !strings.Contains(err.Error(), "check --channel 42") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	other := errors.New("connection reset")
+	if err := explainDiscordError("42", other); err != other {
+		t.Errorf("unrelated errors should be kept, got %v", err)
+	}
+}
+
+func TestCanView(t *testing.T) {
+	if !canView(discordgo.PermissionViewChannel | discordgo.PermissionReadMessageHistory) {
+		t.Error("view and history should be enough")
+	}
+	if canView(discordgo.PermissionViewChannel) {
+		t.Error("without message history, requests are not visible")
+	}
+	if !canView(discordgo.PermissionAdministrator) {
+		t.Error("administrators see every channel")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ciContext describes the CI job a request comes from, as reported by the CI's
+// environment variables.
+type ciContext struct {
+	Provider    string `json:"provider"`
+	PipelineURL string `json:"pipeline_url,omitempty"`
+	Branch      string `json:"branch,omitempty"`
+	Commit      string `json:"commit,omitempty"`
+	// TriggeredBy is the CI user who started the pipeline
+	TriggeredBy string `json:"triggered_by,omitempty"`
+}
+
+// ciProvider maps the environment variables of one CI system to a ciContext.
+type ciProvider struct {
+	name string
+	// detect is set to "true" in jobs of this CI, or to any value with detectAny
+	detect    string
+	detectAny bool
+	// The variables holding each field; pipelineURLFunc builds the URL from several
+	pipelineURL, branch, commit, triggeredBy string
+	pipelineURLFunc                          func(getenv func(string) string) string
+}
+
+// ciProviders are checked in order; the generic CI=true comes last.
+var ciProviders = []ciProvider{
+	{name: "GitLab CI", detect: "GITLAB_CI", pipelineURL: "CI_PIPELINE_URL", branch: "CI_COMMIT_REF_NAME", commit: "CI_COMMIT_SHA", triggeredBy: "GITLAB_USER_LOGIN"},
+	{name: "GitHub Actions", detect: "GITHUB_ACTIONS", branch: "GITHUB_REF_NAME", commit: "GITHUB_SHA", triggeredBy: "GITHUB_ACTOR",
+		pipelineURLFunc: func(getenv func(string) string) string {
+			server, repo, run := getenv("GITHUB_SERVER_URL"), getenv("GITHUB_REPOSITORY"), getenv("GITHUB_RUN_ID")
+			if server == "" || repo == "" || run == "" {
+				return ""
+			}
+			return fmt.Sprintf("%s/%s/actions/runs/%s", server, repo, run)
+		}},
+	{name: "CircleCI", detect: "CIRCLECI", pipelineURL: "CIRCLE_BUILD_URL", branch: "CIRCLE_BRANCH", commit: "CIRCLE_SHA1", triggeredBy: "CIRCLE_USERNAME"},
+	{name: "Buildkite", detect: "BUILDKITE", pipelineURL: "BUILDKITE_BUILD_URL", branch: "BUILDKITE_BRANCH", commit: "BUILDKITE_COMMIT", triggeredBy: "BUILDKITE_BUILD_CREATOR"},
+	{name: "Jenkins", detect: "JENKINS_URL", detectAny: true, pipelineURL: "BUILD_URL", branch: "GIT_BRANCH", commit: "GIT_COMMIT", triggeredBy: "BUILD_USER_ID"},
+	{name: "CI", detect: "CI"},
+}
+
+// detectCI returns the CI job of the environment, if any.
+func detectCI(getenv func(string) string) (ciContext, bool) {
+	for _, p := range ciProviders {
+		v := getenv(p.detect)
+		if v == "" || (!p.detectAny && !strings.EqualFold(v, "true")) {
+			continue
+		}
+		lookup := func(name string) string {
+			if name == "" {
+				return ""
+			}
+			return getenv(name)
+		}
+		ci := ciContext{
+			Provider:    p.name,
+			PipelineURL: lookup(p.pipelineURL),
+			Branch:      lookup(p.branch),
+			Commit:      lookup(p.commit),
+			TriggeredBy: lookup(p.triggeredBy),
+		}
+		if p.pipelineURLFunc != nil {
+			ci.PipelineURL = p.pipelineURLFunc(getenv)
+		}
+		// Only web links are rendered as links
+		if !strings.HasPrefix(ci.PipelineURL, "https://") && !strings.HasPrefix(ci.PipelineURL, "http://") ||
+			strings.ContainsAny(ci.PipelineURL, " <>") {
+			ci.PipelineURL = ""
+		}
+		return ci, true
+	}
+	return ciContext{}, false
+}
+
+// currentCIContext detects the CI job from the environment of the request.
+func currentCIContext() (ciContext, bool) {
+	return detectCI(os.Getenv)
+}
+
+// format renders the CI context as request message lines. The values come from the
+// caller's environment, so they are labeled as reported rather than verified.
+func (c ciContext) format() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n**CI:** %s", c.Provider)
+	if c.PipelineURL != "" {
+		fmt.Fprintf(&b, " ([pipeline](<%s>))", c.PipelineURL)
+	}
+	fmt.Fprint(&b, " _(reported by the environment)_")
+	if c.Branch != "" {
+		fmt.Fprintf(&b, "\n**Branch:** `%s`", inlineCode(c.Branch))
+	}
+	if c.Commit != "" {
+		commit := c.Commit
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		fmt.Fprintf(&b, "\n**Commit:** `%s`", inlineCode(commit))
+	}
+	if c.TriggeredBy != "" {
+		fmt.Fprintf(&b, "\n**Triggered by:** `%s`", inlineCode(c.TriggeredBy))
+	}
+	return b.String()
+}
+
+// inlineCode keeps a value from closing the inline code span it is shown in.
+func inlineCode(s string) string {
+	return strings.ReplaceAll(s, "`", "'")
+}
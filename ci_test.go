@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestDetectCI(t *testing.T) {
+	for name, tc := range map[string]struct {
+		env  map[string]string
+		want ciContext
+		ok   bool
+	}{
+		"gitlab": {map[string]string{
+			"GITLAB_CI": "true", "CI": "true", "CI_PIPELINE_URL": "https://gitlab.example.com/ops/infra/-/pipelines/42",
+			"CI_COMMIT_REF_NAME": "main", "CI_COMMIT_SHA": "0123456789abcdef", "GITLAB_USER_LOGIN": "alice",
+		}, ciContext{"GitLab CI", "https://gitlab.example.com/ops/infra/-/pipelines/42", "main", "0123456789abcdef", "alice"}, true},
+		"github": {map[string]string{
+			"GITHUB_ACTIONS": "true", "GITHUB_SERVER_URL": "https://github.com", "GITHUB_REPOSITORY": "ops/infra",
+			"GITHUB_RUN_ID": "7", "GITHUB_REF_NAME": "main", "GITHUB_SHA": "abc", "GITHUB_ACTOR": "bob",
+		}, ciContext{"GitHub Actions", "https://github.com/ops/infra/actions/runs/7", "main", "abc", "bob"}, true},
+		"jenkins": {map[string]string{"JENKINS_URL": "https://ci.example.com/", "BUILD_URL": "https://ci.example.com/job/deploy/3/"},
+			ciContext{Provider: "Jenkins", PipelineURL: "https://ci.example.com/job/deploy/3/"}, true},
+		"generic":        {map[string]string{"CI": "true"}, ciContext{Provider: "CI"}, true},
+		"not a web link": {map[string]string{"CIRCLECI": "true", "CIRCLE_BUILD_URL": "javascript:alert(1)"}, ciContext{Provider: "CircleCI"}, true},
+		"none":           {map[string]string{"CI": "false"}, ciContext{}, false},
+	} {
+		got, ok := detectCI(func(name string) string { return tc.env[name] })
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("%s: detectCI = %+v, %v; want %+v, %v", name, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestCIContextFormat(t *testing.T) {
+	ci := ciContext{"GitLab CI", "https://gitlab.example.com/p/1", "feat`x", "0123456789abcdef", "alice"}
+	want := "\n**CI:** GitLab CI ([pipeline](<https://gitlab.example.com/p/1>)) _(reported by the environment)_" +
+		"\n**Branch:** `feat'x`\n**Commit:** `0123456789ab`\n**Triggered by:** `alice`"
+	if got := ci.format(); got != want {
+		t.Errorf("format = %q, want %q", got, want)
+	}
+}
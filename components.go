@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+// newComponentNonce returns the random nonce bound to the components of a request
+// message. Unlike the request ID, it is never shown, so a component interaction can
+// only carry it if Discord rendered the component from our message.
+func newComponentNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// componentID returns the custom ID of a request message component bound to nonce,
+// e.g. "psd_approve:<nonce>".
+func componentID(base, nonce string) string {
+	return base + ":" + nonce
+}
+
+// parseComponentID returns the base custom ID of a component bound to nonce, and
+// false for components of other requests and forged or replayed interactions.
+func parseComponentID(customID, nonce string) (string, bool) {
+	base, got, ok := strings.Cut(customID, ":")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+		return "", false
+	}
+	return base, true
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestParseComponentID(t *testing.T) {
+	nonce := newComponentNonce()
+	if len(nonce) != 32 || nonce == newComponentNonce() {
+		t.Fatalf("nonce = %q", nonce)
+	}
+	if base, ok := parseComponentID(componentID(buttonApproveID, nonce), nonce); !ok || base != buttonApproveID {
+		t.Errorf("parseComponentID = %q, %v", base, ok)
+	}
+	for _, customID := range []string{
+		buttonApproveID,
+		componentID(buttonApproveID, newComponentNonce()),
+		componentID(buttonApproveID, ""),
+		componentID(buttonDenyID, nonce[:16]),
+	} {
+		if _, ok := parseComponentID(customID, nonce); ok {
+			t.Errorf("%q: expected mismatch", customID)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Custom IDs of the approval modal
+const (
+	confirmModalPrefix = "psd_confirm:"
+	confirmInputID     = "confirmation"
+	totpInputID        = "totp"
+)
+
+// confirmModalID returns the custom ID of the confirmation modal for a request, so a
+// modal submitted for one request cannot approve another.
+func confirmModalID(requestID string) string {
+	return confirmModalPrefix + requestID
+}
+
+// approvalModal asks the approver to type expected (if set) and/or a TOTP code before
+// their approval counts.
+func approvalModal(requestID, expected string, requireTOTP bool) *discordgo.InteractionResponse {
+	var rows []discordgo.MessageComponent
+	if expected != "" {
+		rows = append(rows, discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.TextInput{
+					CustomID:  confirmInputID,
+					Label:     truncateLabel(fmt.Sprintf("Type %q to approve", expected)),
+					Style:     discordgo.TextInputShort,
+					Required:  true,
+					MaxLength: 200,
+				},
+			},
+		})
+	}
+	if requireTOTP {
+		rows = append(rows, discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.TextInput{
+					CustomID:  totpInputID,
+					Label:     "Authenticator code",
+					Style:     discordgo.TextInputShort,
+					Required:  true,
+					MinLength: totpDigits,
+					MaxLength: totpDigits,
+				},
+			},
+		})
+	}
+	return &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID:   confirmModalID(requestID),
+			Title:      "Confirm high-risk approval",
+			Components: rows,
+		},
+	}
+}
+
+// truncateLabel keeps a component label within Discord's 45 character limit.
+func truncateLabel(label string) string {
+	const maxLabel = 45
+	if r := []rune(label); len(r) > maxLabel {
+		return string(r[:maxLabel-1]) + "…"
+	}
+	return label
+}
+
+// modalValue returns the value of the text input with the given custom ID.
+func modalValue(data discordgo.ModalSubmitInteractionData, customID string) string {
+	for _, row := range data.Components {
+		actions, ok := row.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, c := range actions.Components {
+			if input, ok := c.(*discordgo.TextInput); ok && input.CustomID == customID {
+				return input.Value
+			}
+		}
+	}
+	return ""
+}
+
+// confirmationMatches compares what the approver typed with the expected text,
+// ignoring case and surrounding whitespace.
+func confirmationMatches(typed, expected string) bool {
+	return strings.EqualFold(strings.TrimSpace(typed), strings.TrimSpace(expected))
+}
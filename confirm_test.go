@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestConfirmationMatches(t *testing.T) {
+	if !confirmationMatches(" DB-Prod-1 ", "db-prod-1") {
+		t.Error("expected case-insensitive, trimmed match")
+	}
+	if confirmationMatches("db-prod-2", "db-prod-1") {
+		t.Error("expected mismatch")
+	}
+	if confirmationMatches("", "db-prod-1") {
+		t.Error("expected empty input not to match")
+	}
+}
+
+func TestModalValue(t *testing.T) {
+	data := discordgo.ModalSubmitInteractionData{
+		CustomID: confirmModalID("abc"),
+		Components: []discordgo.MessageComponent{
+			&discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					&discordgo.TextInput{CustomID: confirmInputID, Value: "host-1"},
+				},
+			},
+		},
+	}
+	if got := modalValue(data, confirmInputID); got != "host-1" {
+		t.Errorf("modalValue = %q, want host-1", got)
+	}
+	if got := modalValue(data, "missing"); got != "" {
+		t.Errorf("modalValue = %q, want empty", got)
+	}
+}
+
+func TestTruncateLabel(t *testing.T) {
+	long := "Type \"a-very-long-hostname.example.internal\" to approve"
+	if got := []rune(truncateLabel(long)); len(got) != 45 {
+		t.Errorf("truncated label has %d runes, want 45", len(got))
+	}
+	if got := truncateLabel("short"); got != "short" {
+		t.Errorf("truncateLabel = %q", got)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// containerInspectTimeout bounds the `docker inspect` calls describing a request.
+const containerInspectTimeout = 10 * time.Second
+
+// containerCLIs are the commands whose run and exec are described in requests.
+var containerCLIs = []string{"docker", "podman"}
+
+// containerGlobalOptionsWithValue are the global options of docker and podman that
+// take their value as the next argument.
+var containerGlobalOptionsWithValue = []string{"--config", "-c", "--context", "-H", "--host", "-l", "--log-level", "--tlscacert", "--tlscert", "--tlskey", "--connection", "--url", "--identity", "--root", "--runroot"}
+
+// containerBoolOptions are the options of run and exec that take no value; all other
+// options do.
+var containerBoolOptions = []string{
+	"-d", "--detach", "-i", "--interactive", "-t", "--tty", "--rm", "--privileged", "--init",
+	"-P", "--publish-all", "--read-only", "--no-healthcheck", "--oom-kill-disable", "-q", "--quiet",
+	"--disable-content-trust", "--sig-proxy", "--help", "--replace", "--systemd",
+}
+
+// containerHostNamespaces are the namespace options that share the host's namespace
+// with the container when set to "host".
+var containerHostNamespaces = []string{"--pid", "--network", "--net", "--ipc", "--uts", "--userns", "--cgroupns"}
+
+// containerInvocation describes a `docker run` or `docker exec`.
+type containerInvocation struct {
+	// action is run or exec
+	action string
+	// container is the name of a run's container, or the container of an exec
+	container string
+	image     string
+	// digest identifies the image the container runs, if it is known locally
+	digest string
+	mounts []string
+	// risks are the options that give the container the host's privileges or files
+	risks []string
+}
+
+// parseContainerCommand recognizes `docker run` and `docker exec`, also as
+// `docker container run`, and likewise for podman.
+func parseContainerCommand(commandArgs []string) (containerInvocation, bool) {
+	var inv containerInvocation
+	if !slices.Contains(containerCLIs, filepath.Base(commandArgs[0])) {
+		return inv, false
+	}
+	args := commandArgs[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if slices.Contains(containerGlobalOptionsWithValue, args[0]) && len(args) > 1 {
+			args = args[1:]
+		}
+		args = args[1:]
+	}
+	if len(args) > 0 && args[0] == "container" {
+		args = args[1:]
+	}
+	if len(args) == 0 || (args[0] != "run" && args[0] != "exec") {
+		return inv, false
+	}
+	inv.action = args[0]
+	args = args[1:]
+
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "-" {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !hasValue && !containerBoolOption(name) {
+			if len(args) == 0 {
+				break
+			}
+			value, args = args[0], args[1:]
+		}
+		// A cluster of short options such as -it takes a value only for its last letter
+		if !strings.HasPrefix(name, "--") && len(name) > 2 {
+			name = "-" + name[len(name)-1:]
+		}
+		inv.option(name, value, hasValue)
+	}
+	if len(args) == 0 {
+		return inv, false
+	}
+	if inv.action == "run" {
+		inv.image = args[0]
+	} else {
+		inv.container = args[0]
+	}
+	return inv, true
+}
+
+// containerBoolOption reports whether an option, or a cluster of short options, takes
+// no value.
+func containerBoolOption(name string) bool {
+	if slices.Contains(containerBoolOptions, name) {
+		return true
+	}
+	if strings.HasPrefix(name, "--") || len(name) <= 2 {
+		return false
+	}
+	return slices.Contains(containerBoolOptions, "-"+name[len(name)-1:])
+}
+
+// option records what an option of run or exec means for the request.
+func (inv *containerInvocation) option(name, value string, hasValue bool) {
+	switch name {
+	case "--name":
+		inv.container = value
+	case "--privileged":
+		if !hasValue || value == "true" {
+			inv.risks = append(inv.risks, "--privileged")
+		}
+	case "-v", "--volume":
+		inv.mounts = append(inv.mounts, value)
+		// A volume without a source is an anonymous one
+		if source, _, ok := strings.Cut(value, ":"); ok && isHostPath(source) {
+			inv.risks = append(inv.risks, "host mount "+value)
+		}
+	case "--mount":
+		inv.mounts = append(inv.mounts, value)
+		fields := map[string]string{}
+		for _, field := range strings.Split(value, ",") {
+			k, v, _ := strings.Cut(field, "=")
+			fields[k] = v
+		}
+		if fields["type"] == "bind" {
+			inv.risks = append(inv.risks, "host mount "+value)
+		}
+	case "--volumes-from":
+		inv.mounts = append(inv.mounts, "volumes from "+value)
+	case "--cap-add":
+		if v := strings.ToUpper(value); v == "ALL" || v == "SYS_ADMIN" || v == "CAP_SYS_ADMIN" || v == "SYS_PTRACE" || v == "SYS_MODULE" {
+			inv.risks = append(inv.risks, "--cap-add "+value)
+		}
+	case "--device":
+		inv.risks = append(inv.risks, "--device "+value)
+	case "--security-opt":
+		if strings.HasSuffix(value, "unconfined") || value == "label=disable" || value == "label:disable" {
+			inv.risks = append(inv.risks, "--security-opt "+value)
+		}
+	default:
+		if slices.Contains(containerHostNamespaces, name) && value == "host" {
+			inv.risks = append(inv.risks, name+"=host")
+		}
+	}
+}
+
+// isHostPath reports whether the source of a -v mount is a path on the host rather
+// than a named volume.
+func isHostPath(source string) bool {
+	return strings.HasPrefix(source, "/") || strings.HasPrefix(source, ".") || strings.HasPrefix(source, "~")
+}
+
+// containerEnv are the variables passed on to the inspecting CLI, choosing the daemon
+// the request talks to.
+var containerEnv = []string{"DOCKER_HOST", "DOCKER_CONTEXT", "DOCKER_CONFIG", "CONTAINER_HOST", "CONTAINER_CONNECTION"}
+
+// inspect looks up the image of the invocation with cli: the image of the container
+// for exec, or the local digest of the image for run. cli is a name, looked up by
+// containerInspect as a system tool.
+func (inv *containerInvocation) inspect(cli string) error {
+	if inv.action == "exec" {
+		out, err := containerInspect(cli, "inspect", "--format", "{{.Config.Image}} {{.Image}}", inv.container)
+		if err != nil {
+			return err
+		}
+		inv.image, inv.digest, _ = strings.Cut(out, " ")
+		return nil
+	}
+	out, err := containerInspect(cli, "image", "inspect", "--format", "{{join .RepoDigests \" \"}}", inv.image)
+	if err != nil {
+		// Not pulled yet: run pulls whatever the tag points to then
+		return nil
+	}
+	if digests := strings.Fields(out); len(digests) > 0 {
+		inv.digest = digests[0]
+	}
+	return nil
+}
+
+// containerInspect runs the system docker or podman as the requester: the one the
+// request names may be anything, and root would hand the inspection the root daemon.
+func containerInspect(cli string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), containerInspectTimeout)
+	defer cancel()
+	var env []string
+	for _, name := range containerEnv {
+		if v, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+v)
+		}
+	}
+	cmd, err := requesterCommand(ctx, cli, env, args...)
+	if err != nil {
+		return "", err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s %s failed: %w: %s", cli, args[0], err, msg)
+		}
+		return "", fmt.Errorf("%s %s failed: %w", cli, args[0], err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// formatContainerRisks renders the risky options of a container for the request message.
+func formatContainerRisks(risks []string) string {
+	quoted := make([]string, len(risks))
+	for i, r := range risks {
+		quoted[i] = "`" + inlineCode(r) + "`"
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// format renders the invocation as request message lines.
+func (inv containerInvocation) format() string {
+	var b strings.Builder
+	b.WriteString("\n🐳 ")
+	if inv.container != "" {
+		fmt.Fprintf(&b, "**Container:** `%s` · ", inlineCode(inv.container))
+	}
+	image := "unknown"
+	if inv.image != "" {
+		image = fmt.Sprintf("`%s`", inlineCode(inv.image))
+	}
+	fmt.Fprintf(&b, "**Image:** %s", image)
+	switch {
+	case inv.digest != "":
+		fmt.Fprintf(&b, " (`%s`)", inlineCode(inv.digest))
+	case inv.action == "run":
+		b.WriteString(" (not pulled yet)")
+	}
+	if len(inv.mounts) > 0 {
+		quoted := make([]string, len(inv.mounts))
+		for i, m := range inv.mounts {
+			quoted[i] = "`" + inlineCode(m) + "`"
+		}
+		fmt.Fprintf(&b, "\n**Mounts:** %s", strings.Join(quoted, ", "))
+	}
+	return b.String()
+}
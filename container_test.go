@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseContainerCommand(t *testing.T) {
+	for name, tc := range map[string]struct {
+		args []string
+		want containerInvocation
+		ok   bool
+	}{
+		"run": {
+			[]string{"docker", "run", "--rm", "-it", "--name", "debug", "-v", "data:/data", "alpine:3.20", "sh"},
+			containerInvocation{action: "run", container: "debug", image: "alpine:3.20", mounts: []string{"data:/data"}},
+			true,
+		},
+		"privileged": {
+			[]string{"/usr/bin/podman", "run", "--privileged", "--pid=host", "-v", "/:/host", "--cap-add", "SYS_ADMIN", "busybox"},
+			containerInvocation{action: "run", image: "busybox", mounts: []string{"/:/host"},
+				risks: []string{"--privileged", "--pid=host", "host mount /:/host", "--cap-add SYS_ADMIN"}},
+			true,
+		},
+		"bind mount": {
+			[]string{"docker", "container", "run", "--mount", "type=bind,source=/etc,target=/etc", "-v", "/cache", "nginx"},
+			containerInvocation{action: "run", image: "nginx", mounts: []string{"type=bind,source=/etc,target=/etc", "/cache"},
+				risks: []string{"host mount type=bind,source=/etc,target=/etc"}},
+			true,
+		},
+		"exec": {
+			[]string{"docker", "--context", "prod", "exec", "-it", "-u", "0", "web", "sh"},
+			containerInvocation{action: "exec", container: "web"},
+			true,
+		},
+		"other subcommand": {[]string{"docker", "ps"}, containerInvocation{}, false},
+		"other command":    {[]string{"run", "alpine"}, containerInvocation{}, false},
+	} {
+		got, ok := parseContainerCommand(tc.args)
+		if ok != tc.ok || (ok && !reflect.DeepEqual(got, tc.want)) {
+			t.Errorf("%s: parseContainerCommand = %+v, %v; want %+v, %v", name, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestContainerInvocationFormat(t *testing.T) {
+	inv := containerInvocation{action: "run", container: "debug", image: "alpine:3.20", mounts: []string{"/:/host"}}
+	got := inv.format()
+	for _, want := range []string{"**Container:** `debug`", "**Image:** `alpine:3.20` (not pulled yet)", "**Mounts:** `/:/host`"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("format = %q, want it to contain %q", got, want)
+		}
+	}
+	inv.digest = "alpine@sha256:abc"
+	if got := inv.format(); !strings.Contains(got, "(`alpine@sha256:abc`)") {
+		t.Errorf("format = %q, want the digest", got)
+	}
+	if got := formatContainerRisks([]string{"--privileged", "host mount /:/host"}); got != "`--privileged`, `host mount /:/host`" {
+		t.Errorf("formatContainerRisks = %q", got)
+	}
+}
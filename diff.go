@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// maxDiffDisplay keeps the diff from crowding out the rest of the request message
+	maxDiffDisplay = 1000
+	// maxDiffFileSize and maxDiffLines bound the files that are diffed at all
+	maxDiffFileSize = 1 << 20
+	maxDiffLines    = 2000
+	diffContext     = 3
+)
+
+// fileChange is what a command will write to a file.
+type fileChange struct {
+	path     string
+	old, new []byte
+}
+
+// commandOptionsWithValue are the options of the previewed commands that take a
+// separate value, by command.
+var commandOptionsWithValue = map[string][]string{
+	"cp":      {"-S", "--suffix", "-t", "--target-directory"},
+	"install": {"-S", "--suffix", "-t", "--target-directory", "-m", "--mode", "-o", "--owner", "-g", "--group"},
+	"tee":     {"--output-error"},
+}
+
+// splitOperands separates a command's options from its operands. It returns the
+// target directory (-t) and whether to append (tee -a) along with the operands.
+func splitOperands(name string, args []string) (operands []string, targetDir string, appendMode bool) {
+	withValue := commandOptionsWithValue[name]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return append(operands, args[i+1:]...), targetDir, appendMode
+		case strings.HasPrefix(arg, "--target-directory="):
+			targetDir = strings.TrimPrefix(arg, "--target-directory=")
+		case arg == "--append":
+			appendMode = true
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			for _, opt := range withValue {
+				if arg == opt && i+1 < len(args) {
+					i++
+					if opt == "-t" || opt == "--target-directory" {
+						targetDir = args[i]
+					}
+				}
+			}
+			if name == "tee" && !strings.HasPrefix(arg, "--") && strings.Contains(arg, "a") {
+				appendMode = true
+			}
+		default:
+			operands = append(operands, arg)
+		}
+	}
+	return operands, targetDir, appendMode
+}
+
+// plannedChanges works out which files cp, install or tee will write and with what.
+// tee writes its stdin, so it can only be previewed with buffered stdin.
+func plannedChanges(commandArgs []string, stdinData []byte, haveStdin bool) ([]fileChange, error) {
+	name := filepath.Base(commandArgs[0])
+	operands, targetDir, appendMode := splitOperands(name, commandArgs[1:])
+	switch name {
+	case "cp", "install":
+		var sources []string
+		dest := targetDir
+		if dest == "" {
+			if len(operands) < 2 {
+				return nil, fmt.Errorf("%s needs a source and a destination", name)
+			}
+			sources, dest = operands[:len(operands)-1], operands[len(operands)-1]
+		} else {
+			sources = operands
+		}
+		destIsDir := targetDir != "" || len(sources) > 1
+		if info, err := os.Stat(dest); err == nil && info.IsDir() {
+			destIsDir = true
+		}
+		var changes []fileChange
+		for _, src := range sources {
+			data, err := readForDiff(src)
+			if err != nil {
+				return nil, err
+			}
+			path := dest
+			if destIsDir {
+				path = filepath.Join(dest, filepath.Base(src))
+			}
+			old, err := readForDiff(path)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			changes = append(changes, fileChange{path: path, old: old, new: data})
+		}
+		return changes, nil
+	case "tee":
+		if !haveStdin {
+			return nil, fmt.Errorf("tee needs --show-stdin to be previewed")
+		}
+		var changes []fileChange
+		for _, path := range operands {
+			old, err := readForDiff(path)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			data := stdinData
+			if appendMode {
+				data = append(append([]byte{}, old...), stdinData...)
+			}
+			changes = append(changes, fileChange{path: path, old: old, new: data})
+		}
+		return changes, nil
+	}
+	return nil, fmt.Errorf("%s is not a command that can be previewed (cp, install or tee)", name)
+}
+
+// diffReadTimeout bounds reading a file as the requester.
+const diffReadTimeout = 10 * time.Second
+
+// readForDiff reads a file to be shown in a diff. Files the requesting user could
+// not read themselves are refused, so a preview cannot leak them before approval: the
+// file is opened once, without following a final symlink, and its contents are only
+// used if the requester reads the same through the path, directories included.
+func readForDiff(path string) ([]byte, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if errors.Is(err, syscall.ELOOP) {
+		return nil, fmt.Errorf("%s is a symbolic link", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	if info.Size() > maxDiffFileSize {
+		return nil, fmt.Errorf("%s is too large to preview", path)
+	}
+	data, err := io.ReadAll(io.LimitReader(f, maxDiffFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDiffFileSize {
+		return nil, fmt.Errorf("%s is too large to preview", path)
+	}
+
+	account, err := requesterAccount()
+	if err != nil {
+		return nil, fmt.Errorf("requester: %w", err)
+	}
+	if account == nil {
+		// Not run through sudo: the requester is this user
+		return data, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), diffReadTimeout)
+	defer cancel()
+	cmd, err := requesterCommand(ctx, "cat", nil, "--", path)
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s is not readable by the requesting user", path)
+	}
+	// Swapped since it was opened
+	if !bytes.Equal(theirs, data) {
+		return nil, fmt.Errorf("%s changed while it was read", path)
+	}
+	return data, nil
+}
+
+// formatChanges renders the unified diffs of changes for the request message,
+// truncated to fit.
+func formatChanges(changes []fileChange) string {
+	var b strings.Builder
+	for _, c := range changes {
+		b.WriteString(unifiedDiff(c.path, c.old, c.new))
+	}
+	diff := b.String()
+	if diff == "" {
+		return "\n**Diff:** no changes"
+	}
+	if len(diff) > maxDiffDisplay {
+		diff = diff[:maxDiffDisplay] + fmt.Sprintf("\n... (%d bytes truncated)", len(diff)-maxDiffDisplay)
+	}
+	return "\n**Diff:**\n```diff\n" + strings.TrimSuffix(diff, "\n") + "\n```"
+}
+
+// diffOp is one line of an edit script: ' ' kept, '-' removed or '+' added.
+type diffOp struct {
+	kind byte
+	line string
+	// oldIndex and newIndex are the line's position in the old and new file
+	oldIndex, newIndex int
+}
+
+// unifiedDiff returns the unified diff turning old into new, or "" if they are equal.
+func unifiedDiff(path string, old, new []byte) string {
+	if bytes.Equal(old, new) {
+		return ""
+	}
+	oldName := "a" + path
+	if old == nil {
+		oldName = "/dev/null"
+	}
+	header := fmt.Sprintf("--- %s\n+++ b%s\n", oldName, path)
+	if bytes.IndexByte(old, 0) >= 0 || bytes.IndexByte(new, 0) >= 0 {
+		return header + "Binary files differ\n"
+	}
+	a, b := splitLines(old), splitLines(new)
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		return header + "Files are too long to diff\n"
+	}
+
+	var out strings.Builder
+	out.WriteString(header)
+	ops := diffLines(a, b)
+	for k := 0; k < len(ops); {
+		if ops[k].kind == ' ' {
+			k++
+			continue
+		}
+		// A hunk runs from diffContext lines before a change until a gap of more
+		// than twice diffContext unchanged lines
+		start := max(k-diffContext, 0)
+		end := k
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContext {
+				end = min(end+diffContext, len(ops))
+				break
+			}
+			end = run
+		}
+		writeHunk(&out, ops[start:end])
+		k = end
+	}
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, ops []diffOp) {
+	oldCount, newCount := 0, 0
+	for _, op := range ops {
+		if op.kind != '+' {
+			oldCount++
+		}
+		if op.kind != '-' {
+			newCount++
+		}
+	}
+	// An empty range starts at the line before it
+	oldStart, newStart := ops[0].oldIndex, ops[0].newIndex
+	if oldCount > 0 {
+		oldStart++
+	}
+	if newCount > 0 {
+		newStart++
+	}
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+	for _, op := range ops {
+		out.WriteByte(op.kind)
+		out.WriteString(op.line)
+		if !strings.HasSuffix(op.line, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// diffLines computes a shortest edit script between a and b from their longest
+// common subsequence.
+func diffLines(a, b []string) []diffOp {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i], i, j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j], i, j})
+			j++
+		}
+	}
+	return ops
+}
+
+// splitLines splits data into lines, keeping their line endings.
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
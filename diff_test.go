@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	old := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+	new := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm"
+	want := `--- a/etc/x
++++ b/etc/x
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -10,3 +10,4 @@
+ j
+ k
+ l
++m
+\ No newline at end of file
+`
+	if got := unifiedDiff("/etc/x", []byte(old), []byte(new)); got != want {
+		t.Errorf("unifiedDiff =\n%s\nwant\n%s", got, want)
+	}
+	if got := unifiedDiff("/etc/x", []byte(old), []byte(old)); got != "" {
+		t.Errorf("unifiedDiff of equal files = %q", got)
+	}
+	if got := unifiedDiff("/etc/new", nil, []byte("x\n")); got != "--- /dev/null\n+++ b/etc/new\n@@ -0,0 +1,1 @@\n+x\n" {
+		t.Errorf("unifiedDiff of a new file = %q", got)
+	}
+	if got := unifiedDiff("/bin/x", []byte("\x00a"), []byte("\x00b")); !strings.HasSuffix(got, "Binary files differ\n") {
+		t.Errorf("unifiedDiff of binary files = %q", got)
+	}
+}
+
+func TestSplitOperands(t *testing.T) {
+	ops, dir, app := splitOperands("install", []string{"-m", "0644", "-o", "root", "src", "dst"})
+	if strings.Join(ops, " ") != "src dst" || dir != "" || app {
+		t.Errorf("install: %v %q %v", ops, dir, app)
+	}
+	ops, dir, _ = splitOperands("cp", []string{"-p", "--target-directory=/etc", "--", "-weird"})
+	if strings.Join(ops, " ") != "-weird" || dir != "/etc" {
+		t.Errorf("cp: %v %q", ops, dir)
+	}
+	ops, _, app = splitOperands("tee", []string{"-a", "/etc/hosts"})
+	if strings.Join(ops, " ") != "/etc/hosts" || !app {
+		t.Errorf("tee: %v %v", ops, app)
+	}
+}
+
+func TestPlannedChanges(t *testing.T) {
+	t.Setenv("SUDO_UID", "")
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	os.WriteFile(src, []byte("new\n"), 0644)
+	os.WriteFile(dst, []byte("old\n"), 0644)
+
+	changes, err := plannedChanges([]string{"cp", src, dst}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].path != dst || string(changes[0].old) != "old\n" || string(changes[0].new) != "new\n" {
+		t.Errorf("cp changes = %+v", changes)
+	}
+
+	sub := filepath.Join(dir, "sub")
+	os.Mkdir(sub, 0755)
+	changes, err = plannedChanges([]string{"/usr/bin/install", "-m", "0644", src, sub}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].path != filepath.Join(sub, "src") || changes[0].old != nil {
+		t.Errorf("install changes = %+v", changes)
+	}
+
+	changes, err = plannedChanges([]string{"tee", "-a", dst}, []byte("more\n"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || string(changes[0].new) != "old\nmore\n" {
+		t.Errorf("tee changes = %+v", changes)
+	}
+	if _, err := plannedChanges([]string{"tee", dst}, nil, false); err == nil {
+		t.Error("expected error for tee without buffered stdin")
+	}
+	if _, err := plannedChanges([]string{"rm", dst}, nil, false); err == nil {
+		t.Error("expected error for a command that cannot be previewed")
+	}
+}
+
+func TestReadForDiffRequester(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secret")
+	os.WriteFile(path, []byte("x"), 0644)
+	if err := os.Symlink(path, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readForDiff(filepath.Join(dir, "link")); err == nil {
+		t.Error("expected a symlink to be refused")
+	}
+
+	if os.Geteuid() != 0 {
+		t.Skip("reading as another user needs root")
+	}
+	if _, err := resolveRunAs("65534", ""); err != nil {
+		t.Skip("no user 65534")
+	}
+	t.Setenv("SUDO_UID", "65534")
+	// Temporary directories are private, so the file cannot be reached yet
+	if _, err := readForDiff(path); err == nil {
+		t.Error("expected a file in a private directory to be refused")
+	}
+	os.Chmod(filepath.Dir(dir), 0755)
+	os.Chmod(dir, 0755)
+	if data, err := readForDiff(path); err != nil || string(data) != "x" {
+		t.Errorf("world-readable file: %q, %v", data, err)
+	}
+	os.Chmod(path, 0600)
+	if _, err := readForDiff(path); err == nil {
+		t.Error("expected a file private to another user to be refused")
+	}
+}
+
+func TestFormatChanges(t *testing.T) {
+	if got := formatChanges([]fileChange{{path: "/x", old: []byte("a"), new: []byte("a")}}); got != "\n**Diff:** no changes" {
+		t.Errorf("formatChanges = %q", got)
+	}
+	long := strings.Repeat("line\n", 500)
+	got := formatChanges([]fileChange{{path: "/x", new: []byte(long)}})
+	if !strings.HasPrefix(got, "\n**Diff:**\n```diff\n") || !strings.Contains(got, "bytes truncated") || !strings.HasSuffix(got, "\n```") {
+		t.Errorf("formatChanges of a long diff = %q", got)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// selectApproveForID is the custom ID of the menu approving a request for a duration.
+const selectApproveForID = "psd_approve_for"
+
+// untilRebootLifetime bounds how long an "until reboot" approval lasts on hosts that
+// are rarely rebooted.
+const untilRebootLifetime = 30 * 24 * time.Hour
+
+// bootIDPath changes on every boot; tests override it.
+var bootIDPath = "/proc/sys/kernel/random/boot_id"
+
+// approvalDuration is how long an approval chosen from the menu stands.
+type approvalDuration struct {
+	value, label string
+	// window is how long the approval is cached; zero with untilReboot unset means once
+	window      time.Duration
+	untilReboot bool
+}
+
+var approvalDurations = []approvalDuration{
+	{value: "once", label: "Once"},
+	{value: "1h", label: "1 hour", window: time.Hour},
+	{value: "reboot", label: "Until reboot", untilReboot: true},
+}
+
+// findApprovalDuration returns the duration with the given menu value.
+func findApprovalDuration(value string) (approvalDuration, bool) {
+	for _, d := range approvalDurations {
+		if d.value == value {
+			return d, true
+		}
+	}
+	return approvalDuration{}, false
+}
+
+// approvalDurationMenu is the row offering to approve a request for a duration.
+func approvalDurationMenu(nonce string) discordgo.ActionsRow {
+	options := make([]discordgo.SelectMenuOption, len(approvalDurations))
+	for i, d := range approvalDurations {
+		options[i] = discordgo.SelectMenuOption{Label: d.label, Value: d.value}
+	}
+	return discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			discordgo.SelectMenu{
+				MenuType:    discordgo.StringSelectMenu,
+				CustomID:    componentID(selectApproveForID, nonce),
+				Placeholder: "Approve for...",
+				Options:     options,
+			},
+		},
+	}
+}
+
+// bootID identifies the current boot of the host.
+func bootID() (string, error) {
+	data, err := os.ReadFile(bootIDPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read boot ID: %w", err)
+	}
+	id := strings.TrimSpace(string(data))
+	if id == "" {
+		return "", fmt.Errorf("empty boot ID in %s", bootIDPath)
+	}
+	return id, nil
+}
+
+// bootScopedKey binds an approval cache key to a boot, so the approval no longer
+// matches once the host has rebooted.
+func bootScopedKey(key, bootID string) string {
+	sum := sha256.Sum256([]byte(key + "\x00" + bootID))
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheEntry returns the cache key and expiry storing an approval for d, or ok
+// false if d is not cached at all.
+func (d approvalDuration) cacheEntry(key string, now time.Time) (entryKey string, expires time.Time, ok bool, err error) {
+	switch {
+	case d.untilReboot:
+		id, err := bootID()
+		if err != nil {
+			return "", time.Time{}, false, err
+		}
+		return bootScopedKey(key, id), now.Add(untilRebootLifetime), true, nil
+	case d.window > 0:
+		return key, now.Add(d.window), true, nil
+	}
+	return "", time.Time{}, false, nil
+}
+
+// approvalCacheKeys returns the keys an earlier approval of key may be cached under:
+// the key itself and, when the boot is known, its until-reboot variant.
+func approvalCacheKeys(key string) []string {
+	keys := []string{key}
+	if id, err := bootID(); err == nil {
+		keys = append(keys, bootScopedKey(key, id))
+	}
+	return keys
+}
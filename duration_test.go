@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestApprovalDurationMenu(t *testing.T) {
+	menu := approvalDurationMenu("nonce").Components[0].(discordgo.SelectMenu)
+	if base, ok := parseComponentID(menu.CustomID, "nonce"); !ok || base != selectApproveForID {
+		t.Errorf("custom ID = %q", menu.CustomID)
+	}
+	for _, option := range menu.Options {
+		if _, ok := findApprovalDuration(option.Value); !ok {
+			t.Errorf("option %q has no duration", option.Value)
+		}
+	}
+	if _, ok := findApprovalDuration("forever"); ok {
+		t.Error("expected unknown durations to be rejected")
+	}
+}
+
+func TestApprovalDurationCacheEntry(t *testing.T) {
+	bootIDPath = filepath.Join(t.TempDir(), "boot_id")
+	t.Cleanup(func() { bootIDPath = "/proc/sys/kernel/random/boot_id" })
+	now := time.Now()
+
+	once, _ := findApprovalDuration("once")
+	if _, _, ok, err := once.cacheEntry("k", now); ok || err != nil {
+		t.Errorf("once should not be cached: %v, %v", ok, err)
+	}
+
+	hour, _ := findApprovalDuration("1h")
+	key, expires, ok, err := hour.cacheEntry("k", now)
+	if !ok || err != nil || key != "k" || !expires.Equal(now.Add(time.Hour)) {
+		t.Errorf("1h entry = %q, %v, %v, %v", key, expires, ok, err)
+	}
+
+	reboot, _ := findApprovalDuration("reboot")
+	if _, _, _, err := reboot.cacheEntry("k", now); err == nil {
+		t.Error("expected an error without a boot ID")
+	}
+	if keys := approvalCacheKeys("k"); len(keys) != 1 {
+		t.Errorf("keys without a boot ID = %v", keys)
+	}
+
+	if err := os.WriteFile(bootIDPath, []byte("boot-1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	key, _, ok, err = reboot.cacheEntry("k", now)
+	if !ok || err != nil || key != bootScopedKey("k", "boot-1") {
+		t.Errorf("reboot entry = %q, %v, %v", key, ok, err)
+	}
+	if keys := approvalCacheKeys("k"); len(keys) != 2 || keys[1] != key {
+		t.Errorf("keys = %v, want the boot-scoped key", keys)
+	}
+
+	// After a reboot the approval no longer matches
+	if err := os.WriteFile(bootIDPath, []byte("boot-2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if keys := approvalCacheKeys("k"); keys[1] == key {
+		t.Error("expected a new boot to change the key")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// defaultExecEnvDrop is always dropped, on top of exec_env.drop, unless the config
+// explicitly allows loader variables. The dynamic loader honors these for the
+// (non-setuid) approved command, so a caller could otherwise inject code into it.
+var defaultExecEnvDrop = []string{"LD_*"}
+
+// ExecEnvConfig filters the environment passed to approved commands. Names are
+// matched with the same glob patterns as policies.
+type ExecEnvConfig struct {
+	// Drop removes matching variables
+	Drop []string `json:"drop"`
+	// Keep, if set, passes only matching variables (after Drop)
+	Keep []string `json:"keep"`
+	// AllowLoaderVars passes the LD_* variables that are otherwise always dropped
+	AllowLoaderVars bool `json:"allow_loader_vars"`
+}
+
+// scrubEnv filters environ ("NAME=value" entries) according to cfg.
+func scrubEnv(environ []string, cfg *ExecEnvConfig) []string {
+	drop := defaultExecEnvDrop
+	var keep []string
+	if cfg != nil {
+		if cfg.AllowLoaderVars {
+			drop = nil
+		}
+		drop = append(slices.Clip(drop), cfg.Drop...)
+		keep = cfg.Keep
+	}
+	matchesAny := func(name string, patterns []string) bool {
+		for _, p := range patterns {
+			if matchGlob(p, name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	out := []string{}
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if matchesAny(name, drop) {
+			continue
+		}
+		if len(keep) > 0 && !matchesAny(name, keep) {
+			continue
+		}
+		out = append(out, kv)
+	}
+	return out
+}
+
+// defaultShowEnvAllowlist is used for --show-env when the config has no show_env_allowlist.
+var defaultShowEnvAllowlist = []string{"KUBECONFIG", "AWS_PROFILE", "DEPLOY_ENV"}
+
+// formatEnv renders the allowlisted environment variables that are set. Only
+// allowlisted names are ever shown, so secrets in the environment stay out of chat.
+func formatEnv(allowlist []string, lookup func(string) (string, bool)) string {
+	var lines []string
+	for _, name := range allowlist {
+		if value, ok := lookup(name); ok {
+			lines = append(lines, name+"="+value)
+		}
+	}
+	if len(lines) == 0 {
+		return "\n**Env:** none of the allowed variables are set"
+	}
+	return fmt.Sprintf("\n**Env:**\n```\n%s\n```", strings.Join(lines, "\n"))
+}
+
+// showEnv renders the allowlisted variables from the current environment.
+func showEnv(allowlist []string) string {
+	if len(allowlist) == 0 {
+		allowlist = defaultShowEnvAllowlist
+	}
+	return formatEnv(allowlist, os.LookupEnv)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFormatEnv(t *testing.T) {
+	env := map[string]string{"AWS_PROFILE": "prod", "SECRET_TOKEN": "hunter2"}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	got := formatEnv([]string{"KUBECONFIG", "AWS_PROFILE"}, lookup)
+	want := "\n**Env:**\n```\nAWS_PROFILE=prod\n```"
+	if got != want {
+		t.Errorf("formatEnv = %q, want %q", got, want)
+	}
+
+	if got := formatEnv([]string{"KUBECONFIG"}, lookup); got != "\n**Env:** none of the allowed variables are set" {
+		t.Errorf("formatEnv = %q", got)
+	}
+}
+
+func TestScrubEnv(t *testing.T) {
+	environ := []string{"PATH=/usr/bin", "LD_PRELOAD=/tmp/evil.so", "LD_LIBRARY_PATH=/tmp", "HOME=/root", "AWS_SECRET_ACCESS_KEY=x"}
+
+	t.Run("drops loader variables by default", func(t *testing.T) {
+		got := scrubEnv(environ, nil)
+		want := []string{"PATH=/usr/bin", "HOME=/root", "AWS_SECRET_ACCESS_KEY=x"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("scrubEnv = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("drop patterns", func(t *testing.T) {
+		got := scrubEnv(environ, &ExecEnvConfig{Drop: []string{"LD_*", "AWS_*"}})
+		want := []string{"PATH=/usr/bin", "HOME=/root"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("scrubEnv = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("loader variables are dropped with a configured drop list", func(t *testing.T) {
+		got := scrubEnv(environ, &ExecEnvConfig{Drop: []string{"AWS_*"}})
+		want := []string{"PATH=/usr/bin", "HOME=/root"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("scrubEnv = %v, want %v", got, want)
+		}
+		if got := scrubEnv(environ, &ExecEnvConfig{Keep: []string{"LD_*", "PATH"}}); !reflect.DeepEqual(got, []string{"PATH=/usr/bin"}) {
+			t.Errorf("scrubEnv = %v, want loader variables dropped despite keep", got)
+		}
+	})
+
+	t.Run("explicit opt-out passes loader variables", func(t *testing.T) {
+		got := scrubEnv(environ, &ExecEnvConfig{Drop: []string{"AWS_*"}, AllowLoaderVars: true})
+		want := []string{"PATH=/usr/bin", "LD_PRELOAD=/tmp/evil.so", "LD_LIBRARY_PATH=/tmp", "HOME=/root"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("scrubEnv = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("keep only", func(t *testing.T) {
+		got := scrubEnv(environ, &ExecEnvConfig{Drop: []string{"LD_*"}, Keep: []string{"PATH", "HOME", "LD_*"}})
+		want := []string{"PATH=/usr/bin", "HOME=/root"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("scrubEnv = %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const defaultEscalationFraction = 0.5
+
+// EscalationConfig reposts unanswered requests to a secondary channel and pings a
+// fallback approver group, who may then decide the request as well.
+type EscalationConfig struct {
+	ChannelID   string   `json:"channel_id"`
+	ApproverIDs []string `json:"approver_ids"`
+	RoleIDs     []string `json:"role_ids"`
+	// AfterFraction is the fraction of a stage's timeout after which the request escalates
+	AfterFraction float64 `json:"after_fraction"`
+}
+
+func validateEscalation(e *EscalationConfig) error {
+	if e.ChannelID == "" {
+		return fmt.Errorf("escalation.channel_id is required")
+	}
+	if len(e.ApproverIDs) == 0 {
+		return fmt.Errorf("escalation.approver_ids is required")
+	}
+	if e.AfterFraction == 0 {
+		e.AfterFraction = defaultEscalationFraction
+	}
+	if e.AfterFraction <= 0 || e.AfterFraction >= 1 {
+		return fmt.Errorf("escalation.after_fraction must be between 0 and 1")
+	}
+	return nil
+}
+
+// after returns how long a stage waits before escalating.
+func (e *EscalationConfig) after(stageTimeout time.Duration) time.Duration {
+	return time.Duration(float64(stageTimeout) * e.AfterFraction)
+}
+
+// formatEscalation renders the notice put above an escalated request.
+func formatEscalation(e *EscalationConfig, waited time.Duration) string {
+	return fmt.Sprintf("🚨 **Escalated:** no response after %s. %s", waited.Round(time.Second), escalationMentions(e))
+}
+
+// formatManualEscalation renders the notice put above a request escalated with the
+// Escalate button.
+func formatManualEscalation(e *EscalationConfig, userID string) string {
+	return fmt.Sprintf("🚨 **Escalated** by <@%s>. %s", userID, escalationMentions(e))
+}
+
+// stageApprover reports whether userID may decide the stage at stageIdx: one of the
+// stage's approvers, or an escalation approver if this very stage escalated. Later
+// stages still need their own approvers, so a single escalation approver cannot clear
+// a staged policy alone.
+func stageApprover(userID string, stage ApprovalStage, stageIdx, escalatedStage int, e *EscalationConfig) bool {
+	if isApprover(userID, stage.ApproverIDs) {
+		return true
+	}
+	return e != nil && stageIdx == escalatedStage && isApprover(userID, e.ApproverIDs)
+}
+
+func escalationMentions(e *EscalationConfig) string {
+	var mentions []string
+	for _, id := range e.RoleIDs {
+		mentions = append(mentions, fmt.Sprintf("<@&%s>", id))
+	}
+	for _, id := range e.ApproverIDs {
+		mentions = append(mentions, fmt.Sprintf("<@%s>", id))
+	}
+	return strings.Join(mentions, " ")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateEscalation(t *testing.T) {
+	e := &EscalationConfig{ChannelID: "1", ApproverIDs: []string{"2"}}
+	if err := validateEscalation(e); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e.AfterFraction != defaultEscalationFraction {
+		t.Errorf("after_fraction = %v, want default %v", e.AfterFraction, defaultEscalationFraction)
+	}
+	if got := e.after(10 * time.Minute); got != 5*time.Minute {
+		t.Errorf("after(10m) = %v, want 5m", got)
+	}
+
+	if err := validateEscalation(&EscalationConfig{ChannelID: "1", ApproverIDs: []string{"2"}, AfterFraction: 1.5}); err == nil {
+		t.Error("expected error for after_fraction >= 1")
+	}
+	if err := validateEscalation(&EscalationConfig{ChannelID: "1"}); err == nil {
+		t.Error("expected error for missing approver_ids")
+	}
+}
+
+func TestFormatEscalation(t *testing.T) {
+	e := &EscalationConfig{ApproverIDs: []string{"10"}, RoleIDs: []string{"20"}}
+	got := formatEscalation(e, 150*time.Second)
+	want := "🚨 **Escalated:** no response after 2m30s. <@&20> <@10>"
+	if got != want {
+		t.Errorf("formatEscalation = %q, want %q", got, want)
+	}
+	if got := formatManualEscalation(e, "30"); got != "🚨 **Escalated** by <@30>. <@&20> <@10>" {
+		t.Errorf("formatManualEscalation = %q", got)
+	}
+}
+
+func TestStageApprover(t *testing.T) {
+	e := &EscalationConfig{ApproverIDs: []string{"9"}}
+	stages := []ApprovalStage{
+		{Name: "peer", ApproverIDs: []string{"1"}},
+		{Name: "senior", ApproverIDs: []string{"2"}},
+	}
+	tests := []struct {
+		name           string
+		user           string
+		stageIdx       int
+		escalatedStage int
+		want           bool
+	}{
+		{"stage approver", "1", 0, -1, true},
+		{"other stage's approver", "2", 0, -1, false},
+		{"escalation approver before escalating", "9", 0, -1, false},
+		{"escalation approver on the escalated stage", "9", 0, 0, true},
+		// The senior stage still needs a senior approver
+		{"escalation approver on a later stage", "9", 1, 0, false},
+		{"later stage's approver", "2", 1, 0, true},
+	}
+	for _, tt := range tests {
+		if got := stageApprover(tt.user, stages[tt.stageIdx], tt.stageIdx, tt.escalatedStage, e); got != tt.want {
+			t.Errorf("%s: stageApprover = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if stageApprover("9", stages[0], 0, 0, nil) {
+		t.Error("escalation approver accepted without escalation configured")
+	}
+}
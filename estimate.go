@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultEstimatorTimeout = 10
+	// maxEstimateDisplay keeps the estimate from crowding out the rest of the request message
+	maxEstimateDisplay = 500
+)
+
+// cloudCLIs are the commands whose invocations are passed to the cost estimator.
+var cloudCLIs = []string{"aws", "gcloud", "az"}
+
+// CostEstimatorConfig configures the hook called for recognized cloud CLI commands.
+// The hook is run as Command followed by the wrapped command's arguments, and its
+// stdout is attached to the request.
+type CostEstimatorConfig struct {
+	Command        []string `json:"command"`
+	TimeoutSeconds int      `json:"timeout_seconds"`
+}
+
+// isCloudCLI reports whether the command invokes a recognized cloud CLI.
+func isCloudCLI(commandArgs []string) bool {
+	name := filepath.Base(commandArgs[0])
+	for _, cli := range cloudCLIs {
+		if name == cli {
+			return true
+		}
+	}
+	return false
+}
+
+// estimateCost runs the estimator hook for commandArgs and returns its trimmed output,
+// with secrets masked: it is posted, and the hook sees the unmasked arguments.
+func estimateCost(estimator *CostEstimatorConfig, commandArgs []string, redactions []*regexp.Regexp) (string, error) {
+	timeoutSec := estimator.TimeoutSeconds
+	if timeoutSec <= 0 {
+		timeoutSec = defaultEstimatorTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutSec)*time.Second)
+	defer cancel()
+
+	args := append(append([]string{}, estimator.Command[1:]...), commandArgs...)
+	cmd := exec.CommandContext(ctx, estimator.Command[0], args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+
+	// Masking comes first, so truncation cannot cut a secret out of its pattern
+	estimate := redact(strings.TrimSpace(stdout.String()), redactions)
+	if len(estimate) > maxEstimateDisplay {
+		estimate = estimate[:maxEstimateDisplay] + "\n... (truncated)"
+	}
+	return estimate, nil
+}
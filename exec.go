@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// execStopGrace is how long a command stopped at its execution timeout gets to exit
+// after SIGTERM before it is killed.
+const execStopGrace = 10 * time.Second
+
+// errExecTimeout is returned for a command stopped at its execution timeout.
+var errExecTimeout = errors.New("execution timed out")
+
+// execSpec describes how an approved command is run.
+type execSpec struct {
+	args []string
+	env  []string
+	// pipeStdin makes the command run as a child process fed with stdinData,
+	// instead of replacing this process and inheriting stdin
+	pipeStdin bool
+	stdinData []byte
+	// stdinRest is the unread rest of stdin, streamed after stdinData
+	stdinRest io.Reader
+	// term is the caller's terminal; if set, the command runs as a child process on
+	// a pseudo-terminal proxied to it
+	term *os.File
+	// capture keeps a copy of the command's output, which makes it run as a child process
+	capture *outputCapture
+	// live receives the combined output while the command runs, which makes it run
+	// as a child process
+	live io.Writer
+	// transcript records the terminal output of a session
+	transcript io.Writer
+	// runAs is the identity the command runs as, instead of root
+	runAs *runAsIdentity
+	// cgroup limits the command's resources, which makes it run as a child process
+	cgroup *cgroup
+	// timeout bounds the command's runtime, which makes it run as a child process
+	timeout time.Duration
+	// background runs an enqueued request's command as a child process, so its exit
+	// is audited and reported
+	background bool
+	// onTimeout is called when the command is stopped at its timeout
+	onTimeout func()
+	// stats collects figures about the command when it runs as a child process
+	stats *execStats
+	// binary is the fingerprinted executable, verified right before it runs
+	binary *binaryFingerprint
+	// onStart is called right before the command starts, or replaces this process
+	onStart func(replaced bool)
+	// onExit is called with the exit code when the command ran as a child process
+	onExit func(exitCode int)
+}
+
+// start reports that the command is about to start.
+func (s execSpec) start(replaced bool) {
+	if s.stats != nil {
+		s.stats.started = time.Now()
+	}
+	if s.onStart != nil {
+		s.onStart(replaced)
+	}
+}
+
+// supervised reports whether the command has to run as a child process instead of
+// replacing this process.
+func (s execSpec) supervised() bool {
+	return s.pipeStdin || s.term != nil || s.capture != nil || s.live != nil || s.cgroup != nil || s.timeout > 0 || s.background
+}
+
+// exit reports the command's exit code and exits with it.
+func (s execSpec) exit(exitCode int) {
+	s.cgroup.remove()
+	if s.onExit != nil {
+		s.onExit(exitCode)
+	}
+	os.Exit(exitCode)
+}
+
+// exitCode returns the exit code for the error returned by running a command, or
+// false if the command could not be run at all.
+func exitCode(err error) (int, bool) {
+	if err == nil {
+		return 0, true
+	}
+	if errors.Is(err, errExecTimeout) {
+		return exitExecTimeout, true
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), true
+	}
+	return 0, false
+}
+
+// buffered returns the buffered stdin, followed by the streamed rest of it.
+func (s execSpec) buffered() io.Reader {
+	if s.stdinRest != nil {
+		return io.MultiReader(bytes.NewReader(s.stdinData), s.stdinRest)
+	}
+	return bytes.NewReader(s.stdinData)
+}
+
+// stdin returns the reader the command should get as stdin.
+func (s execSpec) stdin() io.Reader {
+	if s.pipeStdin {
+		return s.buffered()
+	}
+	return os.Stdin
+}
+
+// resolve returns the path of the executable to run. A fingerprinted executable is
+// run from its pinned path, and only if it is unchanged.
+func (s execSpec) resolve() (string, error) {
+	if s.binary == nil {
+		return exec.LookPath(s.args[0])
+	}
+	if err := s.binary.verify(); err != nil {
+		return "", err
+	}
+	return s.binary.path, nil
+}
+
+// command builds an exec.Cmd for running the executable at path as a child process.
+func (s execSpec) command(path string) *exec.Cmd {
+	cmd := exec.Command(path, s.args[1:]...)
+	cmd.Args[0] = s.args[0]
+	cmd.Env = s.env
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	if s.runAs != nil {
+		cmd.SysProcAttr.Credential = s.runAs.credential()
+	}
+	if s.cgroup != nil {
+		s.cgroup.attach(cmd.SysProcAttr)
+	}
+	cmd.Stdin = s.stdin()
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stdout, stderr := []io.Writer{os.Stdout}, []io.Writer{os.Stderr}
+	if s.capture != nil {
+		stdout = append(stdout, s.capture.stdout)
+		stderr = append(stderr, s.capture.stderr)
+	}
+	if s.live != nil {
+		stdout = append(stdout, s.live)
+		stderr = append(stderr, s.live)
+	}
+	// Output is only piped, and so counted, when something else follows it; the
+	// command otherwise writes to this process's stdout and stderr directly
+	if len(stdout) > 1 {
+		if s.stats != nil {
+			s.stats.counted = true
+			stdout = append(stdout, s.stats)
+			stderr = append(stderr, s.stats)
+		}
+		cmd.Stdout = io.MultiWriter(stdout...)
+		cmd.Stderr = io.MultiWriter(stderr...)
+	}
+	return cmd
+}
+
+// wait waits for a started command, stopping it once its timeout passes: SIGTERM
+// first, then SIGKILL if it has not exited after execStopGrace.
+func (s execSpec) wait(cmd *exec.Cmd) error {
+	timedOut := make(chan struct{})
+	if s.timeout > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			timer := time.NewTimer(s.timeout)
+			defer timer.Stop()
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+			}
+			close(timedOut)
+			slog.Warn("execution timed out, stopping command", "timeout", s.timeout)
+			if s.onTimeout != nil {
+				s.onTimeout()
+			}
+			cmd.Process.Signal(syscall.SIGTERM)
+			select {
+			case <-done:
+			case <-time.After(execStopGrace):
+				cmd.Process.Kill()
+			}
+		}()
+	}
+	err := cmd.Wait()
+	s.stats.collect(cmd.ProcessState)
+	select {
+	case <-timedOut:
+		return errExecTimeout
+	default:
+		return err
+	}
+}
+
+// runCommand executes an approved command and never returns. The current process is
+// replaced by the command unless it has to be supervised (see execSpec.supervised).
+func runCommand(spec execSpec) {
+	execPath, err := spec.resolve()
+	if err != nil {
+		slog.Error("failed to find executable", "err", err)
+		os.Exit(exitNotFound)
+	}
+
+	if spec.supervised() {
+		// Supervise the command to pipe buffered stdin to it, proxy its pseudo-terminal,
+		// follow its output or limit its resources
+		spec.start(false)
+		if spec.term != nil {
+			out := []io.Writer{os.Stdout}
+			if spec.capture != nil {
+				spec.capture.combined = true
+				out = append(out, spec.capture.stdout)
+			}
+			if spec.live != nil {
+				out = append(out, spec.live)
+			}
+			if spec.transcript != nil {
+				out = append(out, spec.transcript)
+			}
+			if spec.stats != nil {
+				spec.stats.counted = true
+				out = append(out, spec.stats)
+			}
+			err = spec.runOnPTY(execPath, spec.term, io.MultiWriter(out...))
+		} else {
+			cmd := spec.command(execPath)
+			if err = cmd.Start(); err == nil {
+				err = spec.wait(cmd)
+			}
+		}
+		code, ok := exitCode(err)
+		if !ok {
+			slog.Error("failed to execute command", "err", err)
+			spec.cgroup.remove()
+			os.Exit(exitCannotExecute)
+		}
+		spec.exit(code)
+	}
+
+	// Replace current process with the command
+	if spec.runAs != nil {
+		if err := spec.runAs.become(); err != nil {
+			slog.Error("failed to switch user", "user", spec.runAs.String(), "err", err)
+			os.Exit(exitCannotExecute)
+		}
+	}
+	spec.start(true)
+	err = syscall.Exec(execPath, spec.args, spec.env)
+	if err != nil {
+		slog.Error("failed to execute command", "err", err)
+		os.Exit(exitCannotExecute)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+// Exit codes. An approved command that runs exits with its own exit code, so these
+// are picked from outside the range commonly used by commands.
+const (
+	// exitDenied: the request was denied by an approver or by policy
+	exitDenied = 70
+	// exitTimeout: no decision was made before the request timed out
+	exitTimeout = 71
+	// exitConfigError: invalid flags or configuration
+	exitConfigError = 72
+	// exitDiscordError: the request could not be posted to Discord
+	exitDiscordError = 73
+	// exitInternalError: a local failure, e.g. the audit record could not be written
+	exitInternalError = 74
+	// exitCancelled: the request was withdrawn, by an approver with /psd cancel or
+	// with the cancel subcommand
+	exitCancelled = 75
+	// exitExecTimeout: the approved command was stopped at --exec-timeout, as with
+	// timeout(1)
+	exitExecTimeout = 124
+	// exitCannotExecute and exitNotFound mirror the shell's codes for an approved
+	// command that could not be run
+	exitCannotExecute = 126
+	exitNotFound      = 127
+	// exitInterrupted: interrupted (SIGINT or SIGTERM) before the request was decided
+	exitInterrupted = 130
+)
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Fallback step types
+const (
+	fallbackStepWebhook = "webhook"
+	fallbackStepEmail   = "email"
+)
+
+const (
+	fallbackDecidePath    = "/psd/decide"
+	fallbackNotifyTimeout = 10 * time.Second
+	// fallbackMinSecret is the minimum length of the link signing secret
+	fallbackMinSecret = 32
+)
+
+// FallbackConfig decides requests outside Discord when it is unreachable: the request
+// is sent through the first step of the chain that succeeds, with signed approval
+// links served by a local HTTP endpoint.
+type FallbackConfig struct {
+	// Listen is the address of the approval endpoint, e.g. ":8470"
+	Listen string `json:"listen"`
+	// BaseURL is how approvers reach the endpoint, e.g. "https://host.example:8470"
+	BaseURL string `json:"base_url"`
+	// Secret signs the approval links
+	Secret string `json:"secret"`
+	// TLSCert and TLSKey serve the endpoint over HTTPS
+	TLSCert string         `json:"tls_cert"`
+	TLSKey  string         `json:"tls_key"`
+	Chain   []FallbackStep `json:"chain"`
+}
+
+// FallbackStep is one way of delivering a request when Discord is unreachable. Every
+// recipient is tied to a local user, so the requester is never sent a link.
+type FallbackStep struct {
+	Type string `json:"type"`
+	// URL receives a JSON POST (webhook), and User is the local user it reaches
+	URL  string `json:"url"`
+	User string `json:"user"`
+	// SMTPAddr, From and To address the email (email); Username and Password are optional
+	SMTPAddr string   `json:"smtp_addr"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	// Users maps each address in To to the local user reading it (email)
+	Users map[string]string `json:"users"`
+}
+
+// recipients returns who the step sends links to, as recorded in the audit log, leaving
+// out the requesters.
+func (step FallbackStep) recipients(requesters []string) []string {
+	var approvers []string
+	switch step.Type {
+	case fallbackStepWebhook:
+		if !isApprover(step.User, requesters) {
+			approvers = append(approvers, fallbackStepWebhook+":"+step.User)
+		}
+	case fallbackStepEmail:
+		for _, to := range step.To {
+			if !isApprover(step.Users[to], requesters) {
+				approvers = append(approvers, fallbackStepEmail+":"+to)
+			}
+		}
+	}
+	return approvers
+}
+
+func validateFallback(f *FallbackConfig) error {
+	if f.Listen == "" {
+		return fmt.Errorf("fallback.listen is required")
+	}
+	u, err := url.Parse(f.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("fallback.base_url must be an http(s) URL")
+	}
+	if len(f.Secret) < fallbackMinSecret {
+		return fmt.Errorf("fallback.secret must be at least %d characters", fallbackMinSecret)
+	}
+	if (f.TLSCert == "") != (f.TLSKey == "") {
+		return fmt.Errorf("fallback.tls_cert and fallback.tls_key must be set together")
+	}
+	if len(f.Chain) == 0 {
+		return fmt.Errorf("fallback.chain is required")
+	}
+	for i, step := range f.Chain {
+		switch step.Type {
+		case fallbackStepWebhook:
+			if step.URL == "" || step.User == "" {
+				return fmt.Errorf("fallback.chain[%d]: url and user are required", i)
+			}
+		case fallbackStepEmail:
+			if step.SMTPAddr == "" || step.From == "" || len(step.To) == 0 {
+				return fmt.Errorf("fallback.chain[%d]: smtp_addr, from and to are required", i)
+			}
+			for _, to := range step.To {
+				if step.Users[to] == "" {
+					return fmt.Errorf("fallback.chain[%d].users has no local user for %s", i, to)
+				}
+			}
+		default:
+			return fmt.Errorf("fallback.chain[%d].type must be %q or %q", i, fallbackStepWebhook, fallbackStepEmail)
+		}
+	}
+	return nil
+}
+
+// fallbackRequest is what approvers are shown outside Discord.
+type fallbackRequest struct {
+	ID        string
+	Command   string
+	Host      string
+	CWD       string
+	Requester string
+	// Requesters are the local names of the requester, who must not receive links
+	Requesters []string
+	Expires    time.Time
+}
+
+// fallbackToken is the signed content of an approval link.
+type fallbackToken struct {
+	RequestID string `json:"r"`
+	Action    string `json:"a"`
+	Approver  string `json:"by"`
+	Expires   int64  `json:"exp"`
+}
+
+// Fallback link actions
+const (
+	fallbackApprove = "approve"
+	fallbackDeny    = "deny"
+)
+
+func (f *FallbackConfig) sign(tok fallbackToken) string {
+	payload, _ := json.Marshal(tok)
+	mac := hmac.New(sha256.New, []byte(f.Secret))
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks a link's signature and expiry, that it belongs to req, and that it was
+// sent to a configured recipient other than the requester.
+func (f *FallbackConfig) verify(s string, req fallbackRequest, now time.Time) (fallbackToken, error) {
+	var tok fallbackToken
+	payloadPart, sigPart, ok := strings.Cut(s, ".")
+	if !ok {
+		return tok, errors.New("malformed link")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(payloadPart)
+	if err != nil {
+		return tok, errors.New("malformed link")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil {
+		return tok, errors.New("malformed link")
+	}
+	mac := hmac.New(sha256.New, []byte(f.Secret))
+	mac.Write(payload)
+	if subtle.ConstantTimeCompare(sig, mac.Sum(nil)) != 1 {
+		return tok, errors.New("invalid signature")
+	}
+	if err := json.Unmarshal(payload, &tok); err != nil {
+		return tok, errors.New("malformed link")
+	}
+	if tok.RequestID != req.ID {
+		return tok, errors.New("link is for another request")
+	}
+	if !isApprover(tok.Approver, f.recipients(req.Requesters)) {
+		return tok, errors.New("link is not for an approver")
+	}
+	if now.Unix() > tok.Expires {
+		return tok, errors.New("link has expired")
+	}
+	if tok.Action != fallbackApprove && tok.Action != fallbackDeny {
+		return tok, errors.New("unknown action")
+	}
+	return tok, nil
+}
+
+// recipients returns who the chain sends links to, leaving out the requesters.
+func (f *FallbackConfig) recipients(requesters []string) []string {
+	var approvers []string
+	for _, step := range f.Chain {
+		approvers = append(approvers, step.recipients(requesters)...)
+	}
+	return approvers
+}
+
+// link returns the signed URL deciding req as approver.
+func (f *FallbackConfig) link(req fallbackRequest, action, approver string) string {
+	tok := f.sign(fallbackToken{RequestID: req.ID, Action: action, Approver: approver, Expires: req.Expires.Unix()})
+	return strings.TrimSuffix(f.BaseURL, "/") + fallbackDecidePath + "?token=" + url.QueryEscape(tok)
+}
+
+// fallbackVote is a decision made through an approval link.
+type fallbackVote struct {
+	action   string
+	approver string
+}
+
+// handler serves the approval links. Opening a link only shows the request; the
+// decision is a POST, so link scanners and previews cannot decide it.
+func (f *FallbackConfig) handler(req fallbackRequest, votes chan<- fallbackVote) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(fallbackDecidePath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		token := r.FormValue("token")
+		tok, err := f.verify(token, req, time.Now())
+		if err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, "<p>%s</p>", html.EscapeString(err.Error()))
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			fmt.Fprintf(w, `<h1>Sudo Request</h1><pre>%s</pre><p>Host: %s<br>CWD: %s<br>Requester: %s<br>Request ID: %s</p>`+
+				`<form method="post"><input type="hidden" name="token" value="%s"><button type="submit">%s</button></form>`,
+				html.EscapeString(req.Command), html.EscapeString(req.Host), html.EscapeString(req.CWD),
+				html.EscapeString(req.Requester), html.EscapeString(req.ID), html.EscapeString(token),
+				map[string]string{fallbackApprove: "Approve", fallbackDeny: "Deny"}[tok.Action])
+		case http.MethodPost:
+			select {
+			case votes <- fallbackVote{action: tok.Action, approver: tok.Approver}:
+				fmt.Fprintf(w, "<p>Request %s: %s.</p>", html.EscapeString(req.ID), map[string]string{fallbackApprove: "approved", fallbackDeny: "denied"}[tok.Action])
+			default:
+				w.WriteHeader(http.StatusConflict)
+				fmt.Fprint(w, "<p>The request has already been decided.</p>")
+			}
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	return mux
+}
+
+// notify sends req through the first step of the chain that succeeds.
+func (f *FallbackConfig) notify(req fallbackRequest) error {
+	var errs []error
+	for i, step := range f.Chain {
+		var err error
+		switch step.Type {
+		case fallbackStepWebhook:
+			err = f.notifyWebhook(step, req)
+		case fallbackStepEmail:
+			err = f.notifyEmail(step, req)
+		}
+		if err == nil {
+			slog.Info("request sent through fallback", "step", i, "type", step.Type)
+			return nil
+		}
+		slog.Warn("fallback step failed", "step", i, "type", step.Type, "err", err)
+		errs = append(errs, fmt.Errorf("%s: %w", step.Type, err))
+	}
+	return fmt.Errorf("every fallback step failed: %w", errors.Join(errs...))
+}
+
+func (f *FallbackConfig) notifyWebhook(step FallbackStep, req fallbackRequest) error {
+	recipients := step.recipients(req.Requesters)
+	if len(recipients) == 0 {
+		return errors.New("the webhook reaches the requester")
+	}
+	data, _ := json.Marshal(map[string]any{
+		"request_id":  req.ID,
+		"command":     req.Command,
+		"host":        req.Host,
+		"cwd":         req.CWD,
+		"requester":   req.Requester,
+		"approve_url": f.link(req, fallbackApprove, recipients[0]),
+		"deny_url":    f.link(req, fallbackDeny, recipients[0]),
+		"expires_at":  req.Expires,
+	})
+	client := &http.Client{Timeout: fallbackNotifyTimeout}
+	resp, err := client.Post(step.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// notifyEmail mails each recipient other than the requester their own links, succeeding
+// if any mail is accepted.
+func (f *FallbackConfig) notifyEmail(step FallbackStep, req fallbackRequest) error {
+	recipients := step.recipients(req.Requesters)
+	if len(recipients) == 0 {
+		return errors.New("every recipient is the requester")
+	}
+	var auth smtp.Auth
+	if step.Username != "" {
+		host, _, _ := net.SplitHostPort(step.SMTPAddr)
+		auth = smtp.PlainAuth("", step.Username, step.Password, host)
+	}
+	var errs []error
+	for _, approver := range recipients {
+		to := strings.TrimPrefix(approver, fallbackStepEmail+":")
+		if err := smtp.SendMail(step.SMTPAddr, auth, step.From, []string{to}, f.email(step.From, to, req)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", to, err))
+		}
+	}
+	if len(errs) == len(recipients) {
+		return errors.Join(errs...)
+	}
+	for _, err := range errs {
+		slog.Warn("failed to email fallback approver", "err", err)
+	}
+	return nil
+}
+
+// email renders the approval mail for one recipient.
+func (f *FallbackConfig) email(from, to string, req fallbackRequest) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\nTo: %s\r\nSubject: Sudo request %s on %s\r\n", from, to, req.ID, req.Host)
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "Discord is unreachable, so this request is sent by email.\r\n\r\n")
+	fmt.Fprintf(&b, "Command: %s\r\nHost: %s\r\nCWD: %s\r\nRequester: %s\r\nRequest ID: %s\r\n\r\n", req.Command, req.Host, req.CWD, req.Requester, req.ID)
+	fmt.Fprintf(&b, "Approve: %s\r\n\r\nDeny: %s\r\n\r\n", f.link(req, fallbackApprove, "email:"+to), f.link(req, fallbackDeny, "email:"+to))
+	fmt.Fprintf(&b, "The links expire at %s.\r\n", req.Expires.UTC().Format(time.RFC1123))
+	return []byte(b.String())
+}
+
+// decide serves the approval endpoint, sends req through the chain and waits for a
+// decision. It returns the audit decision and, if decided through a link, who made it.
+func (f *FallbackConfig) decide(req fallbackRequest) (string, string, error) {
+	votes := make(chan fallbackVote, 1)
+	ln, err := net.Listen("tcp", f.Listen)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to listen on %s: %w", f.Listen, err)
+	}
+	srv := &http.Server{Handler: f.handler(req, votes), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		var err error
+		if f.TLSCert != "" {
+			err = srv.ServeTLS(ln, f.TLSCert, f.TLSKey)
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("fallback approval endpoint failed", "err", err)
+		}
+	}()
+	defer srv.Close()
+
+	if err := f.notify(req); err != nil {
+		return "", "", err
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	timer := time.NewTimer(time.Until(req.Expires))
+	defer timer.Stop()
+	select {
+	case v := <-votes:
+		if v.action == fallbackApprove {
+			return auditDecisionApproved, v.approver, nil
+		}
+		return auditDecisionDenied, v.approver, nil
+	case <-timer.C:
+		return auditDecisionTimeout, "", nil
+	case <-sigCh:
+		return auditDecisionInterrupted, "", nil
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testFallbackConfig(webhookURLs ...string) *FallbackConfig {
+	f := &FallbackConfig{
+		Listen:  "127.0.0.1:0",
+		BaseURL: "https://psd.example:8470/",
+		Secret:  strings.Repeat("s", fallbackMinSecret),
+	}
+	for _, u := range webhookURLs {
+		f.Chain = append(f.Chain, FallbackStep{Type: fallbackStepWebhook, URL: u, User: "oncall"})
+	}
+	return f
+}
+
+func TestValidateFallback(t *testing.T) {
+	if err := validateFallback(testFallbackConfig("http://hook")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	short := testFallbackConfig("http://hook")
+	short.Secret = "short"
+	if err := validateFallback(short); err == nil {
+		t.Error("expected error for short secret")
+	}
+	if err := validateFallback(testFallbackConfig()); err == nil {
+		t.Error("expected error for empty chain")
+	}
+	email := testFallbackConfig()
+	email.Chain = []FallbackStep{{Type: fallbackStepEmail, SMTPAddr: "smtp:25"}}
+	if err := validateFallback(email); err == nil {
+		t.Error("expected error for email step without from/to")
+	}
+	email.Chain = []FallbackStep{{Type: fallbackStepEmail, SMTPAddr: "smtp:25", From: "psd@example.com", To: []string{"a@example.com"}}}
+	if err := validateFallback(email); err == nil {
+		t.Error("expected error for email recipient without a local user")
+	}
+	anonymous := testFallbackConfig("http://hook")
+	anonymous.Chain[0].User = ""
+	if err := validateFallback(anonymous); err == nil {
+		t.Error("expected error for webhook without a local user")
+	}
+}
+
+func TestFallbackRecipients(t *testing.T) {
+	f := testFallbackConfig("http://hook")
+	f.Chain = append(f.Chain, FallbackStep{
+		Type:  fallbackStepEmail,
+		To:    []string{"alice@example.com", "bob@example.com"},
+		Users: map[string]string{"alice@example.com": "alice", "bob@example.com": "bob"},
+	})
+	if got := strings.Join(f.recipients([]string{"alice"}), ","); got != "webhook:oncall,email:bob@example.com" {
+		t.Errorf("recipients = %s", got)
+	}
+	if got := strings.Join(f.recipients([]string{"oncall"}), ","); got != "email:alice@example.com,email:bob@example.com" {
+		t.Errorf("recipients = %s", got)
+	}
+
+	// A link minted for the requester does not decide their own request
+	now := time.Now()
+	req := fallbackRequest{ID: "req", Requesters: []string{"alice"}, Expires: now.Add(time.Minute)}
+	u, _ := url.Parse(f.link(req, fallbackApprove, "email:alice@example.com"))
+	if _, err := f.verify(u.Query().Get("token"), req, now); err == nil {
+		t.Error("expected error for the requester's own link")
+	}
+	u, _ = url.Parse(f.link(req, fallbackApprove, "email:mallory@example.com"))
+	if _, err := f.verify(u.Query().Get("token"), req, now); err == nil {
+		t.Error("expected error for a link to an unknown recipient")
+	}
+	if err := testFallbackConfig("http://hook").notify(fallbackRequest{ID: "req", Requesters: []string{"oncall"}}); err == nil {
+		t.Error("expected error when the webhook reaches the requester")
+	}
+}
+
+func TestFallbackLinks(t *testing.T) {
+	f := testFallbackConfig()
+	f.Chain = []FallbackStep{{Type: fallbackStepEmail, To: []string{"a@example.com"}, Users: map[string]string{"a@example.com": "alice"}}}
+	now := time.Now()
+	req := fallbackRequest{ID: "req", Expires: now.Add(time.Minute)}
+	link := f.link(req, fallbackApprove, "email:a@example.com")
+	if !strings.HasPrefix(link, "https://psd.example:8470/psd/decide?token=") {
+		t.Fatalf("unexpected link: %s", link)
+	}
+	u, _ := url.Parse(link)
+	token := u.Query().Get("token")
+
+	tok, err := f.verify(token, req, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tok.Action != fallbackApprove || tok.Approver != "email:a@example.com" {
+		t.Errorf("unexpected token: %+v", tok)
+	}
+	if _, err := f.verify(token, fallbackRequest{ID: "other"}, now); err == nil {
+		t.Error("expected error for another request")
+	}
+	if _, err := f.verify(token, req, now.Add(2*time.Minute)); err == nil {
+		t.Error("expected error for expired link")
+	}
+	// Flipping the action invalidates the signature
+	payload, sig, _ := strings.Cut(token, ".")
+	decoded, _ := base64.RawURLEncoding.DecodeString(payload)
+	flipped := strings.Replace(string(decoded), fallbackApprove, fallbackDeny, 1)
+	forged := base64.RawURLEncoding.EncodeToString([]byte(flipped)) + "." + sig
+	if _, err := f.verify(forged, req, now); err == nil {
+		t.Error("expected error for tampered link")
+	}
+	other := testFallbackConfig()
+	other.Chain = f.Chain
+	other.Secret = strings.Repeat("x", fallbackMinSecret)
+	if _, err := other.verify(token, req, now); err == nil {
+		t.Error("expected error for link signed with another secret")
+	}
+}
+
+func TestFallbackHandler(t *testing.T) {
+	f := testFallbackConfig("http://hook")
+	req := fallbackRequest{ID: "req", Command: "<rm>", Expires: time.Now().Add(time.Minute)}
+	votes := make(chan fallbackVote, 1)
+	srv := httptest.NewServer(f.handler(req, votes))
+	defer srv.Close()
+
+	u, _ := url.Parse(f.link(req, fallbackDeny, "webhook:oncall"))
+	token := u.Query().Get("token")
+
+	// Opening the link only shows the request
+	resp, err := http.Get(srv.URL + fallbackDecidePath + "?token=" + url.QueryEscape(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "&lt;rm&gt;") || !strings.Contains(string(body), `method="post"`) {
+		t.Errorf("unexpected page %d: %s", resp.StatusCode, body)
+	}
+	select {
+	case v := <-votes:
+		t.Fatalf("GET decided the request: %+v", v)
+	default:
+	}
+
+	resp, err = http.PostForm(srv.URL+fallbackDecidePath, url.Values{"token": {token}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if v := <-votes; v.action != fallbackDeny || v.approver != "webhook:oncall" {
+		t.Errorf("unexpected vote: %+v", v)
+	}
+
+	resp, err = http.PostForm(srv.URL+fallbackDecidePath, url.Values{"token": {"bogus"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("invalid token status = %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestFallbackNotifyChain(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	var payload map[string]any
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer working.Close()
+
+	f := testFallbackConfig(failing.URL, working.URL)
+	if err := f.notify(fallbackRequest{ID: "req", Expires: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if payload["request_id"] != "req" || !strings.Contains(payload["approve_url"].(string), "token=") {
+		t.Errorf("unexpected payload: %v", payload)
+	}
+
+	if err := testFallbackConfig(failing.URL).notify(fallbackRequest{ID: "req"}); err == nil {
+		t.Error("expected error when every step fails")
+	}
+}
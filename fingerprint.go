@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// packageQueryTimeout bounds each package manager query, so a slow package
+// database cannot hold up the request.
+const packageQueryTimeout = 3 * time.Second
+
+// binaryFingerprint pins the executable a request is approved for, so it cannot be
+// swapped between approval and execution.
+type binaryFingerprint struct {
+	// path is the absolute path of the executable, with symlinks resolved
+	path   string
+	sha256 string
+	// link is the path found through PATH when it is a symlink to path
+	link string
+	// owner is the package that owns the executable, and ownerKnown whether a
+	// package manager could be asked at all
+	owner      string
+	ownerKnown bool
+}
+
+// fingerprintExecutable resolves name as exec would and hashes the executable.
+func fingerprintExecutable(name string) (*binaryFingerprint, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, err
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return nil, err
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := fileSHA256(resolved)
+	if err != nil {
+		return nil, err
+	}
+	f := &binaryFingerprint{path: resolved, sha256: sum}
+	if resolved != path {
+		f.link = path
+	}
+	// With merged /usr, the package database may only know one of the two paths
+	f.owner, f.ownerKnown = packageOwner(resolved)
+	if f.owner == "" && f.link != "" {
+		f.owner, f.ownerKnown = packageOwner(f.link)
+	}
+	return f, nil
+}
+
+// replaceableBy returns the first of path, with its symlinks resolved, and the
+// directories above it through which id could change what path runs: the hash of an
+// executable only pins it if the requester cannot swap it between verify and exec.
+// It goes by owners and permission bits; ACLs are not considered.
+func replaceableBy(path string, id *runAsIdentity) (string, bool) {
+	inGroup := func(gid uint32) bool { return gid == id.gid || slices.Contains(id.groups, gid) }
+	writable := func(st *syscall.Stat_t) bool {
+		return st.Uid == id.uid && st.Mode&0o200 != 0 || inGroup(st.Gid) && st.Mode&0o020 != 0 || st.Mode&0o002 != 0
+	}
+	var entry *syscall.Stat_t
+	for p := path; ; p = filepath.Dir(p) {
+		var st syscall.Stat_t
+		if err := syscall.Lstat(p, &st); err != nil {
+			// What cannot be checked is treated as replaceable
+			return p, true
+		}
+		switch {
+		case st.Uid == id.uid:
+			// Owners can change the permissions
+			return p, true
+		case entry == nil && writable(&st):
+			return p, true
+		case entry != nil && writable(&st) && (st.Mode&syscall.S_ISVTX == 0 || entry.Uid == id.uid):
+			// Sticky directories only let owners replace their entries
+			return p, true
+		}
+		if p == filepath.Dir(p) {
+			return "", false
+		}
+		entry = &st
+	}
+}
+
+// verify rehashes the executable and fails if it changed since it was fingerprinted.
+func (f *binaryFingerprint) verify() error {
+	sum, err := fileSHA256(f.path)
+	if err != nil {
+		return err
+	}
+	if sum != f.sha256 {
+		return fmt.Errorf("%s changed since the request was posted (sha256 %s, was %s)", f.path, sum, f.sha256)
+	}
+	return nil
+}
+
+// format renders the executable for the approval request.
+func (f *binaryFingerprint) format() string {
+	var b strings.Builder
+	if f.link != "" {
+		fmt.Fprintf(&b, "\n**Binary:** `%s` → `%s` (symlink)", f.link, f.path)
+	} else {
+		fmt.Fprintf(&b, "\n**Binary:** `%s`", f.path)
+	}
+	if f.owner != "" {
+		fmt.Fprintf(&b, "\n**Package:** `%s`", f.owner)
+	} else if f.ownerKnown {
+		b.WriteString("\n**Package:** ⚠️ not owned by any package")
+	}
+	fmt.Fprintf(&b, "\n**SHA-256:** `%s`", f.sha256)
+	return b.String()
+}
+
+// packageManagers are asked in order which package owns a file.
+var packageManagers = []struct {
+	name  string
+	args  []string
+	parse func(output string) string
+}{
+	{"dpkg-query", []string{"-S"}, parseDpkgOwner},
+	{"rpm", []string{"-qf", "--queryformat", "%{NAME}\n"}, parseRPMOwner},
+}
+
+// packageOwner returns the package owning path, and false if no package manager
+// is installed.
+func packageOwner(path string) (string, bool) {
+	known := false
+	for _, pm := range packageManagers {
+		bin, err := exec.LookPath(pm.name)
+		if err != nil {
+			continue
+		}
+		known = true
+		ctx, cancel := context.WithTimeout(context.Background(), packageQueryTimeout)
+		out, err := exec.CommandContext(ctx, bin, append(pm.args, path)...).Output()
+		cancel()
+		if err != nil {
+			// Not owned, or the query failed; either way there is no owner to show
+			continue
+		}
+		if owner := pm.parse(string(out)); owner != "" {
+			return owner, true
+		}
+	}
+	return "", known
+}
+
+// parseDpkgOwner parses `dpkg-query -S` output such as "coreutils: /usr/bin/ls",
+// skipping diversion notes.
+func parseDpkgOwner(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "diversion by ") {
+			continue
+		}
+		if pkgs, _, ok := strings.Cut(line, ": "); ok {
+			return pkgs
+		}
+	}
+	return ""
+}
+
+// parseRPMOwner parses `rpm -qf` output, one package name per line.
+func parseRPMOwner(output string) string {
+	return strings.Join(strings.Fields(output), ", ")
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFingerprintExecutable(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "tool")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\necho one\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(bin, link); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", dir)
+	fp, err := fingerprintExecutable("link")
+	if err != nil {
+		t.Fatalf("fingerprintExecutable: %v", err)
+	}
+	want, _ := filepath.EvalSymlinks(bin)
+	if fp.path != want {
+		t.Errorf("path = %q, want %q", fp.path, want)
+	}
+	if fp.link != link || !strings.Contains(fp.format(), "(symlink)") {
+		t.Errorf("link = %q, format() = %q, want the symlink shown", fp.link, fp.format())
+	}
+	// No package manager is on PATH
+	if fp.ownerKnown || strings.Contains(fp.format(), "Package") {
+		t.Errorf("format() = %q, want no package line", fp.format())
+	}
+	if len(fp.sha256) != 64 {
+		t.Errorf("sha256 = %q", fp.sha256)
+	}
+	if !strings.Contains(fp.format(), fp.sha256) {
+		t.Errorf("format() = %q, want the hash", fp.format())
+	}
+	if err := fp.verify(); err != nil {
+		t.Errorf("verify unchanged: %v", err)
+	}
+
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\necho two\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fp.verify(); err == nil {
+		t.Error("expected verify to fail after the binary changed")
+	}
+
+	if _, err := fingerprintExecutable("missing"); err == nil {
+		t.Error("expected error for missing executable")
+	}
+}
+
+func TestFingerprintFormatOwner(t *testing.T) {
+	fp := &binaryFingerprint{path: "/usr/bin/ls", sha256: "abc", owner: "coreutils", ownerKnown: true}
+	want := "\n**Binary:** `/usr/bin/ls`\n**Package:** `coreutils`\n**SHA-256:** `abc`"
+	if got := fp.format(); got != want {
+		t.Errorf("format() = %q, want %q", got, want)
+	}
+	fp.owner = ""
+	if !strings.Contains(fp.format(), "not owned by any package") {
+		t.Errorf("format() = %q, want a warning for an unowned binary", fp.format())
+	}
+}
+
+func TestParsePackageOwner(t *testing.T) {
+	dpkg := "diversion by dash from: /bin/sh\ndiversion by dash to: /bin/sh.distrib\ndash: /bin/sh\n"
+	if got := parseDpkgOwner(dpkg); got != "dash" {
+		t.Errorf("parseDpkgOwner = %q, want dash", got)
+	}
+	if got := parseDpkgOwner("coreutils, busybox: /usr/bin/ls\n"); got != "coreutils, busybox" {
+		t.Errorf("parseDpkgOwner = %q", got)
+	}
+	if got := parseDpkgOwner(""); got != "" {
+		t.Errorf("parseDpkgOwner(empty) = %q", got)
+	}
+	if got := parseRPMOwner("coreutils\n"); got != "coreutils" {
+		t.Errorf("parseRPMOwner = %q, want coreutils", got)
+	}
+}
+
+func TestReplaceableBy(t *testing.T) {
+	nobody := &runAsIdentity{uid: 65534, gid: 65534}
+	sh, _ := filepath.EvalSymlinks("/bin/sh")
+	if path, ok := replaceableBy(sh, nobody); ok {
+		t.Errorf("%s replaceable through %s", sh, path)
+	}
+
+	if os.Geteuid() != 0 {
+		t.Skip("changing owners needs root")
+	}
+	dir := t.TempDir()
+	os.Chmod(filepath.Dir(dir), 0755)
+	os.Chmod(dir, 0755)
+	bin := filepath.Join(dir, "tool")
+	os.WriteFile(bin, []byte("#!/bin/sh\n"), 0755)
+	// The sticky /tmp does not let others replace root's directories in it
+	if path, ok := replaceableBy(bin, nobody); ok {
+		t.Errorf("root-owned tool replaceable through %s", path)
+	}
+	for name, setup := range map[string]func(){
+		"owned file":    func() { os.Chown(bin, 65534, 0) },
+		"writable file": func() { os.Chmod(bin, 0757) },
+		"owned dir":     func() { os.Chown(dir, 65534, 0) },
+		"writable dir":  func() { os.Chmod(dir, 0777) },
+		"group dir":     func() { os.Chown(dir, 0, 65534); os.Chmod(dir, 0775) },
+	} {
+		os.Chown(bin, 0, 0)
+		os.Chmod(bin, 0755)
+		os.Chown(dir, 0, 0)
+		os.Chmod(dir, 0755)
+		setup()
+		if _, ok := replaceableBy(bin, nobody); !ok {
+			t.Errorf("%s: expected the tool to be replaceable", name)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxForumTitle is Discord's limit on the name of a forum post.
+const maxForumTitle = 100
+
+// forumTitle names the forum post of a request after the host and command.
+func forumTitle(hostname, command string) string {
+	title := hostname + ": " + command
+	if r := []rune(title); len(r) > maxForumTitle {
+		return string(r[:maxForumTitle-1]) + "…"
+	}
+	return title
+}
+
+// isForumPost reports whether a message is the starter message of a forum post,
+// whose ID is that of the post's thread.
+func isForumPost(channelID, messageID string) bool {
+	return messageID != "" && channelID == messageID
+}
+
+// isForum reports whether requests to channelID have to be created as forum posts.
+func isForum(dg *discordgo.Session, channelID string) (bool, error) {
+	ch, err := retryDiscord(func() (*discordgo.Channel, error) {
+		return dg.Channel(channelID, discordRetryOptions...)
+	})
+	if err != nil {
+		return false, err
+	}
+	return ch.Type == discordgo.ChannelTypeGuildForum, nil
+}
+
+// startForumPost creates a forum post titled title whose starter message is
+// msgSend, returning the starter message.
+func startForumPost(dg *discordgo.Session, channelID, title string, msgSend *discordgo.MessageSend) (*discordgo.Message, error) {
+	thread, err := retryDiscord(func() (*discordgo.Channel, error) {
+		rewindFiles(msgSend.Files)
+		return dg.ForumThreadStartComplex(channelID, &discordgo.ThreadStart{Name: title}, msgSend, discordRetryOptions...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &discordgo.Message{ID: thread.ID, ChannelID: thread.ID, Content: msgSend.Content}, nil
+}
+
+// archiveForumPosts archives the forum posts among msgs, except the one with the
+// given ID, whose request goes on in it.
+func archiveForumPosts(dg *discordgo.Session, msgs []trackedMessage, exceptID string) {
+	for _, m := range msgs {
+		if m.ID == exceptID || !isForumPost(m.ChannelID, m.ID) {
+			continue
+		}
+		if err := archiveForumPost(dg, m.ID); err != nil {
+			slog.Warn("failed to archive forum post", "thread_id", m.ID, "err", err)
+		}
+	}
+}
+
+// archiveForumPost archives the forum post whose thread is threadID once its request
+// is resolved.
+func archiveForumPost(dg *discordgo.Session, threadID string) error {
+	archived := true
+	_, err := retryDiscord(func() (*discordgo.Channel, error) {
+		return dg.ChannelEdit(threadID, &discordgo.ChannelEdit{Archived: &archived}, discordRetryOptions...)
+	})
+	return err
+}
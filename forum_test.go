@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestForumTitle(t *testing.T) {
+	if got := forumTitle("web1", "systemctl restart nginx"); got != "web1: systemctl restart nginx" {
+		t.Errorf("forumTitle = %q", got)
+	}
+	got := forumTitle("web1", strings.Repeat("é", 200))
+	if n := utf8.RuneCountInString(got); n != maxForumTitle || !strings.HasSuffix(got, "…") {
+		t.Errorf("long title has %d runes: %q", n, got)
+	}
+}
+
+func TestIsForumPost(t *testing.T) {
+	if !isForumPost("123", "123") {
+		t.Error("a starter message shares its thread's ID")
+	}
+	if isForumPost("123", "456") || isForumPost("", "") {
+		t.Error("expected other messages not to be forum posts")
+	}
+}
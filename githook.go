@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	gitHookPreReceive = "pre-receive"
+	gitHookPrePush    = "pre-push"
+	// maxPushCommits bounds the commits listed per ref in a push request
+	maxPushCommits = 20
+)
+
+// defaultProtectedRefs are the refs a push needs approval for without --protect.
+var defaultProtectedRefs = []string{"refs/heads/main", "refs/heads/master"}
+
+// gitPusherVariables name the pushing user on self-hosted git servers: GitLab,
+// Gitea/Forgejo and Gitolite.
+var gitPusherVariables = []string{"GL_USERNAME", "GITEA_PUSHER_NAME", "GL_USER"}
+
+// gitRefUpdate is a ref a push moves from old to new.
+type gitRefUpdate struct {
+	ref, old, new string
+	// summary describes the commits of the update
+	summary string
+}
+
+// isZeroOID reports whether an object ID is the null one git uses for refs that are
+// created or deleted.
+func isZeroOID(oid string) bool {
+	return oid != "" && strings.Trim(oid, "0") == ""
+}
+
+// parseRefUpdates reads the refs of a push from a hook's stdin: "OLD NEW REF" lines
+// for pre-receive, and "LOCAL_REF LOCAL_OID REMOTE_REF REMOTE_OID" for pre-push.
+func parseRefUpdates(r io.Reader, hook string) ([]gitRefUpdate, error) {
+	var updates []gitRefUpdate
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 0:
+			continue
+		case hook == gitHookPreReceive && len(fields) == 3:
+			updates = append(updates, gitRefUpdate{ref: fields[2], old: fields[0], new: fields[1]})
+		case hook == gitHookPrePush && len(fields) == 4:
+			updates = append(updates, gitRefUpdate{ref: fields[2], old: fields[3], new: fields[1]})
+		default:
+			return nil, fmt.Errorf("unexpected %s input: %q", hook, scanner.Text())
+		}
+	}
+	return updates, scanner.Err()
+}
+
+// protectedUpdates returns the updates of refs matching any of patterns.
+func protectedUpdates(updates []gitRefUpdate, patterns []string) []gitRefUpdate {
+	var protected []gitRefUpdate
+	for _, u := range updates {
+		for _, pattern := range patterns {
+			if matchGlob(pattern, u.ref) {
+				protected = append(protected, u)
+				break
+			}
+		}
+	}
+	return protected
+}
+
+// summarize lists the commits an update adds with git, and whether it rewrites history.
+func (u *gitRefUpdate) summarize() {
+	switch {
+	case isZeroOID(u.new):
+		u.summary = "deleted"
+		return
+	case isZeroOID(u.old):
+		u.summary = "new ref"
+		u.summary += gitLog(u.new, "--not", "--branches")
+		return
+	}
+	u.summary = shortOID(u.old) + ".." + shortOID(u.new)
+	if exec.Command("git", "merge-base", "--is-ancestor", u.old, u.new).Run() != nil {
+		u.summary += " (forced update)"
+	}
+	u.summary += gitLog(u.old + ".." + u.new)
+}
+
+// gitLog returns the one-line log of the revisions, indented, or "" if it cannot be read.
+func gitLog(revisions ...string) string {
+	args := append([]string{"log", "--oneline", "--no-decorate", fmt.Sprintf("--max-count=%d", maxPushCommits+1)}, revisions...)
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return ""
+	}
+	truncated := len(lines) > maxPushCommits
+	if truncated {
+		lines = lines[:maxPushCommits]
+	}
+	log := "\n  " + strings.Join(lines, "\n  ")
+	if truncated {
+		log += "\n  ..."
+	}
+	return log
+}
+
+func shortOID(oid string) string {
+	if len(oid) > 12 {
+		return oid[:12]
+	}
+	return oid
+}
+
+// describePush renders the protected updates of a push for the request.
+func describePush(updates []gitRefUpdate) string {
+	var b bytes.Buffer
+	for _, u := range updates {
+		fmt.Fprintf(&b, "%s: %s\n", u.ref, u.summary)
+	}
+	return b.String()
+}
+
+// gitPusher returns who is pushing, as the git server names them.
+func gitPusher() string {
+	for _, name := range gitPusherVariables {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// gitHookInvocation returns the command asking for approval of a push, described by
+// command. Like the approval shell, other users than root go through sudo.
+func gitHookInvocation(self, channelID, pusher string, command []string, euid int) []string {
+	args := []string{self, "request", "--no-exec", "--show-stdin"}
+	if channelID != "" {
+		args = append(args, "--channel", channelID)
+	}
+	if pusher != "" {
+		args = append(args, "--requester", pusher)
+	}
+	args = append(append(args, "--"), command...)
+	if euid != 0 {
+		args = append([]string{"sudo", "-n"}, args...)
+	}
+	return args
+}
+
+// runGitHook implements `prompt-sudo-discord git-hook`, run as a pre-receive hook on a
+// git server or as a pre-push hook. Pushes updating protected refs are posted with
+// their commits, and refused unless approved.
+func runGitHook(args []string) int {
+	fs := flag.NewFlagSet("git-hook", flag.ContinueOnError)
+	protect := fs.String("protect", strings.Join(defaultProtectedRefs, ","), "Comma-separated glob patterns of the refs whose updates need approval")
+	channelID := fs.String("channel", "", "Discord channel ID or alias to post push requests to (default: default_channel from config)")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	// pre-push hooks are given the remote's name and URL
+	hook := gitHookPreReceive
+	if fs.NArg() == 2 {
+		hook = gitHookPrePush
+	}
+
+	updates, err := parseRefUpdates(os.Stdin, hook)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitInternalError
+	}
+	updates = protectedUpdates(updates, splitList(*protect))
+	if len(updates) == 0 {
+		return 0
+	}
+	command := []string{"git", "push", fs.Arg(0)}
+	if hook == gitHookPreReceive {
+		repo, _ := os.Getwd()
+		command = []string{"git", "receive-pack", filepath.Clean(repo)}
+	}
+	for i := range updates {
+		updates[i].summarize()
+		if hook == gitHookPrePush {
+			command = append(command, updates[i].ref)
+		}
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitInternalError
+	}
+	argv := gitHookInvocation(self, *channelID, gitPusher(), command, os.Geteuid())
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin = strings.NewReader(describePush(updates))
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	err = cmd.Run()
+	code, ok := exitCode(err)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitCannotExecute
+	}
+	if code != 0 {
+		fmt.Fprintf(os.Stderr, "push to protected refs was not approved (exit code %d)\n", code)
+	}
+	return code
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRefUpdates(t *testing.T) {
+	zero := strings.Repeat("0", 40)
+	preReceive := "aaa bbb refs/heads/main\n" + zero + " ccc refs/heads/feature\n"
+	got, err := parseRefUpdates(strings.NewReader(preReceive), gitHookPreReceive)
+	want := []gitRefUpdate{{ref: "refs/heads/main", old: "aaa", new: "bbb"}, {ref: "refs/heads/feature", old: zero, new: "ccc"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("pre-receive: updates = %+v, %v; want %+v", got, err, want)
+	}
+
+	prePush := "refs/heads/main bbb refs/heads/main aaa\n"
+	got, err = parseRefUpdates(strings.NewReader(prePush), gitHookPrePush)
+	want = []gitRefUpdate{{ref: "refs/heads/main", old: "aaa", new: "bbb"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("pre-push: updates = %+v, %v; want %+v", got, err, want)
+	}
+
+	if _, err := parseRefUpdates(strings.NewReader(prePush), gitHookPreReceive); err == nil {
+		t.Error("expected error for malformed input")
+	}
+}
+
+func TestProtectedUpdates(t *testing.T) {
+	updates := []gitRefUpdate{{ref: "refs/heads/main"}, {ref: "refs/heads/release/1.2"}, {ref: "refs/heads/feature"}}
+	got := protectedUpdates(updates, []string{"refs/heads/main", "refs/heads/release/*"})
+	if len(got) != 2 || got[0].ref != "refs/heads/main" || got[1].ref != "refs/heads/release/1.2" {
+		t.Errorf("protectedUpdates = %+v", got)
+	}
+	if !isZeroOID(strings.Repeat("0", 64)) || isZeroOID("") || isZeroOID("0a") {
+		t.Error("isZeroOID is wrong")
+	}
+}
+
+func TestGitHookInvocation(t *testing.T) {
+	command := []string{"git", "receive-pack", "/srv/git/app.git"}
+	got := gitHookInvocation("/usr/local/bin/prompt-sudo-discord", "deploys", "alice", command, 1000)
+	want := []string{"sudo", "-n", "/usr/local/bin/prompt-sudo-discord", "request", "--no-exec", "--show-stdin",
+		"--channel", "deploys", "--requester", "alice", "--", "git", "receive-pack", "/srv/git/app.git"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("invocation = %v, want %v", got, want)
+	}
+	if got := gitHookInvocation("/psd", "", "", command, 0); got[0] != "/psd" {
+		t.Errorf("root invocation = %v", got)
+	}
+}
+
+func TestDescribePush(t *testing.T) {
+	got := describePush([]gitRefUpdate{{ref: "refs/heads/main", summary: "aaa..bbb\n  bbb Fix"}, {ref: "refs/heads/old", summary: "deleted"}})
+	want := "refs/heads/main: aaa..bbb\n  bbb Fix\nrefs/heads/old: deleted\n"
+	if got != want {
+		t.Errorf("describePush = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// gitContextTimeout bounds the git commands describing the working tree, which delay
+// the request.
+const gitContextTimeout = 2 * time.Second
+
+// gitRepoContext describes the git working tree a request is made from: "make deploy"
+// means very different things on main and on a random branch.
+type gitRepoContext struct {
+	Repo string `json:"repo"`
+	// Branch is empty on a detached HEAD
+	Branch string `json:"branch,omitempty"`
+	Commit string `json:"commit"`
+	// Dirty is set when tracked files have uncommitted changes
+	Dirty bool `json:"dirty"`
+}
+
+// currentGitContext describes the git working tree at dir, if it is in one.
+func currentGitContext(dir string) (gitRepoContext, bool) {
+	gitDir, ok := findGitDir(dir)
+	if !ok {
+		return gitRepoContext{}, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), gitContextTimeout)
+	defer cancel()
+	git := gitRunner(ctx, dir, gitDir)
+
+	out, err := git("rev-parse", "--show-toplevel", "HEAD")
+	lines := strings.Split(out, "\n")
+	if err != nil || len(lines) != 2 {
+		return gitRepoContext{}, false
+	}
+	topLevel, commit := lines[0], lines[1]
+	remote, _ := git("config", "--get", "remote.origin.url")
+	g := gitRepoContext{Repo: gitRepoName(remote, topLevel), Commit: commit}
+	g.Branch, _ = git("symbolic-ref", "--short", "-q", "HEAD")
+	status, err := git("status", "--porcelain", "--untracked-files=no")
+	g.Dirty = err == nil && status != ""
+	return g, true
+}
+
+// findGitDir returns the .git directory or file of the working tree holding dir.
+func findGitDir(dir string) (string, bool) {
+	for {
+		gitDir := filepath.Join(dir, ".git")
+		if _, err := os.Lstat(gitDir); err == nil {
+			return gitDir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// gitRunner returns a function running git in dir. Running as root, git runs as the
+// owner of gitDir instead: a repository's config can run commands (core.fsmonitor,
+// filter drivers), which must not get root's privileges.
+func gitRunner(ctx context.Context, dir, gitDir string) func(args ...string) (string, error) {
+	var cred *syscall.Credential
+	if os.Geteuid() == 0 {
+		fi, err := os.Lstat(gitDir)
+		if err != nil {
+			return func(...string) (string, error) { return "", err }
+		}
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok && st.Uid != 0 {
+			cred = &syscall.Credential{Uid: st.Uid, Gid: st.Gid}
+		}
+	}
+	return func(args ...string) (string, error) {
+		args = append([]string{"-c", "core.fsmonitor=false"}, args...)
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		// Reading the status must not rewrite the index as another user
+		cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "LC_ALL=C", "GIT_OPTIONAL_LOCKS=0"}
+		if cred != nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+		}
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+		if err := cmd.Run(); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(stdout.String()), nil
+	}
+}
+
+// gitRepoName names a repository after its origin remote, e.g. "owner/repo", or after
+// its directory. Credentials in the remote URL are left out.
+func gitRepoName(remote, topLevel string) string {
+	path := remote
+	if u, err := url.Parse(remote); err == nil && u.Scheme != "" {
+		path = u.Path
+	} else if _, p, ok := strings.Cut(remote, ":"); ok {
+		// scp-like syntax, git@host:owner/repo.git
+		path = p
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if parts := strings.Split(path, "/"); len(parts) > 2 {
+		path = strings.Join(parts[len(parts)-2:], "/")
+	}
+	if path == "" {
+		return filepath.Base(topLevel)
+	}
+	return path
+}
+
+// format renders the git context as a request message line.
+func (g gitRepoContext) format() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n**Git:** `%s`", inlineCode(g.Repo))
+	if g.Branch != "" {
+		fmt.Fprintf(&b, " on `%s`", inlineCode(g.Branch))
+	} else {
+		b.WriteString(" on a detached HEAD")
+	}
+	fmt.Fprintf(&b, " at `%s`", shortOID(g.Commit))
+	if g.Dirty {
+		b.WriteString(" ⚠️ **uncommitted changes**")
+	}
+	return b.String()
+}
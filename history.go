@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// historyFilter selects decision records of the audit log.
+type historyFilter struct {
+	since    time.Time
+	approver string
+	user     string
+	denied   bool
+}
+
+func (f historyFilter) matches(rec AuditRecord) bool {
+	switch {
+	case rec.Event != auditEventDecision:
+		return false
+	case rec.Time.Before(f.since):
+		return false
+	case f.approver != "" && !slices.Contains(rec.ApproverIDs, f.approver):
+		return false
+	case f.user != "" && rec.Requester != f.user && rec.SudoUser != f.user:
+		return false
+	case f.denied && rec.Decision != auditDecisionDenied && rec.Decision != auditDecisionPolicyDenied:
+		return false
+	}
+	return true
+}
+
+// readHistory returns the decision records of the audit log matching f, oldest first,
+// with the exit code of approved commands filled in from their exit records. Whoever
+// may run history sees commands as they are shown in Discord, redacted with patterns.
+func readHistory(r io.Reader, f historyFilter, patterns []*regexp.Regexp) ([]AuditRecord, error) {
+	var records []AuditRecord
+	byID := map[string]int{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if rec.Event == auditEventExit {
+			if i, ok := byID[rec.RequestID]; ok {
+				records[i].ExitCode = rec.ExitCode
+			}
+			continue
+		}
+		if f.matches(rec) {
+			byID[rec.RequestID] = len(records)
+			records = append(records, rec.redacted(patterns))
+		}
+	}
+	return records, scanner.Err()
+}
+
+// parseSince parses how far back history goes: a Go duration, or a number of days
+// such as "7d".
+func parseSince(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// printHistory prints records as a table, one request per row.
+func printHistory(w io.Writer, records []AuditRecord) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tREQUEST\tDECISION\tREQUESTER\tAPPROVERS\tEXIT\tCOMMAND")
+	for _, rec := range records {
+		requester := rec.Requester
+		if rec.SudoUser != "" && rec.SudoUser != requester {
+			requester += " (" + rec.SudoUser + ")"
+		}
+		approvers := strings.Join(rec.ApproverIDs, ",")
+		if approvers == "" {
+			approvers = "-"
+		}
+		exit := "-"
+		if rec.ExitCode != nil {
+			exit = strconv.Itoa(*rec.ExitCode)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", rec.Time.Local().Format("2006-01-02 15:04:05"), rec.RequestID,
+			rec.Decision, requester, approvers, exit, rec.CommandLine)
+	}
+	return tw.Flush()
+}
+
+// runHistory prints past requests from the audit log.
+func runHistory(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	since := fs.String("since", "7d", "How far back to look: a duration such as 24h, or days such as 7d")
+	approver := fs.String("approver", "", "Only requests approved or denied by this Discord user ID")
+	user := fs.String("user", "", "Only requests by this local user (requester or SUDO_USER)")
+	denied := fs.Bool("denied", false, "Only denied requests, by an approver or by policy")
+	output := fs.String("output", "table", "Output format: table, or json for one audit record per line")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	window, err := parseSince(*since)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--since: %v\n", err)
+		return exitConfigError
+	}
+	if *output != "table" && *output != outputFormatJSON {
+		fmt.Fprintf(os.Stderr, "--output must be table or %s\n", outputFormatJSON)
+		return exitConfigError
+	}
+	config, err := readConfig(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfigError
+	}
+	f, err := os.Open(config.AuditLogPath)
+	if errors.Is(err, os.ErrNotExist) {
+		// Nothing was requested yet
+		f, err = os.Open(os.DevNull)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitInternalError
+	}
+	defer f.Close()
+	records, err := readHistory(f, historyFilter{since: time.Now().Add(-window), approver: *approver, user: *user, denied: *denied}, config.redactions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", config.AuditLogPath, err)
+		return exitInternalError
+	}
+
+	if *output == outputFormatJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, rec := range records {
+			enc.Encode(rec)
+		}
+		return 0
+	}
+	printHistory(os.Stdout, records)
+	return 0
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadHistory(t *testing.T) {
+	now := time.Now()
+	exitCode := 3
+	var log bytes.Buffer
+	enc := json.NewEncoder(&log)
+	for _, rec := range []AuditRecord{
+		{Time: now.Add(-10 * 24 * time.Hour), Event: auditEventDecision, RequestID: "old", Decision: auditDecisionApproved, ApproverIDs: []string{"1"}},
+		{Time: now.Add(-time.Hour), Event: auditEventDecision, RequestID: "a", Command: []string{"apt", "update"}, Requester: "alice", Decision: auditDecisionApproved, ApproverIDs: []string{"1"}},
+		{Time: now.Add(-time.Hour), Event: auditEventExit, RequestID: "a", ExitCode: &exitCode},
+		{Time: now.Add(-time.Minute), Event: auditEventDecision, RequestID: "b", Command: []string{"psql", "--password", "hunter2"}, Requester: "bob", Decision: auditDecisionDenied, ApproverIDs: []string{"2"}},
+		{Time: now.Add(-time.Minute), Event: auditEventDecision, RequestID: "c", Requester: "bob", SudoUser: "carol", Decision: auditDecisionPolicyDenied},
+	} {
+		enc.Encode(rec)
+	}
+
+	for name, tc := range map[string]struct {
+		filter historyFilter
+		want   []string
+	}{
+		"last week": {historyFilter{since: now.Add(-7 * 24 * time.Hour)}, []string{"a", "b", "c"}},
+		"approver":  {historyFilter{since: now.Add(-7 * 24 * time.Hour), approver: "1"}, []string{"a"}},
+		"denied":    {historyFilter{since: now.Add(-7 * 24 * time.Hour), denied: true}, []string{"b", "c"}},
+		"sudo user": {historyFilter{since: now.Add(-7 * 24 * time.Hour), user: "carol"}, []string{"c"}},
+	} {
+		records, err := readHistory(bytes.NewReader(log.Bytes()), tc.filter, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var ids []string
+		for _, rec := range records {
+			ids = append(ids, rec.RequestID)
+		}
+		if strings.Join(ids, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("%s: requests = %v, want %v", name, ids, tc.want)
+		}
+	}
+
+	records, _ := readHistory(bytes.NewReader(log.Bytes()), historyFilter{approver: "1", since: now.Add(-2 * time.Hour)}, nil)
+	if len(records) != 1 || records[0].ExitCode == nil || *records[0].ExitCode != 3 {
+		t.Fatalf("expected the exit code to be joined: %+v", records)
+	}
+	var out bytes.Buffer
+	printHistory(&out, records)
+	if !strings.Contains(out.String(), "apt update") || !strings.Contains(out.String(), "alice") {
+		t.Errorf("unexpected table:\n%s", out.String())
+	}
+
+	redactions, _ := compileRedactions(nil)
+	records, _ = readHistory(bytes.NewReader(log.Bytes()), historyFilter{user: "bob"}, redactions)
+	out.Reset()
+	printHistory(&out, records)
+	if !strings.Contains(out.String(), "psql --password ****") || strings.Contains(out.String(), "hunter2") {
+		t.Errorf("command not redacted:\n%s", out.String())
+	}
+
+	if _, err := readHistory(strings.NewReader("not json\n"), historyFilter{}, nil); err == nil {
+		t.Error("expected error for a corrupt audit log")
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	for s, want := range map[string]time.Duration{"7d": 7 * 24 * time.Hour, "36h": 36 * time.Hour, "0d": 0} {
+		if got, err := parseSince(s); err != nil || got != want {
+			t.Errorf("parseSince(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"d", "-1d", "week", "-5m"} {
+		if _, err := parseSince(s); err == nil {
+			t.Errorf("parseSince(%q): expected error", s)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// HostProfile overrides where requests go and who decides them on the hosts whose
+// name matches its pattern, e.g. to treat production stricter than staging.
+type HostProfile struct {
+	ChannelID      string   `json:"channel_id"`
+	ApproverIDs    []string `json:"approver_ids"`
+	TimeoutSeconds int      `json:"timeout_seconds"`
+	// Policies are evaluated before the global ones
+	Policies []Policy `json:"policies"`
+}
+
+func validateHostProfiles(hosts map[string]HostProfile) error {
+	for pattern, profile := range hosts {
+		if pattern == "" {
+			return fmt.Errorf("hosts: pattern must not be empty")
+		}
+		if profile.TimeoutSeconds < 0 {
+			return fmt.Errorf("hosts[%s]: timeout_seconds must not be negative", pattern)
+		}
+		if err := validatePolicies(profile.Policies); err != nil {
+			return fmt.Errorf("hosts[%s]: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchHostProfile returns the profile whose pattern matches hostname, ignoring case,
+// and its pattern. The most specific pattern wins: an exact name over a glob, then
+// the longest pattern.
+func matchHostProfile(hosts map[string]HostProfile, hostname string) (string, *HostProfile) {
+	patterns := make([]string, 0, len(hosts))
+	for pattern := range hosts {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		a, b := patterns[i], patterns[j]
+		if exactA, exactB := !strings.ContainsAny(a, "*?"), !strings.ContainsAny(b, "*?"); exactA != exactB {
+			return exactA
+		}
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return a < b
+	})
+	for _, pattern := range patterns {
+		if matchGlob(strings.ToLower(pattern), strings.ToLower(hostname)) {
+			profile := hosts[pattern]
+			return pattern, &profile
+		}
+	}
+	return "", nil
+}
+
+// applyHostProfile applies the approvers, timeout and policies of the profile
+// matching hostname to the config, returning the profile's pattern and its channel.
+func (c *Config) applyHostProfile(hostname string) (pattern, channelID string) {
+	pattern, profile := matchHostProfile(c.Hosts, hostname)
+	if profile == nil {
+		return "", ""
+	}
+	if len(profile.ApproverIDs) > 0 {
+		c.ApproverIDs = profile.ApproverIDs
+	}
+	if profile.TimeoutSeconds > 0 {
+		c.TimeoutSeconds = profile.TimeoutSeconds
+	}
+	c.Policies = append(append([]Policy{}, profile.Policies...), c.Policies...)
+	return pattern, profile.ChannelID
+}
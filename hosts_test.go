@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchHostProfile(t *testing.T) {
+	hosts := map[string]HostProfile{
+		"*":          {TimeoutSeconds: 1},
+		"prod-*":     {TimeoutSeconds: 2},
+		"prod-db-*":  {TimeoutSeconds: 3},
+		"prod-db-01": {TimeoutSeconds: 4},
+	}
+	tests := map[string]string{
+		"PROD-DB-01": "prod-db-01",
+		"prod-db-02": "prod-db-*",
+		"prod-web-1": "prod-*",
+		"staging-1":  "*",
+	}
+	for hostname, want := range tests {
+		if got, _ := matchHostProfile(hosts, hostname); got != want {
+			t.Errorf("matchHostProfile(%q) = %q, want %q", hostname, got, want)
+		}
+	}
+	if pattern, profile := matchHostProfile(map[string]HostProfile{"prod-*": {}}, "staging-1"); profile != nil {
+		t.Errorf("expected no profile, got %q", pattern)
+	}
+}
+
+func TestApplyHostProfile(t *testing.T) {
+	config := &Config{
+		ApproverIDs:    []string{"global"},
+		TimeoutSeconds: 300,
+		Policies:       []Policy{{Name: "global"}},
+		Hosts: map[string]HostProfile{
+			"prod-*":    {ChannelID: "prod", ApproverIDs: []string{"sre1", "sre2"}, Policies: []Policy{{Name: "prod"}}},
+			"staging-*": {TimeoutSeconds: 60},
+		},
+	}
+
+	staging := *config
+	if pattern, channel := staging.applyHostProfile("staging-1"); pattern != "staging-*" || channel != "" {
+		t.Errorf("staging profile = %q, %q", pattern, channel)
+	}
+	if staging.TimeoutSeconds != 60 || !reflect.DeepEqual(staging.ApproverIDs, []string{"global"}) {
+		t.Errorf("staging should only override the timeout: %d, %v", staging.TimeoutSeconds, staging.ApproverIDs)
+	}
+
+	pattern, channel := config.applyHostProfile("prod-1")
+	if pattern != "prod-*" || channel != "prod" {
+		t.Errorf("prod profile = %q, %q", pattern, channel)
+	}
+	if !reflect.DeepEqual(config.ApproverIDs, []string{"sre1", "sre2"}) || config.TimeoutSeconds != 300 {
+		t.Errorf("prod overrides = %v, %d", config.ApproverIDs, config.TimeoutSeconds)
+	}
+	if len(config.Policies) != 2 || config.Policies[0].Name != "prod" {
+		t.Errorf("expected host policies first, got %+v", config.Policies)
+	}
+}
+
+func TestValidateHostProfiles(t *testing.T) {
+	if err := validateHostProfiles(map[string]HostProfile{"prod-*": {Policies: []Policy{{Name: "p"}}}}); err == nil {
+		t.Error("expected invalid host policies to be rejected")
+	}
+	if err := validateHostProfiles(map[string]HostProfile{"prod-*": {TimeoutSeconds: -1}}); err == nil {
+		t.Error("expected a negative timeout to be rejected")
+	}
+}
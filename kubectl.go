@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// kubeconfigTimeout bounds `kubectl config view`, which only reads local files.
+const kubeconfigTimeout = 10 * time.Second
+
+// KubernetesConfig configures how kubectl commands are presented.
+type KubernetesConfig struct {
+	// ProductionContexts are glob patterns of kubeconfig contexts whose requests are
+	// flagged as production and need a typed confirmation and a second approver
+	ProductionContexts []string `json:"production_contexts"`
+}
+
+func validateKubernetes(k *KubernetesConfig) error {
+	for i, pattern := range k.ProductionContexts {
+		if pattern == "" {
+			return fmt.Errorf("kubernetes.production_contexts[%d] is empty", i)
+		}
+	}
+	return nil
+}
+
+// isProduction reports whether a kubeconfig context is a production one.
+func (k *KubernetesConfig) isProduction(kubeContext string) bool {
+	if k == nil {
+		return false
+	}
+	for _, pattern := range k.ProductionContexts {
+		if matchGlob(pattern, kubeContext) {
+			return true
+		}
+	}
+	return false
+}
+
+// kubeTarget is the cluster a kubectl command acts on.
+type kubeTarget struct {
+	Context   string
+	Cluster   string
+	Server    string
+	Namespace string
+}
+
+// kubectlFlags are the kubectl flags that select the target, as given on the command
+// line. Flags not given are left empty.
+type kubectlFlags struct {
+	kubeconfig, context, namespace string
+	allNamespaces                  bool
+}
+
+// parseKubectlFlags picks the flags selecting the target out of a kubectl command,
+// in any of the forms kubectl accepts them.
+func parseKubectlFlags(args []string) kubectlFlags {
+	var f kubectlFlags
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(arg, "=")
+		var target *string
+		switch name {
+		case "--kubeconfig":
+			target = &f.kubeconfig
+		case "--context":
+			target = &f.context
+		case "-n", "--namespace":
+			target = &f.namespace
+		case "-A", "--all-namespaces":
+			f.allNamespaces = !hasValue || value == "true"
+			continue
+		default:
+			if v, ok := strings.CutPrefix(arg, "-n"); ok && v != "" && !strings.HasPrefix(arg, "--") {
+				f.namespace = v
+			}
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		*target = value
+	}
+	return f
+}
+
+// isKubectl reports whether the command runs kubectl.
+func isKubectl(commandArgs []string) bool {
+	return filepath.Base(commandArgs[0]) == "kubectl"
+}
+
+// kubectlTarget works out the context and namespace a kubectl command acts on, reading
+// the kubeconfig the command will read with `kubectl config view`. That runs the
+// system kubectl as the requester, so it only shows kubeconfigs they can read.
+func kubectlTarget(commandArgs []string) (kubeTarget, error) {
+	f := parseKubectlFlags(commandArgs[1:])
+	args := []string{"config", "view", "--minify", "-o", "json"}
+	if f.kubeconfig != "" {
+		args = append(args, "--kubeconfig", f.kubeconfig)
+	}
+	if f.context != "" {
+		args = append(args, "--context", f.context)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kubeconfigTimeout)
+	defer cancel()
+	cmd, err := requesterCommand(ctx, "kubectl", []string{"KUBECONFIG=" + kubeconfigPath()}, args...)
+	if err != nil {
+		return kubeTarget{}, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return kubeTarget{}, fmt.Errorf("kubectl config view failed: %w: %s", err, msg)
+		}
+		return kubeTarget{}, fmt.Errorf("kubectl config view failed: %w", err)
+	}
+	target, err := parseKubeconfig(stdout.Bytes())
+	if err != nil {
+		return target, err
+	}
+	switch {
+	case f.allNamespaces:
+		target.Namespace = "*"
+	case f.namespace != "":
+		target.Namespace = f.namespace
+	}
+	return target, nil
+}
+
+// kubeconfigPath is the KUBECONFIG the command itself would read, spelled out since
+// the requester's HOME differs from root's.
+func kubeconfigPath() string {
+	if path := os.Getenv("KUBECONFIG"); path != "" {
+		return path
+	}
+	return filepath.Join(os.Getenv("HOME"), ".kube", "config")
+}
+
+// parseKubeconfig reads the target of a minified kubeconfig, which holds only the
+// context in use.
+func parseKubeconfig(data []byte) (kubeTarget, error) {
+	var raw struct {
+		CurrentContext string `json:"current-context"`
+		Contexts       []struct {
+			Name    string `json:"name"`
+			Context struct {
+				Cluster   string `json:"cluster"`
+				Namespace string `json:"namespace"`
+			} `json:"context"`
+		} `json:"contexts"`
+		Clusters []struct {
+			Name    string `json:"name"`
+			Cluster struct {
+				Server string `json:"server"`
+			} `json:"cluster"`
+		} `json:"clusters"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return kubeTarget{}, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+	if len(raw.Contexts) == 0 {
+		return kubeTarget{}, fmt.Errorf("kubeconfig has no current context")
+	}
+	c := raw.Contexts[0]
+	target := kubeTarget{Context: c.Name, Cluster: c.Context.Cluster, Namespace: c.Context.Namespace}
+	if target.Namespace == "" {
+		target.Namespace = "default"
+	}
+	for _, cluster := range raw.Clusters {
+		if cluster.Name == target.Cluster {
+			target.Server = cluster.Cluster.Server
+		}
+	}
+	return target, nil
+}
+
+// productionStages adds a sign-off by a second approver to a single-stage request
+// against a production context; staged policies already require several people.
+func productionStages(stages []ApprovalStage) []ApprovalStage {
+	if len(stages) != 1 {
+		return stages
+	}
+	signOff := stages[0]
+	signOff.Name = "production sign-off"
+	return append(stages, signOff)
+}
+
+// format renders the target as request message lines, flagging production contexts.
+func (t kubeTarget) format(production bool) string {
+	namespace := fmt.Sprintf("`%s`", inlineCode(t.Namespace))
+	if t.Namespace == "*" {
+		namespace = "all namespaces"
+	}
+	line := fmt.Sprintf("\n☸️ **Context:** `%s` · **Namespace:** %s", inlineCode(t.Context), namespace)
+	if production {
+		line = "\n🔴 **PRODUCTION** Kubernetes context" + line
+	}
+	if t.Server != "" {
+		line += fmt.Sprintf("\n**Cluster:** `%s` (`%s`)", inlineCode(t.Cluster), inlineCode(t.Server))
+	}
+	return line
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseKubectlFlags(t *testing.T) {
+	for name, tc := range map[string]struct {
+		args []string
+		want kubectlFlags
+	}{
+		"none":           {[]string{"get", "pods"}, kubectlFlags{}},
+		"separate":       {[]string{"--context", "prod", "-n", "payments", "delete", "pod", "x"}, kubectlFlags{context: "prod", namespace: "payments"}},
+		"equals":         {[]string{"--kubeconfig=/etc/k.yaml", "--namespace=kube-system", "get", "pods"}, kubectlFlags{kubeconfig: "/etc/k.yaml", namespace: "kube-system"}},
+		"joined short":   {[]string{"-npayments", "get", "pods"}, kubectlFlags{namespace: "payments"}},
+		"all namespaces": {[]string{"get", "pods", "-A"}, kubectlFlags{allNamespaces: true}},
+		"after --":       {[]string{"exec", "pod", "--", "sh", "-n", "x"}, kubectlFlags{}},
+	} {
+		if got := parseKubectlFlags(tc.args); got != tc.want {
+			t.Errorf("%s: parseKubectlFlags = %+v, want %+v", name, got, tc.want)
+		}
+	}
+}
+
+func TestParseKubeconfig(t *testing.T) {
+	data := []byte(`{
+		"current-context": "prod-eu",
+		"contexts": [{"name": "prod-eu", "context": {"cluster": "eks-prod", "user": "admin"}}],
+		"clusters": [{"name": "eks-prod", "cluster": {"server": "https://k8s.example.com"}}]
+	}`)
+	got, err := parseKubeconfig(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := kubeTarget{Context: "prod-eu", Cluster: "eks-prod", Server: "https://k8s.example.com", Namespace: "default"}
+	if got != want {
+		t.Errorf("parseKubeconfig = %+v, want %+v", got, want)
+	}
+	if _, err := parseKubeconfig([]byte(`{"contexts": []}`)); err == nil {
+		t.Error("expected error without a context")
+	}
+}
+
+func TestKubeTargetFormat(t *testing.T) {
+	target := kubeTarget{Context: "prod-eu", Cluster: "eks-prod", Server: "https://k8s.example.com", Namespace: "payments"}
+	got := target.format(true)
+	for _, want := range []string{"🔴 **PRODUCTION**", "**Context:** `prod-eu` · **Namespace:** `payments`", "**Cluster:** `eks-prod` (`https://k8s.example.com`)"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("format = %q, want it to contain %q", got, want)
+		}
+	}
+	target.Namespace = "*"
+	if got := target.format(false); strings.Contains(got, "PRODUCTION") || !strings.Contains(got, "all namespaces") {
+		t.Errorf("format = %q", got)
+	}
+}
+
+func TestKubernetesIsProduction(t *testing.T) {
+	k := &KubernetesConfig{ProductionContexts: []string{"prod-*", "*-production"}}
+	for context, want := range map[string]bool{"prod-eu": true, "eks-production": true, "staging": false} {
+		if got := k.isProduction(context); got != want {
+			t.Errorf("isProduction(%q) = %v, want %v", context, got, want)
+		}
+	}
+	if (*KubernetesConfig)(nil).isProduction("prod-eu") {
+		t.Error("nil config flags production")
+	}
+	if err := validateKubernetes(&KubernetesConfig{ProductionContexts: []string{""}}); err == nil {
+		t.Error("expected error for an empty pattern")
+	}
+}
+
+func TestProductionStages(t *testing.T) {
+	single := []ApprovalStage{{Name: "approval", ApproverIDs: []string{"1", "2"}, TimeoutSeconds: 60}}
+	got := productionStages(single)
+	if len(got) != 2 || got[1].Name != "production sign-off" || len(got[1].ApproverIDs) != 2 || got[1].TimeoutSeconds != 60 {
+		t.Errorf("productionStages = %+v, want a second sign-off stage", got)
+	}
+	staged := []ApprovalStage{{Name: "peer"}, {Name: "senior"}}
+	if got := productionStages(staged); len(got) != 2 {
+		t.Errorf("productionStages added a stage to a staged policy: %+v", got)
+	}
+}
+
+func TestKubeconfigPath(t *testing.T) {
+	t.Setenv("HOME", "/home/alice")
+	t.Setenv("KUBECONFIG", "")
+	if got := kubeconfigPath(); got != "/home/alice/.kube/config" {
+		t.Errorf("kubeconfigPath() = %q", got)
+	}
+	t.Setenv("KUBECONFIG", "/etc/kube/a:/etc/kube/b")
+	if got := kubeconfigPath(); got != "/etc/kube/a:/etc/kube/b" {
+		t.Errorf("kubeconfigPath() = %q", got)
+	}
+}
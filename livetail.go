@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	liveTailLines    = 20
+	liveTailInterval = 5 * time.Second
+	// maxLiveTailDisplay keeps the tail within Discord's message limit
+	maxLiveTailDisplay = 1800
+)
+
+// liveTail keeps the last lines of a supervised command's combined output, for
+// showing them while it runs. Writes may come from stdout and stderr concurrently.
+type liveTail struct {
+	mu      sync.Mutex
+	lines   []string
+	partial string
+	changed bool
+}
+
+func (l *liveTail) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.changed = true
+	text := l.partial + string(p)
+	parts := strings.Split(text, "\n")
+	l.partial = parts[len(parts)-1]
+	l.lines = append(l.lines, parts[:len(parts)-1]...)
+	if over := len(l.lines) - liveTailLines; over > 0 {
+		l.lines = append([]string(nil), l.lines[over:]...)
+	}
+	// A command that never prints a newline must not grow the partial line forever
+	if len(l.partial) > maxLiveTailDisplay {
+		l.partial = l.partial[len(l.partial)-maxLiveTailDisplay:]
+	}
+	return len(p), nil
+}
+
+// snapshot returns the current tail, and whether it changed since the last snapshot.
+func (l *liveTail) snapshot() (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	changed := l.changed
+	l.changed = false
+	lines := l.lines
+	if l.partial != "" {
+		lines = append(append([]string(nil), lines...), l.partial)
+	}
+	tail := strings.Join(lines, "\n")
+	if len(tail) > maxLiveTailDisplay {
+		tail = "…" + tail[len(tail)-maxLiveTailDisplay:]
+	}
+	return tail, changed
+}
+
+// formatLiveTail renders the tail message; status is shown below the output.
+func formatLiveTail(tail, status string) string {
+	if tail == "" {
+		tail = " "
+	}
+	// Backticks in the output must not end the code block early
+	tail = strings.ReplaceAll(tail, "```", "`\u200b``")
+	return fmt.Sprintf("📜 **Output:**\n```\n%s\n```\n%s", tail, status)
+}
+
+// follow posts the tail as a reply to the request message and keeps it updated until
+// the returned function is called with the command's exit code, which writes the
+// final state. Secrets are masked in what is posted.
+func (l *liveTail) follow(dg *discordgo.Session, channelID, messageID string, patterns []*regexp.Regexp) func(exitCode int) {
+	msg, err := sendMessage(dg, channelID, &discordgo.MessageSend{
+		Content: formatLiveTail("", "⏳ Running..."),
+		Reference: &discordgo.MessageReference{
+			MessageID: messageID,
+			ChannelID: channelID,
+		},
+	})
+	if err != nil {
+		slog.Warn("failed to post live output", "err", err)
+		return func(int) {}
+	}
+	update := func(tail, status string) {
+		content := formatLiveTail(redact(tail, patterns), status)
+		if _, err := editMessage(dg, &discordgo.MessageEdit{ID: msg.ID, Channel: channelID, Content: &content}); err != nil {
+			slog.Warn("failed to update live output", "err", err)
+		}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(liveTailInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if tail, changed := l.snapshot(); changed {
+					update(tail, "⏳ Running...")
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func(exitCode int) {
+		close(stop)
+		<-done
+		tail, _ := l.snapshot()
+		status := fmt.Sprintf("✅ **Exited with code %d.**", exitCode)
+		if exitCode != 0 {
+			status = fmt.Sprintf("❌ **Exited with code %d.**", exitCode)
+		}
+		update(tail, status)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLiveTail(t *testing.T) {
+	l := &liveTail{}
+	if _, changed := l.snapshot(); changed {
+		t.Error("snapshot of an empty tail reported a change")
+	}
+	for i := 1; i <= 30; i++ {
+		fmt.Fprintf(l, "line %d\n", i)
+	}
+	l.Write([]byte("partial"))
+	tail, changed := l.snapshot()
+	if !changed {
+		t.Error("snapshot after writes reported no change")
+	}
+	lines := strings.Split(tail, "\n")
+	if len(lines) != liveTailLines+1 || lines[0] != "line 11" || lines[len(lines)-1] != "partial" {
+		t.Errorf("tail = %q, want the last %d lines and the partial one", tail, liveTailLines)
+	}
+	if _, changed := l.snapshot(); changed {
+		t.Error("second snapshot reported a change")
+	}
+
+	l.Write([]byte(strings.Repeat("x", 5000)))
+	if tail, _ := l.snapshot(); len(tail) > maxLiveTailDisplay+len("…") {
+		t.Errorf("tail is %d bytes, want at most %d", len(tail), maxLiveTailDisplay)
+	}
+}
+
+func TestFormatLiveTail(t *testing.T) {
+	got := formatLiveTail("a```b", "⏳ Running...")
+	if strings.Count(got, "```") != 2 {
+		t.Errorf("formatLiveTail = %q, want backticks in the output neutralized", got)
+	}
+	if !strings.HasSuffix(got, "\n⏳ Running...") {
+		t.Errorf("formatLiveTail = %q, want the status last", got)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// localApprovalPhrase has to be typed to approve a request on the terminal.
+const localApprovalPhrase = "APPROVE"
+
+// localApprover is how terminal approvals are recorded in the audit log.
+const localApprover = "local:root"
+
+// localApproval asks root for confirmation on the controlling terminal, as a last
+// resort when Discord is unreachable. It returns the audit decision.
+func localApproval(req fallbackRequest, sudoUser string) (string, error) {
+	// Someone elevating through sudo must not be able to approve their own request
+	if os.Geteuid() != 0 || (sudoUser != "" && sudoUser != "root") {
+		return "", errors.New("local approval is only available to root")
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("no controlling terminal: %w", err)
+	}
+	defer tty.Close()
+
+	answer := make(chan bool, 1)
+	go func() {
+		answer <- promptApproval(tty, tty, req)
+	}()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	timer := time.NewTimer(time.Until(req.Expires))
+	defer timer.Stop()
+	select {
+	case approved := <-answer:
+		if approved {
+			return auditDecisionApproved, nil
+		}
+		return auditDecisionDenied, nil
+	case <-timer.C:
+		fmt.Fprintln(tty)
+		return auditDecisionTimeout, nil
+	case <-sigCh:
+		fmt.Fprintln(tty)
+		return auditDecisionInterrupted, nil
+	}
+}
+
+// promptApproval shows req on w and reports whether the approval phrase was typed.
+func promptApproval(r io.Reader, w io.Writer, req fallbackRequest) bool {
+	fmt.Fprintf(w, "Discord is unreachable. Sudo request %s:\n  %s\n  Host: %s\n  CWD: %s\n",
+		req.ID, req.Command, req.Host, req.CWD)
+	fmt.Fprintf(w, "Type %s to continue: ", localApprovalPhrase)
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && line == "" {
+		return false
+	}
+	return strings.TrimSpace(line) == localApprovalPhrase
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPromptApproval(t *testing.T) {
+	req := fallbackRequest{ID: "req", Command: "reboot", Host: "web1"}
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"APPROVE\n", true},
+		{"  APPROVE  \n", true},
+		{"approve\n", false},
+		{"yes\n", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if got := promptApproval(strings.NewReader(tt.input), &out, req); got != tt.want {
+			t.Errorf("promptApproval(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+		if !strings.Contains(out.String(), "reboot") || !strings.Contains(out.String(), "Type APPROVE") {
+			t.Errorf("unexpected prompt: %q", out.String())
+		}
+	}
+}
+
+func TestLocalApprovalRefusesSudoUsers(t *testing.T) {
+	if _, err := localApproval(fallbackRequest{}, "alice"); err == nil {
+		t.Error("expected local approval to be refused for a sudo user")
+	}
+}
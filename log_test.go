@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "warn", "json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger.Info("hidden")
+	logger.Warn("shown", "request_id", "abc")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected only the warning, got %q", buf.String())
+	}
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["msg"] != "shown" || entry["request_id"] != "abc" {
+		t.Errorf("unexpected entry: %v", entry)
+	}
+
+	if _, err := newLogger(&buf, "verbose", "text"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+	if _, err := newLogger(&buf, "info", "xml"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
@@ -1,16 +1,19 @@
 package main
 
 import (
-	"bytes"
-	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
-	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -22,16 +25,117 @@ var configPath = "/etc/prompt-sudo-discord/config.json"
 
 const defaultTimeout = 300
 
-// Button custom IDs
+const defaultExtendMinutes = 5
+
+// Pending request messages are refreshed this often, at most once per minHeartbeatSeconds
+// to stay clear of Discord's rate limits.
+const (
+	defaultHeartbeatSeconds = 60
+	minHeartbeatSeconds     = 30
+)
+
+// shellPath runs --shell command lines.
+const shellPath = "/bin/sh"
+
+// Button custom IDs, bound to the request with componentID
 const (
 	buttonApproveID = "psd_approve"
 	buttonDenyID    = "psd_deny"
+	buttonExtendID  = "psd_extend"
 )
 
 type Config struct {
-	DiscordToken   string   `json:"discord_token"`
-	ApproverIDs    []string `json:"approver_ids"`
-	TimeoutSeconds int      `json:"timeout_seconds"`
+	// DiscordToken is the bot token, or an ASCII-armored age file encrypting it to
+	// the identity in AgeIdentityFile
+	DiscordToken    string `json:"discord_token"`
+	AgeIdentityFile string `json:"age_identity_file"`
+	// DiscordTokenCommand prints the bot token instead, e.g. by decrypting it with a KMS
+	DiscordTokenCommand []string `json:"discord_token_command"`
+	// DiscordTokenSource reads the bot token from elsewhere: "keyring:SERVICE" for the OS keyring
+	DiscordTokenSource string   `json:"discord_token_source"`
+	ApproverIDs        []string `json:"approver_ids"`
+	TimeoutSeconds     int      `json:"timeout_seconds"`
+	// ExtendMinutes is how much time the Extend button adds to the countdown
+	ExtendMinutes int `json:"extend_minutes"`
+	// HeartbeatSeconds is how often pending request messages are refreshed to show
+	// they are still being waited on
+	HeartbeatSeconds int      `json:"heartbeat_seconds"`
+	Policies         []Policy `json:"policies"`
+	AuditLogPath     string   `json:"audit_log_path"`
+	StateDir         string   `json:"state_dir"`
+	// CacheGraceMinutes is the grace window for --cache-key when no policy sets one
+	CacheGraceMinutes int `json:"cache_grace_minutes"`
+	// AnsiblePlayMinutes is how long an approved Ansible play may run privileged tasks
+	AnsiblePlayMinutes int `json:"ansible_play_minutes"`
+	// ApprovalDurationMenu lets approvers choose how long an approval stands
+	ApprovalDurationMenu bool `json:"approval_duration_menu"`
+	// Hosts overrides channel, approvers, timeout and policies by host name pattern
+	Hosts map[string]HostProfile `json:"hosts"`
+	// MaintenanceWindows auto-approve planned work on a schedule
+	MaintenanceWindows []MaintenanceWindow `json:"maintenance_windows"`
+	// Channels maps aliases usable with --channel to channel IDs
+	Channels map[string]string `json:"channels"`
+	// DefaultChannel (an alias or ID) is used when --channel is not given
+	DefaultChannel string `json:"default_channel"`
+	// AllowedGuildIDs and AllowedChannelIDs restrict where requests may be posted and
+	// which servers' interactions are considered, whatever --channel says
+	AllowedGuildIDs   []string `json:"allowed_guild_ids"`
+	AllowedChannelIDs []string `json:"allowed_channel_ids"`
+	// PolicyHook asks an external policy engine how each request is handled
+	PolicyHook *PolicyHookConfig `json:"policy_hook"`
+
+	CostEstimator *CostEstimatorConfig `json:"cost_estimator"`
+	Escalation    *EscalationConfig    `json:"escalation"`
+	Mentions      *MentionConfig       `json:"mentions"`
+	// Buttons customizes the labels, emoji and styles of the request buttons
+	Buttons *ButtonsConfig `json:"buttons"`
+	// AuditSink mirrors audit records to syslog or journald
+	AuditSink *AuditSinkConfig `json:"audit_sink"`
+	// AuditChannelID receives a compact summary of every decision
+	AuditChannelID string `json:"audit_channel_id"`
+	// AuditSigningKeyFile is the Ed25519 key signing audit records and attesting each
+	// decision, created on first use
+	AuditSigningKeyFile string `json:"audit_signing_key_file"`
+	// AuditSigningCommand signs instead, e.g. with a key held by an HSM or TPM, whose
+	// public key is in AuditSigningPublicKeyFile
+	AuditSigningCommand       []string `json:"audit_signing_command"`
+	AuditSigningPublicKeyFile string   `json:"audit_signing_public_key_file"`
+	// AuditHashChain chains each audit record to the previous one by hash, as signing
+	// does; the head is posted to the audit channel every AuditAnchorMinutes
+	AuditHashChain     bool `json:"audit_hash_chain"`
+	AuditAnchorMinutes int  `json:"audit_anchor_minutes"`
+	// SIEM forwards audit records to Splunk or Elasticsearch
+	SIEM []SIEMConfig `json:"siem"`
+	// GeoIPDatabase is an offline IP-to-ASN database locating SSH clients of requests
+	GeoIPDatabase string `json:"geoip_database"`
+	// Webhooks receive an event when a request is created, decided or its command exits
+	Webhooks []WebhookConfig `json:"webhooks"`
+	// Tracing exports OpenTelemetry spans for each request
+	Tracing *TracingConfig `json:"tracing"`
+	// Kubernetes flags kubectl commands against production contexts
+	Kubernetes *KubernetesConfig `json:"kubernetes"`
+	// BreakGlass enables --break-glass, running commands without approval in incidents
+	BreakGlass *BreakGlassConfig `json:"break_glass"`
+	// Fallback decides requests outside Discord when it is unreachable
+	Fallback *FallbackConfig `json:"fallback"`
+	// AllowLocalFallback lets --allow-local-fallback ask root on the terminal as a last resort
+	AllowLocalFallback bool `json:"allow_local_fallback"`
+	// ResourceLimits caps the CPU, memory and processes of approved commands
+	ResourceLimits *ResourceLimitsConfig `json:"resource_limits"`
+	// MaxOutputAttachmentKB bounds each output stream attached with --attach-output
+	MaxOutputAttachmentKB int `json:"max_output_attachment_kb"`
+	// RedactPatterns are regexes masked in displayed commands, in addition to the defaults
+	RedactPatterns []string `json:"redact_patterns"`
+	redactions     []*regexp.Regexp
+	// ExecEnv filters the environment passed to approved commands
+	ExecEnv *ExecEnvConfig `json:"exec_env"`
+	// ShowEnvAllowlist limits which environment variables --show-env may display
+	ShowEnvAllowlist []string `json:"show_env_allowlist"`
+	// DiscordUserIDs maps local user names (SUDO_USER or --requester) to Discord IDs,
+	// so requesters cannot approve their own requests
+	DiscordUserIDs map[string]string `json:"discord_user_ids"`
+	// TOTPSeeds maps approver Discord IDs to base32 TOTP seeds for policies with require_totp
+	TOTPSeeds map[string]string `json:"totp_seeds"`
 }
 
 type ApprovalResult int
@@ -41,22 +145,40 @@ const (
 	ApprovalApproved
 	ApprovalDenied
 	ApprovalTimeout
+	ApprovalCancelled
 	ApprovalError
 )
 
 func loadConfig(path string) (*Config, error) {
+	config, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if config.DiscordToken, err = config.resolveToken(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// readConfig reads a config without resolving the bot token, for subcommands that do
+// not talk to Discord.
+func readConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config: %w", err)
 	}
+	return parseConfig(data)
+}
 
+// parseConfig parses and validates a config, filling in defaults.
+func parseConfig(data []byte) (*Config, error) {
 	var config Config
 	if err := json.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
 
-	if config.DiscordToken == "" {
-		return nil, fmt.Errorf("discord_token is required")
+	if err := validateTokenSource(&config); err != nil {
+		return nil, err
 	}
 	if len(config.ApproverIDs) == 0 {
 		return nil, fmt.Errorf("approver_ids is required")
@@ -64,10 +186,173 @@ func loadConfig(path string) (*Config, error) {
 	if config.TimeoutSeconds <= 0 {
 		config.TimeoutSeconds = defaultTimeout
 	}
+	if config.ExtendMinutes <= 0 {
+		config.ExtendMinutes = defaultExtendMinutes
+	}
+	if config.HeartbeatSeconds == 0 {
+		config.HeartbeatSeconds = defaultHeartbeatSeconds
+	}
+	if config.AnsiblePlayMinutes <= 0 {
+		config.AnsiblePlayMinutes = defaultAnsiblePlayMinutes
+	}
+	if config.HeartbeatSeconds < minHeartbeatSeconds {
+		return nil, fmt.Errorf("heartbeat_seconds must be at least %d", minHeartbeatSeconds)
+	}
+	if config.AuditLogPath == "" {
+		config.AuditLogPath = defaultAuditLogPath
+	}
+	if config.StateDir == "" {
+		config.StateDir = defaultStateDir
+	}
+	if err := validateAuditSigning(&config); err != nil {
+		return nil, err
+	}
+	if config.AuditAnchorMinutes <= 0 {
+		config.AuditAnchorMinutes = defaultAuditAnchorMinutes
+	}
+	if config.MaxOutputAttachmentKB <= 0 {
+		config.MaxOutputAttachmentKB = defaultMaxOutputAttachmentKB
+	}
+	var err error
+	config.redactions, err = compileRedactions(config.RedactPatterns)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePolicies(config.Policies); err != nil {
+		return nil, err
+	}
+	if err := validateHostProfiles(config.Hosts); err != nil {
+		return nil, err
+	}
+	if err := validateChannels(&config); err != nil {
+		return nil, err
+	}
+	if err := validateAllowlist(&config); err != nil {
+		return nil, err
+	}
+	if err := validateMaintenanceWindows(config.MaintenanceWindows); err != nil {
+		return nil, err
+	}
+	if config.PolicyHook != nil {
+		if err := validatePolicyHook(config.PolicyHook); err != nil {
+			return nil, err
+		}
+	}
+	if config.ResourceLimits != nil {
+		if err := validateResourceLimits(config.ResourceLimits); err != nil {
+			return nil, err
+		}
+	}
+	if config.CostEstimator != nil && len(config.CostEstimator.Command) == 0 {
+		return nil, fmt.Errorf("cost_estimator.command is required")
+	}
+	for userID, seed := range config.TOTPSeeds {
+		if _, err := decodeTOTPSeed(seed); err != nil {
+			return nil, fmt.Errorf("totp_seeds[%s]: %w", userID, err)
+		}
+	}
+	if config.Escalation != nil {
+		if err := validateEscalation(config.Escalation); err != nil {
+			return nil, err
+		}
+	}
+	if config.Kubernetes != nil {
+		if err := validateKubernetes(config.Kubernetes); err != nil {
+			return nil, err
+		}
+	}
+	if config.BreakGlass != nil {
+		if err := validateBreakGlass(config.BreakGlass); err != nil {
+			return nil, err
+		}
+	}
+	if config.Buttons != nil {
+		if err := validateButtons(config.Buttons, config.Escalation != nil); err != nil {
+			return nil, err
+		}
+	}
+	if config.AuditSink != nil {
+		if err := validateAuditSink(config.AuditSink); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateSIEM(config.SIEM); err != nil {
+		return nil, err
+	}
+	if err := validateWebhooks(config.Webhooks); err != nil {
+		return nil, err
+	}
+	if config.Tracing != nil {
+		if err := validateTracing(config.Tracing); err != nil {
+			return nil, err
+		}
+	}
+	if config.Fallback != nil {
+		if err := validateFallback(config.Fallback); err != nil {
+			return nil, err
+		}
+	}
 
 	return &config, nil
 }
 
+// buttonClick is a click on one of the request message's buttons, or the equivalent
+// slash command.
+type buttonClick struct {
+	interaction *discordgo.Interaction
+	userID      string
+	customID    string
+	slash       bool
+	// modal is set when the click is a submitted approval modal
+	modal *modalInput
+	// approveFor is the duration chosen from the approval menu, if any
+	approveFor string
+}
+
+// modalInput is what an approver entered into the approval modal.
+type modalInput struct {
+	confirmation string
+	totp         string
+}
+
+// acknowledge answers a click whose effect is shown by editing the request messages.
+// Slash commands have no message to update, so they get an ephemeral confirmation.
+func acknowledge(dg *discordgo.Session, click buttonClick, confirmation string) {
+	if click.slash {
+		respondEphemeral(dg, click.interaction, confirmation)
+		return
+	}
+	deferUpdate(dg, click.interaction)
+}
+
+// respondEphemeral answers an interaction with a message only the clicking user can see.
+func respondEphemeral(dg *discordgo.Session, i *discordgo.Interaction, content string) {
+	dg.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
+
+// messageLink returns a jump link to a message. Message create responses do not
+// carry the guild ID, so it is looked up from the channel; DMs use "@me".
+func messageLink(dg *discordgo.Session, channelID, messageID string) string {
+	guildID := "@me"
+	if ch, err := dg.Channel(channelID); err == nil && ch.GuildID != "" {
+		guildID = ch.GuildID
+	}
+	return fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, channelID, messageID)
+}
+
+// deferUpdate acknowledges an interaction whose message is edited separately.
+func deferUpdate(dg *discordgo.Session, i *discordgo.Interaction) {
+	dg.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+}
+
 func isApprover(userID string, approverIDs []string) bool {
 	for _, id := range approverIDs {
 		if id == userID {
@@ -77,50 +362,325 @@ func isApprover(userID string, approverIDs []string) bool {
 	return false
 }
 
+// formatCommand renders args as a shell command line that parses back into exactly
+// args, so approvers can tell `rm "a b"` from `rm a b` and copy the command.
 func formatCommand(args []string) string {
-	// Simple formatting - in production might want to escape properly
-	return strings.Join(args, " ")
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellSafe matches arguments that need no quoting.
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes s for a POSIX shell. Arguments with control characters such as
+// newlines use bash's $'...' quoting, so they stay visible on a single line.
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	hasControl := strings.ContainsFunc(s, func(r rune) bool { return r < 0x20 || r == 0x7f })
+	if !hasControl {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+	var b strings.Builder
+	b.WriteString("$'")
+	for _, r := range s {
+		switch r {
+		case '\\', '\'':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\x%02x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteString("'")
+	return b.String()
+}
+
+// formatRequestHeader renders the part of the request message shared by every kind of request.
+func formatRequestHeader(commandStr, hostname, cwd string) string {
+	return fmt.Sprintf("**🔐 Sudo Request**\n"+
+		"```\n%s\n```\n"+
+		"**Host:** `%s`\n"+
+		"**CWD:** `%s`",
+		commandStr, hostname, cwd)
+}
+
+// discordTimestamp renders t as timestamp markup, which Discord shows in each reader's
+// own timezone: style R is relative ("in 4 minutes"), T a time and f a date and time.
+func discordTimestamp(t time.Time, style string) string {
+	return fmt.Sprintf("<t:%d:%s>", t.Unix(), style)
+}
+
+// appendStdin appends the buffered stdin to content, truncated to fit Discord's message limit.
+func appendStdin(content string, stdinData []byte) string {
+	stdinDisplay := string(stdinData)
+	// Discord message limit is 2000 chars; reserve space for the rest of the message,
+	// such as the status lines and footer
+	maxStdinDisplay := 2000 - len(content) - len("\n**Stdin:**\n```\n\n```") - 200
+	if maxStdinDisplay < 0 {
+		maxStdinDisplay = 0
+	}
+	if len(stdinDisplay) > maxStdinDisplay {
+		stdinDisplay = stdinDisplay[:maxStdinDisplay] + fmt.Sprintf("\n... (%d bytes truncated)", len(stdinDisplay)-maxStdinDisplay)
+	}
+	return content + fmt.Sprintf("\n**Stdin:**\n```\n%s\n```", stdinDisplay)
+}
+
+// subcommands are run by naming them first; without one, the arguments are a request.
+var subcommands = []struct {
+	name, summary string
+	run           func(args []string) int
+}{
+	{"cancel", "withdraw a pending request", runCancel},
+	{"become", "run an Ansible task, approving its whole play at once", runBecome},
+	{"check", "check the config and the bot's access to Discord", runCheck},
+	{"flush-siem", "deliver audit records buffered while a SIEM was unreachable", runFlushSIEM},
+	{"git-hook", "ask for approval of pushes to protected refs, as a git hook", runGitHook},
+	{"history", "list past requests from the audit log", runHistory},
+	{"preapprove", "let approvers pre-approve commands with /psd preapprove", runPreapprove},
+	{"shell", "run command lines, each approved on its own", runShell},
+	{"status", "list the pending requests", runStatus},
+	{"validate-config", "check a config file without contacting Discord", runValidateConfig},
+	{"verify-audit", "check the signatures of the audit log and find removed records", runVerifyAudit},
+}
+
+// parseFlags parses the flags of a subcommand. When it fails, it returns the exit
+// code to stop with: 0 for -h, or exitConfigError for flags fs reported as invalid.
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	err := fs.Parse(args)
+	switch {
+	case err == nil:
+		return 0, true
+	case errors.Is(err, flag.ErrHelp):
+		return 0, false
+	default:
+		return exitConfigError, false
+	}
+}
+
+// printUsage describes the subcommands.
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage:")
+	fmt.Fprintln(w, "  prompt-sudo-discord [request] [FLAGS] -- COMMAND [ARGS...]")
+	fmt.Fprintln(w, "  prompt-sudo-discord SUBCOMMAND [ARGS...]")
+	fmt.Fprintln(w, "\nSubcommands:")
+	fmt.Fprintf(w, "  %-16s %s\n", "request", "ask for approval, then run the command (default)")
+	for _, c := range subcommands {
+		fmt.Fprintf(w, "  %-16s %s\n", c.name, c.summary)
+	}
 }
 
 func main() {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		for _, c := range subcommands {
+			if args[0] == c.name {
+				os.Exit(c.run(args[1:]))
+			}
+		}
+		switch args[0] {
+		case "help":
+			printUsage(os.Stdout)
+			os.Exit(0)
+		case "request":
+			args = args[1:]
+		}
+	}
+	runRequest(args)
+}
+
+// runRequest asks for approval of the command in args and runs it once approved. It
+// exits instead of returning.
+func runRequest(args []string) {
+	fs := flag.NewFlagSet("request", flag.ContinueOnError)
+	fs.Usage = func() {
+		printUsage(fs.Output())
+		fmt.Fprintln(fs.Output(), "\nRequest flags:")
+		fs.PrintDefaults()
+	}
 	// Parse flags
-	channelID := flag.String("channel", "", "Discord channel ID to post approval request")
-	replyTo := flag.String("reply-to", "", "Message ID to reply to (optional)")
-	timeout := flag.Int("timeout", 0, "Timeout in seconds (default: from config or 300)")
-	showStdin := flag.Bool("show-stdin", false, "Read stdin and include it in the approval request")
+	channelID := fs.String("channel", "", "Comma-separated Discord channel IDs or aliases to post approval request to; the first decision wins (default: default_channel from config)")
+	replyTo := fs.String("reply-to", "", "Message ID to reply to (optional)")
+	timeout := fs.Int("timeout", 0, "Timeout in seconds (default: from config or 300)")
+	showStdin := fs.Bool("show-stdin", false, "Read stdin and include it in the approval request")
+	requester := fs.String("requester", "", "Local user name of the requester (default: SUDO_USER)")
+	dm := fs.Bool("dm", false, "Also send the request to each approver as a direct message")
+	mention := fs.String("mention", "", "Comma-separated Discord user IDs to @-mention in the request")
+	cacheKey := fs.String("cache-key", "", "Reuse an approval of this exact command under this key within the grace window")
+	breakGlass := fs.String("break-glass", "", "In an incident, run at once without waiting for approval, giving this reason; needs break_glass in config")
+	preapprovalToken := fs.String("preapproval", "", "Run at once under this one-time token from /psd preapprove, if the command matches its pattern")
+	showEnvFlag := fs.Bool("show-env", false, "Include allowlisted environment variables in the approval request")
+	tunnel := fs.Duration("tunnel", 0, "Run the command as a tunnel that is closed after this duration (e.g. 30m)")
+	output := fs.String("output", "", "Print the result as a JSON object on stdout before running the command: json")
+	allowLocalFallback := fs.Bool("allow-local-fallback", false, "If Discord is unreachable, let root approve on the terminal (requires allow_local_fallback in config)")
+	backend := fs.String("backend", backendDiscord, "Where requests are decided: discord, or mock in test builds")
+	diffFlag := fs.Bool("diff", false, "Include a diff of the files cp, install or tee will change in the approval request")
+	ansiblePlayName := fs.String("ansible-play", "", "Approve the privileged tasks of this Ansible play at once, as the become subcommand does")
+	ansibleHosts := fs.String("ansible-hosts", "", "With --ansible-play, comma-separated hosts of the play")
+	ansibleTags := fs.String("ansible-tags", "", "With --ansible-play, comma-separated tags the play runs with")
+	terraformPlanFlag := fs.String("terraform-plan", "", "Attach the summary of this saved Terraform plan to the approval request (default: the plan `terraform apply` is given)")
+	stdinPreview := fs.Int("stdin-preview", 0, "With --show-stdin, only read this many KB of stdin for review and stream the rest to the command after approval")
+	attachOutput := fs.Bool("attach-output", false, "Attach the command's output to the request message as files once it exits")
+	liveOutput := fs.Bool("live-output", false, "Show the last lines of the command's output in a reply to the request while it runs")
+	runAsUser := fs.String("user", "", "Run the approved command as this user (name or uid) instead of root")
+	runAsGroup := fs.String("group", "", "Run the approved command with this group (name or gid); default: the user's primary group")
+	execTimeout := fs.Duration("exec-timeout", 0, "Stop the approved command if it runs longer than this (e.g. 10m)")
+	cwdFlag := fs.String("cwd", "", "Run the approved command in this directory instead of the current one")
+	shellLine := fs.String("shell", "", "Run this command line with /bin/sh -c instead of a command after --")
+	session := fs.String("session", "", "Approve an interactive session with this label, run on a pseudo-terminal (default command: /bin/sh)")
+	sessionDuration := fs.Duration("session-duration", defaultSessionDuration, "How long an approved --session may last")
+	ptyFlag := fs.Bool("pty", false, "Run the command on a pseudo-terminal proxied to this terminal, for interactive commands")
+	onApprove := fs.String("on-approve", "", "Run this script as the requesting user once the request is approved, with the decision as JSON on stdin")
+	onDeny := fs.String("on-deny", "", "Run this script as the requesting user once the request is denied, times out or is withdrawn")
+	enqueue := fs.Bool("enqueue", false, "Post the request and exit at once, printing its ID; the command runs in the background once approved")
+	noExec := fs.Bool("no-exec", false, "Only ask for approval; exit 0 if approved instead of running the command")
+	logLevel := fs.String("log-level", "info", "Minimum level of diagnostics on stderr: debug, info, warn or error")
+	logFormat := fs.String("log-format", logFormatText, "Format of diagnostics on stderr: text or json")
 	// Config path is hardcoded - cannot be overridden by arguments for security
 
-	flag.Parse()
+	if code, ok := parseFlags(fs, args); !ok {
+		os.Exit(code)
+	}
+	// Set in the background process of an enqueued request
+	idReport := requestIDReport()
+
+	logger, err := newLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitConfigError)
+	}
+	slog.SetDefault(logger)
 
 	// Get command to execute (everything after --)
-	commandArgs := flag.Args()
+	commandArgs := fs.Args()
+	if *shellLine != "" {
+		if len(commandArgs) > 0 {
+			slog.Error("--shell cannot be combined with a command after --")
+			os.Exit(exitConfigError)
+		}
+		commandArgs = []string{shellPath, "-c", *shellLine}
+	}
+	// A session is a shell unless another interactive command is given
+	if *session != "" && len(commandArgs) == 0 {
+		commandArgs = []string{shellPath}
+	}
 	if len(commandArgs) == 0 {
-		fmt.Fprintln(os.Stderr, "Error: No command specified")
-		fmt.Fprintln(os.Stderr, "Usage: prompt-sudo-discord --channel CHANNEL_ID [--reply-to MSG_ID] -- COMMAND [ARGS...]")
-		os.Exit(1)
+		slog.Error("no command specified", "usage", "prompt-sudo-discord --channel CHANNEL_ID [--reply-to MSG_ID] -- COMMAND [ARGS...]")
+		os.Exit(exitConfigError)
 	}
 
-	if *channelID == "" {
-		fmt.Fprintln(os.Stderr, "Error: --channel is required")
-		os.Exit(1)
+	if *tunnel < 0 {
+		slog.Error("--tunnel must be a positive duration")
+		os.Exit(exitConfigError)
+	}
+	if *execTimeout < 0 || (*execTimeout > 0 && *tunnel > 0) {
+		slog.Error("--exec-timeout must be a positive duration, and tunnels are already time-boxed by --tunnel")
+		os.Exit(exitConfigError)
+	}
+	if *session != "" && (*tunnel > 0 || *execTimeout > 0 || *sessionDuration <= 0) {
+		slog.Error("--session is time-boxed by a positive --session-duration, and cannot be combined with --tunnel or --exec-timeout")
+		os.Exit(exitConfigError)
+	}
+	if _, ok := backends[*backend]; !ok && *backend != backendDiscord {
+		slog.Error("backend is not available in this build", "backend", *backend)
+		os.Exit(exitConfigError)
+	}
+	if *output != "" && *output != outputFormatJSON {
+		slog.Error("--output must be json")
+		os.Exit(exitConfigError)
+	}
+
+	// Enqueued requests are carried out by a background process, so the caller does
+	// not wait for a decision
+	if *enqueue {
+		if *showStdin || *ptyFlag || *session != "" || *tunnel > 0 || *output != "" || *allowLocalFallback || *noExec {
+			slog.Error("--enqueue cannot be combined with --show-stdin, --pty, --session, --tunnel, --output, --allow-local-fallback or --no-exec")
+			os.Exit(exitConfigError)
+		}
+		id, code, err := enqueueRequest(args)
+		if err != nil {
+			slog.Error("failed to enqueue request", "err", err)
+			os.Exit(code)
+		}
+		fmt.Println(id)
+		os.Exit(0)
+	}
+
+	// Change directory up front, so the request shows, the executable is resolved in
+	// and the command runs in the requested directory
+	if *cwdFlag != "" {
+		if info, err := os.Stat(*cwdFlag); err != nil || !info.IsDir() {
+			slog.Error("--cwd must be an existing directory", "dir", *cwdFlag)
+			os.Exit(exitConfigError)
+		}
+		if err := os.Chdir(*cwdFlag); err != nil {
+			slog.Error("failed to change directory", "dir", *cwdFlag, "err", err)
+			os.Exit(exitConfigError)
+		}
+	}
+
+	if *stdinPreview < 0 || (*stdinPreview > 0 && !*showStdin) {
+		slog.Error("--stdin-preview must be a positive size in KB, used with --show-stdin")
+		os.Exit(exitConfigError)
 	}
 
-	// Read stdin if --show-stdin is enabled
+	// Read stdin if --show-stdin is enabled; with --stdin-preview, only its head is
+	// read now and the rest is streamed to the command once approved
 	var stdinData []byte
+	stdinStreamed := false
+	stdinNote := ""
 	if *showStdin {
+		var in io.Reader = os.Stdin
+		limit := int64(*stdinPreview) * 1024
+		if limit > 0 {
+			in = io.LimitReader(os.Stdin, limit)
+		}
 		var err error
-		stdinData, err = io.ReadAll(os.Stdin)
+		stdinData, err = io.ReadAll(in)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
-			os.Exit(1)
+			slog.Error("failed to read stdin", "err", err)
+			os.Exit(exitInternalError)
+		}
+		// A short read means stdin ended within the preview
+		if limit > 0 && int64(len(stdinData)) == limit {
+			stdinStreamed = true
+			stdinNote = fmt.Sprintf("\n⚠️ Only the first %d KB of stdin is shown; the rest is streamed to the command unseen.", *stdinPreview)
 		}
 	}
 
 	// Load config (path is set at build time)
 	config, err := loadConfig(configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
-		os.Exit(1)
+		slog.Error("failed to load config", "err", err)
+		os.Exit(exitConfigError)
+	}
+
+	// Channels may be given by alias, or left to the config's default
+	requestChannels, err := config.resolveChannels(*channelID)
+	if err != nil {
+		slog.Error("invalid --channel", "err", err)
+		os.Exit(exitConfigError)
+	}
+
+	// The profile of this host class replaces the global routing
+	hostname, _ := os.Hostname()
+	hostProfile, hostChannelID := config.applyHostProfile(hostname)
+	if hostProfile != "" {
+		slog.Debug("using host profile", "hosts", hostProfile)
 	}
 
 	// Use timeout from flag, config, or default
@@ -129,239 +689,1312 @@ func main() {
 		timeoutSec = *timeout
 	}
 
-	// Format command for display
+	// How the command runs once approved
+	spec := execSpec{
+		args:      commandArgs,
+		env:       scrubEnv(os.Environ(), config.ExecEnv),
+		pipeStdin: *showStdin,
+		stdinData: stdinData,
+	}
+	// The exit of an enqueued request's command is reported, as nobody waits for it
+	spec.background = idReport != nil
+	if stdinStreamed {
+		spec.stdinRest = os.Stdin
+	}
+
+	// Interactive commands and sessions get a pseudo-terminal, even when stdin was buffered
+	if *ptyFlag || *session != "" {
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			slog.Error("--pty and --session need a terminal", "err", err)
+			os.Exit(exitConfigError)
+		}
+		spec.term = tty
+	}
+
+	// Commands may run as another identity than root once approved
+	runAsName := ""
+	runAsLine := ""
+	if *runAsUser != "" || *runAsGroup != "" {
+		if os.Geteuid() != 0 {
+			slog.Error("--user and --group need to run as root")
+			os.Exit(exitConfigError)
+		}
+		id, err := resolveRunAs(*runAsUser, *runAsGroup)
+		if err != nil {
+			slog.Error("failed to resolve --user/--group", "err", err)
+			os.Exit(exitConfigError)
+		}
+		spec.runAs = id
+		spec.env = id.env(spec.env)
+		runAsName = id.String()
+		runAsLine = id.format()
+	}
+
+	// Pin the executable, so it cannot be swapped between approval and execution
+	binary, err := fingerprintExecutable(commandArgs[0])
+	if err != nil {
+		slog.Error("failed to find executable", "err", err)
+		os.Exit(exitNotFound)
+	}
+	spec.binary = binary
+	// It is run by path after it is verified, so the requester must not be able to
+	// swap it in between
+	requesterID, err := requesterAccount()
+	if err != nil {
+		slog.Error("failed to look up the requester", "err", err)
+		os.Exit(exitInternalError)
+	}
+	if requesterID != nil {
+		if path, ok := replaceableBy(binary.path, requesterID); ok {
+			slog.Error("executable can be replaced by the requester", "executable", binary.path, "through", path)
+			os.Exit(exitCannotExecute)
+		}
+	}
+
+	// Format command for display; secrets are only masked in what is posted
 	commandStr := formatCommand(commandArgs)
+	displayCommand := redactCommand(commandArgs, config.redactions)
+	// Shell command lines are shown as written, and labeled as such
+	shellNote := ""
+	if *shellLine != "" {
+		displayCommand = redact(*shellLine, config.redactions)
+		shellNote = fmt.Sprintf("\n🐚 **Shell command line**, run with `%s -c`: pipes, redirections and `;` apply", shellPath)
+	}
+	cwd, _ := os.Getwd()
+	requestID := newRequestID()
+	reportRequestID(idReport, requestID)
+	componentNonce := newComponentNonce()
+	requesterCtx := currentRequesterContext(*requester)
+	if requesterCtx.SSHClient != "" {
+		requesterCtx.SSHOrigin = lookupSSHOrigin(requesterCtx.SSHClient, config.GeoIPDatabase)
+	}
+	ciCtx, inCI := currentCIContext()
+	ciLines := ""
+	if inCI {
+		ciLines = ciCtx.format()
+	}
+	gitCtx, inGit := currentGitContext(cwd)
+	gitLines := ""
+	if inGit {
+		gitLines = gitCtx.format()
+	}
+	// kubectl commands show the cluster and namespace they act on up front
+	kubeLines := ""
+	kubeProduction := false
+	if isKubectl(commandArgs) {
+		target, err := kubectlTarget(commandArgs)
+		if err != nil {
+			slog.Warn("kubernetes context unavailable", "err", err)
+			kubeLines = "\n☸️ **Context:** unavailable"
+		} else {
+			kubeProduction = config.Kubernetes.isProduction(target.Context)
+			kubeLines = target.format(kubeProduction)
+		}
+	}
+	// docker and podman run and exec show the image and what the container gets of the host
+	containerLines := ""
+	var containerRisks []string
+	if inv, ok := parseContainerCommand(commandArgs); ok {
+		if err := inv.inspect(filepath.Base(commandArgs[0])); err != nil {
+			slog.Warn("container image unavailable", "err", err)
+		}
+		containerLines = inv.format()
+		containerRisks = inv.risks
+	}
+	policy := matchPolicy(config.Policies, commandStr)
+	// Outside its time windows, a policy applies more strictly
+	outsideWindowsNote := ""
+	if policy != nil {
+		var outside bool
+		if policy, outside = policy.at(time.Now()); outside {
+			outsideWindowsNote = fmt.Sprintf("\n🌙 **Outside the time windows** of policy `%s`: stricter rules apply.", policy.Name)
+		}
+	}
+	// An external policy engine has the last word over the static policies
+	if config.PolicyHook != nil {
+		now := time.Now()
+		policy = config.PolicyHook.decide(policy, policyHookInput{
+			Command:     commandStr,
+			Args:        commandArgs,
+			User:        requesterCtx.User,
+			SudoUser:    requesterCtx.SudoUser,
+			Host:        hostname,
+			CWD:         cwd,
+			RunAs:       runAsName,
+			Time:        now.Format(time.RFC3339),
+			Weekday:     now.Weekday().String(),
+			StdinSHA256: stdinSHA256(*showStdin, stdinData),
+		})
+	}
+	// Planned work is auto-approved within a maintenance window, unless a policy denies it
+	// or asks for more than an approval
+	autoApproval := ""
+	var maintenance *MaintenanceWindow
+	if maintenanceApplies(policy, len(containerRisks) > 0 || kubeProduction) {
+		maintenance = activeMaintenanceWindow(config.MaintenanceWindows, commandStr, time.Now())
+	}
+	if policy != nil && policy.Action == policyActionAutoApprove {
+		autoApproval = fmt.Sprintf("by policy `%s`", policy.Name)
+	} else if maintenance != nil {
+		autoApproval = fmt.Sprintf("during maintenance window `%s`", maintenance.Name)
+	}
 
 	// Create Discord session
 	dg, err := discordgo.New(config.DiscordToken)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating Discord session: %v\n", err)
-		os.Exit(1)
+		slog.Error("failed to create Discord session", "err", err)
+		os.Exit(exitDiscordError)
+	}
+
+	// Every request is audited, whatever its outcome
+	auditLog := &auditor{path: config.AuditLogPath, sink: config.AuditSink, base: AuditRecord{
+		RequestID:        requestID,
+		Command:          commandArgs,
+		CommandSHA256:    commandSHA256(commandArgs),
+		Requester:        requesterCtx.User,
+		SudoUser:         requesterCtx.SudoUser,
+		RequesterUID:     requesterUID(),
+		Host:             hostname,
+		MachineID:        machineID(),
+		CWD:              cwd,
+		RequestedAt:      time.Now(),
+		Executable:       binary.path,
+		ExecutableSHA256: binary.sha256,
+		RunAs:            runAsName,
+		Session:          *session,
+	}}
+	if inCI {
+		auditLog.base.CI = &ciCtx
+	}
+	if inGit {
+		auditLog.base.Git = &gitCtx
+	}
+	if policy != nil {
+		auditLog.base.Policy = policy.Name
+	}
+	if maintenance != nil {
+		auditLog.base.MaintenanceWindow = maintenance.Name
+	}
+	if config.AuditChannelID != "" {
+		auditLog.channel = &auditChannel{dg: dg, channelID: config.AuditChannelID}
+	}
+	auditLog.siem, auditLog.stateDir = config.SIEM, config.StateDir
+	auditLog.redactions = config.redactions
+	auditLog.webhooks = config.Webhooks
+	auditLog.signer, err = config.newAuditSigner()
+	if err != nil {
+		slog.Error("failed to load audit signing key", "err", err)
+		os.Exit(exitConfigError)
+	}
+	auditLog.chain = config.AuditHashChain
+	// Anchors are posted to the audit channel; without one, the head is never anchored
+	if (auditLog.signer != nil || auditLog.chain) && auditLog.channel != nil {
+		auditLog.anchorEvery = time.Duration(config.AuditAnchorMinutes) * time.Minute
+	}
+	if *onApprove != "" || *onDeny != "" {
+		callbacks, err := newDecisionCallbacks(*onApprove, *onDeny)
+		if err != nil {
+			slog.Error("invalid --on-approve or --on-deny", "err", err)
+			os.Exit(exitConfigError)
+		}
+		auditLog.callbacks = callbacks
+	}
+
+	// The trace follows the audit records, and is continued by the approved command
+	trace := newTracer(config.Tracing, auditLog.base.RequestedAt, os.Getenv("TRACEPARENT"))
+	trace.setAttr("psd.request_id", requestID)
+	trace.setAttr("psd.command", displayCommand)
+	trace.setAttr("psd.requester", requesterCtx.User)
+	trace.setAttr("psd.policy", auditLog.base.Policy)
+	trace.setAttr("host.name", hostname)
+	auditLog.tracer = trace
+	if *output == outputFormatJSON {
+		auditLog.result = &resultPrinter{w: os.Stdout}
+	}
+	spec.env = trace.traceparent(spec.env)
+	spec.onStart = trace.started
+	spec.onExit = func(exitCode int) {
+		if err := auditLog.exit(exitCode); err != nil {
+			slog.Error("failed to write audit record", "err", err)
+		}
+	}
+
+	// run executes an approved command, as a tunnel replying to the given request
+	// message if asked to. It never returns.
+	run := func(channelID, messageID string) {
+		// A forum post is archived once the command is done with it
+		archive := func() {
+			if err := archiveForumPost(dg, messageID); err != nil {
+				slog.Warn("failed to archive forum post", "thread_id", messageID, "err", err)
+			}
+		}
+		forumPost := isForumPost(channelID, messageID)
+		if *noExec {
+			if forumPost {
+				archive()
+			}
+			trace.finish()
+			os.Exit(0)
+		}
+		// Captured output is attached to the request message once the command exits
+		if *attachOutput && messageID != "" {
+			spec.capture = newOutputCapture(config.MaxOutputAttachmentKB)
+			onExit := spec.onExit
+			spec.onExit = func(exitCode int) {
+				onExit(exitCode)
+				if err := uploadOutput(dg, channelID, messageID, spec.capture, config.redactions); err != nil {
+					slog.Warn("failed to attach command output", "err", err)
+				}
+			}
+		}
+		// The execution timeout is reported to the request message when it fires;
+		// sessions end at theirs
+		spec.timeout = *execTimeout
+		if *session != "" {
+			spec.timeout = *sessionDuration
+		}
+		if messageID != "" {
+			spec.onTimeout = func() {
+				if *session != "" {
+					postReply(dg, channelID, messageID, fmt.Sprintf("⏰ **Session time is up** after %s; ending the session.", *sessionDuration))
+					return
+				}
+				postReply(dg, channelID, messageID, fmt.Sprintf("⏰ **Execution timed out** after %s; stopping the command.", *execTimeout))
+			}
+		}
+		// Resource limits apply through a cgroup; without it, the command does not run
+		if config.ResourceLimits != nil {
+			cg, err := createCgroup(config.ResourceLimits, requestID)
+			if err != nil {
+				slog.Error("failed to set up resource limits", "err", err)
+				os.Exit(exitCannotExecute)
+			}
+			spec.cgroup = cg
+		}
+		// Commands run as child processes are summarized in a reply once they exit
+		if messageID != "" {
+			spec.stats = &execStats{}
+			onExit := spec.onExit
+			spec.onExit = func(exitCode int) {
+				onExit(exitCode)
+				embed := summaryEmbed(spec.stats, exitCode, time.Since(spec.stats.started), auditLog.decided)
+				if err := postSummary(dg, channelID, messageID, embed); err != nil {
+					slog.Warn("failed to post execution summary", "err", err)
+				}
+			}
+		}
+		// The output tail is followed in a reply, ending with the exit code
+		if *liveOutput && messageID != "" {
+			tail := &liveTail{}
+			spec.live = tail
+			onExit := spec.onExit
+			finish := tail.follow(dg, channelID, messageID, config.redactions)
+			spec.onExit = func(exitCode int) {
+				finish(exitCode)
+				onExit(exitCode)
+			}
+		}
+		// Sessions are recorded locally, and their start and end posted as replies; a
+		// session that cannot be recorded does not start
+		if *session != "" {
+			started := time.Now()
+			recording, err := openSessionRecording(config.StateDir, requestID, *session, started)
+			if err != nil {
+				slog.Error("failed to record session", "err", err)
+				os.Exit(exitCannotExecute)
+			}
+			spec.transcript = recording
+			onStart, onExit := spec.onStart, spec.onExit
+			spec.onStart = func(replaced bool) {
+				started = time.Now()
+				onStart(replaced)
+				if messageID != "" {
+					postReply(dg, channelID, messageID, sessionStarted(*session, *sessionDuration))
+				}
+			}
+			spec.onExit = func(exitCode int) {
+				recording.Close()
+				slog.Info("session ended", "session", *session, "recording", recording.Name())
+				if messageID != "" {
+					postReply(dg, channelID, messageID, sessionEnded(*session, time.Since(started), exitCode, recording.Name()))
+				}
+				onExit(exitCode)
+			}
+		}
+		if forumPost {
+			if spec.supervised() || *tunnel > 0 {
+				onExit := spec.onExit
+				spec.onExit = func(exitCode int) {
+					onExit(exitCode)
+					archive()
+				}
+			} else {
+				// Nothing follows a command that replaces this process
+				archive()
+			}
+		}
+		if *tunnel > 0 {
+			runTunnel(dg, channelID, messageID, spec, *tunnel)
+		}
+		runCommand(spec)
+	}
+
+	// conclude audits a decision made outside the Discord wait loop and acts on it. It
+	// never returns.
+	conclude := func(decision, approver string) {
+		var approvers []string
+		if approver != "" {
+			approvers = []string{approver}
+		}
+		if err := auditLog.decision(decision, approvers); err != nil {
+			slog.Error("failed to write audit record", "err", err)
+			os.Exit(exitInternalError)
+		}
+		switch decision {
+		case auditDecisionApproved, auditDecisionAutoApproved, auditDecisionPreapproved, auditDecisionBreakGlass:
+			slog.Info("approved, executing command", "decision", decision, "approver", approver)
+			run("", "")
+		case auditDecisionDenied:
+			slog.Warn("denied", "approver", approver)
+			os.Exit(exitDenied)
+		case auditDecisionTimeout:
+			slog.Warn("timed out")
+			os.Exit(exitTimeout)
+		default:
+			slog.Warn("interrupted")
+			os.Exit(exitInterrupted)
+		}
+	}
+
+	// fallBack decides the request outside Discord when it cannot be reached. It never
+	// returns.
+	fallBack := func(cause error) {
+		local := *allowLocalFallback && config.AllowLocalFallback
+		if config.Fallback == nil && !local {
+			os.Exit(exitDiscordError)
+		}
+		// Links carry a single approval from someone outside Discord, which cannot satisfy
+		// stricter policies, policy approvers or high-risk confirmations
+		if policy != nil && (len(policy.Stages) > 1 || policy.RequireConfirmation || policy.RequireTOTP || len(policy.ApproverIDs) > 0) {
+			slog.Error("policy cannot be satisfied through the fallback", "policy", policy.Name)
+			os.Exit(exitDiscordError)
+		}
+		if len(containerRisks) > 0 || kubeProduction {
+			slog.Error("high-risk requests cannot be approved through the fallback")
+			os.Exit(exitDiscordError)
+		}
+		slog.Warn("Discord is unreachable, using the fallback", "err", cause)
+		req := fallbackRequest{
+			ID:         requestID,
+			Command:    displayCommand,
+			Host:       hostname,
+			CWD:        cwd,
+			Requester:  requesterCtx.User,
+			Requesters: requesterNames(*requester),
+			Expires:    time.Now().Add(time.Duration(timeoutSec) * time.Second),
+		}
+		decision, approver, err := "", "", errors.New("no fallback chain configured")
+		if config.Fallback != nil {
+			decision, approver, err = config.Fallback.decide(req)
+		}
+		// The terminal is the last resort, after the configured chain
+		if err != nil && local {
+			slog.Warn("asking for approval on the terminal", "err", err)
+			decision, err = localApproval(req, requesterCtx.SudoUser)
+			if decision == auditDecisionApproved || decision == auditDecisionDenied {
+				approver = localApprover
+			}
+		}
+		if err != nil {
+			slog.Error("fallback failed", "err", err)
+			os.Exit(exitDiscordError)
+		}
+		conclude(decision, approver)
+	}
+
+	// Route the request to the host profile's channel, then to the policy's own channel
+	// and approvers, if they have them
+	channels := requestChannels
+	replyToID := *replyTo
+	if hostChannelID != "" && !slices.Equal(channels, []string{hostChannelID}) {
+		channels = []string{hostChannelID}
+		// Replies cannot reference a message in another channel
+		replyToID = ""
+	}
+	approverIDs := config.ApproverIDs
+	if policy != nil {
+		if len(policy.ApproverIDs) > 0 {
+			approverIDs = policy.ApproverIDs
+		}
+		if policy.ChannelID != "" && !slices.Equal(channels, []string{policy.ChannelID}) {
+			channels = []string{policy.ChannelID}
+			// Replies cannot reference a message in another channel
+			replyToID = ""
+		}
+	}
+	if len(channels) == 0 && !*dm && *backend == backendDiscord {
+		slog.Error("--channel is required")
+		os.Exit(exitConfigError)
+	}
+	// A caller must not be able to send requests to a server the bot merely happens to be in
+	if *backend == backendDiscord {
+		for _, id := range channels {
+			if err := config.checkAllowedChannel(dg, id); err != nil {
+				slog.Error("refusing to post to channel", "err", err)
+				os.Exit(exitConfigError)
+			}
+		}
+	}
+
+	// Hard-denied commands are rejected before anything is posted to Discord
+	if policy != nil && policy.Action == policyActionDeny {
+		if err := auditLog.decision(auditDecisionPolicyDenied, nil); err != nil {
+			slog.Error("failed to write audit record", "err", err)
+		}
+		if policy.Reason != "" {
+			slog.Error("command denied by policy", "policy", policy.Name, "reason", policy.Reason)
+		} else {
+			slog.Error("command denied by policy", "policy", policy.Name)
+		}
+		os.Exit(exitDenied)
+	}
+
+	// Requests approved within the grace window run again without re-prompting
+	var graceWindow time.Duration
+	if policy != nil && policy.GraceMinutes > 0 {
+		graceWindow = time.Duration(policy.GraceMinutes) * time.Minute
+	} else if *cacheKey != "" {
+		if config.CacheGraceMinutes <= 0 {
+			slog.Error("--cache-key requires cache_grace_minutes in config")
+			os.Exit(exitConfigError)
+		}
+		graceWindow = time.Duration(config.CacheGraceMinutes) * time.Minute
+	}
+	// Tunnels are time-boxed per approval, and streamed stdin is not fully known, so
+	// neither is served from the cache; stricter policies and high-risk requests, such
+	// as production clusters, are approved every time
+	strictPolicy := policy != nil && (len(policy.Stages) > 1 || policy.RequireConfirmation || policy.RequireTOTP)
+	cacheable := *tunnel == 0 && *session == "" && !stdinStreamed && !kubeProduction && len(containerRisks) == 0 && !strictPolicy
+	if !cacheable {
+		graceWindow = 0
+	}
+	offerDurations := config.ApprovalDurationMenu && cacheable
+	approvalKey := approvalCacheKey(*cacheKey, commandArgs, cwd, stdinData, runAsName, requesterIdentity(), binary)
+	// The tasks of an Ansible play run under the approval of the play
+	playNote := ""
+	if *ansiblePlayName != "" && cacheable {
+		play := ansiblePlay{Name: *ansiblePlayName, Hosts: splitList(*ansibleHosts), Tags: splitList(*ansibleTags)}
+		graceWindow = time.Duration(config.AnsiblePlayMinutes) * time.Minute
+		offerDurations = false
+		approvalKey = ansiblePlayKey(play, requesterUID(), runAsName, commandArgs, binary)
+		playNote = play.format(graceWindow)
+	}
+	if graceWindow > 0 || offerDurations {
+		cached, err := lookupApproval(config.StateDir, approvalCacheKeys(approvalKey), time.Now())
+		if err != nil {
+			slog.Warn("approval cache unavailable", "err", err)
+		} else if cached {
+			if err := auditLog.decision(auditDecisionCached, nil); err != nil {
+				slog.Error("failed to write audit record", "err", err)
+				os.Exit(exitInternalError)
+			}
+			slog.Info("approved earlier within the grace window, executing command")
+			run("", "")
+		}
+	}
+
+	// A pre-approval from `/psd preapprove` stands in for the approver, once
+	autoDecision, autoApprover := auditDecisionAutoApproved, ""
+	if *preapprovalToken != "" && autoApproval == "" {
+		// A pre-approval carries a single approval, like the fallback's links
+		if policy != nil && (len(policy.Stages) > 1 || policy.RequireConfirmation || policy.RequireTOTP) {
+			slog.Error("policy cannot be satisfied by a pre-approval", "policy", policy.Name)
+			os.Exit(exitDenied)
+		}
+		if len(containerRisks) > 0 || kubeProduction {
+			slog.Error("high-risk requests cannot be pre-approved")
+			os.Exit(exitDenied)
+		}
+		p, err := consumePreapproval(config.StateDir, *preapprovalToken, commandStr, time.Now())
+		if err != nil {
+			slog.Error("pre-approval rejected", "err", err)
+			os.Exit(exitDenied)
+		}
+		if err := checkPreapprover(p, policy, requesterDiscordIDs(config.DiscordUserIDs, requesterNames(*requester))); err != nil {
+			slog.Error("pre-approval rejected", "preapproval", p.ID, "err", err)
+			os.Exit(exitDenied)
+		}
+		autoApproval = fmt.Sprintf("by pre-approval `%s` of <@%s>", p.ID, p.ApproverID)
+		autoDecision, autoApprover = auditDecisionPreapproved, p.ApproverID
+		auditLog.base.Preapproval = &p
+	}
+
+	// Break-glass runs at once, but loudly: roles are pinged and the audit says so
+	breakGlassBanner := ""
+	if *breakGlass != "" && autoApproval == "" {
+		if config.BreakGlass == nil {
+			slog.Error("--break-glass is not enabled in config")
+			os.Exit(exitConfigError)
+		}
+		if err := config.BreakGlass.checkSecret(); err != nil {
+			slog.Error("break-glass refused", "err", err)
+			os.Exit(exitDenied)
+		}
+		autoApproval = "by **break-glass**, with no approver"
+		autoDecision = auditDecisionBreakGlass
+		auditLog.base.BreakGlass = *breakGlass
+		breakGlassBanner = formatBreakGlass(config.BreakGlass.RoleIDs, *breakGlass, os.Getenv("SUDO_USER"), requesterUID())
+		slog.Warn("breaking glass", "reason", *breakGlass)
+	}
+
+	// Other backends (e.g. the mock backend of test builds) replace Discord entirely
+	if *backend != backendDiscord {
+		if autoApproval != "" {
+			conclude(autoDecision, autoApprover)
+		}
+		decision, approver, err := backends[*backend](fallbackRequest{
+			ID:        requestID,
+			Command:   displayCommand,
+			Host:      hostname,
+			CWD:       cwd,
+			Requester: requesterCtx.User,
+			Expires:   time.Now().Add(time.Duration(timeoutSec) * time.Second),
+		})
+		if err != nil {
+			slog.Error("backend failed", "backend", *backend, "err", err)
+			os.Exit(exitDiscordError)
+		}
+		conclude(decision, approver)
+	}
+
+	// Auto-approved commands skip the approval flow but are still announced and audited
+	if autoApproval != "" {
+		infoContent := breakGlassBanner + formatRequestHeader(displayCommand, hostname, cwd) + kubeLines + containerLines + shellNote + runAsLine + binary.format() + requesterCtx.format() + ciLines + gitLines
+		if *showEnvFlag {
+			infoContent += showEnv(config.ShowEnvAllowlist)
+		}
+		if *tunnel > 0 {
+			infoContent += fmt.Sprintf("\n**Tunnel:** closes %s after approval", *tunnel)
+		}
+		if *session != "" {
+			infoContent += formatSessionRequest(*session, *sessionDuration)
+		}
+		if *showStdin {
+			infoContent = appendStdin(infoContent, stdinData) + stdinNote
+		}
+		infoContent += "\n\n✅ **Auto-approved** " + autoApproval + "."
+		infoSend := &discordgo.MessageSend{Content: infoContent}
+		if breakGlassBanner != "" {
+			infoSend.AllowedMentions = &discordgo.MessageAllowedMentions{Roles: config.BreakGlass.RoleIDs}
+		}
+		var infoMsg *discordgo.Message
+		var announceErr error
+		if len(channels) > 0 {
+			msgs, errs := sendToChannels(dg, channels, infoSend, replyToID, forumTitle(hostname, displayCommand))
+			for _, err := range errs {
+				slog.Warn("failed to send Discord message", "err", err)
+			}
+			if len(msgs) == 0 {
+				announceErr = fmt.Errorf("failed to send Discord message: %w", errors.Join(errs...))
+			} else {
+				infoMsg = msgs[0]
+				// Only the first post follows the execution; the others are resolved already
+				for _, m := range msgs[1:] {
+					if isForumPost(m.ChannelID, m.ID) {
+						if err := archiveForumPost(dg, m.ID); err != nil {
+							slog.Warn("failed to archive forum post", "thread_id", m.ID, "err", err)
+						}
+					}
+				}
+			}
+		} else {
+			// --dm without a channel: let the approvers know directly
+			dms, errs := sendDMs(dg, approverIDs, infoSend)
+			for _, err := range errs {
+				slog.Warn("failed to DM approver", "err", err)
+			}
+			if len(dms) == 0 {
+				announceErr = errors.New("no approver could be reached by DM")
+			} else {
+				infoMsg = dms[0]
+			}
+		}
+		// Break-glass is for incidents, which may include Discord itself: the run goes on
+		// unannounced, and the audit log says so
+		if announceErr != nil {
+			if breakGlassBanner == "" {
+				slog.Error("failed to announce auto-approved command", "err", announceErr)
+				os.Exit(exitDiscordError)
+			}
+			slog.Error("break-glass run could not be announced in Discord, running anyway", "err", announceErr)
+			auditLog.base.AnnounceError = announceErr.Error()
+		}
+
+		channelID, messageID := "", ""
+		if infoMsg != nil {
+			channelID, messageID = infoMsg.ChannelID, infoMsg.ID
+		}
+		if auditLog.result != nil && infoMsg != nil {
+			auditLog.result.link = messageLink(dg, channelID, messageID)
+		}
+		var approvers []string
+		if autoApprover != "" {
+			approvers = []string{autoApprover}
+		}
+		if err := auditLog.decision(autoDecision, approvers); err != nil {
+			slog.Error("failed to write audit record", "err", err)
+			os.Exit(exitInternalError)
+		}
+
+		slog.Info("auto-approved, executing command", "policy", auditLog.base.Policy, "maintenance_window", auditLog.base.MaintenanceWindow)
+		run(channelID, messageID)
 	}
 
 	// No specific intents needed; interactions arrive via the gateway regardless
 
-	// Channel for approval result
-	resultCh := make(chan ApprovalResult, 1)
-	var requestMsgID string
+	// Button clicks are handed to the wait loop below, which tracks the approval stages
+	clickCh := make(chan buttonClick, 16)
+	var requestMsgs requestMessages
 
 	// Interaction handler (button clicks)
 	dg.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-		if i.Type != discordgo.InteractionMessageComponent {
+		if !config.interactionAllowed(i.GuildID, i.ChannelID, requestMsgs.channelIDs()) {
 			return
 		}
-
-		// Only process interactions on our request message
-		if i.Message == nil || i.Message.ID != requestMsgID {
+		var click buttonClick
+		switch i.Type {
+		case discordgo.InteractionMessageComponent:
+			// Only process interactions on our request messages
+			if i.Message == nil || !requestMsgs.contains(i.Message.ID) {
+				return
+			}
+			data := i.MessageComponentData()
+			base, ok := parseComponentID(data.CustomID, componentNonce)
+			if !ok {
+				slog.Warn("ignoring component interaction with a foreign or forged custom ID", "custom_id", data.CustomID)
+				return
+			}
+			click.customID = base
+			if base == selectApproveForID && len(data.Values) == 1 {
+				click.customID = buttonApproveID
+				click.approveFor = data.Values[0]
+			}
+		case discordgo.InteractionApplicationCommand:
+			// Every running instance sees every slash command; only answer for our request
+			action, id, ok := parseSlashCommand(i.ApplicationCommandData())
+			if !ok || id != requestID {
+				return
+			}
+			click.customID = slashButtons[action]
+			click.slash = true
+		case discordgo.InteractionModalSubmit:
+			data := i.ModalSubmitData()
+			if data.CustomID != confirmModalID(requestID) {
+				return
+			}
+			click.customID = buttonApproveID
+			click.modal = &modalInput{
+				confirmation: modalValue(data, confirmInputID),
+				totp:         modalValue(data, totpInputID),
+			}
+			// Modals opened from a slash command have no message to update
+			click.slash = i.Message == nil
+		default:
 			return
 		}
 
-		// Check if user is an approver
-		userID := ""
+		click.interaction = i.Interaction
 		if i.Member != nil {
-			userID = i.Member.User.ID
+			click.userID = i.Member.User.ID
 		} else if i.User != nil {
-			userID = i.User.ID
-		}
-		if !isApprover(userID, config.ApproverIDs) {
-			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Content: "⚠️ You are not an authorized approver.",
-					Flags:   discordgo.MessageFlagsEphemeral,
-				},
-			})
-			return
+			click.userID = i.User.ID
 		}
-
-		customID := i.MessageComponentData().CustomID
-
-		switch customID {
-		case buttonApproveID:
-			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseDeferredMessageUpdate,
-			})
-			select {
-			case resultCh <- ApprovalApproved:
-			default:
-			}
-		case buttonDenyID:
-			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseDeferredMessageUpdate,
-			})
-			select {
-			case resultCh <- ApprovalDenied:
-			default:
-			}
+		select {
+		case clickCh <- click:
+		default:
 		}
 	})
 
+	// The gateway reconnects and resumes on its own; the wait loop re-validates the
+	// request messages once it is back, since clicks may have been missed meanwhile
+	reconnectCh := make(chan struct{}, 1)
+	dg.ShouldReconnectOnError = true
+	disconnected := false
+	var disconnectMu sync.Mutex
+	dg.AddHandler(func(s *discordgo.Session, d *discordgo.Disconnect) {
+		disconnectMu.Lock()
+		defer disconnectMu.Unlock()
+		disconnected = true
+		slog.Warn("Discord gateway disconnected, reconnecting")
+	})
+	onReconnect := func() {
+		disconnectMu.Lock()
+		defer disconnectMu.Unlock()
+		if !disconnected {
+			return
+		}
+		disconnected = false
+		select {
+		case reconnectCh <- struct{}{}:
+		default:
+		}
+	}
+	dg.AddHandler(func(s *discordgo.Session, r *discordgo.Resumed) { onReconnect() })
+	dg.AddHandler(func(s *discordgo.Session, r *discordgo.Ready) { onReconnect() })
+
 	// Open websocket connection
 	err = dg.Open()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening Discord connection: %v\n", err)
-		os.Exit(1)
+		slog.Error("failed to open Discord connection", "err", err)
+		fallBack(err)
 	}
 	defer dg.Close()
 
+	if err := ensureSlashCommand(dg); err != nil {
+		slog.Warn("failed to register slash command", "command", "/"+slashCommandName, "err", err)
+	}
+
 	// Build the request message
-	hostname, _ := os.Hostname()
-	cwd, _ := os.Getwd()
+	stages := approvalStages(policy, approverIDs, timeoutSec)
+	// Production clusters need a second person, not a larger pool of approvers
+	if kubeProduction {
+		stages = productionStages(stages)
+	}
 
-	requestContent := fmt.Sprintf("**🔐 Sudo Request**\n"+
-		"```\n%s\n```\n"+
-		"**Host:** `%s`\n"+
-		"**CWD:** `%s`\n"+
-		"**Timeout:** %ds",
-		commandStr, hostname, cwd, timeoutSec)
+	// Four-eyes: the requester's own Discord account can never approve
+	requesterIDs := requesterDiscordIDs(config.DiscordUserIDs, requesterNames(*requester))
+	for _, stage := range stages {
+		if len(withoutRequesters(stage.ApproverIDs, requesterIDs)) == 0 {
+			slog.Error("no approver other than the requester", "stage", stage.Name)
+			os.Exit(exitConfigError)
+		}
+	}
+	if kubeProduction && len(withoutRequesters(stageApproverIDs(stages), requesterIDs)) < 2 {
+		slog.Error("production Kubernetes contexts need two approvers other than the requester")
+		os.Exit(exitConfigError)
+	}
+	requestContent := formatRequestHeader(displayCommand, hostname, cwd) + kubeLines + containerLines + shellNote + runAsLine + binary.format() + requesterCtx.format() + ciLines + gitLines + playNote + outsideWindowsNote
+	if *showEnvFlag {
+		requestContent += showEnv(config.ShowEnvAllowlist)
+	}
+	// The deadline is shown in the waiting status, which follows extensions and stages
+	if len(stages) > 1 {
+		requestContent += "\n**Stages:** " + formatStages(stages)
+	}
+	if *tunnel > 0 {
+		requestContent += fmt.Sprintf("\n**Tunnel:** closes %s after approval", *tunnel)
+	}
+	if *session != "" {
+		requestContent += formatSessionRequest(*session, *sessionDuration)
+	}
 
-	if *showStdin {
-		stdinDisplay := string(stdinData)
-		// Discord message limit is 2000 chars; reserve space for the rest of the message
-		maxStdinDisplay := 2000 - len(requestContent) - len("\n**Stdin:**\n```\n\n```") - 50
-		if maxStdinDisplay < 0 {
-			maxStdinDisplay = 0
+	// High-risk commands need the approver to type the host name (or a keyword), as do
+	// containers given the host's privileges or files and production clusters
+	confirmationText := ""
+	if (policy != nil && policy.RequireConfirmation) || len(containerRisks) > 0 || kubeProduction {
+		if policy != nil {
+			confirmationText = policy.ConfirmationText
 		}
-		if len(stdinDisplay) > maxStdinDisplay {
-			stdinDisplay = stdinDisplay[:maxStdinDisplay] + fmt.Sprintf("\n... (%d bytes truncated)", len(stdinDisplay)-maxStdinDisplay)
+		if confirmationText == "" {
+			confirmationText = hostname
+		}
+		if len(containerRisks) > 0 {
+			requestContent += fmt.Sprintf("\n⚠️ **High risk:** the container gets %s; approving requires typing a confirmation.", formatContainerRisks(containerRisks))
+		} else if kubeProduction {
+			requestContent += "\n⚠️ **High risk:** production Kubernetes context; approving requires typing a confirmation and a second approver."
+		} else {
+			requestContent += "\n⚠️ **High risk:** approving requires typing a confirmation."
 		}
-		requestContent += fmt.Sprintf("\n**Stdin:**\n```\n%s\n```", stdinDisplay)
+	}
+	requireTOTP := policy != nil && policy.RequireTOTP
+	if requireTOTP {
+		requestContent += "\n🔑 Approving requires an authenticator code."
 	}
 
-	msgSend := &discordgo.MessageSend{
-		Content: requestContent,
-		Components: []discordgo.MessageComponent{
-			discordgo.ActionsRow{
-				Components: []discordgo.MessageComponent{
-					discordgo.Button{
-						Label:    "Approve",
-						Style:    discordgo.SuccessButton,
-						CustomID: buttonApproveID,
-						Emoji: &discordgo.ComponentEmoji{
-							Name: "✅",
-						},
-					},
-					discordgo.Button{
-						Label:    "Deny",
-						Style:    discordgo.DangerButton,
-						CustomID: buttonDenyID,
-						Emoji: &discordgo.ComponentEmoji{
-							Name: "❌",
-						},
-					},
-				},
-			},
-		},
+	// Attach projected cost for cloud provisioning commands
+	if config.CostEstimator != nil && isCloudCLI(commandArgs) {
+		estimate, err := estimateCost(config.CostEstimator, commandArgs, config.redactions)
+		if err != nil {
+			slog.Warn("cost estimator failed", "err", err)
+			requestContent += "\n**Estimate:** unavailable"
+		} else if estimate != "" {
+			requestContent += fmt.Sprintf("\n**Estimate:**\n```\n%s\n```", estimate)
+		}
+	}
+
+	// Preview what file-modifying commands will change
+	if *diffFlag {
+		changes, err := plannedChanges(commandArgs, stdinData, *showStdin && !stdinStreamed)
+		if err != nil {
+			slog.Warn("diff preview unavailable", "err", err)
+			requestContent += "\n**Diff:** unavailable"
+		} else {
+			requestContent += redact(formatChanges(changes), config.redactions)
+		}
+	}
+
+	// Approvers of terraform apply review the plan it carries out, not just its name
+	var planFiles []*discordgo.File
+	tfGlobals, tfPlanFile, tfApply := terraformApply(commandArgs)
+	if *terraformPlanFlag != "" {
+		// Absolute, so it is not taken relative to a -chdir of the command
+		tfPlanFile, _ = filepath.Abs(*terraformPlanFlag)
 	}
-	if *replyTo != "" {
-		msgSend.Reference = &discordgo.MessageReference{
-			MessageID: *replyTo,
-			ChannelID: *channelID,
+	if tfPlanFile != "" {
+		cli := "terraform"
+		if tfApply {
+			cli = filepath.Base(commandArgs[0])
+		}
+		plan, err := readTerraformPlan(cli, tfGlobals, tfPlanFile)
+		if err != nil {
+			slog.Warn("terraform plan unavailable", "err", err)
+			requestContent += "\n**Terraform plan:** unavailable"
+		} else if len(plan.resources) == 0 {
+			requestContent += "\n**Terraform plan:** " + plan.summary()
+		} else {
+			requestContent += fmt.Sprintf("\n**Terraform plan:** %s Resources in `%s`.", plan.summary(), terraformPlanAttachment)
+			planFiles = append(planFiles, &discordgo.File{
+				Name:        terraformPlanAttachment,
+				ContentType: "text/plain",
+				Reader:      strings.NewReader(redact(plan.attachment(tfPlanFile), config.redactions)),
+			})
 		}
+	} else if tfApply {
+		requestContent += "\n⚠️ **Terraform:** applies without a saved plan, so its changes cannot be shown."
 	}
 
-	// Send the request message
-	var msg *discordgo.Message
-	msg, err = dg.ChannelMessageSendComplex(*channelID, msgSend)
+	if *showStdin {
+		requestContent = appendStdin(requestContent, stdinData) + stdinNote
+	}
 
+	// Mentions only go into the initial message so later edits don't ping again
+	mentionUserIDs, mentionRoleIDs, err := requestMentions(config.Mentions, splitList(*mention), config.StateDir)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error sending Discord message: %v\n", err)
-		os.Exit(1)
+		slog.Warn("mention rotation unavailable, mentioning every user", "err", err)
 	}
-	requestMsgID = msg.ID
+	// The request ID footer lets approvers decide with /psd when buttons misbehave
+	requestedAt := time.Now()
+	footer := fmt.Sprintf("-# Request ID: `%s` · Requested %s", requestID, discordTimestamp(requestedAt, "f"))
 
-	fmt.Fprintf(os.Stderr, "Approval request sent (message ID: %s)\n", requestMsgID)
-	fmt.Fprintf(os.Stderr, "Waiting for approval (timeout: %ds)...\n", timeoutSec)
+	// The countdown of the first stage starts when the request is made, so the deadline
+	// shown in the request is the one enforced
+	stageStarted := requestedAt
+	// stageTimeout grows when approvers extend the countdown
+	stageTimeout := stages[0].timeout()
+	// statusLines records the progress of multi-stage approvals below the request
+	var statusLines []string
+	withStatus := func(status string) string {
+		lines := append(append([]string{}, statusLines...), status)
+		return requestContent + "\n\n" + strings.Join(lines, "\n") + "\n" + footer
+	}
+	// waitingStatus ends with when the request was last refreshed, which the heartbeat
+	// keeps recent: a request whose process is gone shows an old check and a past deadline
+	waitingStatus := func(stageIdx int) string {
+		expires := discordTimestamp(stageStarted.Add(stageTimeout), "R")
+		checked := "\n-# 🟢 Still waiting, checked " + discordTimestamp(time.Now(), "R")
+		if len(stages) == 1 {
+			return fmt.Sprintf("⏳ Waiting for approval, expires %s...", expires) + checked
+		}
+		return fmt.Sprintf("⏳ Waiting for stage %d/%d (**%s**), expires %s...", stageIdx+1, len(stages), stages[stageIdx].Name, expires) + checked
+	}
+	initialContent := withStatus(waitingStatus(0))
+	if mentions := formatMentions(mentionUserIDs, mentionRoleIDs); mentions != "" {
+		initialContent = mentions + "\n" + initialContent
+	}
 
-	// Setup context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutSec)*time.Second)
-	defer cancel()
+	// Buttons are labeled for the server of the first channel, since every copy of the
+	// request carries the same ones
+	var buttonLocale string
+	if config.Buttons != nil && len(config.Buttons.Locales) > 0 && len(channels) > 0 {
+		buttonLocale = guildLocale(dg, channels[0])
+	}
+	requestButtons := func(escalate bool) discordgo.ActionsRow {
+		return config.Buttons.requestButtons(buttonLocale, config.ExtendMinutes, escalate, componentNonce)
+	}
+	msgSend := &discordgo.MessageSend{
+		Content: initialContent,
+		Components: []discordgo.MessageComponent{
+			requestButtons(config.Escalation != nil),
+		},
+		Files: planFiles,
+	}
+	// Approvers may let the approval stand for a while so identical requests don't
+	// prompt again; high-risk and multi-stage requests are only ever approved once
+	if offerDurations && len(stages) == 1 && confirmationText == "" && !requireTOTP {
+		msgSend.Components = append(msgSend.Components, approvalDurationMenu(componentNonce))
+	}
+
+	post := trace.child("post request")
+	// Send the request message to every channel; the first message sent is the
+	// primary one that escalations link to and tunnel status replies to, and the
+	// first decision on any of them wins
+	// Fail fast on channels where the request could not be posted or would go unseen
+	var checked []string
+	for _, id := range channels {
+		blind, err := checkRequestChannel(dg, dg.State.User.ID, id, stageApproverIDs(stages))
+		if err != nil {
+			slog.Error("cannot post the request to this channel", "channel_id", id, "err", err)
+			continue
+		}
+		if len(blind) > 0 {
+			slog.Warn("some approvers cannot see the channel", "channel_id", id, "approvers", blind)
+		}
+		checked = append(checked, id)
+	}
+	if len(channels) > 0 && len(checked) == 0 && !*dm {
+		os.Exit(exitDiscordError)
+	}
+	channels = checked
+	if len(channels) > 0 {
+		msgs, errs := sendToChannels(dg, channels, msgSend, replyToID, forumTitle(hostname, displayCommand))
+		for _, err := range errs {
+			slog.Warn("failed to send Discord message", "err", err)
+		}
+		if len(msgs) == 0 {
+			slog.Error("failed to send Discord message", "err", errors.Join(errs...))
+			fallBack(errors.Join(errs...))
+		}
+		for _, msg := range msgs {
+			requestMsgs.add(msg, "")
+			slog.Info("approval request sent", "channel_id", msg.ChannelID, "message_id", msg.ID)
+		}
+	}
+	if *dm {
+		dms, errs := sendDMs(dg, stageApproverIDs(stages), msgSend)
+		for _, err := range errs {
+			slog.Warn("failed to DM approver", "err", err)
+		}
+		for _, m := range dms {
+			requestMsgs.add(m, "")
+		}
+		if len(dms) == 0 && len(channels) == 0 {
+			slog.Error("no approver could be reached by DM")
+			fallBack(errors.Join(errs...))
+		}
+		slog.Info("approval request sent by DM", "approvers", len(dms))
+	}
+	post.finish()
+	trace.waiting()
+	primary := requestMsgs.all()[0]
+	primaryLink := messageLink(dg, primary.ChannelID, primary.ID)
+	if auditLog.result != nil {
+		auditLog.result.link = primaryLink
+	}
+	auditLog.created(primaryLink)
+	// The requester can withdraw the request with `cancel`, which signals SIGUSR1
+	cancelCh := make(chan os.Signal, 1)
+	signal.Notify(cancelCh, syscall.SIGUSR1)
+	pending, err := registerPending(config.StateDir, pendingRequest{
+		RequestID: requestID,
+		PID:       os.Getpid(),
+		SudoUser:  os.Getenv("SUDO_USER"),
+		Command:   displayCommand,
+		ChannelID: primary.ChannelID,
+		Link:      primaryLink,
+		Requested: requestedAt,
+		Deadline:  stageStarted.Add(stageTimeout),
+	})
+	if err != nil {
+		slog.Warn("the request cannot be cancelled with the cancel command", "err", err)
+	}
+	slog.Info("waiting for approval", "request_id", requestID, "timeout_seconds", timeoutSec)
 
 	// Handle interrupt
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
 
-	// Wait for result
+	// The heartbeat refreshes the waiting status when nothing else has for a while
+	heartbeatInterval := time.Duration(config.HeartbeatSeconds) * time.Second
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	// editAll updates every message carrying the request, e.g. the original and its escalation
+	editAll := func(content string, components []discordgo.MessageComponent) {
+		heartbeat.Reset(heartbeatInterval)
+		for _, m := range requestMsgs.all() {
+			editContent := content
+			if m.prefix != "" {
+				editContent = m.prefix + "\n" + content
+			}
+			_, err := editMessage(dg, &discordgo.MessageEdit{
+				ID:         m.ID,
+				Channel:    m.ChannelID,
+				Content:    &editContent,
+				Components: &components,
+			})
+			if err != nil {
+				slog.Warn("failed to update Discord message", "message_id", m.ID, "err", err)
+			}
+		}
+	}
+	// disableButtons edits the request messages to remove buttons and append a status line
+	disableButtons := func(status string) {
+		editAll(withStatus(status), []discordgo.MessageComponent{})
+	}
+
+	// Unanswered requests escalate once, after a fraction of the current stage's timeout
+	var escalateCh <-chan time.Time
+	escalated := false
+	// escalatedStage is the stage the escalation approvers were called in for
+	escalatedStage := -1
+	if config.Escalation != nil {
+		escalateTimer := time.NewTimer(time.Until(stageStarted.Add(config.Escalation.after(stageTimeout))))
+		defer escalateTimer.Stop()
+		escalateCh = escalateTimer.C
+	}
+
+	// Wait for each approval stage in turn
 	var result ApprovalResult
-	select {
-	case result = <-resultCh:
-		// Got a response
-	case <-ctx.Done():
-		result = ApprovalTimeout
-	case <-sigCh:
-		fmt.Fprintln(os.Stderr, "\nInterrupted")
-		// Update Discord message - remove buttons and show cancelled status
-		cancelContent := requestContent + "\n\n⚠️ **Cancelled** (interrupted)."
-		dg.ChannelMessageEditComplex(&discordgo.MessageEdit{
-			ID:         requestMsgID,
-			Channel:    *channelID,
-			Content:    &cancelContent,
-			Components: &[]discordgo.MessageComponent{},
-		})
-		os.Exit(130)
+	var approvedBy []string
+	var approvedFor *approvalDuration
+	var deniedBy string
+	var withdrawnBy string
+	stageIdx := 0
+	stageTimer := time.NewTimer(time.Until(stageStarted.Add(stageTimeout)))
+	defer stageTimer.Stop()
+	// escalate reposts the request to the escalation channel with notice above it,
+	// dropping the Escalate button from every copy
+	escalate := func(notice string, stageIdx int) {
+		escalateCh = nil
+		escalated = true
+		escalatedStage = stageIdx
+		if config.Buttons != nil && config.Buttons.Escalate != nil {
+			msgSend.Components[0] = requestButtons(false)
+			editAll(withStatus(waitingStatus(stageIdx)), msgSend.Components)
+		}
+		escalationSend := &discordgo.MessageSend{
+			Content:    notice + "\n" + withStatus("🔗 Originally posted: "+messageLink(dg, primary.ChannelID, primary.ID)),
+			Components: msgSend.Components,
+		}
+		escalationMsg, err := sendMessage(dg, config.Escalation.ChannelID, escalationSend)
+		if err != nil {
+			slog.Error("failed to send escalation message", "err", err)
+			return
+		}
+		requestMsgs.add(escalationMsg, notice)
 	}
+wait:
+	for {
+		select {
+		case click := <-clickCh:
+			stage := stages[stageIdx]
+			if !stageApprover(click.userID, stage, stageIdx, escalatedStage, config.Escalation) {
+				respondEphemeral(dg, click.interaction, "⚠️ You are not an authorized approver.")
+				continue
+			}
+			// Every stage needs a different person
+			if isApprover(click.userID, approvedBy) {
+				respondEphemeral(dg, click.interaction, "⚠️ You already approved an earlier stage.")
+				continue
+			}
 
-	// disableButtons edits the original message to remove buttons and append a status line
-	disableButtons := func(status string) {
-		editContent := requestContent + "\n\n" + status
-		dg.ChannelMessageEditComplex(&discordgo.MessageEdit{
-			ID:         requestMsgID,
-			Channel:    *channelID,
-			Content:    &editContent,
-			Components: &[]discordgo.MessageComponent{},
-		})
+			switch click.customID {
+			case buttonApproveID:
+				if isApprover(click.userID, requesterIDs) {
+					respondEphemeral(dg, click.interaction, "⚠️ You cannot approve your own request. Another approver is required.")
+					continue
+				}
+				if click.approveFor != "" {
+					d, ok := findApprovalDuration(click.approveFor)
+					if !ok {
+						respondEphemeral(dg, click.interaction, "⚠️ Unknown approval duration.")
+						continue
+					}
+					approvedFor = &d
+				}
+				if confirmationText != "" || requireTOTP {
+					if click.modal == nil {
+						if requireTOTP && config.TOTPSeeds[click.userID] == "" {
+							respondEphemeral(dg, click.interaction, "⚠️ This request requires an authenticator code, but none is configured for you.")
+							continue
+						}
+						dg.InteractionRespond(click.interaction, approvalModal(requestID, confirmationText, requireTOTP))
+						continue
+					}
+					if confirmationText != "" && !confirmationMatches(click.modal.confirmation, confirmationText) {
+						respondEphemeral(dg, click.interaction, "⚠️ Confirmation did not match. The request was not approved.")
+						continue
+					}
+					if requireTOTP && !validateTOTP(config.TOTPSeeds[click.userID], click.modal.totp, time.Now()) {
+						respondEphemeral(dg, click.interaction, "⚠️ Invalid authenticator code. The request was not approved.")
+						continue
+					}
+				}
+				approvedBy = append(approvedBy, click.userID)
+				stageIdx++
+				if stageIdx == len(stages) {
+					acknowledge(dg, click, "✅ Approved.")
+					result = ApprovalApproved
+					break wait
+				}
+
+				// Escalate to the next stage, which gets its own timeout
+				next := stages[stageIdx]
+				statusLines = append(statusLines, fmt.Sprintf("☑️ Stage %d/%d (**%s**) approved by <@%s>.", stageIdx, len(stages), stage.Name, click.userID))
+				slog.Info("stage approved, waiting for next stage", "stage", stage.Name, "next_stage", next.Name, "timeout_seconds", next.TimeoutSeconds)
+				stageTimer.Reset(next.timeout())
+				stageStarted = time.Now()
+				stageTimeout = next.timeout()
+				pending.setDeadline(stageStarted.Add(stageTimeout))
+				if config.Escalation != nil && !escalated {
+					escalateTimer := time.NewTimer(config.Escalation.after(next.timeout()))
+					defer escalateTimer.Stop()
+					escalateCh = escalateTimer.C
+				}
+				acknowledge(dg, click, fmt.Sprintf("☑️ Stage **%s** approved.", stage.Name))
+				editAll(withStatus(waitingStatus(stageIdx)), msgSend.Components)
+			case buttonDenyID:
+				deniedBy = click.userID
+				acknowledge(dg, click, "❌ Denied.")
+				result = ApprovalDenied
+				break wait
+			case cancelID:
+				withdrawnBy = click.userID
+				acknowledge(dg, click, "🚫 Withdrawn.")
+				result = ApprovalCancelled
+				break wait
+			case buttonExtendID:
+				extension := time.Duration(config.ExtendMinutes) * time.Minute
+				stageTimeout += extension
+				deadline := stageStarted.Add(stageTimeout)
+				stageTimer.Reset(time.Until(deadline))
+				pending.setDeadline(deadline)
+				statusLines = append(statusLines, fmt.Sprintf("⏳ Extended by %s by <@%s>. New deadline: %s.", extension, click.userID, discordTimestamp(deadline, "T")))
+				slog.Info("timeout extended", "by", extension, "deadline", deadline)
+				acknowledge(dg, click, fmt.Sprintf("⏳ Extended by %s.", extension))
+				editAll(withStatus(waitingStatus(stageIdx)), msgSend.Components)
+			case buttonEscalateID:
+				if escalated {
+					respondEphemeral(dg, click.interaction, "⚠️ This request was already escalated.")
+					continue
+				}
+				slog.Info("escalating on request", "by", click.userID)
+				acknowledge(dg, click, "🚨 Escalated.")
+				escalate(formatManualEscalation(config.Escalation, click.userID), stageIdx)
+			}
+		case <-escalateCh:
+			slog.Info("no response yet, escalating")
+			escalate(formatEscalation(config.Escalation, time.Since(stageStarted)), stageIdx)
+		case <-heartbeat.C:
+			editAll(withStatus(waitingStatus(stageIdx)), msgSend.Components)
+		case <-reconnectCh:
+			slog.Info("Discord gateway reconnected, checking request messages")
+			lost, errs := lostButtons(dg, requestMsgs.all())
+			for _, err := range errs {
+				slog.Warn("request message unavailable", "err", err)
+			}
+			if lost {
+				slog.Warn("request message lost its buttons, restoring them")
+				editAll(withStatus(waitingStatus(stageIdx)), msgSend.Components)
+			}
+		case <-stageTimer.C:
+			result = ApprovalTimeout
+			break wait
+		case <-sigCh:
+			slog.Warn("interrupted")
+			// Update Discord message - remove buttons and show cancelled status
+			disableButtons("⚠️ **Cancelled** (interrupted).")
+			archiveForumPosts(dg, requestMsgs.all(), "")
+			if err := auditLog.decision(auditDecisionInterrupted, nil); err != nil {
+				slog.Error("failed to write audit record", "err", err)
+			}
+			pending.remove()
+			os.Exit(exitInterrupted)
+		case <-cancelCh:
+			by := pending.cancelledBy()
+			slog.Warn("cancelled", "by", by)
+			disableButtons(fmt.Sprintf("🚫 **Withdrawn** by `%s`.", by))
+			archiveForumPosts(dg, requestMsgs.all(), "")
+			if err := auditLog.decision(auditDecisionCancelled, nil); err != nil {
+				slog.Error("failed to write audit record", "err", err)
+			}
+			pending.remove()
+			os.Exit(exitCancelled)
+		}
 	}
+	pending.remove()
 
 	// Handle result
 	switch result {
 	case ApprovalApproved:
-		fmt.Fprintln(os.Stderr, "✅ Approved! Executing command...")
-
-		disableButtons("✅ **Approved.** Executing...")
+		approvedStatus := "✅ **Approved"
+		if approvedFor != nil {
+			slog.Info("approved, executing command", "approver_ids", approvedBy, "approved_for", approvedFor.label)
+			approvedStatus += " (" + strings.ToLower(approvedFor.label) + ")"
+		} else {
+			slog.Info("approved, executing command", "approver_ids", approvedBy)
+		}
 
-		// Close Discord connection before exec
-		dg.Close()
+		// An approval that cannot be audited is not executed
+		if err := auditLog.decision(auditDecisionApproved, approvedBy); err != nil {
+			slog.Error("failed to write audit record", "err", err)
+			disableButtons("⚠️ **Approved, but not executed:** the audit record could not be written.")
+			os.Exit(exitInternalError)
+		}
+		if *noExec {
+			disableButtons(approvedStatus + ".**")
+		} else {
+			disableButtons(approvedStatus + ".** Executing...")
+		}
 
-		if *showStdin {
-			// Use os/exec to pipe buffered stdin to the command
-			cmd := exec.Command(commandArgs[0], commandArgs[1:]...)
-			cmd.Stdin = bytes.NewReader(stdinData)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			err := cmd.Run()
+		// A duration chosen from the menu replaces the grace window
+		if approvedFor != nil {
+			key, expires, ok, err := approvedFor.cacheEntry(approvalKey, time.Now())
 			if err != nil {
-				if exitErr, ok := err.(*exec.ExitError); ok {
-					os.Exit(exitErr.ExitCode())
+				slog.Warn("failed to cache approval", "err", err)
+			} else if ok {
+				if err := storeApproval(config.StateDir, key, expires); err != nil {
+					slog.Warn("failed to cache approval", "err", err)
 				}
-				fmt.Fprintf(os.Stderr, "Error executing command: %v\n", err)
-				os.Exit(1)
 			}
-		} else {
-			// Replace current process with the command
-			execPath, err := exec.LookPath(commandArgs[0])
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error finding executable: %v\n", err)
-				os.Exit(1)
-			}
-			err = syscall.Exec(execPath, commandArgs, os.Environ())
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error executing command: %v\n", err)
-				os.Exit(1)
+		} else if graceWindow > 0 {
+			if err := storeApproval(config.StateDir, approvalKey, time.Now().Add(graceWindow)); err != nil {
+				slog.Warn("failed to cache approval", "err", err)
 			}
 		}
 
+		// The execution follows in the primary message only
+		archiveForumPosts(dg, requestMsgs.all(), primary.ID)
+
+		// Close Discord connection before exec
+		dg.Close()
+
+		run(primary.ChannelID, primary.ID)
+
 	case ApprovalDenied:
-		fmt.Fprintln(os.Stderr, "❌ Denied.")
+		slog.Warn("denied", "approver_id", deniedBy)
 		disableButtons("❌ **Denied.**")
-		os.Exit(1)
+		archiveForumPosts(dg, requestMsgs.all(), "")
+		if err := auditLog.decision(auditDecisionDenied, []string{deniedBy}); err != nil {
+			slog.Error("failed to write audit record", "err", err)
+		}
+		os.Exit(exitDenied)
+
+	case ApprovalCancelled:
+		slog.Warn("withdrawn by an approver", "approver_id", withdrawnBy)
+		disableButtons(fmt.Sprintf("🚫 **Withdrawn** by <@%s>.", withdrawnBy))
+		archiveForumPosts(dg, requestMsgs.all(), "")
+		if err := auditLog.decision(auditDecisionCancelled, []string{withdrawnBy}); err != nil {
+			slog.Error("failed to write audit record", "err", err)
+		}
+		os.Exit(exitCancelled)
 
 	case ApprovalTimeout:
-		fmt.Fprintln(os.Stderr, "⏰ Timeout.")
-		disableButtons(fmt.Sprintf("⏰ **Timed out** after %ds.", timeoutSec))
-		os.Exit(1)
+		slog.Warn("timed out")
+		if err := auditLog.decision(auditDecisionTimeout, approvedBy); err != nil {
+			slog.Error("failed to write audit record", "err", err)
+		}
+		if len(stages) > 1 {
+			stage := stages[stageIdx]
+			disableButtons(fmt.Sprintf("⏰ **Timed out** waiting for stage %d/%d (**%s**) after %ds.", stageIdx+1, len(stages), stage.Name, int(stageTimeout.Seconds())))
+		} else {
+			disableButtons(fmt.Sprintf("⏰ **Timed out** after %ds.", int(stageTimeout.Seconds())))
+		}
+		archiveForumPosts(dg, requestMsgs.all(), "")
+		os.Exit(exitTimeout)
 
 	default:
-		fmt.Fprintln(os.Stderr, "Unknown error")
-		os.Exit(1)
+		slog.Error("unknown error")
+		os.Exit(exitInternalError)
 	}
 }
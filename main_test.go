@@ -3,12 +3,15 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 // buildTestBinary compiles the binary with a custom config path for testing.
@@ -27,6 +30,14 @@ func buildTestBinary(t *testing.T, configPath string) string {
 	return binPath
 }
 
+// exitCodeOf returns the exit code of a finished test binary, or -1.
+func exitCodeOf(err error) int {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
 // writeTestConfig writes a test config file and returns its path.
 func writeTestConfig(t *testing.T) string {
 	t.Helper()
@@ -61,9 +72,12 @@ func TestShowStdinFlag(t *testing.T) {
 		}
 		output := string(out)
 		// Should fail at Discord connection, not at stdin reading
-		if strings.Contains(output, "Error reading stdin") {
+		if strings.Contains(output, "failed to read stdin") {
 			t.Fatal("should not read stdin without --show-stdin")
 		}
+		if code := exitCodeOf(err); code != exitDiscordError {
+			t.Errorf("exit code = %d, want %d", code, exitDiscordError)
+		}
 	})
 
 	t.Run("with --show-stdin reads stdin before Discord connect", func(t *testing.T) {
@@ -76,7 +90,7 @@ func TestShowStdinFlag(t *testing.T) {
 		}
 		output := string(out)
 		// Should fail at Discord connection, not at stdin reading
-		if strings.Contains(output, "Error reading stdin") {
+		if strings.Contains(output, "failed to read stdin") {
 			t.Fatalf("failed to read stdin: %s", output)
 		}
 	})
@@ -89,7 +103,7 @@ func TestShowStdinFlag(t *testing.T) {
 			t.Fatal("expected error (no valid Discord token), got success")
 		}
 		output := string(out)
-		if strings.Contains(output, "Error reading stdin") {
+		if strings.Contains(output, "failed to read stdin") {
 			t.Fatalf("failed to read empty stdin: %s", output)
 		}
 	})
@@ -114,6 +128,73 @@ func TestShowStdinExecution(t *testing.T) {
 	})
 }
 
+func TestStreamedStdin(t *testing.T) {
+	spec := execSpec{
+		args:      []string{"cat"},
+		pipeStdin: true,
+		stdinData: []byte("head\n"),
+		stdinRest: strings.NewReader("rest\n"),
+	}
+	cmd := spec.command("/bin/cat")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("cat command failed: %v", err)
+	}
+	if stdout.String() != "head\nrest\n" {
+		t.Fatalf("expected the preview followed by the rest, got %q", stdout.String())
+	}
+}
+
+func TestExecTimeout(t *testing.T) {
+	fired := make(chan struct{}, 1)
+	spec := execSpec{
+		args:      []string{"sleep", "10"},
+		timeout:   100 * time.Millisecond,
+		onTimeout: func() { fired <- struct{}{} },
+	}
+	cmd := spec.command("/bin/sleep")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	err := spec.wait(cmd)
+	if code, ok := exitCode(err); !ok || code != exitExecTimeout {
+		t.Errorf("exit code = %d, %v, want %d", code, ok, exitExecTimeout)
+	}
+	select {
+	case <-fired:
+	default:
+		t.Error("onTimeout was not called")
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("command was stopped after %s", elapsed)
+	}
+
+	spec.timeout = time.Minute
+	cmd = exec.Command("true")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := spec.wait(cmd); err != nil {
+		t.Errorf("command within its timeout: %v", err)
+	}
+}
+
+func TestStdinPreviewFlag(t *testing.T) {
+	configPath := writeTestConfig(t)
+	binPath := buildTestBinary(t, configPath)
+
+	cmd := exec.Command(binPath, "--stdin-preview", "64", "--channel", "12345", "--", "cat")
+	out, err := cmd.CombinedOutput()
+	if code := exitCodeOf(err); code != exitConfigError {
+		t.Errorf("exit code = %d, want %d", code, exitConfigError)
+	}
+	if !strings.Contains(string(out), "used with --show-stdin") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
 func TestFormatCommand(t *testing.T) {
 	tests := []struct {
 		args     []string
@@ -122,6 +203,13 @@ func TestFormatCommand(t *testing.T) {
 		{[]string{"echo", "hello"}, "echo hello"},
 		{[]string{"ls", "-la", "/tmp"}, "ls -la /tmp"},
 		{[]string{"single"}, "single"},
+		{[]string{"rm", "a b"}, "rm 'a b'"},
+		{[]string{"rm", "a", "b"}, "rm a b"},
+		{[]string{"echo", "it's"}, `echo 'it'\''s'`},
+		{[]string{"echo", ""}, "echo ''"},
+		{[]string{"echo", "$HOME", "*"}, "echo '$HOME' '*'"},
+		{[]string{"printf", "a\nb\t'c'\x1b"}, `printf $'a\nb\t\'c\'\x1b'`},
+		{[]string{"ls", "--color=auto", "/tmp/x@y"}, "ls --color=auto /tmp/x@y"},
 	}
 	for _, tt := range tests {
 		got := formatCommand(tt.args)
@@ -131,6 +219,13 @@ func TestFormatCommand(t *testing.T) {
 	}
 }
 
+func TestDiscordTimestamp(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("JST", 9*60*60))
+	if got := discordTimestamp(at, "R"); got != "<t:1714532400:R>" {
+		t.Errorf("discordTimestamp = %q", got)
+	}
+}
+
 func TestIsApprover(t *testing.T) {
 	ids := []string{"111", "222", "333"}
 	if !isApprover("222", ids) {
@@ -194,5 +289,209 @@ func TestLoadConfig(t *testing.T) {
 		if config.TimeoutSeconds != defaultTimeout {
 			t.Errorf("timeout = %d, want %d", config.TimeoutSeconds, defaultTimeout)
 		}
+		if config.ExtendMinutes != defaultExtendMinutes {
+			t.Errorf("extend = %d, want %d", config.ExtendMinutes, defaultExtendMinutes)
+		}
+		if config.HeartbeatSeconds != defaultHeartbeatSeconds {
+			t.Errorf("heartbeat = %d, want %d", config.HeartbeatSeconds, defaultHeartbeatSeconds)
+		}
+	})
+
+	t.Run("heartbeat too frequent", func(t *testing.T) {
+		_, err := parseConfig([]byte(`{"discord_token": "t", "approver_ids": ["123"], "heartbeat_seconds": 5}`))
+		if err == nil || !strings.Contains(err.Error(), "heartbeat_seconds") {
+			t.Fatalf("expected heartbeat_seconds error, got: %v", err)
+		}
 	})
 }
+
+func TestTunnelFlag(t *testing.T) {
+	configPath := writeTestConfig(t)
+	binPath := buildTestBinary(t, configPath)
+
+	cmd := exec.Command(binPath, "--tunnel", "-1m", "--channel", "12345", "--", "ssh", "-N", "host")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatal("expected error for negative --tunnel, got success")
+	}
+	if code := exitCodeOf(err); code != exitConfigError {
+		t.Errorf("exit code = %d, want %d", code, exitConfigError)
+	}
+	if !strings.Contains(string(out), "--tunnel must be a positive duration") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+func TestSubcommands(t *testing.T) {
+	configPath := writeTestConfig(t)
+	binPath := buildTestBinary(t, configPath)
+
+	out, err := exec.Command(binPath, "help").CombinedOutput()
+	if err != nil {
+		t.Fatalf("help failed: %v: %s", err, out)
+	}
+	for _, c := range []string{"request", "become", "cancel", "check", "git-hook", "history", "preapprove", "shell", "status", "validate-config", "verify-audit"} {
+		if !strings.Contains(string(out), "  "+c+" ") {
+			t.Errorf("help does not list %s: %s", c, out)
+		}
+	}
+
+	// request is the default, and may be named explicitly
+	out, err = exec.Command(binPath, "request", "--tunnel", "-1m", "--channel", "12345", "--", "ssh", "-N", "host").CombinedOutput()
+	if code := exitCodeOf(err); code != exitConfigError || !strings.Contains(string(out), "--tunnel must be a positive duration") {
+		t.Errorf("request: exit code = %d, output: %s", code, out)
+	}
+}
+
+func TestEnqueueConflicts(t *testing.T) {
+	configPath := writeTestConfig(t)
+	binPath := buildTestBinary(t, configPath)
+
+	out, err := exec.Command(binPath, "--enqueue", "--pty", "--channel", "12345", "--", "true").CombinedOutput()
+	if code := exitCodeOf(err); code != exitConfigError || !strings.Contains(string(out), "--enqueue cannot be combined") {
+		t.Errorf("exit code = %d, output: %s", code, out)
+	}
+}
+
+func TestCwdFlag(t *testing.T) {
+	configPath := writeTestConfig(t)
+	binPath := buildTestBinary(t, configPath)
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	cmd := exec.Command(binPath, "--cwd", missing, "--channel", "12345", "--", "ls")
+	out, err := cmd.CombinedOutput()
+	if code := exitCodeOf(err); code != exitConfigError {
+		t.Errorf("exit code = %d, want %d", code, exitConfigError)
+	}
+	if !strings.Contains(string(out), "--cwd must be an existing directory") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+func TestShellFlag(t *testing.T) {
+	configPath := writeTestConfig(t)
+	binPath := buildTestBinary(t, configPath)
+
+	cmd := exec.Command(binPath, "--shell", "ls | wc -l", "--channel", "12345", "--", "ls")
+	out, err := cmd.CombinedOutput()
+	if code := exitCodeOf(err); code != exitConfigError {
+		t.Errorf("exit code = %d, want %d", code, exitConfigError)
+	}
+	if !strings.Contains(string(out), "--shell cannot be combined") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+func TestDenyPolicy(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		DiscordToken: "Bot fake-token",
+		ApproverIDs:  []string{"123"},
+		AuditLogPath: filepath.Join(dir, "audit.jsonl"),
+		Policies: []Policy{
+			{Name: "no-mkfs", Commands: []string{"mkfs*"}, Action: policyActionDeny, Reason: "use provisioning"},
+		},
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	binPath := buildTestBinary(t, configPath)
+
+	cmd := exec.Command(binPath, "--channel", "12345", "--", "mkfs.ext4", "/dev/sdb")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatal("expected denied command to fail")
+	}
+	if code := exitCodeOf(err); code != exitDenied {
+		t.Errorf("exit code = %d, want %d", code, exitDenied)
+	}
+	if !strings.Contains(string(out), `msg="command denied by policy" policy=no-mkfs reason="use provisioning"`) {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	audit, err := os.ReadFile(cfg.AuditLogPath)
+	if err != nil {
+		t.Fatalf("expected audit record: %v", err)
+	}
+	if !strings.Contains(string(audit), auditDecisionPolicyDenied) {
+		t.Fatalf("unexpected audit log: %s", audit)
+	}
+}
+
+func TestLogFormatJSON(t *testing.T) {
+	configPath := writeTestConfig(t)
+	binPath := buildTestBinary(t, configPath)
+
+	cmd := exec.Command(binPath, "--log-format", "json", "--tunnel", "-1m", "--channel", "12345", "--", "true")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatal("expected error for negative --tunnel, got success")
+	}
+	var entry map[string]any
+	if err := json.Unmarshal(out, &entry); err != nil {
+		t.Fatalf("expected a JSON log line, got %s: %v", out, err)
+	}
+	if entry["level"] != "ERROR" || entry["msg"] != "--tunnel must be a positive duration" {
+		t.Errorf("unexpected log entry: %v", entry)
+	}
+}
+
+func TestOutputJSON(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		DiscordToken: "Bot fake-token",
+		ApproverIDs:  []string{"123"},
+		AuditLogPath: filepath.Join(dir, "audit.jsonl"),
+		Policies: []Policy{
+			{Name: "no-mkfs", Commands: []string{"mkfs*"}, Action: policyActionDeny},
+		},
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	binPath := buildTestBinary(t, configPath)
+
+	cmd := exec.Command(binPath, "--output", "json", "--channel", "12345", "--", "mkfs.ext4", "/dev/sdb")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err == nil {
+		t.Fatal("expected denied command to fail")
+	}
+	var res requestResult
+	if err := json.Unmarshal(stdout.Bytes(), &res); err != nil {
+		t.Fatalf("expected a JSON result on stdout, got %q: %v", stdout.String(), err)
+	}
+	if res.Decision != auditDecisionPolicyDenied || res.Policy != "no-mkfs" || res.RequestID == "" {
+		t.Errorf("unexpected result: %+v", res)
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	for name, tc := range map[string]struct {
+		args []string
+		code int
+		ok   bool
+	}{
+		"valid":   {[]string{"--since", "24h", "apt"}, 0, true},
+		"help":    {[]string{"-h"}, 0, false},
+		"unknown": {[]string{"--bogus"}, exitConfigError, false},
+		"invalid": {[]string{"--denied=maybe"}, exitConfigError, false},
+	} {
+		fs := flag.NewFlagSet("history", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		fs.String("since", "7d", "")
+		fs.Bool("denied", false, "")
+		if code, ok := parseFlags(fs, tc.args); code != tc.code || ok != tc.ok {
+			t.Errorf("%s: parseFlags = %d, %v, want %d, %v", name, code, ok, tc.code, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxMaintenanceMinutes bounds how long a maintenance window may stay open.
+const maxMaintenanceMinutes = 7 * 24 * 60
+
+// MaintenanceWindow auto-approves matching commands for DurationMinutes after each
+// start given by its cron Schedule.
+type MaintenanceWindow struct {
+	Name string `json:"name"`
+	// Schedule is a five-field cron expression: minute, hour, day of month, month, day of week
+	Schedule        string   `json:"schedule"`
+	DurationMinutes int      `json:"duration_minutes"`
+	Commands        []string `json:"commands"`
+	// Timezone is the zone the schedule is read in (default: local time)
+	Timezone string `json:"timezone"`
+}
+
+// cronSchedule is a parsed cron expression; each field is the set of matching values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow []bool
+	// domAny and dowAny record unrestricted day fields, as cron matches either day
+	// field when both are restricted
+	domAny, dowAny bool
+}
+
+// parseCron parses a five-field cron expression supporting *, lists, ranges and steps.
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	// Sunday is both 0 and 7
+	s.dow[0] = s.dow[0] || s.dow[7]
+	s.domAny, s.dowAny = fields[2] == "*", fields[4] == "*"
+	return &s, nil
+}
+
+func parseCronField(field string, min, max int) ([]bool, error) {
+	set := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid step in cron field %q", field)
+			}
+			step = n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return nil, fmt.Errorf("invalid cron field %q", field)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return nil, fmt.Errorf("invalid cron field %q", field)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("cron field %q is out of range %d-%d", field, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// matches reports whether the schedule fires in the minute of t.
+func (s *cronSchedule) matches(t time.Time) bool {
+	if !s.minute[t.Minute()] || !s.hour[t.Hour()] || !s.month[t.Month()] {
+		return false
+	}
+	dom, dow := s.dom[t.Day()], s.dow[t.Weekday()]
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func validateMaintenanceWindows(windows []MaintenanceWindow) error {
+	for i, w := range windows {
+		if w.Name == "" {
+			return fmt.Errorf("maintenance_windows[%d]: name is required", i)
+		}
+		if len(w.Commands) == 0 {
+			return fmt.Errorf("maintenance window %q: commands is required", w.Name)
+		}
+		if w.DurationMinutes <= 0 || w.DurationMinutes > maxMaintenanceMinutes {
+			return fmt.Errorf("maintenance window %q: duration_minutes must be between 1 and %d", w.Name, maxMaintenanceMinutes)
+		}
+		if _, err := parseCron(w.Schedule); err != nil {
+			return fmt.Errorf("maintenance window %q: %w", w.Name, err)
+		}
+		if w.Timezone != "" {
+			if _, err := time.LoadLocation(w.Timezone); err != nil {
+				return fmt.Errorf("maintenance window %q: %w", w.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// isOpen reports whether the window opened within DurationMinutes before now.
+func (w MaintenanceWindow) isOpen(now time.Time) bool {
+	schedule, err := parseCron(w.Schedule)
+	if err != nil {
+		return false
+	}
+	if w.Timezone != "" {
+		if loc, err := time.LoadLocation(w.Timezone); err == nil {
+			now = now.In(loc)
+		}
+	}
+	minute := now.Truncate(time.Minute)
+	for i := 0; i < w.DurationMinutes; i++ {
+		if schedule.matches(minute.Add(-time.Duration(i) * time.Minute)) {
+			return true
+		}
+	}
+	return false
+}
+
+// maintenanceApplies reports whether a maintenance window may auto-approve a request: not
+// when its policy asks for more than a single approval, for its own approvers or for a
+// confirmation, nor when it is high-risk.
+func maintenanceApplies(policy *Policy, highRisk bool) bool {
+	if highRisk {
+		return false
+	}
+	if policy == nil {
+		return true
+	}
+	return len(policy.Stages) <= 1 && !policy.RequireConfirmation && !policy.RequireTOTP &&
+		len(stageApproverIDs(approvalStages(policy, policy.ApproverIDs, 0))) == 0
+}
+
+// activeMaintenanceWindow returns the first window that is open at now and covers
+// command, or nil.
+func activeMaintenanceWindow(windows []MaintenanceWindow, command string, now time.Time) *MaintenanceWindow {
+	for i, w := range windows {
+		if !w.isOpen(now) {
+			continue
+		}
+		for _, pattern := range w.Commands {
+			if matchGlob(pattern, command) {
+				return &windows[i]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronSchedule(t *testing.T) {
+	tests := []struct {
+		expr string
+		t    time.Time
+		want bool
+	}{
+		// 2026-01-06 is a Tuesday
+		{"0 22 * * 2", time.Date(2026, 1, 6, 22, 0, 0, 0, time.UTC), true},
+		{"0 22 * * 2", time.Date(2026, 1, 6, 22, 1, 0, 0, time.UTC), false},
+		{"0 22 * * 2", time.Date(2026, 1, 7, 22, 0, 0, 0, time.UTC), false},
+		{"*/15 9-17 * * 1-5", time.Date(2026, 1, 6, 9, 45, 0, 0, time.UTC), true},
+		{"*/15 9-17 * * 1-5", time.Date(2026, 1, 6, 9, 40, 0, 0, time.UTC), false},
+		{"0 0 * * 7", time.Date(2026, 1, 4, 0, 0, 0, 0, time.UTC), true},
+		{"30 2 1,15 * *", time.Date(2026, 3, 15, 2, 30, 0, 0, time.UTC), true},
+		// With both day fields restricted, either one matches
+		{"0 3 1 * 2", time.Date(2026, 1, 6, 3, 0, 0, 0, time.UTC), true},
+		{"0 3 1 * 2", time.Date(2026, 1, 1, 3, 0, 0, 0, time.UTC), true},
+		{"0 3 1 * 2", time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.expr, err)
+		}
+		if got := s.matches(tt.t); got != tt.want {
+			t.Errorf("%q matches %s = %v, want %v", tt.expr, tt.t.Format(time.RFC3339), got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("expected %q to be rejected", expr)
+		}
+	}
+}
+
+func TestActiveMaintenanceWindow(t *testing.T) {
+	windows := []MaintenanceWindow{{
+		Name:            "patch tuesday",
+		Schedule:        "0 22 * * 2",
+		DurationMinutes: 120,
+		Commands:        []string{"apt *"},
+		Timezone:        "UTC",
+	}}
+	open := time.Date(2026, 1, 6, 23, 59, 0, 0, time.UTC)
+	if w := activeMaintenanceWindow(windows, "apt upgrade -y", open); w == nil || w.Name != "patch tuesday" {
+		t.Errorf("expected the window to cover apt at %s", open)
+	}
+	if w := activeMaintenanceWindow(windows, "rm -rf /srv", open); w != nil {
+		t.Error("expected other commands not to be covered")
+	}
+	if w := activeMaintenanceWindow(windows, "apt upgrade -y", open.Add(time.Minute)); w != nil {
+		t.Error("expected the window to be closed after its duration")
+	}
+	if w := activeMaintenanceWindow(windows, "apt upgrade -y", time.Date(2026, 1, 6, 21, 59, 0, 0, time.UTC)); w != nil {
+		t.Error("expected the window to be closed before it opens")
+	}
+}
+
+func TestMaintenanceApplies(t *testing.T) {
+	for name, tc := range map[string]struct {
+		policy   *Policy
+		highRisk bool
+		want     bool
+	}{
+		"no policy":            {nil, false, true},
+		"plain policy":         {&Policy{Name: "p"}, false, true},
+		"high risk":            {nil, true, false},
+		"stages":               {&Policy{Name: "p", Stages: []ApprovalStage{{Name: "a"}, {Name: "b"}}}, false, false},
+		"confirmation":         {&Policy{Name: "p", RequireConfirmation: true}, false, false},
+		"totp":                 {&Policy{Name: "p", RequireTOTP: true}, false, false},
+		"own approvers":        {&Policy{Name: "p", ApproverIDs: []string{"111"}}, false, false},
+		"stage with approvers": {&Policy{Name: "p", Stages: []ApprovalStage{{ApproverIDs: []string{"111"}}}}, false, false},
+	} {
+		if got := maintenanceApplies(tc.policy, tc.highRisk); got != tc.want {
+			t.Errorf("%s: maintenanceApplies = %v, want %v", name, got, tc.want)
+		}
+	}
+}
+
+func TestValidateMaintenanceWindows(t *testing.T) {
+	valid := MaintenanceWindow{Name: "w", Schedule: "0 22 * * 2", DurationMinutes: 60, Commands: []string{"apt *"}}
+	if err := validateMaintenanceWindows([]MaintenanceWindow{valid}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	invalid := []func(w *MaintenanceWindow){
+		func(w *MaintenanceWindow) { w.Name = "" },
+		func(w *MaintenanceWindow) { w.Commands = nil },
+		func(w *MaintenanceWindow) { w.DurationMinutes = 0 },
+		func(w *MaintenanceWindow) { w.DurationMinutes = maxMaintenanceMinutes + 1 },
+		func(w *MaintenanceWindow) { w.Schedule = "tuesday night" },
+	}
+	for i, mutate := range invalid {
+		w := valid
+		mutate(&w)
+		if err := validateMaintenanceWindows([]MaintenanceWindow{w}); err == nil {
+			t.Errorf("case %d: expected %+v to be rejected", i, w)
+		}
+	}
+}
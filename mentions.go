@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const mentionRotationFile = "mention-rotation.json"
+
+// MentionConfig lists who is @-mentioned in request messages so approvers get a push
+// notification. With Rotate, only one of UserIDs is mentioned per request, in turn.
+type MentionConfig struct {
+	UserIDs []string `json:"user_ids"`
+	RoleIDs []string `json:"role_ids"`
+	Rotate  bool     `json:"rotate"`
+}
+
+// nextRotation returns the index of the next of n rotating mentions and advances the
+// persisted rotation counter.
+func nextRotation(stateDir string, n int) (int, error) {
+	var rotation struct {
+		Next int `json:"next"`
+	}
+	idx := 0
+	err := updateStateFile(stateDir, mentionRotationFile, &rotation, func() bool {
+		idx = rotation.Next % n
+		rotation.Next = idx + 1
+		return true
+	})
+	return idx, err
+}
+
+// requestMentions returns the user and role IDs to mention for one request.
+// extraUserIDs (from --mention) are always mentioned. If the rotation cannot be
+// advanced, it returns the error along with every user, so the request is not posted
+// without anyone to notice it.
+func requestMentions(m *MentionConfig, extraUserIDs []string, stateDir string) (userIDs, roleIDs []string, err error) {
+	if m != nil {
+		userIDs = slices.Clone(m.UserIDs)
+		roleIDs = m.RoleIDs
+		if m.Rotate && len(m.UserIDs) > 0 {
+			var idx int
+			if idx, err = nextRotation(stateDir, len(m.UserIDs)); err == nil {
+				userIDs = []string{m.UserIDs[idx]}
+			}
+		}
+	}
+	for _, id := range extraUserIDs {
+		if !isApprover(id, userIDs) {
+			userIDs = append(userIDs, id)
+		}
+	}
+	return userIDs, roleIDs, err
+}
+
+// formatMentions renders user and role mentions as a single line.
+func formatMentions(userIDs, roleIDs []string) string {
+	var parts []string
+	for _, id := range roleIDs {
+		parts = append(parts, fmt.Sprintf("<@&%s>", id))
+	}
+	for _, id := range userIDs {
+		parts = append(parts, fmt.Sprintf("<@%s>", id))
+	}
+	return strings.Join(parts, " ")
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRequestMentions(t *testing.T) {
+	stateDir := filepath.Join(t.TempDir(), "state")
+
+	t.Run("mentions everyone without rotation", func(t *testing.T) {
+		m := &MentionConfig{UserIDs: []string{"1", "2"}, RoleIDs: []string{"9"}}
+		users, roles, err := requestMentions(m, []string{"3", "1"}, stateDir)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(users, []string{"1", "2", "3"}) || !reflect.DeepEqual(roles, []string{"9"}) {
+			t.Errorf("mentions = %v %v", users, roles)
+		}
+	})
+
+	t.Run("rotates through users", func(t *testing.T) {
+		m := &MentionConfig{UserIDs: []string{"1", "2", "3"}, Rotate: true}
+		var got []string
+		for i := 0; i < 4; i++ {
+			users, _, err := requestMentions(m, nil, stateDir)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got = append(got, users...)
+		}
+		if !reflect.DeepEqual(got, []string{"1", "2", "3", "1"}) {
+			t.Errorf("rotation = %v", got)
+		}
+	})
+
+	t.Run("mentions everyone when the rotation fails", func(t *testing.T) {
+		notDir := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(notDir, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		m := &MentionConfig{UserIDs: []string{"1", "2"}, RoleIDs: []string{"9"}, Rotate: true}
+		users, roles, err := requestMentions(m, []string{"3"}, notDir)
+		if err == nil {
+			t.Error("expected the rotation error to be returned")
+		}
+		if !reflect.DeepEqual(users, []string{"1", "2", "3"}) || !reflect.DeepEqual(roles, []string{"9"}) {
+			t.Errorf("mentions = %v %v", users, roles)
+		}
+	})
+}
+
+func TestFormatMentions(t *testing.T) {
+	if got := formatMentions([]string{"1"}, []string{"9"}); got != "<@&9> <@1>" {
+		t.Errorf("formatMentions = %q", got)
+	}
+	if got := formatMentions(nil, nil); got != "" {
+		t.Errorf("formatMentions = %q, want empty", got)
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	if got := splitList(" a, b,,c "); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("splitList = %v", got)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// trackedMessage is a message carrying a request's buttons. Prefix is kept above the
+// request content whenever the message is edited.
+type trackedMessage struct {
+	*discordgo.Message
+	prefix string
+}
+
+// requestMessages tracks the messages carrying a request's buttons, e.g. the channel
+// post, approver DMs and escalations. It is shared between the interaction handler
+// and the wait loop.
+type requestMessages struct {
+	mu   sync.Mutex
+	msgs []trackedMessage
+}
+
+func (r *requestMessages) add(msg *discordgo.Message, prefix string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, trackedMessage{msg, prefix})
+}
+
+func (r *requestMessages) contains(messageID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, msg := range r.msgs {
+		if msg.ID == messageID {
+			return true
+		}
+	}
+	return false
+}
+
+// channelIDs returns the channels the request messages are in.
+func (r *requestMessages) channelIDs() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ids []string
+	for _, msg := range r.msgs {
+		ids = append(ids, msg.ChannelID)
+	}
+	return ids
+}
+
+func (r *requestMessages) all() []trackedMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]trackedMessage{}, r.msgs...)
+}
+
+// sendToChannels sends msgSend to each channel, returning the messages that were
+// delivered. In forum channels, a post titled title is created instead. Only the
+// message in the first channel replies to replyToID, since replies cannot reference
+// a message in another channel.
+func sendToChannels(dg *discordgo.Session, channelIDs []string, msgSend *discordgo.MessageSend, replyToID, title string) ([]*discordgo.Message, []error) {
+	var sent []*discordgo.Message
+	var errs []error
+	for i, channelID := range channelIDs {
+		send := *msgSend
+		send.Reference = nil
+		forum, err := isForum(dg, channelID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("channel %s: %w", channelID, err))
+			continue
+		}
+		if forum {
+			msg, err := startForumPost(dg, channelID, title, &send)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("forum %s: %w", channelID, explainDiscordError(channelID, err)))
+				continue
+			}
+			sent = append(sent, msg)
+			continue
+		}
+		if i == 0 && replyToID != "" {
+			send.Reference = &discordgo.MessageReference{
+				MessageID: replyToID,
+				ChannelID: channelID,
+			}
+		}
+		msg, err := sendMessage(dg, channelID, &send)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("channel %s: %w", channelID, explainDiscordError(channelID, err)))
+			continue
+		}
+		sent = append(sent, msg)
+	}
+	return sent, errs
+}
+
+// sendDMs sends msgSend to each user as a direct message, returning the messages that
+// were delivered. Failures are reported per user so one closed DM doesn't block the rest.
+func sendDMs(dg *discordgo.Session, userIDs []string, msgSend *discordgo.MessageSend) ([]*discordgo.Message, []error) {
+	var sent []*discordgo.Message
+	var errs []error
+	for _, userID := range userIDs {
+		ch, err := retryDiscord(func() (*discordgo.Channel, error) {
+			return dg.UserChannelCreate(userID, discordRetryOptions...)
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("user %s: %w", userID, err))
+			continue
+		}
+		msg, err := sendMessage(dg, ch.ID, msgSend)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("user %s: %w", userID, err))
+			continue
+		}
+		sent = append(sent, msg)
+	}
+	return sent, errs
+}
+
+// lostButtons fetches the tracked messages after a gateway reconnect and reports
+// whether any of them lost its buttons, e.g. because it was edited in the meantime.
+// Messages that cannot be fetched are reported as errors.
+func lostButtons(dg *discordgo.Session, msgs []trackedMessage) (bool, []error) {
+	lost := false
+	var errs []error
+	for _, m := range msgs {
+		msg, err := retryDiscord(func() (*discordgo.Message, error) {
+			return dg.ChannelMessage(m.ChannelID, m.ID, discordRetryOptions...)
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("message %s: %w", m.ID, err))
+			continue
+		}
+		if len(msg.Components) == 0 {
+			lost = true
+		}
+	}
+	return lost, errs
+}
@@ -0,0 +1,142 @@
+//go:build mockbackend
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The mock backend decides requests without Discord, for exercising the full
+// request, decision and execution flow in CI and staging. It is only compiled with
+// -tags mockbackend, since anyone who can reach it can approve requests.
+
+const (
+	backendMock           = "mock"
+	defaultMockListenAddr = "127.0.0.1:8471"
+)
+
+func init() {
+	backends[backendMock] = mockDecide
+}
+
+// mockDecide serves a local HTTP UI on PSD_MOCK_LISTEN and, if PSD_MOCK_FIFO is set,
+// reads decisions from that FIFO: one line of "approve [NAME]" or "deny [NAME]".
+func mockDecide(req fallbackRequest) (string, string, error) {
+	votes := make(chan fallbackVote, 1)
+	addr := os.Getenv("PSD_MOCK_LISTEN")
+	if addr == "" {
+		addr = defaultMockListenAddr
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	srv := &http.Server{Handler: mockHandler(req, votes), ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(ln)
+	defer srv.Close()
+	slog.Info("mock backend waiting for a decision", "url", "http://"+ln.Addr().String()+"/")
+
+	if fifo := os.Getenv("PSD_MOCK_FIFO"); fifo != "" {
+		go func() {
+			if err := readMockDecisions(fifo, votes); err != nil {
+				slog.Error("failed to read mock decisions", "fifo", fifo, "err", err)
+			}
+		}()
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	timer := time.NewTimer(time.Until(req.Expires))
+	defer timer.Stop()
+	select {
+	case v := <-votes:
+		if v.action == fallbackApprove {
+			return auditDecisionApproved, v.approver, nil
+		}
+		return auditDecisionDenied, v.approver, nil
+	case <-timer.C:
+		return auditDecisionTimeout, "", nil
+	case <-sigCh:
+		return auditDecisionInterrupted, "", nil
+	}
+}
+
+// parseMockDecision parses a FIFO line such as "approve alice".
+func parseMockDecision(line string) (fallbackVote, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || len(fields) > 2 {
+		return fallbackVote{}, false
+	}
+	action := strings.ToLower(fields[0])
+	if action != fallbackApprove && action != fallbackDeny {
+		return fallbackVote{}, false
+	}
+	approver := "mock"
+	if len(fields) == 2 {
+		approver = "mock:" + fields[1]
+	}
+	return fallbackVote{action: action, approver: approver}, true
+}
+
+func readMockDecisions(path string, votes chan<- fallbackVote) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		v, ok := parseMockDecision(scanner.Text())
+		if !ok {
+			slog.Warn("ignoring mock decision", "line", scanner.Text())
+			continue
+		}
+		select {
+		case votes <- v:
+		default:
+		}
+		return nil
+	}
+	return scanner.Err()
+}
+
+func mockHandler(req fallbackRequest, votes chan<- fallbackVote) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<h1>Sudo Request (mock)</h1><pre>%s</pre><p>Host: %s<br>CWD: %s<br>Requester: %s<br>Request ID: %s</p>`+
+			`<form method="post" action="/decide"><input name="approver" placeholder="approver">`+
+			`<button name="action" value="approve">Approve</button><button name="action" value="deny">Deny</button></form>`,
+			html.EscapeString(req.Command), html.EscapeString(req.Host), html.EscapeString(req.CWD),
+			html.EscapeString(req.Requester), html.EscapeString(req.ID))
+	})
+	mux.HandleFunc("/decide", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		v, ok := parseMockDecision(r.FormValue("action") + " " + r.FormValue("approver"))
+		if !ok {
+			http.Error(w, "action must be approve or deny", http.StatusBadRequest)
+			return
+		}
+		select {
+		case votes <- v:
+			fmt.Fprintf(w, "Request %s: %s\n", req.ID, v.action)
+		default:
+			http.Error(w, "the request has already been decided", http.StatusConflict)
+		}
+	})
+	return mux
+}
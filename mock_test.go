@@ -0,0 +1,78 @@
+//go:build mockbackend
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMockDecision(t *testing.T) {
+	tests := []struct {
+		line     string
+		ok       bool
+		action   string
+		approver string
+	}{
+		{"approve", true, fallbackApprove, "mock"},
+		{"deny alice", true, fallbackDeny, "mock:alice"},
+		{"  APPROVE bob ", true, fallbackApprove, "mock:bob"},
+		{"", false, "", ""},
+		{"maybe", false, "", ""},
+		{"approve a b", false, "", ""},
+	}
+	for _, tt := range tests {
+		v, ok := parseMockDecision(tt.line)
+		if ok != tt.ok || v.action != tt.action || v.approver != tt.approver {
+			t.Errorf("parseMockDecision(%q) = %+v, %v", tt.line, v, ok)
+		}
+	}
+}
+
+func TestMockHandler(t *testing.T) {
+	req := fallbackRequest{ID: "abc", Command: "<script>", Expires: time.Now().Add(time.Minute)}
+	votes := make(chan fallbackVote, 1)
+	srv := httptest.NewServer(mockHandler(req, votes))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET / = %s", resp.Status)
+	}
+
+	resp, err = http.PostForm(srv.URL+"/decide", url.Values{"action": {"maybe"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid action = %s, want 400", resp.Status)
+	}
+
+	resp, err = http.PostForm(srv.URL+"/decide", url.Values{"action": {"approve"}, "approver": {"ci"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if v := <-votes; v.action != fallbackApprove || v.approver != "mock:ci" {
+		t.Errorf("vote = %+v", v)
+	}
+
+	votes <- fallbackVote{}
+	resp, err = http.Post(srv.URL+"/decide", "application/x-www-form-urlencoded", strings.NewReader("action=deny"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("second decision = %s, want 409", resp.Status)
+	}
+}
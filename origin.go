@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sshOriginTimeout bounds the reverse DNS lookup of the SSH client, which delays the
+// request.
+const sshOriginTimeout = 2 * time.Second
+
+// resolver looks up the SSH client's name; tests override it.
+var resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+} = net.DefaultResolver
+
+// sshOrigin is where the SSH client of a request connects from, so approvers can spot
+// requests from unexpected places.
+type sshOrigin struct {
+	// Hostname is the client's reverse DNS name, and Verified whether it resolves back
+	// to the client's address; anyone controlling the address's reverse zone can claim
+	// any name
+	Hostname string
+	Verified bool
+	// Country, ASN and ASName come from geoip_database
+	Country string
+	ASN     uint32
+	ASName  string
+}
+
+// lookupSSHOrigin resolves the name of the SSH client at addr ("ip:port") and, with
+// geoDB, its country and AS. It returns nil if nothing is known about it.
+func lookupSSHOrigin(addr, geoDB string) *sshOrigin {
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return nil
+	}
+	ip := ap.Addr().Unmap()
+	var o sshOrigin
+	ctx, cancel := context.WithTimeout(context.Background(), sshOriginTimeout)
+	defer cancel()
+	o.Hostname, o.Verified = reverseDNS(ctx, ip)
+	if geoDB != "" && ip.IsGlobalUnicast() && !ip.IsPrivate() {
+		if err := o.lookupIP2ASN(geoDB, ip); err != nil {
+			slog.Warn("failed to look up SSH client in geoip_database", "err", err)
+		}
+	}
+	if o == (sshOrigin{}) {
+		return nil
+	}
+	return &o
+}
+
+// reverseDNS returns the name of ip, and whether the name resolves back to ip.
+func reverseDNS(ctx context.Context, ip netip.Addr) (string, bool) {
+	names, err := resolver.LookupAddr(ctx, ip.String())
+	if err != nil || len(names) == 0 {
+		return "", false
+	}
+	name := strings.TrimSuffix(names[0], ".")
+	addrs, err := resolver.LookupNetIP(ctx, "ip", name)
+	if err != nil {
+		return name, false
+	}
+	for _, a := range addrs {
+		if a.Unmap() == ip {
+			return name, true
+		}
+	}
+	return name, false
+}
+
+// lookupIP2ASN finds ip in an offline IP-to-ASN database in the TSV format of
+// iptoasn.com: "range_start range_end AS_number country_code AS_description", sorted by
+// range.
+func (o *sshOrigin) lookupIP2ASN(path string, ip netip.Addr) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.SplitN(sc.Text(), "\t", 5)
+		if len(fields) < 4 {
+			continue
+		}
+		start, err1 := netip.ParseAddr(fields[0])
+		end, err2 := netip.ParseAddr(fields[1])
+		if err1 != nil || err2 != nil || start.Is4() != ip.Is4() {
+			continue
+		}
+		if ip.Less(start) {
+			// Ranges are sorted, so no later one holds ip
+			return nil
+		}
+		if end.Less(ip) {
+			continue
+		}
+		asn, _ := strconv.ParseUint(fields[2], 10, 32)
+		// AS 0 marks ranges that are not routed
+		if asn == 0 {
+			return nil
+		}
+		o.ASN = uint32(asn)
+		if country := fields[3]; country != "None" {
+			o.Country = country
+		}
+		if len(fields) == 5 && fields[4] != "Not routed" {
+			o.ASName = fields[4]
+		}
+		return nil
+	}
+	return sc.Err()
+}
+
+// format renders the origin after the SSH client's address.
+func (o *sshOrigin) format() string {
+	if o == nil {
+		return ""
+	}
+	var parts []string
+	if o.Hostname != "" {
+		name := "`" + inlineCode(o.Hostname) + "`"
+		if !o.Verified {
+			name += " (unverified)"
+		}
+		parts = append(parts, name)
+	}
+	if o.Country != "" {
+		parts = append(parts, strings.TrimSpace(countryFlag(o.Country)+" "+inlineCode(o.Country)))
+	}
+	if o.ASN != 0 {
+		as := fmt.Sprintf("AS%d", o.ASN)
+		if o.ASName != "" {
+			as += " `" + inlineCode(o.ASName) + "`"
+		}
+		parts = append(parts, as)
+	}
+	return " · " + strings.Join(parts, " · ")
+}
+
+// countryFlag returns the flag emoji of an ISO 3166 country code, or "" if it is not one.
+func countryFlag(code string) string {
+	if len(code) != 2 {
+		return ""
+	}
+	var flag strings.Builder
+	for _, c := range strings.ToUpper(code) {
+		if c < 'A' || c > 'Z' {
+			return ""
+		}
+		flag.WriteRune(0x1F1E6 + c - 'A')
+	}
+	return flag.String()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeResolver resolves names from maps.
+type fakeResolver struct {
+	names map[string]string
+	addrs map[string][]netip.Addr
+}
+
+func (r fakeResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	if name, ok := r.names[addr]; ok {
+		return []string{name}, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func (r fakeResolver) LookupNetIP(_ context.Context, _, host string) ([]netip.Addr, error) {
+	if addrs, ok := r.addrs[host]; ok {
+		return addrs, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestLookupSSHOrigin(t *testing.T) {
+	saved := resolver
+	t.Cleanup(func() { resolver = saved })
+	resolver = fakeResolver{
+		names: map[string]string{"203.0.113.5": "bastion.example.com.", "198.51.100.7": "www.bank.example.", "2001:db8::1": "v6.example.net."},
+		addrs: map[string][]netip.Addr{
+			"bastion.example.com": {netip.MustParseAddr("203.0.113.5")},
+			"www.bank.example":    {netip.MustParseAddr("192.0.2.1")},
+		},
+	}
+	db := filepath.Join(t.TempDir(), "ip2asn-combined.tsv")
+	os.WriteFile(db, []byte("1.0.0.0\t1.0.0.255\t13335\tUS\tCLOUDFLARENET\n"+
+		"198.51.100.0\t198.51.100.255\t64500\tJP\tEXAMPLE-NET\n"+
+		"203.0.113.0\t203.0.113.255\t0\tNone\tNot routed\n"+
+		"2001:db8::\t2001:db8::ffff\t64501\tDE\tEXAMPLE-V6\n"), 0644)
+
+	tests := []struct {
+		addr string
+		want *sshOrigin
+	}{
+		{"203.0.113.5:52144", &sshOrigin{Hostname: "bastion.example.com", Verified: true}},
+		// A reverse name that does not resolve back is shown, but flagged
+		{"198.51.100.7:52144", &sshOrigin{Hostname: "www.bank.example", Country: "JP", ASN: 64500, ASName: "EXAMPLE-NET"}},
+		{"[2001:db8::1]:52144", &sshOrigin{Hostname: "v6.example.net", Country: "DE", ASN: 64501, ASName: "EXAMPLE-V6"}},
+		{"10.0.0.1:52144", nil},
+		{"not an address", nil},
+	}
+	for _, tt := range tests {
+		got := lookupSSHOrigin(tt.addr, db)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("lookupSSHOrigin(%q) = %+v, want %+v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestSSHOriginFormat(t *testing.T) {
+	o := &sshOrigin{Hostname: "www.bank.example", Country: "JP", ASN: 64500, ASName: "EXAMPLE`NET"}
+	if got, want := o.format(), " · `www.bank.example` (unverified) · 🇯🇵 JP · AS64500 `EXAMPLE'NET`"; got != want {
+		t.Errorf("format = %q, want %q", got, want)
+	}
+	ctx := requesterContext{User: "alice", SSHClient: "203.0.113.5:52144", SSHOrigin: &sshOrigin{Hostname: "bastion.example.com", Verified: true}}
+	if got, want := ctx.format(), "\n**Requester:** `alice`\n**SSH from:** `203.0.113.5:52144` · `bastion.example.com`"; got != want {
+		t.Errorf("format = %q, want %q", got, want)
+	}
+}
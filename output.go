@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Output formats for --output
+const outputFormatJSON = "json"
+
+// requestResult is the machine-readable result printed with --output json.
+type requestResult struct {
+	RequestID   string    `json:"request_id"`
+	Decision    string    `json:"decision"`
+	ApproverIDs []string  `json:"approver_ids"`
+	MessageLink string    `json:"message_link,omitempty"`
+	Policy      string    `json:"policy,omitempty"`
+	RequestedAt time.Time `json:"requested_at"`
+	DecidedAt   time.Time `json:"decided_at"`
+	WaitSeconds float64   `json:"wait_seconds"`
+}
+
+// resultPrinter prints the result of a request once it is decided.
+type resultPrinter struct {
+	w io.Writer
+	// link is the request message, once it has been posted
+	link string
+}
+
+func (p *resultPrinter) print(rec AuditRecord) error {
+	return json.NewEncoder(p.w).Encode(newRequestResult(rec, p.link))
+}
+
+// newRequestResult returns the result of the decision record rec, whose request
+// message is at link.
+func newRequestResult(rec AuditRecord, link string) requestResult {
+	res := requestResult{
+		RequestID:   rec.RequestID,
+		Decision:    rec.Decision,
+		ApproverIDs: rec.ApproverIDs,
+		MessageLink: link,
+		Policy:      rec.Policy,
+		RequestedAt: rec.RequestedAt,
+		DecidedAt:   rec.Time,
+		WaitSeconds: rec.Time.Sub(rec.RequestedAt).Seconds(),
+	}
+	if res.ApproverIDs == nil {
+		res.ApproverIDs = []string{}
+	}
+	return res
+}
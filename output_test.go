@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestResultPrinter(t *testing.T) {
+	var buf bytes.Buffer
+	p := &resultPrinter{w: &buf, link: "https://discord.com/channels/1/2/3"}
+	requested := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := p.print(AuditRecord{
+		Time:        requested.Add(90 * time.Second),
+		RequestID:   "abc",
+		Decision:    auditDecisionApproved,
+		ApproverIDs: []string{"123"},
+		RequestedAt: requested,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res requestResult
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatalf("invalid output %s: %v", buf.Bytes(), err)
+	}
+	if res.RequestID != "abc" || res.Decision != auditDecisionApproved || res.MessageLink != p.link {
+		t.Errorf("unexpected result: %+v", res)
+	}
+	if len(res.ApproverIDs) != 1 || res.WaitSeconds != 90 {
+		t.Errorf("unexpected approvers or wait: %+v", res)
+	}
+
+	// Undecided approvers are an empty list, not null
+	buf.Reset()
+	p.print(AuditRecord{Decision: auditDecisionTimeout})
+	if !bytes.Contains(buf.Bytes(), []byte(`"approver_ids":[]`)) {
+		t.Errorf("expected empty approver list: %s", buf.Bytes())
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// pendingDirName is the directory of state_dir with a file per pending request. The
+// process waiting on a request holds a shared lock on its file, so a file that can be
+// locked exclusively belongs to a process that is gone.
+const pendingDirName = "pending"
+
+// pendingRequest is the record of a request waiting for a decision.
+type pendingRequest struct {
+	RequestID string `json:"request_id"`
+	PID       int    `json:"pid"`
+	// SudoUser is the account that ran the request through sudo, which may cancel it
+	SudoUser  string    `json:"sudo_user,omitempty"`
+	Command   string    `json:"command"`
+	ChannelID string    `json:"channel_id,omitempty"`
+	Link      string    `json:"link,omitempty"`
+	Requested time.Time `json:"requested"`
+	// Deadline is when the current stage times out, following extensions
+	Deadline time.Time `json:"deadline"`
+	// CancelledBy is set by `cancel` before it signals the waiting process
+	CancelledBy string `json:"cancelled_by,omitempty"`
+}
+
+// pendingRegistration is the registry entry of the running request.
+type pendingRegistration struct {
+	path string
+	f    *os.File
+}
+
+func pendingPath(stateDir, requestID string) string {
+	return filepath.Join(stateDir, pendingDirName, requestID+".json")
+}
+
+// registerPending records req as pending until the registration is removed or the
+// process exits.
+func registerPending(stateDir string, req pendingRequest) (*pendingRegistration, error) {
+	if err := os.MkdirAll(filepath.Join(stateDir, pendingDirName), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create pending directory: %w", err)
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	// The record is locked before it is renamed into place, so it is never seen unlocked
+	path := pendingPath(stateDir, req.RequestID)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register pending request: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to lock pending request: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to register pending request: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to register pending request: %w", err)
+	}
+	return &pendingRegistration{path: path, f: f}, nil
+}
+
+// remove unregisters the request once it is decided.
+func (r *pendingRegistration) remove() {
+	if r == nil {
+		return
+	}
+	os.Remove(r.path)
+	r.f.Close()
+}
+
+// setDeadline records a new deadline of the request, after an extension or once a
+// stage is approved.
+func (r *pendingRegistration) setDeadline(deadline time.Time) {
+	if r == nil {
+		return
+	}
+	var req pendingRequest
+	data, err := os.ReadFile(r.path)
+	if err != nil || json.Unmarshal(data, &req) != nil {
+		return
+	}
+	req.Deadline = deadline
+	if data, err = json.Marshal(req); err == nil {
+		// Written in place, keeping the lock on the file
+		os.WriteFile(r.path, data, 0600)
+	}
+}
+
+// cancelledBy returns who cancelled the request with `cancel`.
+func (r *pendingRegistration) cancelledBy() string {
+	var req pendingRequest
+	if data, err := os.ReadFile(r.path); err == nil && json.Unmarshal(data, &req) == nil && req.CancelledBy != "" {
+		return req.CancelledBy
+	}
+	return "unknown"
+}
+
+// readPending returns the pending request requestID. It fails if there is none, and
+// removes the record of a request whose process is gone.
+func readPending(stateDir, requestID string) (pendingRequest, error) {
+	var req pendingRequest
+	// Request IDs come from the command line and name files
+	if id, err := hex.DecodeString(requestID); err != nil || len(id) != 8 {
+		return req, fmt.Errorf("invalid request ID %q", requestID)
+	}
+	path := pendingPath(stateDir, requestID)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return req, fmt.Errorf("no pending request %s", requestID)
+	} else if err != nil {
+		return req, err
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err == nil {
+		os.Remove(path)
+		return req, fmt.Errorf("no pending request %s", requestID)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return req, err
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return req, fmt.Errorf("failed to parse pending request %s: %w", requestID, err)
+	}
+	return req, nil
+}
+
+// listPending returns the pending requests, oldest first.
+func listPending(stateDir string) ([]pendingRequest, error) {
+	entries, err := os.ReadDir(filepath.Join(stateDir, pendingDirName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var reqs []pendingRequest
+	for _, e := range entries {
+		requestID, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		// Requests decided in the meantime, or whose process is gone, are skipped
+		if req, err := readPending(stateDir, requestID); err == nil {
+			reqs = append(reqs, req)
+		}
+	}
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].Requested.Before(reqs[j].Requested) })
+	return reqs, nil
+}
+
+// printPending prints reqs as a table, one request per row.
+func printPending(w io.Writer, reqs []pendingRequest, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "REQUEST\tAGE\tREMAINING\tUSER\tCOMMAND\tLINK")
+	for _, req := range reqs {
+		user := req.SudoUser
+		if user == "" {
+			user = "root"
+		}
+		remaining := "-"
+		if !req.Deadline.IsZero() {
+			remaining = max(req.Deadline.Sub(now), 0).Round(time.Second).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", req.RequestID, now.Sub(req.Requested).Round(time.Second),
+			remaining, user, req.Command, req.Link)
+	}
+	return tw.Flush()
+}
+
+// runStatus lists the pending requests of this host.
+func runStatus(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	output := fs.String("output", "table", "Output format: table, or json for one request per line")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *output != "table" && *output != outputFormatJSON {
+		fmt.Fprintf(os.Stderr, "--output must be table or %s\n", outputFormatJSON)
+		return exitConfigError
+	}
+	config, err := readConfig(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfigError
+	}
+	reqs, err := listPending(config.StateDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitInternalError
+	}
+	if *output == outputFormatJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, req := range reqs {
+			enc.Encode(req)
+		}
+		return 0
+	}
+	printPending(os.Stdout, reqs, time.Now())
+	return 0
+}
+
+// cancelPending marks the pending request requestID as cancelled by user and signals
+// its process, which withdraws it.
+func cancelPending(stateDir, requestID, user string) error {
+	req, err := readPending(stateDir, requestID)
+	if err != nil {
+		return err
+	}
+	req.CancelledBy = user
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	// Written in place, since the waiting process holds its lock on this file
+	if err := os.WriteFile(pendingPath(stateDir, requestID), data, 0600); err != nil {
+		return fmt.Errorf("failed to update pending request: %w", err)
+	}
+	if err := syscall.Kill(req.PID, syscall.SIGUSR1); err != nil {
+		return fmt.Errorf("failed to signal process %d: %w", req.PID, err)
+	}
+	return nil
+}
+
+// runCancel withdraws a pending request. Through sudo, only the user who made the
+// request may cancel it; root may cancel any.
+func runCancel(args []string) int {
+	fs := flag.NewFlagSet("cancel", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: prompt-sudo-discord cancel REQUEST_ID")
+	}
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitConfigError
+	}
+	requestID := fs.Arg(0)
+
+	config, err := readConfig(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfigError
+	}
+	req, err := readPending(config.StateDir, requestID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfigError
+	}
+	user := os.Getenv("SUDO_USER")
+	if user != "" && user != req.SudoUser {
+		fmt.Fprintf(os.Stderr, "request %s was not made by %s\n", requestID, user)
+		return exitDenied
+	}
+	if user == "" {
+		user = "root"
+	}
+	if err := cancelPending(config.StateDir, requestID, user); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitInternalError
+	}
+	fmt.Printf("cancelled request %s: %s\n", requestID, req.Command)
+	return 0
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCancelPending(t *testing.T) {
+	stateDir := t.TempDir()
+	reg, err := registerPending(stateDir, pendingRequest{RequestID: "0123456789abcdef", PID: os.Getpid(), SudoUser: "alice", Command: "apt update"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.remove()
+	req, err := readPending(stateDir, "0123456789abcdef")
+	if err != nil || req.SudoUser != "alice" || req.Command != "apt update" {
+		t.Fatalf("readPending = %+v, %v", req, err)
+	}
+	deadline := time.Now().Add(5 * time.Minute).Truncate(time.Second)
+	reg.setDeadline(deadline)
+	reqs, err := listPending(stateDir)
+	if err != nil || len(reqs) != 1 || !reqs[0].Deadline.Equal(deadline) {
+		t.Fatalf("listPending = %+v, %v", reqs, err)
+	}
+
+	cancelled := make(chan os.Signal, 1)
+	signal.Notify(cancelled, syscall.SIGUSR1)
+	defer signal.Stop(cancelled)
+	if err := cancelPending(stateDir, "0123456789abcdef", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("process was not signalled")
+	}
+	if by := reg.cancelledBy(); by != "alice" {
+		t.Errorf("cancelledBy = %q", by)
+	}
+
+	reg.remove()
+	if _, err := readPending(stateDir, "0123456789abcdef"); err == nil {
+		t.Error("expected no pending request after remove")
+	}
+}
+
+func TestReadPendingStale(t *testing.T) {
+	stateDir := t.TempDir()
+	// A record nobody holds a lock on was left by a process that is gone
+	path := pendingPath(stateDir, "0123456789abcdef")
+	os.MkdirAll(filepath.Dir(path), 0700)
+	if err := os.WriteFile(path, []byte(`{"request_id": "0123456789abcdef", "pid": 1}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readPending(stateDir, "0123456789abcdef"); err == nil {
+		t.Error("expected stale request to be ignored")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("stale record was not removed: %v", err)
+	}
+	if _, err := readPending(stateDir, "../../etc/passwd"); err == nil {
+		t.Error("expected invalid request ID to be rejected")
+	}
+}
+
+func TestPrintPending(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	reqs := []pendingRequest{
+		{RequestID: "0123456789abcdef", SudoUser: "alice", Command: "apt update", Link: "https://discord.com/channels/1/2/3", Requested: now.Add(-90 * time.Second), Deadline: now.Add(210 * time.Second)},
+		{RequestID: "fedcba9876543210", Command: "reboot", Requested: now.Add(-time.Hour), Deadline: now.Add(-time.Minute)},
+	}
+	var out strings.Builder
+	printPending(&out, reqs, now)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("unexpected table:\n%s", out.String())
+	}
+	for i, want := range [][]string{{"0123456789abcdef", "1m30s", "3m30s", "alice", "apt update", "https://discord.com/channels/1/2/3"}, {"fedcba9876543210", "1h0m0s", "0s", "root", "reboot"}} {
+		for _, field := range want {
+			if !strings.Contains(lines[i+1], field) {
+				t.Errorf("row %d = %q, want %q in it", i, lines[i+1], field)
+			}
+		}
+	}
+}
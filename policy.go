@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Policy actions
+const (
+	policyActionRequireApproval = "require_approval"
+	policyActionAutoApprove     = "auto_approve"
+	policyActionDeny            = "deny"
+)
+
+// Policy decides how requests whose command matches one of its patterns are handled.
+// Policies are evaluated in config order and the first match wins.
+type Policy struct {
+	Name     string   `json:"name"`
+	Commands []string `json:"commands"`
+	Action   string   `json:"action"`
+	// Reason is shown to the caller when a deny policy rejects a command
+	Reason string `json:"reason"`
+	// GraceMinutes lets identical requests run without re-prompting for this long after an approval
+	GraceMinutes int `json:"grace_minutes"`
+	// ApproverIDs and ChannelID route matching requests away from the global approvers and --channel
+	ApproverIDs []string `json:"approver_ids"`
+	ChannelID   string   `json:"channel_id"`
+	// Stages require sequential sign-off from different approver groups
+	Stages []ApprovalStage `json:"stages"`
+	// RequireConfirmation makes approvers type ConfirmationText (default: the host name)
+	// into a modal before their approval counts
+	RequireConfirmation bool   `json:"require_confirmation"`
+	ConfirmationText    string `json:"confirmation_text"`
+	// RequireTOTP makes approvers enter a code from their authenticator (see totp_seeds)
+	RequireTOTP bool `json:"require_totp"`
+	// TimeWindows limit when the policy applies as written, in Timezone (default:
+	// local time); OutsideWindows says how it applies otherwise
+	TimeWindows    []TimeWindow    `json:"time_windows"`
+	Timezone       string          `json:"timezone"`
+	OutsideWindows *OutsideWindows `json:"outside_windows"`
+}
+
+// ApprovalStage is one step of a sequential approval chain.
+type ApprovalStage struct {
+	Name           string   `json:"name"`
+	ApproverIDs    []string `json:"approver_ids"`
+	TimeoutSeconds int      `json:"timeout_seconds"`
+}
+
+func (s ApprovalStage) timeout() time.Duration {
+	return time.Duration(s.TimeoutSeconds) * time.Second
+}
+
+// approvalStages returns the stages a request has to pass. Without a staged policy,
+// a request has a single stage using the given approvers and timeout. Stages that
+// leave out approvers or a timeout inherit them.
+func approvalStages(policy *Policy, approverIDs []string, timeoutSec int) []ApprovalStage {
+	if policy == nil || len(policy.Stages) == 0 {
+		return []ApprovalStage{{Name: "approval", ApproverIDs: approverIDs, TimeoutSeconds: timeoutSec}}
+	}
+	stages := make([]ApprovalStage, len(policy.Stages))
+	for i, s := range policy.Stages {
+		if len(s.ApproverIDs) == 0 {
+			s.ApproverIDs = approverIDs
+		}
+		if s.TimeoutSeconds <= 0 {
+			s.TimeoutSeconds = timeoutSec
+		}
+		stages[i] = s
+	}
+	return stages
+}
+
+// stageApproverIDs returns every approver taking part in any of the stages.
+func stageApproverIDs(stages []ApprovalStage) []string {
+	var ids []string
+	for _, s := range stages {
+		for _, id := range s.ApproverIDs {
+			if !isApprover(id, ids) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// formatStages renders the approval chain for the request message.
+func formatStages(stages []ApprovalStage) string {
+	parts := make([]string, len(stages))
+	for i, s := range stages {
+		parts[i] = fmt.Sprintf("%s (%ds)", s.Name, s.TimeoutSeconds)
+	}
+	return strings.Join(parts, " → ")
+}
+
+func validatePolicies(policies []Policy) error {
+	for i, p := range policies {
+		if p.Name == "" {
+			return fmt.Errorf("policies[%d]: name is required", i)
+		}
+		if len(p.Commands) == 0 {
+			return fmt.Errorf("policy %q: commands is required", p.Name)
+		}
+		if p.GraceMinutes < 0 {
+			return fmt.Errorf("policy %q: grace_minutes must not be negative", p.Name)
+		}
+		for j, stage := range p.Stages {
+			if stage.Name == "" {
+				return fmt.Errorf("policy %q: stages[%d]: name is required", p.Name, j)
+			}
+		}
+		switch p.Action {
+		case "", policyActionRequireApproval, policyActionAutoApprove, policyActionDeny:
+		default:
+			return fmt.Errorf("policy %q: unknown action %q", p.Name, p.Action)
+		}
+		if err := validateTimeWindows(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchPolicy returns the first policy with a pattern matching command, or nil.
+func matchPolicy(policies []Policy, command string) *Policy {
+	for i := range policies {
+		for _, pattern := range policies[i].Commands {
+			if matchGlob(pattern, command) {
+				return &policies[i]
+			}
+		}
+	}
+	return nil
+}
+
+// matchGlob reports whether s matches pattern in full. Unlike path.Match,
+// '*' also matches '/', since commands usually contain paths.
+func matchGlob(pattern, s string) bool {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString("(?s:.*)")
+		case '?':
+			b.WriteString("(?s:.)")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String()).MatchString(s)
+}
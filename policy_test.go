@@ -0,0 +1,93 @@
+package main
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"apt update", "apt update", true},
+		{"apt *", "apt install vim", true},
+		{"systemctl status *", "systemctl status nginx", true},
+		{"systemctl status *", "systemctl restart nginx", false},
+		{"/usr/bin/*", "/usr/bin/ls -la", true},
+		{"cat /var/log/?.log", "cat /var/log/a.log", true},
+		{"cat /var/log/?.log", "cat /var/log/ab.log", false},
+		{"ls (a)", "ls (a)", true},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestMatchPolicy(t *testing.T) {
+	policies := []Policy{
+		{Name: "status", Commands: []string{"systemctl status *"}, Action: policyActionAutoApprove},
+		{Name: "systemctl", Commands: []string{"systemctl *"}},
+	}
+	if p := matchPolicy(policies, "systemctl status nginx"); p == nil || p.Name != "status" {
+		t.Errorf("expected status policy, got %v", p)
+	}
+	if p := matchPolicy(policies, "systemctl restart nginx"); p == nil || p.Name != "systemctl" {
+		t.Errorf("expected systemctl policy, got %v", p)
+	}
+	if p := matchPolicy(policies, "apt update"); p != nil {
+		t.Errorf("expected no policy, got %v", p)
+	}
+}
+
+func TestValidatePolicies(t *testing.T) {
+	if err := validatePolicies([]Policy{{Name: "ok", Commands: []string{"ls"}, Action: policyActionAutoApprove}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := validatePolicies([]Policy{{Name: "bad", Commands: []string{"ls"}, Action: "maybe"}}); err == nil {
+		t.Error("expected error for unknown action")
+	}
+	if err := validatePolicies([]Policy{{Name: "empty"}}); err == nil {
+		t.Error("expected error for missing commands")
+	}
+}
+
+func TestApprovalStages(t *testing.T) {
+	t.Run("single stage without staged policy", func(t *testing.T) {
+		stages := approvalStages(nil, []string{"1"}, 300)
+		if len(stages) != 1 || stages[0].TimeoutSeconds != 300 || stages[0].ApproverIDs[0] != "1" {
+			t.Errorf("unexpected stages: %+v", stages)
+		}
+	})
+
+	t.Run("stages inherit approvers and timeout", func(t *testing.T) {
+		policy := &Policy{Stages: []ApprovalStage{
+			{Name: "peer"},
+			{Name: "senior", ApproverIDs: []string{"9"}, TimeoutSeconds: 600},
+		}}
+		stages := approvalStages(policy, []string{"1", "2"}, 300)
+		if len(stages) != 2 {
+			t.Fatalf("expected 2 stages, got %d", len(stages))
+		}
+		if stages[0].TimeoutSeconds != 300 || len(stages[0].ApproverIDs) != 2 {
+			t.Errorf("peer stage = %+v", stages[0])
+		}
+		if stages[1].TimeoutSeconds != 600 || stages[1].ApproverIDs[0] != "9" {
+			t.Errorf("senior stage = %+v", stages[1])
+		}
+		if got := formatStages(stages); got != "peer (300s) → senior (600s)" {
+			t.Errorf("formatStages = %q", got)
+		}
+	})
+}
+
+func TestStageApproverIDs(t *testing.T) {
+	stages := []ApprovalStage{
+		{Name: "peer", ApproverIDs: []string{"1", "2"}},
+		{Name: "senior", ApproverIDs: []string{"2", "3"}},
+	}
+	got := stageApproverIDs(stages)
+	if len(got) != 3 || got[0] != "1" || got[1] != "2" || got[2] != "3" {
+		t.Errorf("stageApproverIDs = %v, want [1 2 3]", got)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	defaultPolicyHookTimeout = 5
+	// policyHookName names the policy of requests no static policy covers
+	policyHookName = "policy_hook"
+)
+
+// PolicyHookConfig asks an external policy engine, such as an OPA data API, how each
+// request is handled.
+type PolicyHookConfig struct {
+	// URL receives a JSON POST of {"input": ...}, e.g. "http://127.0.0.1:8181/v1/data/psd/decision"
+	URL            string            `json:"url"`
+	Headers        map[string]string `json:"headers"`
+	TimeoutSeconds int               `json:"timeout_seconds"`
+}
+
+func validatePolicyHook(h *PolicyHookConfig) error {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("policy_hook.url must be an http(s) URL")
+	}
+	if h.TimeoutSeconds <= 0 {
+		h.TimeoutSeconds = defaultPolicyHookTimeout
+	}
+	return nil
+}
+
+// policyHookInput is the request document the policy engine decides on.
+type policyHookInput struct {
+	Command  string   `json:"command"`
+	Args     []string `json:"args"`
+	User     string   `json:"user"`
+	SudoUser string   `json:"sudo_user,omitempty"`
+	Host     string   `json:"host"`
+	CWD      string   `json:"cwd"`
+	RunAs    string   `json:"run_as"`
+	Time     string   `json:"time"`
+	Weekday  string   `json:"weekday"`
+	// StdinSHA256 is set when stdin is shown with the request
+	StdinSHA256 string `json:"stdin_sha256,omitempty"`
+	// Policy is the static policy matching the command, if any
+	Policy string `json:"policy,omitempty"`
+}
+
+// stdinSHA256 returns the hex SHA-256 of the stdin shown with a request, or "" without one.
+func stdinSHA256(shown bool, data []byte) string {
+	if !shown {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// policyHookDecision is the policy engine's result; empty fields leave the static
+// policy as it is.
+type policyHookDecision struct {
+	Action      string   `json:"action"`
+	Reason      string   `json:"reason"`
+	ChannelID   string   `json:"channel_id"`
+	ApproverIDs []string `json:"approver_ids"`
+	// RequiredApprovals is how many approvers have to sign off, one after another
+	RequiredApprovals int `json:"required_approvals"`
+}
+
+// query posts input to the policy engine. A nil decision means the engine has no
+// result for the request.
+func (h *PolicyHookConfig) query(input policyHookInput) (*policyHookDecision, error) {
+	data, err := json.Marshal(map[string]any{"input": input})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{Timeout: time.Duration(h.TimeoutSeconds) * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("policy engine returned %s", resp.Status)
+	}
+	var body struct {
+		Result *policyHookDecision `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid policy engine response: %w", err)
+	}
+	if d := body.Result; d != nil {
+		switch d.Action {
+		case "", policyActionRequireApproval, policyActionAutoApprove, policyActionDeny:
+		default:
+			return nil, fmt.Errorf("policy engine returned unknown action %q", d.Action)
+		}
+		if d.RequiredApprovals < 0 {
+			return nil, fmt.Errorf("policy engine returned negative required_approvals")
+		}
+	}
+	return body.Result, nil
+}
+
+// apply returns policy with the decision's fields overriding its own.
+func (d *policyHookDecision) apply(policy *Policy) *Policy {
+	decided := Policy{Name: policyHookName}
+	if policy != nil {
+		decided = *policy
+	}
+	if d.Action != "" {
+		decided.Action = d.Action
+	}
+	if d.Reason != "" {
+		decided.Reason = d.Reason
+	}
+	if d.ChannelID != "" {
+		decided.ChannelID = d.ChannelID
+	}
+	if len(d.ApproverIDs) > 0 {
+		decided.ApproverIDs = d.ApproverIDs
+	}
+	if d.RequiredApprovals > 0 {
+		decided.Stages = nil
+		if d.RequiredApprovals > 1 {
+			for i := 1; i <= d.RequiredApprovals; i++ {
+				decided.Stages = append(decided.Stages, ApprovalStage{Name: fmt.Sprintf("approval %d", i)})
+			}
+		}
+	}
+	return &decided
+}
+
+// decide asks the policy engine about a request whose static policy is policy, and
+// returns the policy to apply. If the engine cannot be asked, the request is denied.
+func (h *PolicyHookConfig) decide(policy *Policy, input policyHookInput) *Policy {
+	if policy != nil {
+		input.Policy = policy.Name
+	}
+	d, err := h.query(input)
+	if err != nil {
+		slog.Error("policy hook failed", "err", err)
+		return &Policy{Name: policyHookName, Action: policyActionDeny, Reason: "policy evaluation failed"}
+	}
+	if d == nil {
+		return policy
+	}
+	return d.apply(policy)
+}
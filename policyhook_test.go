@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidatePolicyHook(t *testing.T) {
+	h := &PolicyHookConfig{URL: "http://127.0.0.1:8181/v1/data/psd/decision"}
+	if err := validatePolicyHook(h); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h.TimeoutSeconds != defaultPolicyHookTimeout {
+		t.Errorf("timeout = %d, want default", h.TimeoutSeconds)
+	}
+	for _, u := range []string{"", "127.0.0.1:8181", "file:///tmp/policy"} {
+		if err := validatePolicyHook(&PolicyHookConfig{URL: u}); err == nil {
+			t.Errorf("expected error for %q", u)
+		}
+	}
+}
+
+func policyHookServer(t *testing.T, status int, response string) (*PolicyHookConfig, *policyHookInput) {
+	t.Helper()
+	var got struct {
+		Input policyHookInput `json:"input"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(srv.Close)
+	return &PolicyHookConfig{URL: srv.URL, TimeoutSeconds: 5}, &got.Input
+}
+
+func TestPolicyHookDecide(t *testing.T) {
+	static := &Policy{Name: "restarts", Commands: []string{"systemctl restart *"}, RequireTOTP: true}
+	h, input := policyHookServer(t, http.StatusOK, `{"result":{"channel_id":"42","required_approvals":2}}`)
+
+	got := h.decide(static, policyHookInput{Command: "systemctl restart nginx", StdinSHA256: stdinSHA256(true, []byte("x"))})
+	if input.Command != "systemctl restart nginx" || input.Policy != "restarts" || input.StdinSHA256 == "" {
+		t.Errorf("unexpected input: %+v", input)
+	}
+	if got.Name != "restarts" || got.ChannelID != "42" || !got.RequireTOTP {
+		t.Errorf("unexpected policy: %+v", got)
+	}
+	if len(got.Stages) != 2 || got.Stages[1].Name != "approval 2" {
+		t.Errorf("stages = %+v, want 2", got.Stages)
+	}
+	if static.ChannelID != "" || len(static.Stages) != 0 {
+		t.Error("static policy was modified")
+	}
+}
+
+func TestPolicyHookWithoutStaticPolicy(t *testing.T) {
+	h, _ := policyHookServer(t, http.StatusOK, `{"result":{"action":"auto_approve"}}`)
+	got := h.decide(nil, policyHookInput{Command: "ls"})
+	if got == nil || got.Name != policyHookName || got.Action != policyActionAutoApprove {
+		t.Errorf("unexpected policy: %+v", got)
+	}
+}
+
+func TestPolicyHookUndefined(t *testing.T) {
+	static := &Policy{Name: "static"}
+	h, _ := policyHookServer(t, http.StatusOK, `{}`)
+	if got := h.decide(static, policyHookInput{}); got != static {
+		t.Errorf("got %+v, want the static policy", got)
+	}
+	if got := h.decide(nil, policyHookInput{}); got != nil {
+		t.Errorf("got %+v, want no policy", got)
+	}
+}
+
+func TestPolicyHookFailsClosed(t *testing.T) {
+	for name, tc := range map[string]struct {
+		status   int
+		response string
+	}{
+		"server error":   {http.StatusInternalServerError, `{}`},
+		"invalid json":   {http.StatusOK, `not json`},
+		"unknown action": {http.StatusOK, `{"result":{"action":"maybe"}}`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h, _ := policyHookServer(t, tc.status, tc.response)
+			got := h.decide(&Policy{Name: "static", Action: policyActionAutoApprove}, policyHookInput{})
+			if got.Action != policyActionDeny || got.Reason != "policy evaluation failed" {
+				t.Errorf("unexpected policy: %+v", got)
+			}
+		})
+	}
+}
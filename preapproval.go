@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	preapprovalsFile = "preapprovals.json"
+	// maxPreapprovalDuration bounds how long a pre-approval token stays valid
+	maxPreapprovalDuration = 7 * 24 * time.Hour
+	// defaultPreapproveListen is how long `prompt-sudo-discord preapprove` answers
+	defaultPreapproveListen = time.Hour
+	preapprovalTokenPrefix  = "psdpre_"
+)
+
+// preapproval is a one-time approval, issued ahead of time, of a command matching
+// Pattern. It is stored under the hash of its token, never the token itself.
+type preapproval struct {
+	// ID identifies the pre-approval in messages and audit records; it is derived from
+	// the token hash, so it does not reveal the token
+	ID         string    `json:"id"`
+	Pattern    string    `json:"pattern"`
+	ApproverID string    `json:"approver_id"`
+	IssuedAt   time.Time `json:"issued_at"`
+	Expires    time.Time `json:"expires"`
+}
+
+// preapprovalHash returns the key a token is stored under.
+func preapprovalHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// pruneExpired drops the pre-approvals expired at now, and reports whether any was.
+func pruneExpired(tokens map[string]preapproval, now time.Time) bool {
+	pruned := false
+	for hash, p := range tokens {
+		if !now.Before(p.Expires) {
+			delete(tokens, hash)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+// issuePreapproval stores a pre-approval of commands matching pattern for d, and
+// returns its one-time token.
+func issuePreapproval(stateDir, pattern string, d time.Duration, approverID string, now time.Time) (string, preapproval, error) {
+	if pattern == "" {
+		return "", preapproval{}, errors.New("the pattern must not be empty")
+	}
+	if d <= 0 || d > maxPreapprovalDuration {
+		return "", preapproval{}, fmt.Errorf("the duration must be positive and at most %s", maxPreapprovalDuration)
+	}
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", preapproval{}, err
+	}
+	token := preapprovalTokenPrefix + hex.EncodeToString(b)
+	hash := preapprovalHash(token)
+	p := preapproval{ID: hash[:12], Pattern: pattern, ApproverID: approverID, IssuedAt: now, Expires: now.Add(d)}
+	tokens := map[string]preapproval{}
+	err := updateStateFile(stateDir, preapprovalsFile, &tokens, func() bool {
+		pruneExpired(tokens, now)
+		tokens[hash] = p
+		return true
+	})
+	if err != nil {
+		return "", preapproval{}, err
+	}
+	return token, p, nil
+}
+
+// revokePreapproval removes a pre-approval whose token never reached the approver.
+func revokePreapproval(stateDir, token string) error {
+	hash := preapprovalHash(token)
+	tokens := map[string]preapproval{}
+	return updateStateFile(stateDir, preapprovalsFile, &tokens, func() bool {
+		if _, ok := tokens[hash]; !ok {
+			return false
+		}
+		delete(tokens, hash)
+		return true
+	})
+}
+
+// checkPreapprover checks that whoever issued p may approve the request: not its
+// requester, and one of the policy's own approvers if it has them, since any approver of
+// the host can issue pre-approvals.
+func checkPreapprover(p preapproval, policy *Policy, requesterIDs []string) error {
+	if isApprover(p.ApproverID, requesterIDs) {
+		return errors.New("pre-approval was issued by the requester")
+	}
+	if policy == nil {
+		return nil
+	}
+	if ids := stageApproverIDs(approvalStages(policy, policy.ApproverIDs, 0)); len(ids) > 0 && !isApprover(p.ApproverID, ids) {
+		return fmt.Errorf("pre-approval was not issued by an approver of policy %s", policy.Name)
+	}
+	return nil
+}
+
+// consumePreapproval redeems token for command, which its pattern must match. A token
+// is used up by the first command it approves; one whose pattern does not match stays.
+func consumePreapproval(stateDir, token, command string, now time.Time) (preapproval, error) {
+	hash := preapprovalHash(token)
+	tokens := map[string]preapproval{}
+	var p preapproval
+	var found bool
+	var mismatch error
+	err := updateStateFile(stateDir, preapprovalsFile, &tokens, func() bool {
+		modified := pruneExpired(tokens, now)
+		p, found = tokens[hash]
+		if !found {
+			return modified
+		}
+		if !matchGlob(p.Pattern, command) {
+			mismatch = fmt.Errorf("the command does not match pre-approval %s of %q", p.ID, p.Pattern)
+			return modified
+		}
+		delete(tokens, hash)
+		return true
+	})
+	switch {
+	case err != nil:
+		return preapproval{}, err
+	case !found:
+		return preapproval{}, errors.New("unknown pre-approval token; it may have expired or been used already")
+	case mismatch != nil:
+		return preapproval{}, mismatch
+	}
+	return p, nil
+}
+
+// preapproveRequest is a `/psd preapprove` invocation.
+type preapproveRequest struct {
+	pattern, duration string
+	// host limits the pre-approval to the host of this name; empty lets any listening
+	// host answer
+	host string
+}
+
+// parsePreapproveCommand extracts a `/psd preapprove` invocation.
+func parsePreapproveCommand(data discordgo.ApplicationCommandInteractionData) (preapproveRequest, bool) {
+	var req preapproveRequest
+	if data.Name != slashCommandName || len(data.Options) != 1 || data.Options[0].Name != slashPreapprove {
+		return req, false
+	}
+	for _, opt := range data.Options[0].Options {
+		switch opt.Name {
+		case "pattern":
+			req.pattern = opt.StringValue()
+		case "duration":
+			req.duration = opt.StringValue()
+		case "host":
+			req.host = opt.StringValue()
+		}
+	}
+	return req, req.pattern != "" && req.duration != ""
+}
+
+// answerPreapproval issues the pre-approval an approver asked for, and returns the
+// reply to show them along with the token, or "" if none was issued.
+func answerPreapproval(config *Config, hostname string, req preapproveRequest, userID string, now time.Time) (string, string) {
+	if !isApprover(userID, config.ApproverIDs) {
+		return "❌ Only approvers of " + hostname + " can pre-approve commands.", ""
+	}
+	d, err := parseSince(req.duration)
+	if err != nil {
+		return fmt.Sprintf("❌ %v.", err), ""
+	}
+	token, p, err := issuePreapproval(config.StateDir, req.pattern, d, userID, now)
+	if err != nil {
+		return fmt.Sprintf("❌ Failed to pre-approve: %v.", err), ""
+	}
+	return fmt.Sprintf("✅ Pre-approved one run of commands matching `%s` on **%s** until <t:%d:f> (pre-approval `%s`).\nHand this token to whoever runs the command; it works once:\n```\n--preapproval %s\n```",
+		inlineCode(p.Pattern), hostname, p.Expires.Unix(), p.ID, token), token
+}
+
+// runPreapprove implements `prompt-sudo-discord preapprove`: while it runs, approvers can
+// issue pre-approvals for this host with `/psd preapprove`.
+func runPreapprove(args []string) int {
+	fs := flag.NewFlagSet("preapprove", flag.ContinueOnError)
+	listen := fs.Duration("for", defaultPreapproveListen, "How long to answer /psd preapprove before exiting")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfigError
+	}
+	hostname, _ := os.Hostname()
+	config.applyHostProfile(hostname)
+
+	dg, err := discordgo.New(config.DiscordToken)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitDiscordError
+	}
+	dg.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		if i.Type != discordgo.InteractionApplicationCommand || !config.interactionAllowed(i.GuildID, i.ChannelID, nil) {
+			return
+		}
+		req, ok := parsePreapproveCommand(i.ApplicationCommandData())
+		if !ok || (req.host != "" && !strings.EqualFold(req.host, hostname)) {
+			return
+		}
+		userID := ""
+		if i.Member != nil {
+			userID = i.Member.User.ID
+		} else if i.User != nil {
+			userID = i.User.ID
+		}
+		content, token := answerPreapproval(config, hostname, req, userID, time.Now())
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: content,
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		// Another host may have answered first; its token is the one the approver got
+		if err != nil && token != "" {
+			if err := revokePreapproval(config.StateDir, token); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to revoke unanswered pre-approval: %v\n", err)
+			}
+			return
+		}
+		if token != "" {
+			fmt.Printf("pre-approved %q for %s by %s\n", req.pattern, req.duration, userID)
+		}
+	})
+	if err := dg.Open(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitDiscordError
+	}
+	defer dg.Close()
+	if err := ensureSlashCommand(dg); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to register /%s: %v\n", slashCommandName, err)
+	}
+
+	fmt.Printf("answering /%s %s for %s for %s\n", slashCommandName, slashPreapprove, hostname, *listen)
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	timer := time.NewTimer(*listen)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-sigCh:
+	}
+	return 0
+}
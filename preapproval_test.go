@@ -0,0 +1,139 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestPreapproval(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	token, issued, err := issuePreapproval(dir, "systemctl restart app*", time.Hour, "111", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, preapprovalTokenPrefix) || strings.Contains(issued.ID, token) {
+		t.Errorf("unexpected token %q for pre-approval %s", token, issued.ID)
+	}
+
+	// A command not matching the pattern leaves the token usable
+	if _, err := consumePreapproval(dir, token, "systemctl stop app", now); err == nil {
+		t.Error("expected a command outside the pattern to be refused")
+	}
+	p, err := consumePreapproval(dir, token, "systemctl restart app-worker", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ID != issued.ID || p.ApproverID != "111" {
+		t.Errorf("consumed %+v, want %+v", p, issued)
+	}
+	// One time only
+	if _, err := consumePreapproval(dir, token, "systemctl restart app-worker", now); err == nil {
+		t.Error("expected a used token to be refused")
+	}
+
+	token, _, err = issuePreapproval(dir, "*", time.Minute, "111", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := consumePreapproval(dir, token, "true", now.Add(2*time.Minute)); err == nil {
+		t.Error("expected an expired token to be refused")
+	}
+	if _, err := consumePreapproval(dir, "psdpre_forged", "true", now); err == nil {
+		t.Error("expected an unknown token to be refused")
+	}
+
+	token, _, err = issuePreapproval(dir, "*", time.Minute, "111", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := revokePreapproval(dir, token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := consumePreapproval(dir, token, "true", now); err == nil {
+		t.Error("expected a revoked token to be refused")
+	}
+}
+
+func TestIssuePreapprovalLimits(t *testing.T) {
+	dir := t.TempDir()
+	for name, tc := range map[string]struct {
+		pattern string
+		d       time.Duration
+	}{
+		"empty pattern": {"", time.Hour},
+		"no duration":   {"*", 0},
+		"too long":      {"*", maxPreapprovalDuration + time.Hour},
+	} {
+		if _, _, err := issuePreapproval(dir, tc.pattern, tc.d, "111", time.Now()); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestCheckPreapprover(t *testing.T) {
+	p := preapproval{ID: "abc", ApproverID: "111"}
+	for name, tc := range map[string]struct {
+		policy     *Policy
+		requesters []string
+		ok         bool
+	}{
+		"no policy":                {nil, nil, true},
+		"issued by the requester":  {nil, []string{"111"}, false},
+		"policy without approvers": {&Policy{Name: "p"}, nil, true},
+		"policy approver":          {&Policy{Name: "p", ApproverIDs: []string{"111", "222"}}, nil, true},
+		"not a policy approver":    {&Policy{Name: "p", ApproverIDs: []string{"222"}}, nil, false},
+		"not a stage approver":     {&Policy{Name: "p", Stages: []ApprovalStage{{ApproverIDs: []string{"222"}}}}, nil, false},
+		"stage approver":           {&Policy{Name: "p", Stages: []ApprovalStage{{ApproverIDs: []string{"111"}}}}, nil, true},
+	} {
+		if err := checkPreapprover(p, tc.policy, tc.requesters); (err == nil) != tc.ok {
+			t.Errorf("%s: err = %v", name, err)
+		}
+	}
+}
+
+func TestAnswerPreapproval(t *testing.T) {
+	config := &Config{ApproverIDs: []string{"111"}, StateDir: t.TempDir()}
+	req := preapproveRequest{pattern: "reboot", duration: "2h"}
+
+	if _, token := answerPreapproval(config, "web1", req, "222", time.Now()); token != "" {
+		t.Error("expected a non-approver to be refused")
+	}
+	content, token := answerPreapproval(config, "web1", req, "111", time.Now())
+	if token == "" || !strings.Contains(content, token) {
+		t.Fatalf("expected the reply to carry the token: %s", content)
+	}
+	req.duration = "soon"
+	if _, token := answerPreapproval(config, "web1", req, "111", time.Now()); token != "" {
+		t.Error("expected an invalid duration to be refused")
+	}
+}
+
+func TestParsePreapproveCommand(t *testing.T) {
+	data := discordgo.ApplicationCommandInteractionData{
+		Name: slashCommandName,
+		Options: []*discordgo.ApplicationCommandInteractionDataOption{
+			{
+				Name: slashPreapprove,
+				Type: discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandInteractionDataOption{
+					{Name: "pattern", Type: discordgo.ApplicationCommandOptionString, Value: "make deploy"},
+					{Name: "duration", Type: discordgo.ApplicationCommandOptionString, Value: "4h"},
+					{Name: "host", Type: discordgo.ApplicationCommandOptionString, Value: "web1"},
+				},
+			},
+		},
+	}
+	req, ok := parsePreapproveCommand(data)
+	if !ok || req != (preapproveRequest{pattern: "make deploy", duration: "4h", host: "web1"}) {
+		t.Errorf("parsePreapproveCommand = %+v, %v", req, ok)
+	}
+	// The request subcommands are not pre-approvals
+	data.Options[0].Name = slashApprove
+	if _, ok := parsePreapproveCommand(data); ok {
+		t.Error("expected /psd approve to be ignored")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runOnPTY runs the executable at path as a child process on a new pseudo-terminal,
+// for interactive commands such as passwd or editors. The buffered stdin is typed
+// into it first, then input from term, and its output is copied to out. While it
+// runs, term is put in raw mode and its window size is passed on. term may be nil,
+// in which case only the buffered stdin is typed.
+func (s execSpec) runOnPTY(path string, term *os.File, out io.Writer) error {
+	master, slavePath, err := openPTY()
+	if err != nil {
+		return fmt.Errorf("failed to allocate a pseudo-terminal: %w", err)
+	}
+	defer master.Close()
+	slave, err := os.OpenFile(slavePath, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", slavePath, err)
+	}
+
+	cmd := s.command(path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	// The pseudo-terminal becomes the controlling terminal of a new session
+	cmd.SysProcAttr.Setsid, cmd.SysProcAttr.Setctty, cmd.SysProcAttr.Ctty = true, true, 0
+
+	input := s.buffered()
+	if term != nil {
+		restore, err := makeRaw(term)
+		if err != nil {
+			slave.Close()
+			return fmt.Errorf("failed to set up the terminal: %w", err)
+		}
+		defer restore()
+		input = io.MultiReader(input, term)
+
+		resizeCh := make(chan os.Signal, 1)
+		signal.Notify(resizeCh, syscall.SIGWINCH)
+		defer signal.Stop(resizeCh)
+		resizeCh <- syscall.SIGWINCH
+		go func() {
+			for range resizeCh {
+				copyWinsize(master, term)
+			}
+		}()
+	}
+
+	err = cmd.Start()
+	slave.Close()
+	if err != nil {
+		return err
+	}
+	go io.Copy(master, input)
+	// Output ends once the command and everything it started have closed the terminal
+	outputDone := make(chan struct{})
+	go func() {
+		io.Copy(out, master)
+		close(outputDone)
+	}()
+	err = s.wait(cmd)
+	<-outputDone
+	return err
+}
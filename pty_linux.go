@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func ioctl(f *os.File, req uint, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(req), uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// openPTY opens a new pseudo-terminal and returns its master and the path of its slave.
+func openPTY() (*os.File, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	var unlock int32
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	var n uint32
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n), nil
+}
+
+// makeRaw puts the terminal in raw mode, like cfmakeraw(3), and returns a function
+// restoring its previous mode.
+func makeRaw(term *os.File) (func(), error) {
+	var old syscall.Termios
+	if err := ioctl(term, syscall.TCGETS, unsafe.Pointer(&old)); err != nil {
+		return nil, err
+	}
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(term, syscall.TCSETS, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+	return func() { ioctl(term, syscall.TCSETS, unsafe.Pointer(&old)) }, nil
+}
+
+// copyWinsize gives the pseudo-terminal the window size of term.
+func copyWinsize(master, term *os.File) {
+	var ws struct{ row, col, xpixel, ypixel uint16 }
+	if ioctl(term, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)) == nil {
+		ioctl(master, syscall.TIOCSWINSZ, unsafe.Pointer(&ws))
+	}
+}
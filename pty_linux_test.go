@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunOnPTY(t *testing.T) {
+	if _, _, err := openPTY(); err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	spec := execSpec{
+		args:      []string{"sh", "-c", `test -t 0 && test -t 1 && read x && echo "got $x"; exit 3`},
+		stdinData: []byte("hello\n"),
+	}
+	var out bytes.Buffer
+	err := spec.runOnPTY("/bin/sh", nil, &out)
+	if code, ok := exitCode(err); !ok || code != 3 {
+		t.Fatalf("runOnPTY = %v, want exit code 3", err)
+	}
+	if !strings.Contains(out.String(), "got hello") {
+		t.Errorf("output = %q, want the command to read from its terminal", out.String())
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+var errPTYUnsupported = errors.New("pseudo-terminals are only supported on Linux")
+
+func openPTY() (*os.File, string, error) {
+	return nil, "", errPTYUnsupported
+}
+
+func makeRaw(term *os.File) (func(), error) {
+	return nil, errPTYUnsupported
+}
+
+func copyWinsize(master, term *os.File) {}
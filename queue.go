@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// requestIDFDVariable names the file descriptor an enqueued request reports its ID
+// on, once it has one.
+const requestIDFDVariable = "PSD_REQUEST_ID_FD"
+
+// withoutEnqueue returns the request arguments without --enqueue, for the background
+// process carrying out the request.
+func withoutEnqueue(args []string) []string {
+	var out []string
+	for i, arg := range args {
+		if arg == "--" {
+			return append(out, args[i:]...)
+		}
+		switch arg {
+		case "--enqueue", "-enqueue", "--enqueue=true", "-enqueue=true":
+			continue
+		}
+		out = append(out, arg)
+	}
+	return out
+}
+
+// enqueueRequest starts the request in args as a background process, detached from the
+// terminal, and returns its ID once the process has one. The process then waits for
+// the decision and runs the command on its own; its stdio is /dev/null, since the
+// caller is gone by then. If it fails before it has an ID, its exit code is returned.
+func enqueueRequest(args []string) (string, int, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", exitInternalError, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return "", exitInternalError, err
+	}
+	defer r.Close()
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		w.Close()
+		return "", exitInternalError, err
+	}
+	defer devNull.Close()
+
+	cmd := exec.Command(self, append([]string{"request"}, withoutEnqueue(args)...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = devNull, devNull, devNull
+	// The pipe becomes fd 3 of the process
+	cmd.ExtraFiles = []*os.File{w}
+	cmd.Env = append(os.Environ(), requestIDFDVariable+"=3")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return "", exitInternalError, err
+	}
+	w.Close()
+
+	line, _ := bufio.NewReader(r).ReadString('\n')
+	if id := strings.TrimSpace(line); id != "" {
+		cmd.Process.Release()
+		return id, 0, nil
+	}
+	// The process exited before it had an ID, e.g. on an invalid flag or config
+	err = cmd.Wait()
+	code, ok := exitCode(err)
+	if !ok || code == 0 {
+		code = exitInternalError
+	}
+	return "", code, fmt.Errorf("the request failed before it was posted (exit code %d); run it without --enqueue to see why", code)
+}
+
+// requestIDReport returns where an enqueued request reports its ID, or nil if it was
+// not enqueued. The variable is removed, so the command does not inherit it.
+func requestIDReport() *os.File {
+	v := os.Getenv(requestIDFDVariable)
+	if v == "" {
+		return nil
+	}
+	os.Unsetenv(requestIDFDVariable)
+	fd, err := strconv.Atoi(v)
+	if err != nil || fd < 3 {
+		return nil
+	}
+	return os.NewFile(uintptr(fd), "request-id")
+}
+
+// reportRequestID sends the request ID to the process that enqueued the request.
+func reportRequestID(f *os.File, requestID string) {
+	if f == nil {
+		return
+	}
+	fmt.Fprintln(f, requestID)
+	f.Close()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestWithoutEnqueue(t *testing.T) {
+	got := withoutEnqueue([]string{"--enqueue", "--channel", "ops", "-enqueue=true", "--", "echo", "--enqueue"})
+	want := []string{"--channel", "ops", "--", "echo", "--enqueue"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("withoutEnqueue = %v, want %v", got, want)
+	}
+}
+
+func TestRequestIDReport(t *testing.T) {
+	t.Setenv(requestIDFDVariable, "")
+	if f := requestIDReport(); f != nil {
+		t.Errorf("report = %v, want nil", f)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	// The report owns its descriptor, so w must not close it again later
+	fd, err := syscall.Dup(int(w.Fd()))
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(requestIDFDVariable, strconv.Itoa(fd))
+	f := requestIDReport()
+	if f == nil {
+		t.Fatal("report = nil")
+	}
+	if _, ok := os.LookupEnv(requestIDFDVariable); ok {
+		t.Errorf("%s is still set", requestIDFDVariable)
+	}
+	reportRequestID(f, "0123456789abcdef")
+	buf := make([]byte, 64)
+	n, _ := r.Read(buf)
+	if got := string(buf[:n]); got != "0123456789abcdef\n" {
+		t.Errorf("reported %q", got)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const redactedText = "****"
+
+// defaultRedactPatterns mask common secrets in displayed commands. When a pattern has
+// capture groups, only the groups are masked so the surrounding flag stays readable.
+var defaultRedactPatterns = []string{
+	// --password=..., --token ..., -p=...
+	`(?i)--?(?:password|passwd|pass|token|secret|api-?key|access-?key)[= ](\S+)`,
+	// PGPASSWORD=..., GITHUB_TOKEN=... given as arguments (e.g. to env)
+	`(?i)\b[A-Z0-9_]*(?:PASSWORD|PASSWD|TOKEN|SECRET|API_?KEY)[A-Z0-9_]*=(\S+)`,
+	// Authorization headers
+	`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`,
+	// AWS access key IDs
+	`\b((?:AKIA|ASIA)[0-9A-Z]{16})\b`,
+	// Credentials embedded in URLs
+	`://[^:/\s@]+:([^@\s]+)@`,
+}
+
+// compileRedactions compiles the default patterns followed by the configured ones.
+func compileRedactions(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range append(append([]string{}, defaultRedactPatterns...), patterns...) {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %w", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// redactCommand is args as displayed anywhere but the audit log: quoted for a shell,
+// with every match of the patterns masked. Patterns apply to the whole line, since a
+// flag and its secret may be separate arguments.
+func redactCommand(args []string, patterns []*regexp.Regexp) string {
+	return redact(formatCommand(args), patterns)
+}
+
+// redact masks every match of the patterns in s.
+func redact(s string, patterns []*regexp.Regexp) string {
+	for _, re := range patterns {
+		s = redactPattern(s, re)
+	}
+	return s
+}
+
+func redactPattern(s string, re *regexp.Regexp) string {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+	var out []byte
+	last := 0
+	for _, m := range matches {
+		if re.NumSubexp() == 0 {
+			out = append(out, s[last:m[0]]...)
+			out = append(out, redactedText...)
+			last = m[1]
+			continue
+		}
+		for g := 1; g <= re.NumSubexp(); g++ {
+			start, end := m[2*g], m[2*g+1]
+			if start < 0 || start < last {
+				continue
+			}
+			out = append(out, s[last:start]...)
+			out = append(out, redactedText...)
+			last = end
+		}
+	}
+	out = append(out, s[last:]...)
+	return string(out)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// requesterNames returns the local identities of the person asking: the user sudo
+// was invoked by (which the caller cannot forge) and the --requester override.
+func requesterNames(requesterFlag string) []string {
+	var names []string
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" {
+		names = append(names, sudoUser)
+	}
+	if requesterFlag != "" && !isApprover(requesterFlag, names) {
+		names = append(names, requesterFlag)
+	}
+	return names
+}
+
+// requesterDiscordIDs maps local requester names to Discord accounts via discord_user_ids.
+func requesterDiscordIDs(userMap map[string]string, names []string) []string {
+	var ids []string
+	for _, name := range names {
+		if id, ok := userMap[name]; ok && !isApprover(id, ids) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// withoutRequesters returns the approvers that are not the requester.
+func withoutRequesters(approverIDs, requesterIDs []string) []string {
+	var others []string
+	for _, id := range approverIDs {
+		if !isApprover(id, requesterIDs) {
+			others = append(others, id)
+		}
+	}
+	return others
+}
+
+// requesterIdentity identifies the local account asking, which cannot be forged: the
+// UID and name sudo was invoked by.
+func requesterIdentity() string {
+	return fmt.Sprintf("%d:%s", requesterUID(), os.Getenv("SUDO_USER"))
+}
+
+// requesterContext describes who is asking and from where.
+type requesterContext struct {
+	// User is the --requester override, SUDO_USER or USER, in that order
+	User string
+	// SudoUser is shown alongside User when --requester claims a different identity
+	SudoUser string
+	TTY      string
+	// SSHClient is the remote address of the SSH session, if any, and SSHOrigin its name
+	// and location
+	SSHClient string
+	SSHOrigin *sshOrigin
+}
+
+// currentRequesterContext captures the requester identity and session from the environment.
+func currentRequesterContext(requesterFlag string) requesterContext {
+	ctx := requesterContext{
+		User:     requesterFlag,
+		SudoUser: os.Getenv("SUDO_USER"),
+		TTY:      controllingTTY(),
+	}
+	if ctx.User == "" {
+		ctx.User = ctx.SudoUser
+	}
+	if ctx.User == "" {
+		ctx.User = os.Getenv("USER")
+	}
+	if ctx.SudoUser == ctx.User {
+		ctx.SudoUser = ""
+	}
+	ctx.SSHClient = sshClientAddress(os.Getenv("SSH_CONNECTION"), os.Getenv("SSH_CLIENT"))
+	return ctx
+}
+
+// controllingTTY returns the terminal attached to stdin, stdout or stderr, if any.
+func controllingTTY() string {
+	for _, fd := range []string{"0", "1", "2"} {
+		target, err := os.Readlink("/proc/self/fd/" + fd)
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(target, "/dev/pts/") || strings.HasPrefix(target, "/dev/tty") {
+			return target
+		}
+	}
+	return ""
+}
+
+// sshClientAddress extracts "ip:port" of the SSH client from SSH_CONNECTION
+// ("client_ip client_port server_ip server_port") or, failing that, SSH_CLIENT
+// ("client_ip client_port server_port").
+func sshClientAddress(sshConnection, sshClient string) string {
+	for _, v := range []string{sshConnection, sshClient} {
+		if fields := strings.Fields(v); len(fields) >= 2 {
+			if strings.Contains(fields[0], ":") {
+				return fmt.Sprintf("[%s]:%s", fields[0], fields[1])
+			}
+			return fields[0] + ":" + fields[1]
+		}
+	}
+	return ""
+}
+
+// format renders the requester context as request message lines.
+func (r requesterContext) format() string {
+	var b strings.Builder
+	if r.User != "" {
+		fmt.Fprintf(&b, "\n**Requester:** `%s`", r.User)
+		if r.SudoUser != "" {
+			fmt.Fprintf(&b, " (sudo by `%s`)", r.SudoUser)
+		}
+	}
+	if r.TTY != "" {
+		fmt.Fprintf(&b, "\n**TTY:** `%s`", r.TTY)
+	}
+	if r.SSHClient != "" {
+		fmt.Fprintf(&b, "\n**SSH from:** `%s`%s", r.SSHClient, r.SSHOrigin.format())
+	}
+	return b.String()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRequesterNames(t *testing.T) {
+	t.Setenv("SUDO_USER", "alice")
+	if got := requesterNames("deploy-bot"); !reflect.DeepEqual(got, []string{"alice", "deploy-bot"}) {
+		t.Errorf("requesterNames = %v", got)
+	}
+	if got := requesterNames("alice"); !reflect.DeepEqual(got, []string{"alice"}) {
+		t.Errorf("requesterNames = %v", got)
+	}
+
+	t.Setenv("SUDO_USER", "")
+	if got := requesterNames(""); len(got) != 0 {
+		t.Errorf("requesterNames = %v, want none", got)
+	}
+}
+
+func TestRequesterDiscordIDs(t *testing.T) {
+	userMap := map[string]string{"alice": "111", "bob": "222"}
+	if got := requesterDiscordIDs(userMap, []string{"alice", "carol"}); !reflect.DeepEqual(got, []string{"111"}) {
+		t.Errorf("requesterDiscordIDs = %v", got)
+	}
+}
+
+func TestWithoutRequesters(t *testing.T) {
+	if got := withoutRequesters([]string{"111", "222"}, []string{"111"}); !reflect.DeepEqual(got, []string{"222"}) {
+		t.Errorf("withoutRequesters = %v", got)
+	}
+	if got := withoutRequesters([]string{"111"}, []string{"111"}); len(got) != 0 {
+		t.Errorf("withoutRequesters = %v, want none", got)
+	}
+}
+
+func TestSSHClientAddress(t *testing.T) {
+	tests := []struct {
+		conn, client, want string
+	}{
+		{"203.0.113.5 52144 10.0.0.1 22", "", "203.0.113.5:52144"},
+		{"", "203.0.113.5 52144 22", "203.0.113.5:52144"},
+		{"2001:db8::1 52144 2001:db8::2 22", "", "[2001:db8::1]:52144"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		if got := sshClientAddress(tt.conn, tt.client); got != tt.want {
+			t.Errorf("sshClientAddress(%q, %q) = %q, want %q", tt.conn, tt.client, got, tt.want)
+		}
+	}
+}
+
+func TestRequesterContextFormat(t *testing.T) {
+	ctx := requesterContext{User: "deploy-bot", SudoUser: "alice", TTY: "/dev/pts/3", SSHClient: "203.0.113.5:52144"}
+	want := "\n**Requester:** `deploy-bot` (sudo by `alice`)\n**TTY:** `/dev/pts/3`\n**SSH from:** `203.0.113.5:52144`"
+	if got := ctx.format(); got != want {
+		t.Errorf("format = %q, want %q", got, want)
+	}
+	if got := (requesterContext{}).format(); got != "" {
+		t.Errorf("format = %q, want empty", got)
+	}
+}
+
+func TestCurrentRequesterContext(t *testing.T) {
+	t.Setenv("SUDO_USER", "alice")
+	t.Setenv("USER", "root")
+	if ctx := currentRequesterContext(""); ctx.User != "alice" || ctx.SudoUser != "" {
+		t.Errorf("context = %+v", ctx)
+	}
+	if ctx := currentRequesterContext("deploy-bot"); ctx.User != "deploy-bot" || ctx.SudoUser != "alice" {
+		t.Errorf("context = %+v", ctx)
+	}
+}
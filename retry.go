@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	discordRetryAttempts  = 5
+	discordRetryBaseDelay = 500 * time.Millisecond
+	discordRetryMaxDelay  = 8 * time.Second
+)
+
+// discordRetryOptions leave rate limits to retryDiscord, so every retry is bounded.
+var discordRetryOptions = []discordgo.RequestOption{discordgo.WithRetryOnRatelimit(false)}
+
+// retryDiscord calls fn until it succeeds or fails permanently, retrying rate limits
+// after the delay Discord asks for and server or network errors with exponential
+// backoff and jitter.
+func retryDiscord[T any](fn func() (T, error)) (T, error) {
+	for attempt := 0; ; attempt++ {
+		v, err := fn()
+		delay, ok := discordRetryDelay(err, attempt)
+		if !ok || attempt+1 >= discordRetryAttempts {
+			return v, err
+		}
+		slog.Warn("Discord API request failed, retrying", "err", err, "delay", delay)
+		time.Sleep(delay)
+	}
+}
+
+// discordRetryDelay returns how long to wait before retrying after err, or false if
+// err is not transient.
+func discordRetryDelay(err error, attempt int) (time.Duration, bool) {
+	if err == nil {
+		return 0, false
+	}
+	var rateLimit *discordgo.RateLimitError
+	if errors.As(err, &rateLimit) {
+		return rateLimit.RetryAfter + jitter(discordRetryBaseDelay), true
+	}
+	var restErr *discordgo.RESTError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &restErr):
+		if restErr.Response == nil || restErr.Response.StatusCode < 500 {
+			return 0, false
+		}
+	case errors.As(err, &netErr):
+	default:
+		return 0, false
+	}
+	backoff := discordRetryBaseDelay << attempt
+	if backoff > discordRetryMaxDelay || backoff <= 0 {
+		backoff = discordRetryMaxDelay
+	}
+	// Equal jitter: half the backoff, plus up to the other half at random
+	return backoff/2 + jitter(backoff/2), true
+}
+
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return rand.N(max)
+}
+
+// sendMessage posts a message, retrying transient failures.
+func sendMessage(dg *discordgo.Session, channelID string, msgSend *discordgo.MessageSend) (*discordgo.Message, error) {
+	return retryDiscord(func() (*discordgo.Message, error) {
+		rewindFiles(msgSend.Files)
+		return dg.ChannelMessageSendComplex(channelID, msgSend, discordRetryOptions...)
+	})
+}
+
+// rewindFiles lets the attachments of a message be uploaded again, on a retry or to
+// another channel.
+func rewindFiles(files []*discordgo.File) {
+	for _, f := range files {
+		if s, ok := f.Reader.(io.Seeker); ok {
+			s.Seek(0, io.SeekStart)
+		}
+	}
+}
+
+// editMessage edits a message, retrying transient failures.
+func editMessage(dg *discordgo.Session, edit *discordgo.MessageEdit) (*discordgo.Message, error) {
+	return retryDiscord(func() (*discordgo.Message, error) {
+		return dg.ChannelMessageEditComplex(edit, discordRetryOptions...)
+	})
+}
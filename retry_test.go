@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func restError(status int) error {
+	return &discordgo.RESTError{Response: &http.Response{StatusCode: status}}
+}
+
+func TestDiscordRetryDelay(t *testing.T) {
+	if _, ok := discordRetryDelay(nil, 0); ok {
+		t.Error("nil error should not be retried")
+	}
+	if _, ok := discordRetryDelay(restError(http.StatusForbidden), 0); ok {
+		t.Error("4xx should not be retried")
+	}
+	if _, ok := discordRetryDelay(errors.New("invalid JSON"), 0); ok {
+		t.Error("unknown errors should not be retried")
+	}
+
+	for attempt := 0; attempt < 10; attempt++ {
+		delay, ok := discordRetryDelay(restError(http.StatusServiceUnavailable), attempt)
+		if !ok {
+			t.Fatal("5xx should be retried")
+		}
+		backoff := discordRetryBaseDelay << attempt
+		if backoff > discordRetryMaxDelay {
+			backoff = discordRetryMaxDelay
+		}
+		if delay < backoff/2 || delay > backoff {
+			t.Errorf("attempt %d: delay %s outside [%s, %s]", attempt, delay, backoff/2, backoff)
+		}
+	}
+
+	rateLimit := &discordgo.RateLimitError{RateLimit: &discordgo.RateLimit{
+		TooManyRequests: &discordgo.TooManyRequests{RetryAfter: 3 * time.Second},
+	}}
+	delay, ok := discordRetryDelay(rateLimit, 0)
+	if !ok || delay < 3*time.Second {
+		t.Errorf("rate limit delay = %s, %v; want at least retry_after", delay, ok)
+	}
+}
+
+func TestRetryDiscord(t *testing.T) {
+	calls := 0
+	got, err := retryDiscord(func() (string, error) {
+		calls++
+		if calls == 1 {
+			return "", restError(http.StatusInternalServerError)
+		}
+		return "ok", nil
+	})
+	if err != nil || got != "ok" || calls != 2 {
+		t.Errorf("got %q, %v after %d calls", got, err, calls)
+	}
+
+	calls = 0
+	_, err = retryDiscord(func() (string, error) {
+		calls++
+		return "", restError(http.StatusNotFound)
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("permanent error: err %v after %d calls", err, calls)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// runAsIdentity is the user and group an approved command runs as, instead of root.
+type runAsIdentity struct {
+	user, group string
+	uid, gid    uint32
+	groups      []uint32
+	home        string
+}
+
+// resolveRunAs looks up the identity for --user and --group, by name or numeric ID.
+// The group defaults to the user's primary group, and the user to the current one.
+func resolveRunAs(userName, groupName string) (*runAsIdentity, error) {
+	var u *user.User
+	var err error
+	if userName != "" {
+		if u, err = lookupUser(userName); err != nil {
+			return nil, err
+		}
+	} else if u, err = user.Current(); err != nil {
+		return nil, err
+	}
+	id := &runAsIdentity{user: u.Username, home: u.HomeDir}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %s has a non-numeric uid %q", u.Username, u.Uid)
+	}
+	id.uid = uint32(uid)
+
+	gidStr := u.Gid
+	if groupName != "" {
+		g, err := lookupGroup(groupName)
+		if err != nil {
+			return nil, err
+		}
+		gidStr = g.Gid
+	}
+	gid, err := strconv.ParseUint(gidStr, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("non-numeric gid %q", gidStr)
+	}
+	id.gid = uint32(gid)
+	if g, err := user.LookupGroupId(gidStr); err == nil {
+		id.group = g.Name
+	} else {
+		id.group = gidStr
+	}
+
+	// The user's supplementary groups come along, as with sudo -u
+	if userName != "" {
+		ids, err := u.GroupIds()
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up groups of %s: %w", u.Username, err)
+		}
+		for _, s := range ids {
+			if g, err := strconv.ParseUint(s, 10, 32); err == nil {
+				id.groups = append(id.groups, uint32(g))
+			}
+		}
+	}
+	return id, nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return user.LookupId(name)
+	}
+	return user.Lookup(name)
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return user.LookupGroupId(name)
+	}
+	return user.LookupGroup(name)
+}
+
+// String renders the identity as user:group.
+func (id *runAsIdentity) String() string {
+	return id.user + ":" + id.group
+}
+
+// format renders the identity for the approval request.
+func (id *runAsIdentity) format() string {
+	return fmt.Sprintf("\n👤 **Runs as:** `%s` (uid %d), group `%s` (gid %d)", id.user, id.uid, id.group, id.gid)
+}
+
+// credential is the identity for a child process.
+func (id *runAsIdentity) credential() *syscall.Credential {
+	return &syscall.Credential{Uid: id.uid, Gid: id.gid, Groups: id.groups}
+}
+
+// env returns env with HOME, USER and LOGNAME set for the identity's user.
+func (id *runAsIdentity) env(env []string) []string {
+	out := make([]string, 0, len(env)+3)
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		switch name {
+		case "HOME", "USER", "LOGNAME":
+		default:
+			out = append(out, kv)
+		}
+	}
+	return append(out, "HOME="+id.home, "USER="+id.user, "LOGNAME="+id.user)
+}
+
+// become switches this process to the identity, before it is replaced by the command.
+// Groups are set first, while this process still may.
+func (id *runAsIdentity) become() error {
+	groups := make([]int, len(id.groups))
+	for i, g := range id.groups {
+		groups[i] = int(g)
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(int(id.gid)); err != nil {
+		return fmt.Errorf("setgid: %w", err)
+	}
+	if err := syscall.Setuid(int(id.uid)); err != nil {
+		return fmt.Errorf("setuid: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestResolveRunAs(t *testing.T) {
+	root, err := resolveRunAs("0", "")
+	if err != nil {
+		t.Fatalf("resolveRunAs(0): %v", err)
+	}
+	if root.uid != 0 || root.user != "root" || root.gid != 0 {
+		t.Errorf("resolveRunAs(0) = %+v", root)
+	}
+	if _, err := resolveRunAs("no-such-user-psd", ""); err == nil {
+		t.Error("expected error for unknown user")
+	}
+	if _, err := resolveRunAs("", "no-such-group-psd"); err == nil {
+		t.Error("expected error for unknown group")
+	}
+
+	current, _ := user.Current()
+	onlyGroup, err := resolveRunAs("", "0")
+	if err != nil {
+		t.Fatalf("resolveRunAs(group 0): %v", err)
+	}
+	if onlyGroup.user != current.Username || onlyGroup.gid != 0 {
+		t.Errorf("resolveRunAs(group 0) = %+v, want the current user", onlyGroup)
+	}
+	if !strings.Contains(root.format(), "`root` (uid 0)") {
+		t.Errorf("format() = %q", root.format())
+	}
+}
+
+func TestRunAsEnv(t *testing.T) {
+	id := &runAsIdentity{user: "alice", home: "/home/alice"}
+	env := id.env([]string{"HOME=/root", "PATH=/bin", "USER=root"})
+	want := []string{"PATH=/bin", "HOME=/home/alice", "USER=alice", "LOGNAME=alice"}
+	if !slices.Equal(env, want) {
+		t.Errorf("env = %v, want %v", env, want)
+	}
+}
+
+func TestRunAsChild(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("switching users needs root")
+	}
+	id, err := resolveRunAs("nobody", "")
+	if err != nil {
+		t.Skipf("no nobody user: %v", err)
+	}
+	spec := execSpec{args: []string{"id", "-u"}, runAs: id}
+	cmd := spec.command("/usr/bin/id")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(out.String()); got != strconv.Itoa(int(id.uid)) {
+		t.Errorf("id -u = %s, want %d", got, id.uid)
+	}
+}
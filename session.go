@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSessionDuration = time.Hour
+	// sessionsDir holds the recordings of sessions, below the state directory
+	sessionsDir = "sessions"
+)
+
+// formatSessionRequest describes a session in the request message.
+func formatSessionRequest(label string, duration time.Duration) string {
+	return fmt.Sprintf("\n🧑‍💻 **Session:** `%s`, interactive for up to %s after approval; the terminal output is recorded", sessionLabel(label), duration)
+}
+
+// sessionLabel keeps a label from breaking out of the code span it is shown in.
+func sessionLabel(label string) string {
+	return strings.ReplaceAll(label, "`", "'")
+}
+
+// openSessionRecording creates the root-only file the terminal output of a session
+// is recorded to, starting with a header naming the session.
+func openSessionRecording(stateDir, requestID, label string, started time.Time) (*os.File, error) {
+	dir := filepath.Join(stateDir, sessionsDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, requestID+".log"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session recording: %w", err)
+	}
+	if _, err := fmt.Fprintf(f, "# session %q, request %s, started %s\n", label, requestID, started.UTC().Format(time.RFC3339)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write session recording: %w", err)
+	}
+	return f, nil
+}
+
+// sessionStarted is posted when an approved session begins.
+func sessionStarted(label string, duration time.Duration) string {
+	return fmt.Sprintf("🟢 **Session started:** `%s`. Ends in %s at the latest.", sessionLabel(label), duration)
+}
+
+// sessionEnded is posted when a session is over, saying where it was recorded.
+func sessionEnded(label string, elapsed time.Duration, exitCode int, recording string) string {
+	return fmt.Sprintf("🔴 **Session ended:** `%s` after %s (exit code %d). Recorded to `%s` on the host.", sessionLabel(label), elapsed.Round(time.Second), exitCode, recording)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenSessionRecording(t *testing.T) {
+	stateDir := filepath.Join(t.TempDir(), "state")
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	f, err := openSessionRecording(stateDir, "req1", "db failover", started)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f.WriteString("$ whoami\r\nroot\r\n")
+	f.Close()
+
+	path := filepath.Join(stateDir, sessionsDir, "req1.log")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# session \"db failover\", request req1, started 2026-01-02T03:04:05Z\n$ whoami\r\nroot\r\n"
+	if string(data) != want {
+		t.Errorf("recording = %q, want %q", data, want)
+	}
+	info, _ := os.Stat(path)
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("recording mode = %o, want 600", perm)
+	}
+
+	// A recording is never overwritten
+	if _, err := openSessionRecording(stateDir, "req1", "again", started); err == nil {
+		t.Error("expected an existing recording to be refused")
+	}
+}
+
+func TestSessionMessages(t *testing.T) {
+	if got := formatSessionRequest("x`y", time.Hour); !strings.Contains(got, "`x'y`") || !strings.Contains(got, "1h0m0s") {
+		t.Errorf("formatSessionRequest = %q", got)
+	}
+	got := sessionEnded("rescue", 90*time.Second+300*time.Millisecond, 130, "/var/lib/psd/sessions/r.log")
+	want := "🔴 **Session ended:** `rescue` after 1m30s (exit code 130). Recorded to `/var/lib/psd/sessions/r.log` on the host."
+	if got != want {
+		t.Errorf("sessionEnded = %q, want %q", got, want)
+	}
+}
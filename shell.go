@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+)
+
+const shellPrompt = "psd$ "
+
+// approvalShell reads command lines and has each one approved before it runs.
+type approvalShell struct {
+	// run requests approval for a command line and runs it, returning its exit code
+	run    func(line string) int
+	prompt io.Writer
+}
+
+// serve runs the command lines read from in until it ends or `exit`, and returns the
+// exit code of the last command. Only `cd` and `exit` are handled by the shell itself.
+func (s *approvalShell) serve(in io.Reader) int {
+	status := 0
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(s.prompt, shellPrompt)
+		if !scanner.Scan() {
+			fmt.Fprintln(s.prompt)
+			return status
+		}
+		line := strings.TrimSpace(scanner.Text())
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0 || strings.HasPrefix(line, "#"):
+		case fields[0] == "exit":
+			return status
+		case fields[0] == "cd":
+			status = s.chdir(fields[1:])
+		default:
+			status = s.run(line)
+		}
+	}
+}
+
+// chdir changes the directory the following commands run in.
+func (s *approvalShell) chdir(args []string) int {
+	dir := os.Getenv("HOME")
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	if len(args) > 1 || dir == "" {
+		fmt.Fprintln(s.prompt, "cd: usage: cd [DIR]")
+		return 1
+	}
+	if err := os.Chdir(dir); err != nil {
+		fmt.Fprintf(s.prompt, "cd: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// shellInvocation returns the command that requests approval for line. Only root can
+// read the config, so other users go through sudo; without a channel, the config's
+// default channel is used.
+func shellInvocation(self, channelID, line string, euid int) []string {
+	args := []string{self}
+	if channelID != "" {
+		args = append(args, "--channel", channelID)
+	}
+	args = append(args, "--shell", line)
+	if euid != 0 {
+		args = append([]string{"sudo", "-n"}, args...)
+	}
+	return args
+}
+
+// runShell implements `prompt-sudo-discord shell`: a restricted shell, e.g. the login
+// shell of a break-glass account, in which every command line is approved on its own.
+func runShell(args []string) int {
+	fs := flag.NewFlagSet("shell", flag.ContinueOnError)
+	channelID := fs.String("channel", "", "Discord channel ID or alias to post each command's approval request (default: default_channel from config)")
+	command := fs.String("c", "", "Run this command line instead of reading them from stdin, as with sh -c")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitInternalError
+	}
+
+	// Interrupts are meant for the running command, not the shell
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	defer signal.Stop(sigCh)
+
+	s := &approvalShell{prompt: os.Stderr}
+	s.run = func(line string) int {
+		argv := shellInvocation(self, *channelID, line, os.Geteuid())
+		cmd := exec.Command(argv[0], argv[1:]...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		err := cmd.Run()
+		code, ok := exitCode(err)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitCannotExecute
+		}
+		return code
+	}
+	if *command != "" {
+		return s.run(*command)
+	}
+	return s.serve(os.Stdin)
+}
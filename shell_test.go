@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApprovalShell(t *testing.T) {
+	wd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(wd) })
+	dir := t.TempDir()
+
+	var ran []string
+	var prompt bytes.Buffer
+	s := &approvalShell{prompt: &prompt, run: func(line string) int {
+		ran = append(ran, line)
+		if line == "false" {
+			return 1
+		}
+		return 0
+	}}
+	input := "\n# comment\n  ls -l | head  \ncd " + dir + "\nfalse\nexit\nnever run\n"
+	if code := s.serve(strings.NewReader(input)); code != 1 {
+		t.Errorf("exit code = %d, want the last command's 1", code)
+	}
+	if want := []string{"ls -l | head", "false"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %q, want %q", ran, want)
+	}
+	if got, _ := os.Getwd(); got != dir {
+		t.Errorf("cd changed to %q, want %q", got, dir)
+	}
+	if n := strings.Count(prompt.String(), shellPrompt); n != 6 {
+		t.Errorf("prompted %d times, want 6", n)
+	}
+
+	if code := s.serve(strings.NewReader("cd /nonexistent\n")); code != 1 {
+		t.Errorf("failed cd exit code = %d, want 1", code)
+	}
+}
+
+func TestShellInvocation(t *testing.T) {
+	got := shellInvocation("/usr/local/bin/psd", "123", "ls | wc -l", 0)
+	want := []string{"/usr/local/bin/psd", "--channel", "123", "--shell", "ls | wc -l"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("as root: %q, want %q", got, want)
+	}
+	got = shellInvocation("/usr/local/bin/psd", "123", "id", 1000)
+	if got[0] != "sudo" || got[1] != "-n" || got[2] != "/usr/local/bin/psd" {
+		t.Errorf("as another user: %q, want it to go through sudo", got)
+	}
+	got = shellInvocation("/usr/local/bin/psd", "", "id", 0)
+	if want := []string{"/usr/local/bin/psd", "--shell", "id"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("without a channel: %q, want %q", got, want)
+	}
+}
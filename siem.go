@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SIEM types
+const (
+	siemSplunk        = "splunk"
+	siemElasticsearch = "elasticsearch"
+)
+
+const (
+	defaultSIEMTimeout     = 5
+	defaultSIEMMaxBuffered = 1000
+	defaultSIEMIndex       = "prompt-sudo-discord"
+	defaultSplunkSource    = "prompt-sudo-discord:audit"
+	// siemRetryAttempts bounds the attempts of flush-siem to deliver buffered records
+	siemRetryAttempts  = 3
+	siemRetryBaseDelay = 250 * time.Millisecond
+)
+
+// SIEMConfig forwards audit records to Splunk or Elasticsearch. Records that cannot be
+// delivered are buffered in the state directory until flush-siem sends them.
+type SIEMConfig struct {
+	Type string `json:"type"`
+	// URL is the base URL of the Splunk HTTP Event Collector or of Elasticsearch
+	URL string `json:"url"`
+	// Token is the HEC token for Splunk, or an API key for Elasticsearch
+	Token string `json:"token"`
+	// Index is the Splunk index (default: the token's), or the Elasticsearch index or
+	// data stream (default: prompt-sudo-discord)
+	Index string `json:"index"`
+	// SourceType is the Splunk sourcetype (default: prompt-sudo-discord:audit)
+	SourceType     string `json:"sourcetype"`
+	TimeoutSeconds int    `json:"timeout_seconds"`
+	// MaxBuffered bounds the records kept while the SIEM is unreachable; the oldest
+	// are dropped beyond it
+	MaxBuffered int `json:"max_buffered"`
+}
+
+func validateSIEM(siems []SIEMConfig) error {
+	for i := range siems {
+		s := &siems[i]
+		switch s.Type {
+		case siemSplunk:
+			if s.Token == "" {
+				return fmt.Errorf("siem[%d].token is required for splunk", i)
+			}
+			if s.SourceType == "" {
+				s.SourceType = defaultSplunkSource
+			}
+		case siemElasticsearch:
+			if s.SourceType != "" {
+				return fmt.Errorf("siem[%d].sourcetype is not supported for elasticsearch", i)
+			}
+			if s.Index == "" {
+				s.Index = defaultSIEMIndex
+			}
+		default:
+			return fmt.Errorf("siem[%d].type must be %q or %q", i, siemSplunk, siemElasticsearch)
+		}
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("siem[%d].url must be an http(s) URL", i)
+		}
+		if s.TimeoutSeconds <= 0 {
+			s.TimeoutSeconds = defaultSIEMTimeout
+		}
+		if s.MaxBuffered <= 0 {
+			s.MaxBuffered = defaultSIEMMaxBuffered
+		}
+	}
+	return nil
+}
+
+// bufferFile is the state file buffering the records not yet delivered to the SIEM.
+func (s *SIEMConfig) bufferFile() string {
+	sum := sha256.Sum256([]byte(s.Type + " " + s.URL + " " + s.Index))
+	return "siem-" + hex.EncodeToString(sum[:6]) + ".json"
+}
+
+// forward sends rec to the SIEM in a single attempt, which the request waits for, and
+// buffers it if that fails. Buffered records are left to flush-siem.
+func (s *SIEMConfig) forward(stateDir string, rec AuditRecord) error {
+	_, _, sendErr := s.post([]AuditRecord{rec})
+	if sendErr == nil {
+		return nil
+	}
+	n, err := s.buffer(stateDir, nil, nil, []AuditRecord{rec})
+	if err != nil {
+		return errors.Join(sendErr, err)
+	}
+	return fmt.Errorf("%d records buffered: %w", n, sendErr)
+}
+
+// buffer takes the flushed records out of the buffer, puts those still unsent back at
+// its start and appends records, keeping the last MaxBuffered. It returns how many
+// records are buffered.
+func (s *SIEMConfig) buffer(stateDir string, flushed, unsent, records []AuditRecord) (int, error) {
+	done := map[siemRecordKey]bool{}
+	for _, rec := range flushed {
+		done[siemKeyOf(rec)] = true
+	}
+	var buffered []AuditRecord
+	err := updateStateFile(stateDir, s.bufferFile(), &buffered, func() bool {
+		if len(flushed) == 0 && len(records) == 0 {
+			return false
+		}
+		// Records appended meanwhile stay; those flushed may have been dropped already
+		kept := slices.DeleteFunc(buffered, func(rec AuditRecord) bool { return done[siemKeyOf(rec)] })
+		pending := slices.Concat(unsent, kept, records)
+		if dropped := len(pending) - s.MaxBuffered; dropped > 0 {
+			slog.Warn("SIEM buffer is full, dropping the oldest records", "url", s.URL, "dropped", dropped)
+			pending = pending[dropped:]
+		}
+		buffered = pending
+		return true
+	})
+	return len(buffered), err
+}
+
+// flush sends the buffered records, retrying transient failures, and returns how many
+// were delivered. The buffer is only locked to read and update it, so requests are not
+// held up by the SIEM; concurrent flushes wait for each other instead.
+func (s *SIEMConfig) flush(stateDir string) (int, error) {
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return 0, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	lock, err := os.OpenFile(filepath.Join(stateDir, s.bufferFile()+".flush.lock"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return 0, err
+	}
+
+	var pending []AuditRecord
+	if err := updateStateFile(stateDir, s.bufferFile(), &pending, func() bool { return false }); err != nil {
+		return 0, err
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+	unsent, sendErr := s.deliver(pending)
+	if _, err := s.buffer(stateDir, pending, unsent, nil); err != nil {
+		return 0, errors.Join(sendErr, err)
+	}
+	return len(pending) - len(unsent), sendErr
+}
+
+// siemRecordKey identifies a buffered record.
+type siemRecordKey struct {
+	time      int64
+	requestID string
+	event     string
+	seq       uint64
+}
+
+func siemKeyOf(rec AuditRecord) siemRecordKey {
+	return siemRecordKey{rec.Time.UnixNano(), rec.RequestID, rec.Event, rec.Seq}
+}
+
+// deliver posts records, retrying transient failures with backoff, and returns the
+// ones still not delivered.
+func (s *SIEMConfig) deliver(records []AuditRecord) ([]AuditRecord, error) {
+	var err error
+	var transient bool
+	for attempt := 0; ; attempt++ {
+		records, transient, err = s.post(records)
+		if err == nil || !transient || attempt+1 >= siemRetryAttempts {
+			return records, err
+		}
+		backoff := siemRetryBaseDelay << attempt
+		time.Sleep(backoff/2 + jitter(backoff/2))
+	}
+}
+
+// post sends records in one batch, and returns those the SIEM did not accept, and
+// whether trying again may help.
+func (s *SIEMConfig) post(records []AuditRecord) ([]AuditRecord, bool, error) {
+	var body []byte
+	var err error
+	var endpoint, auth, contentType string
+	switch s.Type {
+	case siemSplunk:
+		body, err = formatSplunk(records, s.Index, s.SourceType)
+		endpoint, auth, contentType = "/services/collector/event", "Splunk "+s.Token, "application/json"
+	case siemElasticsearch:
+		body, err = formatElasticBulk(records, s.Index)
+		endpoint, contentType = "/_bulk", "application/x-ndjson"
+		if s.Token != "" {
+			auth = "ApiKey " + s.Token
+		}
+	}
+	if err != nil {
+		return records, false, err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(s.URL, "/")+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return records, false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	client := &http.Client{Timeout: time.Duration(s.TimeoutSeconds) * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return records, true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return records, transientStatus(resp.StatusCode), fmt.Errorf("%s returned %s", s.Type, resp.Status)
+	}
+	if s.Type == siemElasticsearch {
+		return rejectedBulkItems(resp.Body, records)
+	}
+	return nil, false, nil
+}
+
+// transientStatus reports whether a request failing with an HTTP status may succeed
+// when tried again.
+func transientStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// splunkEvent is an event of the Splunk HTTP Event Collector.
+type splunkEvent struct {
+	Time       float64     `json:"time"`
+	Host       string      `json:"host"`
+	Source     string      `json:"source"`
+	SourceType string      `json:"sourcetype"`
+	Index      string      `json:"index,omitempty"`
+	Event      AuditRecord `json:"event"`
+}
+
+// formatSplunk renders records as HEC events, which are sent concatenated.
+func formatSplunk(records []AuditRecord, index, sourceType string) ([]byte, error) {
+	var b bytes.Buffer
+	for _, rec := range records {
+		data, err := json.Marshal(splunkEvent{
+			Time:       float64(rec.Time.UnixMilli()) / 1000,
+			Host:       rec.Host,
+			Source:     syslogAppName,
+			SourceType: sourceType,
+			Index:      index,
+			Event:      rec,
+		})
+		if err != nil {
+			return nil, err
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	return b.Bytes(), nil
+}
+
+// elasticDocument is an audit record as indexed in Elasticsearch.
+type elasticDocument struct {
+	Timestamp time.Time `json:"@timestamp"`
+	AuditRecord
+}
+
+// formatElasticBulk renders records as a bulk request creating a document each, which
+// also works for data streams.
+func formatElasticBulk(records []AuditRecord, index string) ([]byte, error) {
+	action, err := json.Marshal(map[string]any{"create": map[string]string{"_index": index}})
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	for _, rec := range records {
+		data, err := json.Marshal(elasticDocument{Timestamp: rec.Time, AuditRecord: rec})
+		if err != nil {
+			return nil, err
+		}
+		b.Write(action)
+		b.WriteByte('\n')
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	return b.Bytes(), nil
+}
+
+// rejectedBulkItems returns the records whose items of a bulk response failed, and
+// whether any failed transiently; a bulk request succeeds as a whole even when some of
+// its documents are rejected.
+func rejectedBulkItems(r io.Reader, records []AuditRecord) ([]AuditRecord, bool, error) {
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  *struct {
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(r).Decode(&result); err != nil {
+		return records, false, fmt.Errorf("invalid bulk response: %w", err)
+	}
+	if !result.Errors {
+		return nil, false, nil
+	}
+	var rejected []AuditRecord
+	var transient bool
+	var reason string
+	for i, item := range result.Items {
+		for _, op := range item {
+			if op.Error == nil || i >= len(records) {
+				continue
+			}
+			rejected = append(rejected, records[i])
+			transient = transient || transientStatus(op.Status)
+			if reason == "" {
+				reason = fmt.Sprintf("%d %s", op.Status, op.Error.Reason)
+			}
+		}
+	}
+	return rejected, transient, fmt.Errorf("elasticsearch rejected %d records: %s", len(rejected), reason)
+}
+
+// runFlushSIEM implements `prompt-sudo-discord flush-siem`, e.g. from a timer: requests
+// only try to forward their own record once, so what was buffered is delivered here.
+func runFlushSIEM(args []string) int {
+	fs := flag.NewFlagSet("flush-siem", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: prompt-sudo-discord flush-siem")
+		fs.PrintDefaults()
+	}
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	config, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfigError
+	}
+	status := 0
+	for i := range config.SIEM {
+		s := &config.SIEM[i]
+		n, err := s.flush(config.StateDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %d records delivered: %v\n", s.URL, n, err)
+			status = 1
+			continue
+		}
+		fmt.Printf("%s: %d records delivered\n", s.URL, n)
+	}
+	return status
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestValidateSIEM(t *testing.T) {
+	siems := []SIEMConfig{
+		{Type: siemSplunk, URL: "https://splunk.example:8088", Token: "hec"},
+		{Type: siemElasticsearch, URL: "https://es.example:9200"},
+	}
+	if err := validateSIEM(siems); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if siems[0].SourceType != defaultSplunkSource || siems[1].Index != defaultSIEMIndex {
+		t.Errorf("defaults not applied: %+v", siems)
+	}
+	if siems[0].TimeoutSeconds != defaultSIEMTimeout || siems[1].MaxBuffered != defaultSIEMMaxBuffered {
+		t.Errorf("defaults not applied: %+v", siems)
+	}
+	for name, s := range map[string]SIEMConfig{
+		"unknown type":            {Type: "graylog", URL: "https://siem.example"},
+		"splunk without token":    {Type: siemSplunk, URL: "https://splunk.example"},
+		"not http":                {Type: siemElasticsearch, URL: "tcp://es.example"},
+		"elastic with sourcetype": {Type: siemElasticsearch, URL: "https://es.example", SourceType: "psd"},
+	} {
+		if err := validateSIEM([]SIEMConfig{s}); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestSplunkForward(t *testing.T) {
+	events := make(chan splunkEvent, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/services/collector/event" || r.Header.Get("Authorization") != "Splunk hec" {
+			t.Errorf("unexpected request %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		dec := json.NewDecoder(r.Body)
+		for dec.More() {
+			var e splunkEvent
+			if err := dec.Decode(&e); err != nil {
+				t.Errorf("invalid event: %v", err)
+				return
+			}
+			events <- e
+		}
+	}))
+	defer srv.Close()
+
+	siems := []SIEMConfig{{Type: siemSplunk, URL: srv.URL + "/", Token: "hec", Index: "security"}}
+	if err := validateSIEM(siems); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 500_000_000)
+	rec := AuditRecord{Time: now, Event: auditEventDecision, RequestID: "abc", Host: "web1", Decision: auditDecisionDenied}
+	if err := siems[0].forward(t.TempDir(), rec); err != nil {
+		t.Fatal(err)
+	}
+	e := <-events
+	if e.Time != 1700000000.5 || e.Host != "web1" || e.Index != "security" || e.SourceType != defaultSplunkSource {
+		t.Errorf("unexpected event metadata: %+v", e)
+	}
+	if e.Event.RequestID != "abc" || e.Event.Decision != auditDecisionDenied {
+		t.Errorf("unexpected event: %+v", e.Event)
+	}
+}
+
+func TestElasticsearchBuffering(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	var attempts atomic.Int32
+	var indexed []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		if down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path != "/_bulk" || r.Header.Get("Authorization") != "ApiKey key" {
+			t.Errorf("unexpected request %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		var items []string
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			var action map[string]map[string]string
+			if err := json.Unmarshal(sc.Bytes(), &action); err != nil || action["create"]["_index"] != "audit" {
+				t.Errorf("unexpected action %s", sc.Text())
+			}
+			sc.Scan()
+			var doc map[string]any
+			if err := json.Unmarshal(sc.Bytes(), &doc); err != nil || doc["@timestamp"] == nil {
+				t.Errorf("unexpected document %s", sc.Text())
+			}
+			id := doc["request_id"].(string)
+			indexed = append(indexed, id)
+			// A mapping error rejects a single document
+			if id == "bad" {
+				items = append(items, `{"create":{"status":400,"error":{"reason":"mapping"}}}`)
+			} else {
+				items = append(items, `{"create":{"status":201}}`)
+			}
+		}
+		errors := strings.Contains(strings.Join(items, ""), "error")
+		json.NewEncoder(w).Encode(map[string]any{"errors": errors, "items": json.RawMessage("[" + strings.Join(items, ",") + "]")})
+	}))
+	defer srv.Close()
+
+	siems := []SIEMConfig{{Type: siemElasticsearch, URL: srv.URL, Token: "key", Index: "audit", MaxBuffered: 2}}
+	if err := validateSIEM(siems); err != nil {
+		t.Fatal(err)
+	}
+	s, dir := &siems[0], t.TempDir()
+	for _, id := range []string{"dropped", "first", "second"} {
+		if err := s.forward(dir, AuditRecord{Time: time.Now(), RequestID: id}); err == nil {
+			t.Fatalf("%s: expected error while the SIEM is down", id)
+		}
+	}
+	// Requests try once, without retrying
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("%d attempts for 3 records, want 3", n)
+	}
+
+	// Requests only send their own record; the buffer is left to flush
+	down.Store(false)
+	if err := s.forward(dir, AuditRecord{Time: time.Now(), RequestID: "now"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.flush(dir); err != nil || n != 2 {
+		t.Fatalf("flush = %d, %v, want 2 records delivered", n, err)
+	}
+	if got := strings.Join(indexed, ","); got != "now,first,second" {
+		t.Errorf("indexed %s, want now,first,second", got)
+	}
+
+	// A rejected record stays buffered, and is not retried within a flush
+	indexed = nil
+	if err := s.forward(dir, AuditRecord{Time: time.Now(), RequestID: "bad"}); err == nil {
+		t.Fatal("expected the rejected record to be reported")
+	}
+	if n, err := s.flush(dir); err == nil || n != 0 {
+		t.Fatalf("flush = %d, %v, want the rejected record reported again", n, err)
+	}
+	if got := strings.Join(indexed, ","); got != "bad,bad" {
+		t.Errorf("indexed %s, want bad,bad", got)
+	}
+	if n, err := s.flush(dir); err == nil || n != 0 {
+		t.Errorf("flush = %d, %v, want the record still buffered", n, err)
+	}
+}
+
+func TestAuditorRedactsSIEM(t *testing.T) {
+	events := make(chan splunkEvent, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e splunkEvent
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("invalid event: %v", err)
+		}
+		events <- e
+	}))
+	defer srv.Close()
+
+	siems := []SIEMConfig{{Type: siemSplunk, URL: srv.URL, Token: "hec"}}
+	if err := validateSIEM(siems); err != nil {
+		t.Fatal(err)
+	}
+	redactions, _ := compileRedactions(nil)
+	dir := t.TempDir()
+	a := &auditor{path: dir + "/audit.jsonl", siem: siems, stateDir: dir, redactions: redactions}
+	rec := AuditRecord{Event: auditEventDecision, RequestID: "abc", Command: []string{"mysql", "--password", "hunter2"}, Decision: auditDecisionApproved}
+	if err := a.write(rec); err != nil {
+		t.Fatal(err)
+	}
+	e := <-events
+	if e.Event.Command != nil || e.Event.CommandLine != "mysql --password ****" {
+		t.Errorf("forwarded command = %q, %q, want it redacted", e.Event.Command, e.Event.CommandLine)
+	}
+}
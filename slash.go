@@ -0,0 +1,126 @@
+package main
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+const slashCommandName = "psd"
+
+// Slash command subcommands
+const (
+	slashApprove = "approve"
+	slashDeny    = "deny"
+	slashCancel  = "cancel"
+	// slashPreapprove is answered by `prompt-sudo-discord preapprove`, not by a request
+	slashPreapprove = "preapprove"
+)
+
+// cancelID identifies `/psd cancel`, which has no button: approvers withdraw a request,
+// e.g. one from a runaway automation, rather than deny it.
+const cancelID = "psd_cancel"
+
+// slashButtons maps slash subcommands to the button they stand in for.
+var slashButtons = map[string]string{
+	slashApprove: buttonApproveID,
+	slashDeny:    buttonDenyID,
+	slashCancel:  cancelID,
+}
+
+// slashCommand is the `/psd` application command, a fallback for clients (or bridges)
+// where buttons misbehave.
+var slashCommand = &discordgo.ApplicationCommand{
+	Name:        slashCommandName,
+	Description: "Decide a pending sudo request",
+	Options: []*discordgo.ApplicationCommandOption{
+		requestIDSubcommand(slashApprove, "Approve a pending sudo request"),
+		requestIDSubcommand(slashDeny, "Deny a pending sudo request"),
+		requestIDSubcommand(slashCancel, "Withdraw a pending sudo request, e.g. from a runaway automation"),
+		preapproveSubcommand,
+	},
+}
+
+// preapproveSubcommand issues a one-time token approving a later command ahead of time.
+var preapproveSubcommand = &discordgo.ApplicationCommandOption{
+	Type:        discordgo.ApplicationCommandOptionSubCommand,
+	Name:        slashPreapprove,
+	Description: "Approve one later run of a command ahead of time, for a host listening with `preapprove`",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "pattern",
+			Description: "Glob pattern of the command, as in policies",
+			Required:    true,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "duration",
+			Description: "How long the token stays valid, e.g. 4h or 2d",
+			Required:    true,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "host",
+			Description: "Host to pre-approve on, if several are listening",
+		},
+	},
+}
+
+func requestIDSubcommand(name, description string) *discordgo.ApplicationCommandOption {
+	return &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionSubCommand,
+		Name:        name,
+		Description: description,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "request-id",
+				Description: "Request ID shown at the bottom of the request message",
+				Required:    true,
+			},
+		},
+	}
+}
+
+// ensureSlashCommand registers the `/psd` command unless a registration with the same
+// subcommands exists. Registering on every request would quickly exhaust Discord's
+// daily command creation limit.
+func ensureSlashCommand(dg *discordgo.Session) error {
+	appID := dg.State.User.ID
+	existing, err := dg.ApplicationCommands(appID, "")
+	if err != nil {
+		return err
+	}
+	for _, cmd := range existing {
+		if cmd.Name == slashCommand.Name && sameSubcommands(cmd, slashCommand) {
+			return nil
+		}
+	}
+	_, err = dg.ApplicationCommandCreate(appID, "", slashCommand)
+	return err
+}
+
+func sameSubcommands(a, b *discordgo.ApplicationCommand) bool {
+	if len(a.Options) != len(b.Options) {
+		return false
+	}
+	for i := range a.Options {
+		if a.Options[i].Name != b.Options[i].Name {
+			return false
+		}
+	}
+	return true
+}
+
+// parseSlashCommand extracts the subcommand and request ID from a `/psd` invocation.
+func parseSlashCommand(data discordgo.ApplicationCommandInteractionData) (action, requestID string, ok bool) {
+	if data.Name != slashCommandName || len(data.Options) != 1 {
+		return "", "", false
+	}
+	sub := data.Options[0]
+	for _, opt := range sub.Options {
+		if opt.Name == "request-id" {
+			return sub.Name, opt.StringValue(), true
+		}
+	}
+	return "", "", false
+}
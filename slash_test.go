@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestParseSlashCommand(t *testing.T) {
+	data := discordgo.ApplicationCommandInteractionData{
+		Name: slashCommandName,
+		Options: []*discordgo.ApplicationCommandInteractionDataOption{
+			{
+				Name: slashApprove,
+				Type: discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandInteractionDataOption{
+					{Name: "request-id", Type: discordgo.ApplicationCommandOptionString, Value: "abc123"},
+				},
+			},
+		},
+	}
+	action, id, ok := parseSlashCommand(data)
+	if !ok || action != slashApprove || id != "abc123" {
+		t.Errorf("parseSlashCommand = %q, %q, %v", action, id, ok)
+	}
+
+	data.Name = "other"
+	if _, _, ok := parseSlashCommand(data); ok {
+		t.Error("expected other commands to be ignored")
+	}
+}
+
+func TestSlashButtons(t *testing.T) {
+	for _, opt := range slashCommand.Options {
+		if _, ok := slashButtons[opt.Name]; !ok && opt.Name != slashPreapprove {
+			t.Errorf("subcommand %q has no button mapping", opt.Name)
+		}
+	}
+}
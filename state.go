@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const defaultStateDir = "/var/lib/prompt-sudo-discord"
+
+// updateStateFile loads the JSON state file name from stateDir into v under an
+// exclusive lock, runs fn, and saves v atomically if fn reports a modification.
+// A missing file leaves v untouched.
+func updateStateFile(stateDir, name string, v any, fn func() bool) error {
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	path := filepath.Join(stateDir, name)
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s lock: %w", name, err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock %s: %w", name, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+	}
+
+	if !fn() {
+		return nil
+	}
+
+	data, err = json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
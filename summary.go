@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	summaryColorSuccess = 0x2ecc71
+	summaryColorFailure = 0xe74c3c
+)
+
+// execStats collects figures about a command run as a child process. It counts
+// the output written to it.
+type execStats struct {
+	started time.Time
+	// outputBytes counts stdout and stderr, if counted is set
+	outputBytes atomic.Int64
+	counted     bool
+	// maxRSSKB is the peak resident set size of the command, in kilobytes
+	maxRSSKB int64
+}
+
+func (s *execStats) Write(p []byte) (int, error) {
+	s.outputBytes.Add(int64(len(p)))
+	return len(p), nil
+}
+
+// collect records the resource usage of the exited command.
+func (s *execStats) collect(state *os.ProcessState) {
+	if s == nil || state == nil {
+		return
+	}
+	if ru, ok := state.SysUsage().(*syscall.Rusage); ok {
+		s.maxRSSKB = int64(ru.Maxrss)
+	}
+}
+
+// formatApprover renders an approver ID: Discord users are mentioned, and other
+// approvers (such as fallback ones) are shown as is.
+func formatApprover(id string) string {
+	if _, err := strconv.ParseUint(id, 10, 64); err == nil {
+		return fmt.Sprintf("<@%s>", id)
+	}
+	return fmt.Sprintf("`%s`", id)
+}
+
+// formatBytes renders a byte count with a binary unit.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// summaryEmbed renders the post-execution summary of a command.
+func summaryEmbed(stats *execStats, exitCode int, duration time.Duration, decision AuditRecord) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title: "✅ Command finished",
+		Color: summaryColorSuccess,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Exit code", Value: fmt.Sprintf("`%d`", exitCode), Inline: true},
+			{Name: "Duration", Value: duration.Round(time.Millisecond).String(), Inline: true},
+		},
+		Footer: &discordgo.MessageEmbedFooter{Text: "Request ID: " + decision.RequestID},
+	}
+	if exitCode != 0 {
+		embed.Title = "❌ Command failed"
+		embed.Color = summaryColorFailure
+	}
+	if stats.counted {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Output", Value: formatBytes(stats.outputBytes.Load()), Inline: true})
+	}
+	if stats.maxRSSKB > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Peak RSS", Value: formatBytes(stats.maxRSSKB * 1024), Inline: true})
+	}
+	authorized := "—"
+	switch {
+	case len(decision.ApproverIDs) > 0:
+		var approvers []string
+		for _, id := range decision.ApproverIDs {
+			approvers = append(approvers, formatApprover(id))
+		}
+		authorized = strings.Join(approvers, ", ")
+	case decision.Decision == auditDecisionAutoApproved:
+		authorized = fmt.Sprintf("policy `%s`", decision.Policy)
+	case decision.Decision == auditDecisionCached:
+		authorized = "earlier approval (grace window)"
+	case decision.Decision == auditDecisionBreakGlass:
+		authorized = "🚨 break-glass, no approver"
+	}
+	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Authorized by", Value: authorized, Inline: true})
+	return embed
+}
+
+// postSummary replies to the request message with the post-execution summary.
+func postSummary(dg *discordgo.Session, channelID, messageID string, embed *discordgo.MessageEmbed) error {
+	_, err := sendMessage(dg, channelID, &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{embed},
+		Reference: &discordgo.MessageReference{
+			MessageID: messageID,
+			ChannelID: channelID,
+		},
+		// Approvers are listed, not pinged
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	return err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+		3 << 30:         "3.0 GiB",
+	}
+	for n, want := range tests {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestFormatApprover(t *testing.T) {
+	if got := formatApprover("123456789012345678"); got != "<@123456789012345678>" {
+		t.Errorf("formatApprover(discord ID) = %q", got)
+	}
+	if got := formatApprover("email:ops@example.com"); got != "`email:ops@example.com`" {
+		t.Errorf("formatApprover(fallback approver) = %q", got)
+	}
+}
+
+func TestSummaryEmbed(t *testing.T) {
+	stats := &execStats{maxRSSKB: 2048, counted: true}
+	stats.Write(make([]byte, 3000))
+	embed := summaryEmbed(stats, 2, 1500*time.Millisecond, AuditRecord{
+		RequestID:   "abc",
+		Decision:    auditDecisionApproved,
+		ApproverIDs: []string{"42"},
+	})
+	if !strings.Contains(embed.Title, "failed") || embed.Color != summaryColorFailure {
+		t.Errorf("title = %q, color = %x, want a failure", embed.Title, embed.Color)
+	}
+	fields := map[string]string{}
+	for _, f := range embed.Fields {
+		fields[f.Name] = f.Value
+	}
+	want := map[string]string{
+		"Exit code":     "`2`",
+		"Duration":      "1.5s",
+		"Output":        "2.9 KiB",
+		"Peak RSS":      "2.0 MiB",
+		"Authorized by": "<@42>",
+	}
+	for name, value := range want {
+		if fields[name] != value {
+			t.Errorf("field %s = %q, want %q", name, fields[name], value)
+		}
+	}
+
+	embed = summaryEmbed(&execStats{}, 0, time.Second, AuditRecord{Decision: auditDecisionAutoApproved, Policy: "reads"})
+	for _, f := range embed.Fields {
+		switch f.Name {
+		case "Output", "Peak RSS":
+			t.Errorf("unexpected field %s without figures", f.Name)
+		case "Authorized by":
+			if f.Value != "policy `reads`" {
+				t.Errorf("Authorized by = %q, want the policy", f.Value)
+			}
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Audit sink types
+const (
+	auditSinkSyslog   = "syslog"
+	auditSinkJournald = "journald"
+)
+
+const (
+	defaultSyslogAddress  = "/dev/log"
+	defaultJournalAddress = "/run/systemd/journal/socket"
+
+	syslogAppName = "prompt-sudo-discord"
+	// syslogFacilityAuthpriv and syslogSeverityInfo form the PRI of every message
+	syslogFacilityAuthpriv = 10
+	syslogSeverityInfo     = 6
+	// syslogSDID is the structured data ID (32473 is the documentation enterprise number)
+	syslogSDID = "psd@32473"
+)
+
+// AuditSinkConfig mirrors audit records to syslog or journald, in addition to the audit log file.
+type AuditSinkConfig struct {
+	Type string `json:"type"`
+	// Network is the syslog transport: "unixgram" (default), "udp" or "tcp"
+	Network string `json:"network"`
+	// Address is the syslog socket or host:port, or the journald socket
+	Address string `json:"address"`
+	// Format is the content of syslog messages: "rfc5424" structured data (default),
+	// "cef" or "leef"
+	Format string `json:"format"`
+}
+
+func validateAuditSink(s *AuditSinkConfig) error {
+	switch s.Type {
+	case auditSinkSyslog:
+		if s.Network == "" {
+			s.Network = "unixgram"
+		}
+		if s.Address == "" {
+			s.Address = defaultSyslogAddress
+		}
+		switch s.Network {
+		case "unixgram", "udp", "tcp":
+		default:
+			return fmt.Errorf("audit_sink.network must be unixgram, udp or tcp")
+		}
+		if s.Format == "" {
+			s.Format = auditFormatRFC5424
+		}
+		switch s.Format {
+		case auditFormatRFC5424, auditFormatCEF, auditFormatLEEF:
+		default:
+			return fmt.Errorf("audit_sink.format must be %s, %s or %s", auditFormatRFC5424, auditFormatCEF, auditFormatLEEF)
+		}
+	case auditSinkJournald:
+		if s.Network != "" {
+			return fmt.Errorf("audit_sink.network is not supported for journald")
+		}
+		if s.Format != "" {
+			return fmt.Errorf("audit_sink.format is not supported for journald")
+		}
+		if s.Address == "" {
+			s.Address = defaultJournalAddress
+		}
+	default:
+		return fmt.Errorf("audit_sink.type must be %q or %q", auditSinkSyslog, auditSinkJournald)
+	}
+	return nil
+}
+
+// auditField is a structured field of an audit record, in a stable order.
+type auditField struct {
+	key, value string
+}
+
+// auditFields lists the fields of rec, a record redacted for mirroring.
+func auditFields(rec AuditRecord) []auditField {
+	fields := []auditField{
+		{"event", rec.Event},
+		{"request_id", rec.RequestID},
+	}
+	add := func(key, value string) {
+		if value != "" {
+			fields = append(fields, auditField{key, value})
+		}
+	}
+	add("command", rec.CommandLine)
+	add("command_sha256", rec.CommandSHA256)
+	add("executable", rec.Executable)
+	add("executable_sha256", rec.ExecutableSHA256)
+	add("run_as", rec.RunAs)
+	add("session", rec.Session)
+	add("requester", rec.Requester)
+	add("sudo_user", rec.SudoUser)
+	add("requester_uid", strconv.Itoa(rec.RequesterUID))
+	add("machine_id", rec.MachineID)
+	add("cwd", rec.CWD)
+	add("policy", rec.Policy)
+	add("maintenance_window", rec.MaintenanceWindow)
+	add("decision", rec.Decision)
+	add("approver_ids", strings.Join(rec.ApproverIDs, ","))
+	if rec.ExitCode != nil {
+		add("exit_code", strconv.Itoa(*rec.ExitCode))
+	}
+	return fields
+}
+
+// auditSummary is the human-readable message of a mirrored audit record, with its
+// redacted command.
+func auditSummary(rec AuditRecord) string {
+	if rec.Event == auditEventExit && rec.ExitCode != nil {
+		return fmt.Sprintf("request %s exited with code %d: %s", rec.RequestID, *rec.ExitCode, rec.CommandLine)
+	}
+	return fmt.Sprintf("request %s %s: %s", rec.RequestID, rec.Decision, rec.CommandLine)
+}
+
+// send mirrors rec to the configured sink.
+func (s *AuditSinkConfig) send(rec AuditRecord) error {
+	var network string
+	var msg []byte
+	switch s.Type {
+	case auditSinkSyslog:
+		network = s.Network
+		if s.Format == auditFormatRFC5424 {
+			msg = formatSyslog(rec, time.Now())
+		} else {
+			msg = formatSyslogEvent(rec, s.Format, time.Now())
+		}
+		if network == "tcp" {
+			// RFC 6587 octet counting
+			msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+		}
+	case auditSinkJournald:
+		network = "unixgram"
+		msg = formatJournal(rec)
+	}
+	conn, err := net.DialTimeout(network, s.Address, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", s.Type, err)
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(msg); err != nil {
+		return fmt.Errorf("failed to write to %s: %w", s.Type, err)
+	}
+	return nil
+}
+
+// formatSyslog renders rec as an RFC 5424 message with the record's fields as structured data.
+func formatSyslog(rec AuditRecord, now time.Time) []byte {
+	var b bytes.Buffer
+	writeSyslogHeader(&b, rec, now)
+	fmt.Fprintf(&b, "[%s", syslogSDID)
+	for _, f := range auditFields(rec) {
+		fmt.Fprintf(&b, ` %s="%s"`, f.key, syslogParamEscaper.Replace(f.value))
+	}
+	b.WriteString("] ")
+	b.WriteString(auditSummary(rec))
+	return b.Bytes()
+}
+
+// writeSyslogHeader writes the RFC 5424 header of rec's message, up to the structured data.
+func writeSyslogHeader(b *bytes.Buffer, rec AuditRecord, now time.Time) {
+	hostname := rec.Host
+	if hostname == "" {
+		hostname = "-"
+	}
+	fmt.Fprintf(b, "<%d>1 %s %s %s %d %s ",
+		syslogFacilityAuthpriv*8+syslogSeverityInfo,
+		now.UTC().Format(time.RFC3339Nano),
+		hostname, syslogAppName, os.Getpid(), rec.Event)
+}
+
+// syslogParamEscaper escapes structured data parameter values (RFC 5424 section 6.3.3).
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// formatJournal renders rec in the journald native protocol, with fields prefixed PSD_.
+func formatJournal(rec AuditRecord) []byte {
+	var b bytes.Buffer
+	writeField := func(key, value string) {
+		if !strings.Contains(value, "\n") {
+			fmt.Fprintf(&b, "%s=%s\n", key, value)
+			return
+		}
+		// Values containing newlines are length-prefixed
+		b.WriteString(key)
+		b.WriteByte('\n')
+		binary.Write(&b, binary.LittleEndian, uint64(len(value)))
+		b.WriteString(value)
+		b.WriteByte('\n')
+	}
+	writeField("MESSAGE", auditSummary(rec))
+	writeField("PRIORITY", strconv.Itoa(syslogSeverityInfo))
+	writeField("SYSLOG_FACILITY", strconv.Itoa(syslogFacilityAuthpriv))
+	writeField("SYSLOG_IDENTIFIER", syslogAppName)
+	for _, f := range auditFields(rec) {
+		writeField("PSD_"+strings.ToUpper(f.key), f.value)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateAuditSink(t *testing.T) {
+	s := &AuditSinkConfig{Type: auditSinkSyslog}
+	if err := validateAuditSink(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Network != "unixgram" || s.Address != defaultSyslogAddress {
+		t.Errorf("defaults = %q %q", s.Network, s.Address)
+	}
+	if err := validateAuditSink(&AuditSinkConfig{Type: "kafka"}); err == nil {
+		t.Error("expected error for unknown type")
+	}
+	if err := validateAuditSink(&AuditSinkConfig{Type: auditSinkSyslog, Network: "sctp"}); err == nil {
+		t.Error("expected error for unknown network")
+	}
+	if err := validateAuditSink(&AuditSinkConfig{Type: auditSinkJournald, Network: "udp"}); err == nil {
+		t.Error("expected error for journald network")
+	}
+}
+
+func TestFormatSyslog(t *testing.T) {
+	rec := AuditRecord{
+		Event:       auditEventDecision,
+		RequestID:   "abc",
+		Command:     []string{"echo", `a"]\b`},
+		Host:        "web1",
+		Decision:    auditDecisionApproved,
+		ApproverIDs: []string{"1", "2"},
+	}.redacted(nil)
+	msg := string(formatSyslog(rec, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)))
+	if !strings.HasPrefix(msg, "<86>1 2024-01-02T03:04:05Z web1 prompt-sudo-discord ") {
+		t.Errorf("unexpected header: %s", msg)
+	}
+	for _, want := range []string{
+		` decision [psd@32473 event="decision" request_id="abc"`,
+		`command="echo 'a\"\]\\b'"`,
+		`approver_ids="1,2"]`,
+		"] request abc approved: echo ",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message %s does not contain %s", msg, want)
+		}
+	}
+}
+
+func TestFormatJournal(t *testing.T) {
+	code := 2
+	rec := AuditRecord{Event: auditEventExit, RequestID: "abc", Command: []string{"printf", "a\nb"}, CWD: "/tmp/a\nb", ExitCode: &code}.redacted(nil)
+	msg := formatJournal(rec)
+	if !bytes.Contains(msg, []byte("PSD_EXIT_CODE=2\n")) || !bytes.Contains(msg, []byte("SYSLOG_IDENTIFIER=prompt-sudo-discord\n")) {
+		t.Errorf("unexpected journal message: %q", msg)
+	}
+	// The directory contains a newline and must use the length-prefixed form
+	if !bytes.Contains(msg, []byte("PSD_CWD\n")) {
+		t.Errorf("expected binary PSD_CWD field: %q", msg)
+	}
+	// The command is quoted in the summary, which stays on one line
+	if !bytes.Contains(msg, []byte("MESSAGE=request abc exited with code 2: printf $'a\\nb'\n")) {
+		t.Errorf("expected quoted command in MESSAGE: %q", msg)
+	}
+}
+
+func TestAuditSinkSend(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	redactions, _ := compileRedactions(nil)
+	a := &auditor{
+		path:       filepath.Join(t.TempDir(), "audit.jsonl"),
+		base:       AuditRecord{RequestID: "abc", Command: []string{"mysql", "--password=hunter2"}},
+		sink:       &AuditSinkConfig{Type: auditSinkJournald, Address: addr},
+		redactions: redactions,
+	}
+	if err := a.decision(auditDecisionDenied, []string{"123"}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf[:n], []byte("PSD_DECISION=denied\n")) {
+		t.Errorf("unexpected datagram: %q", buf[:n])
+	}
+	if !bytes.Contains(buf[:n], []byte("PSD_COMMAND=mysql --password=****\n")) || bytes.Contains(buf[:n], []byte("hunter2")) {
+		t.Errorf("command not redacted: %q", buf[:n])
+	}
+}
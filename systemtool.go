@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// systemToolPath is where tools run to describe a request are looked up, like sudo's
+// secure_path; the caller's PATH and argv[0] are never used for them.
+const systemToolPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// systemTool resolves name in systemToolPath. The tool runs before anyone approved, so
+// it must be one only root can change: it and every directory above it are owned by
+// root and writable by nobody else.
+func systemTool(name string) (string, error) {
+	if name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("%q is not a tool name", name)
+	}
+	for _, dir := range filepath.SplitList(systemToolPath) {
+		path, err := filepath.EvalSymlinks(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Mode()&0o111 == 0 {
+			continue
+		}
+		if err := rootOnly(path); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+	return "", fmt.Errorf("%s not found in %s", name, systemToolPath)
+}
+
+// rootOnly fails unless path and the directories above it are owned by root and not
+// writable by group or others.
+func rootOnly(path string) error {
+	for p := path; ; p = filepath.Dir(p) {
+		info, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok || st.Uid != 0 || info.Mode().Perm()&0o022 != 0 {
+			return fmt.Errorf("%s can be changed by users other than root", p)
+		}
+		if p == filepath.Dir(p) {
+			return nil
+		}
+	}
+}
+
+// requesterAccount is the user who ran sudo, for work done on their behalf before
+// approval. It is nil when there is no one to drop to: not running as root, or root
+// itself asked.
+func requesterAccount() (*runAsIdentity, error) {
+	if os.Geteuid() != 0 {
+		return nil, nil
+	}
+	uid := os.Getenv("SUDO_UID")
+	if uid == "" || uid == "0" {
+		return nil, nil
+	}
+	return resolveRunAs(uid, "")
+}
+
+// requesterCommand prepares the system tool name to run as the requester, in a minimal
+// environment with env added.
+func requesterCommand(ctx context.Context, name string, env []string, args ...string) (*exec.Cmd, error) {
+	path, err := systemTool(name)
+	if err != nil {
+		return nil, err
+	}
+	account, err := requesterAccount()
+	if err != nil {
+		return nil, fmt.Errorf("requester: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Env = append([]string{"PATH=" + systemToolPath, "LC_ALL=C"}, env...)
+	if account != nil {
+		cmd.Env = account.env(cmd.Env)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: account.credential()}
+	} else if home := os.Getenv("HOME"); home != "" {
+		cmd.Env = append(cmd.Env, "HOME="+home)
+	}
+	return cmd, nil
+}
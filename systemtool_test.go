@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSystemTool(t *testing.T) {
+	path, err := systemTool("sh")
+	if err != nil {
+		t.Fatalf("systemTool(sh): %v", err)
+	}
+	if !filepath.IsAbs(path) {
+		t.Errorf("systemTool(sh) = %q, want an absolute path", path)
+	}
+	for _, name := range []string{"", "./sh", "/bin/sh", "no-such-tool-psd"} {
+		if _, err := systemTool(name); err == nil {
+			t.Errorf("systemTool(%q): expected error", name)
+		}
+	}
+	// Temporary directories sit under the world-writable /tmp
+	if err := rootOnly(t.TempDir()); err == nil {
+		t.Error("rootOnly(temp dir): expected error")
+	}
+}
+
+func TestRequesterCommand(t *testing.T) {
+	t.Setenv("SUDO_UID", "")
+	cmd, err := requesterCommand(context.Background(), "sh", []string{"KUBECONFIG=/k"}, "-c", "echo $KUBECONFIG $PATH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/k " + systemToolPath + "\n"; string(out) != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+
+	if os.Geteuid() != 0 {
+		return
+	}
+	if _, err := resolveRunAs("65534", ""); err != nil {
+		t.Skip("no user 65534")
+	}
+	t.Setenv("SUDO_UID", "65534")
+	cmd, err = requesterCommand(context.Background(), "id", nil, "-u")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := cmd.Output(); err != nil || string(out) != "65534\n" {
+		t.Errorf("id -u = %q, %v, want 65534", out, err)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// terraformShowTimeout bounds `terraform show`, which may download providers
+	terraformShowTimeout = 60 * time.Second
+	// terraformPlanAttachment names the file listing the planned resource changes
+	terraformPlanAttachment = "terraform-plan.txt"
+)
+
+// terraformCLIs are the commands whose `apply` is previewed; OpenTofu reads the same plans.
+var terraformCLIs = []string{"terraform", "tofu"}
+
+// terraformOptionsWithValue are the apply options that may take their value as the
+// next argument.
+var terraformOptionsWithValue = []string{"var", "var-file", "target", "replace", "lock-timeout", "parallelism", "state", "state-out", "backup"}
+
+// terraformApply recognizes `terraform apply`. It returns the global options (such as
+// -chdir) and the saved plan the command applies, if any.
+func terraformApply(commandArgs []string) (globals []string, planFile string, ok bool) {
+	isTerraform := false
+	for _, cli := range terraformCLIs {
+		if filepath.Base(commandArgs[0]) == cli {
+			isTerraform = true
+		}
+	}
+	if !isTerraform {
+		return nil, "", false
+	}
+	args := commandArgs[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		globals = append(globals, args[0])
+		args = args[1:]
+	}
+	if len(args) == 0 || args[0] != "apply" {
+		return nil, "", false
+	}
+	var operands []string
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			operands = append(operands, arg)
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		for _, opt := range terraformOptionsWithValue {
+			if name == opt && i+1 < len(args) {
+				i++
+			}
+		}
+	}
+	if len(operands) > 0 {
+		planFile = operands[len(operands)-1]
+	}
+	return globals, planFile, true
+}
+
+// terraformPlan summarizes the resource changes of a saved plan.
+type terraformPlan struct {
+	add, change, destroy int
+	// resources lists each changed resource as in `terraform plan`, e.g. "+ aws_instance.web"
+	resources []string
+}
+
+// terraformActionSymbols are the symbols `terraform plan` marks changes with, by action.
+var terraformActionSymbols = map[string]string{
+	"create":        "+",
+	"update":        "~",
+	"delete":        "-",
+	"delete,create": "-/+",
+	"create,delete": "+/-",
+	"read":          "<=",
+}
+
+// parseTerraformPlan reads the JSON of `terraform show -json PLAN`.
+func parseTerraformPlan(data []byte) (terraformPlan, error) {
+	var raw struct {
+		ResourceChanges []struct {
+			Address string `json:"address"`
+			Change  struct {
+				Actions []string `json:"actions"`
+			} `json:"change"`
+		} `json:"resource_changes"`
+	}
+	var plan terraformPlan
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return plan, fmt.Errorf("failed to parse plan: %w", err)
+	}
+	for _, rc := range raw.ResourceChanges {
+		actions := strings.Join(rc.Change.Actions, ",")
+		symbol, ok := terraformActionSymbols[actions]
+		if !ok {
+			// no-op
+			continue
+		}
+		// Replacements count as an add and a destroy, as terraform counts them
+		switch actions {
+		case "create":
+			plan.add++
+		case "update":
+			plan.change++
+		case "delete":
+			plan.destroy++
+		case "delete,create", "create,delete":
+			plan.add++
+			plan.destroy++
+		}
+		plan.resources = append(plan.resources, symbol+" "+rc.Address)
+	}
+	return plan, nil
+}
+
+// readTerraformPlan renders planFile with the terraform CLI cli, one of terraformCLIs.
+// `show` loads the providers of the working directory, so it runs the system CLI as
+// the user who ran sudo: plans and providers they could not use themselves fail.
+func readTerraformPlan(cli string, globals []string, planFile string) (terraformPlan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), terraformShowTimeout)
+	defer cancel()
+	var env []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "TF_") {
+			env = append(env, kv)
+		}
+	}
+	args := append(append(append([]string{}, globals...), "show", "-json", "-no-color"), planFile)
+	cmd, err := requesterCommand(ctx, cli, env, args...)
+	if err != nil {
+		return terraformPlan{}, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return terraformPlan{}, fmt.Errorf("%s show failed: %w: %s", cli, err, msg)
+		}
+		return terraformPlan{}, fmt.Errorf("%s show failed: %w", cli, err)
+	}
+	return parseTerraformPlan(stdout.Bytes())
+}
+
+// summary renders the counts of the plan as `terraform plan` does.
+func (p terraformPlan) summary() string {
+	if len(p.resources) == 0 {
+		return "No changes."
+	}
+	return fmt.Sprintf("Plan: %d to add, %d to change, %d to destroy.", p.add, p.change, p.destroy)
+}
+
+// attachment renders the plan as the text of the request's plan attachment.
+func (p terraformPlan) attachment(planFile string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Saved plan: %s\n\n", planFile)
+	for _, r := range p.resources {
+		fmt.Fprintf(&b, "  %s\n", r)
+	}
+	if len(p.resources) > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString(p.summary() + "\n")
+	return b.String()
+}
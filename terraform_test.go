@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTerraformApply(t *testing.T) {
+	for name, tc := range map[string]struct {
+		args     []string
+		globals  []string
+		planFile string
+		ok       bool
+	}{
+		"saved plan":   {[]string{"terraform", "apply", "plan.bin"}, nil, "plan.bin", true},
+		"options":      {[]string{"/usr/bin/terraform", "-chdir=infra", "apply", "-auto-approve", "-lock-timeout", "30s", "plan.bin"}, []string{"-chdir=infra"}, "plan.bin", true},
+		"var value":    {[]string{"tofu", "apply", "-var", "env=prod"}, nil, "", true},
+		"without plan": {[]string{"terraform", "apply", "-auto-approve"}, nil, "", true},
+		"plan":         {[]string{"terraform", "plan"}, nil, "", false},
+		"other":        {[]string{"apply", "plan.bin"}, nil, "", false},
+	} {
+		globals, planFile, ok := terraformApply(tc.args)
+		if !reflect.DeepEqual(globals, tc.globals) || planFile != tc.planFile || ok != tc.ok {
+			t.Errorf("%s: terraformApply = %v, %q, %v; want %v, %q, %v", name, globals, planFile, ok, tc.globals, tc.planFile, tc.ok)
+		}
+	}
+}
+
+func TestParseTerraformPlan(t *testing.T) {
+	data := []byte(`{"resource_changes": [
+		{"address": "aws_instance.web", "change": {"actions": ["create"]}},
+		{"address": "aws_security_group.web", "change": {"actions": ["update"]}},
+		{"address": "aws_db_instance.main", "change": {"actions": ["delete", "create"]}},
+		{"address": "aws_s3_bucket.logs", "change": {"actions": ["no-op"]}},
+		{"address": "aws_eip.old", "change": {"actions": ["delete"]}}
+	]}`)
+	plan, err := parseTerraformPlan(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := plan.summary(), "Plan: 2 to add, 1 to change, 2 to destroy."; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+	want := "Saved plan: plan.bin\n\n  + aws_instance.web\n  ~ aws_security_group.web\n  -/+ aws_db_instance.main\n  - aws_eip.old\n\n" +
+		"Plan: 2 to add, 1 to change, 2 to destroy.\n"
+	if got := plan.attachment("plan.bin"); got != want {
+		t.Errorf("attachment = %q, want %q", got, want)
+	}
+
+	empty, err := parseTerraformPlan([]byte(`{"resource_changes": []}`))
+	if err != nil || empty.summary() != "No changes." {
+		t.Errorf("empty plan: summary = %q, err = %v", empty.summary(), err)
+	}
+	if _, err := parseTerraformPlan([]byte("not json")); err == nil {
+		t.Error("expected error for invalid JSON")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a recurring period in which a policy applies as written, e.g.
+// business hours. A window ending before it starts runs over midnight, into the
+// next day.
+type TimeWindow struct {
+	// Days are weekday names or ranges, e.g. ["mon-fri"]; empty means every day
+	Days  []string `json:"days"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+// OutsideWindows is how a policy with time windows applies outside them. Without
+// an action, requests need approval even if the policy auto-approves them.
+type OutsideWindows struct {
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+	// ExtraApprovals adds stages, each needing another approver
+	ExtraApprovals int `json:"extra_approvals"`
+	// ChannelID and ApproverIDs route requests, e.g. to the on-call channel
+	ChannelID   string   `json:"channel_id"`
+	ApproverIDs []string `json:"approver_ids"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseDays returns the set of weekdays named by days; empty means every day.
+func parseDays(days []string) ([7]bool, error) {
+	var set [7]bool
+	if len(days) == 0 {
+		return [7]bool{true, true, true, true, true, true, true}, nil
+	}
+	for _, d := range days {
+		from, to, isRange := strings.Cut(strings.ToLower(d), "-")
+		if !isRange {
+			to = from
+		}
+		first, ok1 := weekdays[from]
+		last, ok2 := weekdays[to]
+		if !ok1 || !ok2 {
+			return set, fmt.Errorf("unknown day %q", d)
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			set[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	return set, nil
+}
+
+// parseClock returns the minutes since midnight of an HH:MM time.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w TimeWindow) validate() error {
+	if _, err := parseDays(w.Days); err != nil {
+		return err
+	}
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return err
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return err
+	}
+	if start == end {
+		return fmt.Errorf("window %s-%s is empty", w.Start, w.End)
+	}
+	return nil
+}
+
+// contains reports whether t falls within the window. The window must be valid.
+func (w TimeWindow) contains(t time.Time) bool {
+	days, _ := parseDays(w.Days)
+	start, _ := parseClock(w.Start)
+	end, _ := parseClock(w.End)
+	now := t.Hour()*60 + t.Minute()
+	if start < end {
+		return days[t.Weekday()] && now >= start && now < end
+	}
+	// Over midnight: the evening belongs to today's window, the morning to yesterday's
+	yesterday := (t.Weekday() + 6) % 7
+	return (days[t.Weekday()] && now >= start) || (days[yesterday] && now < end)
+}
+
+func validateTimeWindows(p Policy) error {
+	if p.OutsideWindows != nil && len(p.TimeWindows) == 0 {
+		return fmt.Errorf("policy %q: outside_windows needs time_windows", p.Name)
+	}
+	for i, w := range p.TimeWindows {
+		if err := w.validate(); err != nil {
+			return fmt.Errorf("policy %q: time_windows[%d]: %w", p.Name, i, err)
+		}
+	}
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			return fmt.Errorf("policy %q: %w", p.Name, err)
+		}
+	}
+	if o := p.OutsideWindows; o != nil {
+		switch o.Action {
+		case "", policyActionRequireApproval, policyActionDeny:
+		default:
+			return fmt.Errorf("policy %q: outside_windows: action must be %s or %s", p.Name, policyActionRequireApproval, policyActionDeny)
+		}
+		if o.ExtraApprovals < 0 {
+			return fmt.Errorf("policy %q: outside_windows: extra_approvals must not be negative", p.Name)
+		}
+	}
+	return nil
+}
+
+// withinWindows reports whether now falls within one of the policy's time windows,
+// in its time zone. Policies without windows always apply as written.
+func (p *Policy) withinWindows(now time.Time) bool {
+	if len(p.TimeWindows) == 0 {
+		return true
+	}
+	if p.Timezone != "" {
+		if loc, err := time.LoadLocation(p.Timezone); err == nil {
+			now = now.In(loc)
+		}
+	}
+	for _, w := range p.TimeWindows {
+		if w.contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// at returns the policy as it applies at now: as written within its time windows,
+// and with outside_windows applied outside them. The second result reports the latter.
+func (p *Policy) at(now time.Time) (*Policy, bool) {
+	if p.withinWindows(now) {
+		return p, false
+	}
+	outside := *p
+	// Approvals from within the windows must not carry over
+	outside.GraceMinutes = 0
+	o := p.OutsideWindows
+	if o == nil {
+		o = &OutsideWindows{}
+	}
+	outside.Action = o.Action
+	if outside.Action == "" {
+		outside.Action = policyActionRequireApproval
+	}
+	if o.Reason != "" {
+		outside.Reason = o.Reason
+	}
+	if o.ChannelID != "" {
+		outside.ChannelID = o.ChannelID
+	}
+	if len(o.ApproverIDs) > 0 {
+		outside.ApproverIDs = o.ApproverIDs
+	}
+	if o.ExtraApprovals > 0 {
+		stages := append([]ApprovalStage{}, p.Stages...)
+		if len(stages) == 0 {
+			stages = append(stages, ApprovalStage{Name: "approval"})
+		}
+		for i := 1; i <= o.ExtraApprovals; i++ {
+			name := "outside hours"
+			if o.ExtraApprovals > 1 {
+				name = fmt.Sprintf("outside hours %d", i)
+			}
+			stages = append(stages, ApprovalStage{Name: name})
+		}
+		outside.Stages = stages
+	}
+	return &outside, true
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeWindowContains(t *testing.T) {
+	business := TimeWindow{Days: []string{"mon-fri"}, Start: "09:00", End: "17:00"}
+	night := TimeWindow{Days: []string{"fri"}, Start: "22:00", End: "02:00"}
+	weekend := TimeWindow{Days: []string{"sat-sun"}, Start: "00:00", End: "23:59"}
+	// 2026-01-05 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 1, 5+day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		window TimeWindow
+		t      time.Time
+		want   bool
+	}{
+		{business, at(0, 9, 0), true},
+		{business, at(0, 16, 59), true},
+		{business, at(0, 17, 0), false},
+		{business, at(0, 3, 0), false},
+		{business, at(5, 12, 0), false},
+		{night, at(4, 23, 0), true},
+		{night, at(5, 1, 59), true},
+		{night, at(5, 2, 0), false},
+		{night, at(4, 1, 0), false},
+		{weekend, at(6, 12, 0), true},
+		{weekend, at(0, 12, 0), false},
+	}
+	for _, tt := range tests {
+		if got := tt.window.contains(tt.t); got != tt.want {
+			t.Errorf("%v contains %s = %v, want %v", tt.window, tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	days, err := parseDays([]string{"fri-mon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [7]bool{true, true, false, false, false, true, true}; days != want {
+		t.Errorf("fri-mon = %v, want %v", days, want)
+	}
+	if _, err := parseDays([]string{"someday"}); err == nil {
+		t.Error("expected an unknown day to be rejected")
+	}
+}
+
+func TestPolicyAt(t *testing.T) {
+	policy := &Policy{
+		Name:         "restarts",
+		Action:       policyActionAutoApprove,
+		GraceMinutes: 30,
+		TimeWindows:  []TimeWindow{{Days: []string{"mon-fri"}, Start: "09:00", End: "17:00"}},
+		Timezone:     "UTC",
+	}
+	day := time.Date(2026, 1, 5, 15, 0, 0, 0, time.UTC)
+	night := time.Date(2026, 1, 5, 3, 0, 0, 0, time.UTC)
+
+	if p, outside := policy.at(day); outside || p != policy {
+		t.Error("expected the policy to apply as written within its windows")
+	}
+	p, outside := policy.at(night)
+	if !outside || p.Action != policyActionRequireApproval || p.GraceMinutes != 0 {
+		t.Errorf("outside without outside_windows = %+v", p)
+	}
+
+	policy.OutsideWindows = &OutsideWindows{ExtraApprovals: 1, ChannelID: "oncall"}
+	p, _ = policy.at(night)
+	if len(p.Stages) != 2 || p.Stages[1].Name != "outside hours" || p.ChannelID != "oncall" {
+		t.Errorf("extra approver and routing = %+v", p)
+	}
+	if len(policy.Stages) != 0 || policy.ChannelID != "" {
+		t.Error("the configured policy must not change")
+	}
+
+	policy.OutsideWindows = &OutsideWindows{Action: policyActionDeny, Reason: "not at night"}
+	if p, _ := policy.at(night); p.Action != policyActionDeny || p.Reason != "not at night" {
+		t.Errorf("auto-deny = %+v", p)
+	}
+}
+
+func TestValidateTimeWindows(t *testing.T) {
+	invalid := []Policy{
+		{Name: "p", OutsideWindows: &OutsideWindows{}},
+		{Name: "p", TimeWindows: []TimeWindow{{Start: "9am", End: "17:00"}}},
+		{Name: "p", TimeWindows: []TimeWindow{{Start: "09:00", End: "09:00"}}},
+		{Name: "p", TimeWindows: []TimeWindow{{Start: "09:00", End: "17:00"}}, Timezone: "Nowhere/City"},
+		{Name: "p", TimeWindows: []TimeWindow{{Start: "09:00", End: "17:00"}}, OutsideWindows: &OutsideWindows{Action: policyActionAutoApprove}},
+	}
+	for _, p := range invalid {
+		if err := validateTimeWindows(p); err == nil {
+			t.Errorf("expected %+v to be rejected", p)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// tokenCommandTimeout bounds discord_token_command, e.g. a call to a cloud KMS.
+const tokenCommandTimeout = 30 * time.Second
+
+// discord_token_source prefixes
+const (
+	// tokenSourceKeyring prefixes the service name of a token in the OS keyring
+	tokenSourceKeyring = "keyring:"
+	// tokenSourceAWSSecret prefixes the ID or ARN of an AWS Secrets Manager secret,
+	// optionally followed by #KEY to read a key of a JSON secret
+	tokenSourceAWSSecret = "aws-secretsmanager:"
+	// tokenSourceAWSParameter prefixes the name or ARN of an SSM parameter
+	tokenSourceAWSParameter = "aws-ssm:"
+	// tokenSourceAzureSecret prefixes an Azure Key Vault secret as VAULT/SECRET[/VERSION]
+	tokenSourceAzureSecret = "azure-keyvault:"
+)
+
+var tokenSources = []string{tokenSourceKeyring, tokenSourceAWSSecret, tokenSourceAWSParameter, tokenSourceAzureSecret}
+
+func validateTokenSource(c *Config) error {
+	sources := 0
+	for _, set := range []bool{c.DiscordToken != "", len(c.DiscordTokenCommand) > 0, c.DiscordTokenSource != ""} {
+		if set {
+			sources++
+		}
+	}
+	switch {
+	case sources == 0:
+		return fmt.Errorf("discord_token is required")
+	case sources > 1:
+		return fmt.Errorf("discord_token, discord_token_command and discord_token_source are mutually exclusive")
+	case isAgeArmored(c.DiscordToken) && c.AgeIdentityFile == "":
+		return fmt.Errorf("age_identity_file is required to decrypt discord_token")
+	}
+	if c.DiscordTokenSource != "" {
+		valid := false
+		for _, prefix := range tokenSources {
+			if name, ok := strings.CutPrefix(c.DiscordTokenSource, prefix); ok && name != "" {
+				valid = true
+			}
+		}
+		if !valid {
+			return fmt.Errorf("discord_token_source must start with one of %s, followed by a name", strings.Join(tokenSources, ", "))
+		}
+	}
+	return nil
+}
+
+// resolveToken returns the bot token, decrypting discord_token if it is encrypted
+// with age, running discord_token_command or reading it from discord_token_source.
+func (c *Config) resolveToken() (string, error) {
+	if len(c.DiscordTokenCommand) > 0 {
+		token, err := runTokenCommand("discord_token_command", c.DiscordTokenCommand)
+		return botToken(token), err
+	}
+	if service, ok := strings.CutPrefix(c.DiscordTokenSource, tokenSourceKeyring); ok {
+		command, err := keyringCommand(runtime.GOOS, service)
+		if err != nil {
+			return "", err
+		}
+		token, err := runTokenCommand("keyring lookup", command)
+		return botToken(token), err
+	}
+	// Cloud secret managers, read with the identity of the instance or workload
+	var fetch func() (string, error)
+	if ref, ok := strings.CutPrefix(c.DiscordTokenSource, tokenSourceAWSSecret); ok {
+		fetch = func() (string, error) { return getAWSSecret(ref) }
+	} else if name, ok := strings.CutPrefix(c.DiscordTokenSource, tokenSourceAWSParameter); ok {
+		fetch = func() (string, error) { return getAWSParameter(name) }
+	} else if ref, ok := strings.CutPrefix(c.DiscordTokenSource, tokenSourceAzureSecret); ok {
+		fetch = func() (string, error) { return getAzureSecret(ref) }
+	}
+	if fetch != nil {
+		token, err := fetch()
+		if err != nil {
+			return "", fmt.Errorf("failed to read discord_token_source: %w", err)
+		}
+		return botToken(strings.TrimSpace(token)), nil
+	}
+	if !isAgeArmored(c.DiscordToken) {
+		return c.DiscordToken, nil
+	}
+	data, err := os.ReadFile(c.AgeIdentityFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read age identity: %w", err)
+	}
+	identities, err := parseAgeIdentities(data)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", c.AgeIdentityFile, err)
+	}
+	token, err := ageDecryptArmored(c.DiscordToken, identities)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt discord_token: %w", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// botToken adds the "Bot " prefix Discord expects to a token from a secret store,
+// which usually holds the raw token.
+func botToken(token string) string {
+	if token == "" || strings.HasPrefix(token, "Bot ") {
+		return token
+	}
+	return "Bot " + token
+}
+
+// keyringCommand returns the command printing the secret stored for service in the
+// keyring of the user running it: the Keychain on macOS, and the Secret Service
+// (GNOME Keyring, KWallet) elsewhere.
+func keyringCommand(goos, service string) ([]string, error) {
+	switch goos {
+	case "darwin":
+		return []string{"security", "find-generic-password", "-s", service, "-w"}, nil
+	case "windows":
+		return nil, fmt.Errorf("discord_token_source: no keyring support on %s", goos)
+	}
+	return []string{"secret-tool", "lookup", "service", service}, nil
+}
+
+// runTokenCommand runs command and returns its trimmed stdout as the token; name
+// describes the command in errors.
+func runTokenCommand(name string, command []string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s failed: %w: %s", name, err, msg)
+		}
+		return "", fmt.Errorf("%s failed: %w", name, err)
+	}
+	token := strings.TrimSpace(stdout.String())
+	if token == "" {
+		return "", fmt.Errorf("%s printed no token", name)
+	}
+	return token, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValidateTokenSource(t *testing.T) {
+	for name, tc := range map[string]struct {
+		config Config
+		valid  bool
+	}{
+		"plain":          {Config{DiscordToken: "token"}, true},
+		"command":        {Config{DiscordTokenCommand: []string{"cat", "/run/token"}}, true},
+		"age":            {Config{DiscordToken: testAgeToken, AgeIdentityFile: "/etc/psd/age.key"}, true},
+		"none":           {Config{}, false},
+		"both":           {Config{DiscordToken: "token", DiscordTokenCommand: []string{"cat"}}, false},
+		"age without id": {Config{DiscordToken: testAgeToken}, false},
+		"keyring":        {Config{DiscordTokenSource: "keyring:psd"}, true},
+		"keyring empty":  {Config{DiscordTokenSource: "keyring:"}, false},
+		"unknown source": {Config{DiscordTokenSource: "vault:psd"}, false},
+		"aws secret":     {Config{DiscordTokenSource: "aws-secretsmanager:psd/token#token"}, true},
+		"aws parameter":  {Config{DiscordTokenSource: "aws-ssm:/psd/token"}, true},
+		"aws empty":      {Config{DiscordTokenSource: "aws-ssm:"}, false},
+		"gcp secret":     {Config{DiscordTokenSource: "gcp-secretmanager:psd-token"}, false},
+		"azure secret":   {Config{DiscordTokenSource: "azure-keyvault:ops/psd-token"}, true},
+		"source and cmd": {Config{DiscordTokenSource: "keyring:psd", DiscordTokenCommand: []string{"cat"}}, false},
+	} {
+		if err := validateTokenSource(&tc.config); (err == nil) != tc.valid {
+			t.Errorf("%s: err = %v, want valid = %v", name, err, tc.valid)
+		}
+	}
+}
+
+func TestResolveToken(t *testing.T) {
+	identityPath := filepath.Join(t.TempDir(), "age.key")
+	if err := os.WriteFile(identityPath, []byte(testAgeIdentity+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for name, tc := range map[string]struct {
+		config Config
+		want   string
+	}{
+		"plain":   {Config{DiscordToken: "token"}, "token"},
+		"age":     {Config{DiscordToken: testAgeToken, AgeIdentityFile: identityPath}, "MTIzNDU2Nzg5MDEyMzQ1Njc4.GxYzAb.test-token-value"},
+		"command": {Config{DiscordTokenCommand: []string{"echo", " Bot from-kms "}}, "Bot from-kms"},
+		"raw":     {Config{DiscordTokenCommand: []string{"printf", "raw-token\n"}}, "Bot raw-token"},
+	} {
+		got, err := tc.config.resolveToken()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		} else if got != tc.want {
+			t.Errorf("%s: token = %q, want %q", name, got, tc.want)
+		}
+	}
+
+	for name, config := range map[string]Config{
+		"missing identity": {DiscordToken: testAgeToken, AgeIdentityFile: filepath.Join(t.TempDir(), "missing")},
+		"failing command":  {DiscordTokenCommand: []string{"false"}},
+		"empty output":     {DiscordTokenCommand: []string{"true"}},
+	} {
+		if _, err := config.resolveToken(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestKeyringCommand(t *testing.T) {
+	for goos, want := range map[string][]string{
+		"darwin": {"security", "find-generic-password", "-s", "psd", "-w"},
+		"linux":  {"secret-tool", "lookup", "service", "psd"},
+	} {
+		got, err := keyringCommand(goos, "psd")
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: command = %v, %v; want %v", goos, got, err, want)
+		}
+	}
+	if _, err := keyringCommand("windows", "psd"); err == nil {
+		t.Error("expected error on windows")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B test vectors (SHA-1, last 6 digits)
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(key, uint64(tt.unix/30)); got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	seed := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111109, 0)
+
+	if !validateTOTP(seed, "081804", now) {
+		t.Error("expected current code to be valid")
+	}
+	if !validateTOTP(seed, "081804", now.Add(30*time.Second)) {
+		t.Error("expected previous step to be accepted for clock skew")
+	}
+	if validateTOTP(seed, "081804", now.Add(5*time.Minute)) {
+		t.Error("expected stale code to be rejected")
+	}
+	if validateTOTP(seed, "000000", now) {
+		t.Error("expected wrong code to be rejected")
+	}
+	if validateTOTP("not base32!", "081804", now) {
+		t.Error("expected invalid seed to be rejected")
+	}
+}
+
+func TestDecodeTOTPSeed(t *testing.T) {
+	if _, err := decodeTOTPSeed("gezd gnbv gy3t qojq"); err != nil {
+		t.Errorf("expected lower case seed with spaces to decode: %v", err)
+	}
+	if _, err := decodeTOTPSeed(""); err == nil {
+		t.Error("expected empty seed to fail")
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTracingServiceName = "prompt-sudo-discord"
+	tracingExportTimeout      = 5 * time.Second
+)
+
+// TracingConfig exports OpenTelemetry spans for each request over OTLP/HTTP (JSON).
+type TracingConfig struct {
+	// Endpoint is the OTLP traces URL, e.g. http://localhost:4318/v1/traces
+	Endpoint    string            `json:"endpoint"`
+	Headers     map[string]string `json:"headers"`
+	ServiceName string            `json:"service_name"`
+}
+
+func validateTracing(c *TracingConfig) error {
+	if c.Endpoint == "" {
+		return fmt.Errorf("tracing.endpoint is required")
+	}
+	u, err := url.Parse(c.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("tracing.endpoint must be an http(s) URL")
+	}
+	if c.ServiceName == "" {
+		c.ServiceName = defaultTracingServiceName
+	}
+	return nil
+}
+
+// traceparentPattern matches a W3C traceparent header (version 00).
+var traceparentPattern = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+
+// span is a finished or in-progress span of a request's trace.
+type span struct {
+	name         string
+	spanID       string
+	parentSpanID string
+	start, end   time.Time
+	attrs        map[string]string
+	failed       bool
+}
+
+// set records an attribute on the span.
+func (s *span) set(key, value string) {
+	if s == nil || value == "" {
+		return
+	}
+	s.attrs[key] = value
+}
+
+// finish ends the span, unless it has already ended.
+func (s *span) finish() {
+	if s != nil && s.end.IsZero() {
+		s.end = time.Now()
+	}
+}
+
+// tracer collects the spans of a single request and exports them once it is over.
+// A nil tracer records nothing, so call sites need not check whether tracing is enabled.
+type tracer struct {
+	config  *TracingConfig
+	traceID string
+	root    *span
+	wait    *span
+	exec    *span
+	spans   []*span
+}
+
+// newTracer starts the root span of a request, continuing the caller's trace if
+// TRACEPARENT is set.
+func newTracer(config *TracingConfig, start time.Time, traceparent string) *tracer {
+	if config == nil {
+		return nil
+	}
+	t := &tracer{config: config, traceID: randomHex(16)}
+	parentSpanID := ""
+	if m := traceparentPattern.FindStringSubmatch(traceparent); m != nil {
+		t.traceID, parentSpanID = m[1], m[2]
+	}
+	t.root = t.startSpan("sudo request", parentSpanID, start)
+	// The exec span's ID is known up front so it can be handed to the command
+	t.exec = &span{name: "exec", spanID: randomHex(8), parentSpanID: t.root.spanID, attrs: map[string]string{}}
+	return t
+}
+
+func (t *tracer) startSpan(name, parentSpanID string, start time.Time) *span {
+	s := &span{name: name, spanID: randomHex(8), parentSpanID: parentSpanID, start: start, attrs: map[string]string{}}
+	t.spans = append(t.spans, s)
+	return s
+}
+
+// child starts a span under the root span.
+func (t *tracer) child(name string) *span {
+	if t == nil {
+		return nil
+	}
+	return t.startSpan(name, t.root.spanID, time.Now())
+}
+
+// setAttr records an attribute on the root span.
+func (t *tracer) setAttr(key, value string) {
+	if t != nil {
+		t.root.set(key, value)
+	}
+}
+
+// waiting starts the span covering the wait for a decision.
+func (t *tracer) waiting() {
+	if t != nil {
+		t.wait = t.child("wait for decision")
+	}
+}
+
+// traceparent returns env with TRACEPARENT pointing at the exec span, so the
+// approved command's own spans join this trace.
+func (t *tracer) traceparent(env []string) []string {
+	if t == nil {
+		return env
+	}
+	out := make([]string, 0, len(env)+1)
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, "TRACEPARENT=") {
+			out = append(out, kv)
+		}
+	}
+	return append(out, fmt.Sprintf("TRACEPARENT=00-%s-%s-01", t.traceID, t.exec.spanID))
+}
+
+// started records the start of the approved command. A command that replaces this
+// process ends the trace right away.
+func (t *tracer) started(replaced bool) {
+	if t == nil {
+		return
+	}
+	t.exec.start = time.Now()
+	t.spans = append(t.spans, t.exec)
+	if replaced {
+		t.exec.set("psd.exec", "replaced")
+		t.finish()
+	}
+}
+
+// record follows the request's audit records: decisions end the wait, and the
+// trace is exported once nothing more will run.
+func (t *tracer) record(rec AuditRecord) {
+	if t == nil {
+		return
+	}
+	switch rec.Event {
+	case auditEventDecision:
+		t.wait.finish()
+		t.root.set("psd.decision", rec.Decision)
+		t.root.set("psd.approver_ids", strings.Join(rec.ApproverIDs, ","))
+		switch rec.Decision {
+		case auditDecisionApproved, auditDecisionAutoApproved, auditDecisionCached, auditDecisionPreapproved, auditDecisionBreakGlass:
+		default:
+			t.root.failed = rec.Decision != auditDecisionPolicyDenied
+			t.finish()
+		}
+	case auditEventExit:
+		if rec.ExitCode != nil {
+			t.exec.set("process.exit.code", strconv.Itoa(*rec.ExitCode))
+			t.exec.failed = *rec.ExitCode != 0
+		}
+		t.finish()
+	}
+}
+
+// finish ends all open spans and exports the trace.
+func (t *tracer) finish() {
+	if t == nil {
+		return
+	}
+	for _, s := range t.spans {
+		s.finish()
+	}
+	if err := t.export(); err != nil {
+		slog.Warn("failed to export trace", "err", err)
+	}
+}
+
+func (t *tracer) export() error {
+	data, err := json.Marshal(t.payload())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.config.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.config.Headers {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{Timeout: tracingExportTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP/JSON encoding (opentelemetry-proto, ExportTraceServiceRequest)
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpStatus struct {
+	// Code is 2 (STATUS_CODE_ERROR) for failed spans and 0 (unset) otherwise
+	Code int `json:"code,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+func otlpAttributes(attrs map[string]string) []otlpAttribute {
+	var out []otlpAttribute
+	for k, v := range attrs {
+		a := otlpAttribute{Key: k}
+		a.Value.StringValue = v
+		out = append(out, a)
+	}
+	return out
+}
+
+func (t *tracer) payload() map[string]any {
+	var spans []otlpSpan
+	for _, s := range t.spans {
+		o := otlpSpan{
+			TraceID:      t.traceID,
+			SpanID:       s.spanID,
+			ParentSpanID: s.parentSpanID,
+			Name:         s.name,
+			// SPAN_KIND_INTERNAL
+			Kind:              1,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.attrs),
+		}
+		if s.failed {
+			o.Status.Code = 2
+		}
+		spans = append(spans, o)
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes(map[string]string{"service.name": t.config.ServiceName}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": defaultTracingServiceName},
+				"spans": spans,
+			}},
+		}},
+	}
+}
+
+// randomHex returns n random bytes, hex-encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
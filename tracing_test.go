@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateTracing(t *testing.T) {
+	c := &TracingConfig{Endpoint: "http://localhost:4318/v1/traces"}
+	if err := validateTracing(c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.ServiceName != defaultTracingServiceName {
+		t.Errorf("service name = %q", c.ServiceName)
+	}
+	for _, endpoint := range []string{"", "localhost:4318", "grpc://collector:4317"} {
+		if err := validateTracing(&TracingConfig{Endpoint: endpoint}); err == nil {
+			t.Errorf("expected error for endpoint %q", endpoint)
+		}
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var trace *tracer
+	trace.setAttr("k", "v")
+	trace.child("post").finish()
+	trace.waiting()
+	trace.started(true)
+	trace.record(AuditRecord{Event: auditEventDecision, Decision: auditDecisionDenied})
+	env := []string{"A=1"}
+	if got := trace.traceparent(env); len(got) != 1 {
+		t.Errorf("nil tracer changed env: %v", got)
+	}
+}
+
+func TestTracerExport(t *testing.T) {
+	var body []byte
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		auth = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	parentTrace := strings.Repeat("a", 32)
+	parentSpan := strings.Repeat("b", 16)
+	config := &TracingConfig{Endpoint: srv.URL, ServiceName: "psd-test", Headers: map[string]string{"Authorization": "Bearer x"}}
+	trace := newTracer(config, time.Now(), "00-"+parentTrace+"-"+parentSpan+"-01")
+	trace.setAttr("psd.request_id", "req")
+
+	env := trace.traceparent([]string{"TRACEPARENT=stale", "A=1"})
+	if len(env) != 2 || env[1] != "TRACEPARENT=00-"+parentTrace+"-"+trace.exec.spanID+"-01" {
+		t.Errorf("unexpected env: %v", env)
+	}
+
+	trace.child("post request").finish()
+	trace.waiting()
+	trace.record(AuditRecord{Event: auditEventDecision, Decision: auditDecisionApproved, ApproverIDs: []string{"1"}})
+	if body != nil {
+		t.Fatal("trace exported before the command ran")
+	}
+	trace.started(false)
+	code := 1
+	trace.record(AuditRecord{Event: auditEventExit, ExitCode: &code})
+	if auth != "Bearer x" {
+		t.Errorf("Authorization = %q", auth)
+	}
+
+	var payload struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("invalid payload %s: %v", body, err)
+	}
+	spans := payload.ResourceSpans[0].ScopeSpans[0].Spans
+	names := map[string]otlpSpan{}
+	for _, s := range spans {
+		if s.TraceID != parentTrace {
+			t.Errorf("span %q trace ID = %q", s.Name, s.TraceID)
+		}
+		if s.EndTimeUnixNano == "" || s.EndTimeUnixNano < s.StartTimeUnixNano {
+			t.Errorf("span %q not ended", s.Name)
+		}
+		names[s.Name] = s
+	}
+	for _, name := range []string{"sudo request", "post request", "wait for decision", "exec"} {
+		if _, ok := names[name]; !ok {
+			t.Errorf("missing span %q in %v", name, spans)
+		}
+	}
+	if names["sudo request"].ParentSpanID != parentSpan {
+		t.Errorf("root parent = %q, want %q", names["sudo request"].ParentSpanID, parentSpan)
+	}
+	if names["exec"].Status.Code != 2 {
+		t.Errorf("exec status = %d, want error", names["exec"].Status.Code)
+	}
+}
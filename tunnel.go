@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	tunnelKeepaliveInterval = 5 * time.Minute
+	// tunnelStopGrace is how long a tunnel gets to exit after SIGTERM before it is killed
+	tunnelStopGrace = 10 * time.Second
+)
+
+// postReply posts content as a reply to the given message, logging failures.
+func postReply(dg *discordgo.Session, channelID, messageID, content string) {
+	_, err := sendMessage(dg, channelID, &discordgo.MessageSend{
+		Content: content,
+		Reference: &discordgo.MessageReference{
+			MessageID: messageID,
+			ChannelID: channelID,
+		},
+	})
+	if err != nil {
+		slog.Error("failed to send Discord message", "err", err)
+	}
+}
+
+// runTunnel runs an approved tunnel command (e.g. `ssh -N -L ...`) for at most duration,
+// posting keepalive status and a closing notice as replies to the request message.
+// It never returns.
+func runTunnel(dg *discordgo.Session, channelID, messageID string, spec execSpec, duration time.Duration) {
+	execPath, err := spec.resolve()
+	if err != nil {
+		slog.Error("failed to find executable", "err", err)
+		postReply(dg, channelID, messageID, fmt.Sprintf("⚠️ **Tunnel failed to start:** `%v`", err))
+		os.Exit(exitNotFound)
+	}
+	cmd := spec.command(execPath)
+	spec.start(false)
+	if err := cmd.Start(); err != nil {
+		slog.Error("failed to execute command", "err", err)
+		postReply(dg, channelID, messageID, fmt.Sprintf("⚠️ **Tunnel failed to start:** `%v`", err))
+		os.Exit(exitCannotExecute)
+	}
+
+	started := time.Now()
+	deadline := started.Add(duration)
+	postReply(dg, channelID, messageID, fmt.Sprintf("🔌 **Tunnel open.** Closes in %s.", duration))
+
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		spec.stats.collect(cmd.ProcessState)
+		done <- err
+	}()
+
+	// stop terminates the tunnel, escalating to SIGKILL if it ignores SIGTERM
+	stop := func() {
+		cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-done:
+		case <-time.After(tunnelStopGrace):
+			cmd.Process.Kill()
+			<-done
+		}
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	expiry := time.NewTimer(duration)
+	defer expiry.Stop()
+	keepalive := time.NewTicker(tunnelKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-keepalive.C:
+			remaining := time.Until(deadline).Round(time.Second)
+			postReply(dg, channelID, messageID, fmt.Sprintf("🔌 Tunnel still open. Closes in %s.", remaining))
+
+		case <-expiry.C:
+			slog.Info("tunnel duration expired, closing")
+			stop()
+			postReply(dg, channelID, messageID, fmt.Sprintf("🔒 **Tunnel closed:** approved duration of %s expired.", duration))
+			spec.exit(0)
+
+		case <-sigCh:
+			slog.Warn("interrupted")
+			stop()
+			postReply(dg, channelID, messageID, "🔒 **Tunnel closed** (interrupted).")
+			spec.exit(exitInterrupted)
+
+		case err := <-done:
+			elapsed := time.Since(started).Round(time.Second)
+			code, ok := exitCode(err)
+			if !ok {
+				slog.Error("failed to execute command", "err", err)
+				os.Exit(exitCannotExecute)
+			}
+			if code != 0 {
+				postReply(dg, channelID, messageID, fmt.Sprintf("🔒 **Tunnel closed:** command exited with code %d after %s.", code, elapsed))
+			} else {
+				postReply(dg, channelID, messageID, fmt.Sprintf("🔒 **Tunnel closed:** command exited after %s.", elapsed))
+			}
+			spec.exit(code)
+		}
+	}
+}
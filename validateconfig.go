@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// configIssue is a problem found in the config, at the JSON path of the offending
+// value, e.g. "policies[0].channel_id".
+type configIssue struct {
+	path string
+	msg  string
+}
+
+// configWalker reads a config document token by token against the Config type,
+// recording where each value is and which keys Config does not know.
+type configWalker struct {
+	dec *json.Decoder
+	// positions maps JSON paths to the offset just after their key, or the first
+	// token of array elements, which is on the line they start
+	positions map[string]int64
+	issues    []configIssue
+}
+
+func walkConfig(data []byte) (*configWalker, error) {
+	w := &configWalker{dec: json.NewDecoder(bytes.NewReader(data)), positions: map[string]int64{}}
+	if err := w.value("", reflect.TypeOf(Config{})); err != nil {
+		return nil, err
+	}
+	if _, err := w.dec.Token(); err != io.EOF {
+		return nil, &trailingDataError{offset: w.dec.InputOffset()}
+	}
+	return w, nil
+}
+
+// trailingDataError is returned for anything following the config object.
+type trailingDataError struct {
+	offset int64
+}
+
+func (e *trailingDataError) Error() string {
+	return "unexpected data after the config"
+}
+
+// value walks the next value, which should be of type t; a nil t accepts anything.
+func (w *configWalker) value(path string, t reflect.Type) error {
+	tok, err := w.dec.Token()
+	if err != nil {
+		return err
+	}
+	if _, ok := w.positions[path]; !ok {
+		w.positions[path] = w.dec.InputOffset()
+	}
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch tok {
+	case json.Delim('{'):
+		return w.object(path, t)
+	case json.Delim('['):
+		var elem reflect.Type
+		if t != nil && t.Kind() == reflect.Slice {
+			elem = t.Elem()
+		}
+		for i := 0; w.dec.More(); i++ {
+			if err := w.value(fmt.Sprintf("%s[%d]", path, i), elem); err != nil {
+				return err
+			}
+		}
+		_, err := w.dec.Token()
+		return err
+	}
+	return nil
+}
+
+func (w *configWalker) object(path string, t reflect.Type) error {
+	for w.dec.More() {
+		tok, err := w.dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		var child string
+		var elem reflect.Type
+		switch {
+		case t != nil && t.Kind() == reflect.Map:
+			child, elem = fmt.Sprintf("%s[%s]", path, key), t.Elem()
+		case t != nil && t.Kind() == reflect.Struct:
+			child = key
+			if path != "" {
+				child = path + "." + key
+			}
+			var ok bool
+			if elem, ok = jsonField(t, key); !ok {
+				msg := fmt.Sprintf("unknown key %q", key)
+				if name := foldedJSONField(t, key); name != "" {
+					msg += fmt.Sprintf(" (did you mean %q?)", name)
+				}
+				w.issues = append(w.issues, configIssue{child, msg})
+			}
+		default:
+			child = path + "." + key
+		}
+		w.positions[child] = w.dec.InputOffset()
+		if err := w.value(child, elem); err != nil {
+			return err
+		}
+	}
+	_, err := w.dec.Token()
+	return err
+}
+
+// jsonFieldName returns the JSON key of a struct field, or "" if it is not decoded.
+func jsonFieldName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// jsonField returns the type of the field of t with JSON key key.
+func jsonField(t reflect.Type, key string) (reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if jsonFieldName(t.Field(i)) == key {
+			return t.Field(i).Type, true
+		}
+	}
+	return nil, false
+}
+
+// foldedJSONField returns the JSON key of t that key differs from only in case,
+// which encoding/json would accept but is most likely a typo.
+func foldedJSONField(t reflect.Type, key string) string {
+	for i := 0; i < t.NumField(); i++ {
+		if name := jsonFieldName(t.Field(i)); name != "" && strings.EqualFold(name, key) {
+			return name
+		}
+	}
+	return ""
+}
+
+// parentPath returns the path of the value containing path.
+func parentPath(path string) string {
+	if strings.HasSuffix(path, "]") {
+		return path[:strings.LastIndex(path, "[")]
+	}
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+// line returns the line of the value at path, or of the closest enclosing value
+// present in the document, or 0 if there is none.
+func (w *configWalker) line(data []byte, path string) int {
+	for ; path != ""; path = parentPath(path) {
+		if offset, ok := w.positions[path]; ok {
+			return lineAt(data, offset)
+		}
+	}
+	return 0
+}
+
+// lineAt returns the line of data that offset is on.
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// lintConfig returns problems in a config that loads but is likely not what was meant.
+func lintConfig(config *Config) []configIssue {
+	var issues []configIssue
+	snowflake := func(path, id string) {
+		if id != "" && !snowflakePattern.MatchString(id) {
+			issues = append(issues, configIssue{path, fmt.Sprintf("%q is not a Discord ID", id)})
+		}
+	}
+	snowflakes := func(path string, ids []string) {
+		for i, id := range ids {
+			snowflake(fmt.Sprintf("%s[%d]", path, i), id)
+		}
+	}
+	// Overriding approvers with an empty list falls back to the defaults, which an
+	// explicit [] is unlikely to mean
+	approvers := func(path string, ids []string) {
+		if ids != nil && len(ids) == 0 {
+			issues = append(issues, configIssue{path, "is empty, so the default approvers apply; leave it out or list approvers"})
+		}
+		snowflakes(path, ids)
+	}
+	policies := func(path string, list []Policy) {
+		names := map[string]bool{}
+		for i, p := range list {
+			pp := fmt.Sprintf("%s[%d]", path, i)
+			if names[p.Name] {
+				issues = append(issues, configIssue{pp + ".name", fmt.Sprintf("policy %q is defined more than once", p.Name)})
+			}
+			names[p.Name] = true
+			snowflake(pp+".channel_id", p.ChannelID)
+			approvers(pp+".approver_ids", p.ApproverIDs)
+			for j, s := range p.Stages {
+				approvers(fmt.Sprintf("%s.stages[%d].approver_ids", pp, j), s.ApproverIDs)
+			}
+			if o := p.OutsideWindows; o != nil {
+				snowflake(pp+".outside_windows.channel_id", o.ChannelID)
+				approvers(pp+".outside_windows.approver_ids", o.ApproverIDs)
+			}
+			for j, pattern := range p.Commands {
+				if earlier := shadowingPolicy(list[:i], pattern); earlier != "" {
+					issues = append(issues, configIssue{fmt.Sprintf("%s.commands[%d]", pp, j),
+						fmt.Sprintf("%q never applies: policy %q comes first and covers it", pattern, earlier)})
+				}
+			}
+		}
+	}
+
+	snowflakes("approver_ids", config.ApproverIDs)
+	snowflake("audit_channel_id", config.AuditChannelID)
+	policies("policies", config.Policies)
+	patterns := make([]string, 0, len(config.Hosts))
+	for pattern := range config.Hosts {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		profile := config.Hosts[pattern]
+		path := fmt.Sprintf("hosts[%s]", pattern)
+		snowflake(path+".channel_id", profile.ChannelID)
+		approvers(path+".approver_ids", profile.ApproverIDs)
+		policies(path+".policies", profile.Policies)
+	}
+	if e := config.Escalation; e != nil {
+		snowflake("escalation.channel_id", e.ChannelID)
+		snowflakes("escalation.approver_ids", e.ApproverIDs)
+	}
+	if m := config.Mentions; m != nil {
+		snowflakes("mentions.user_ids", m.UserIDs)
+		snowflakes("mentions.role_ids", m.RoleIDs)
+	}
+	for _, user := range sortedKeys(config.DiscordUserIDs) {
+		snowflake(fmt.Sprintf("discord_user_ids[%s]", user), config.DiscordUserIDs[user])
+	}
+	for _, userID := range sortedKeys(config.TOTPSeeds) {
+		snowflake(fmt.Sprintf("totp_seeds[%s]", userID), userID)
+	}
+	return issues
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// shadowingPolicy returns the name of the first of policies that matches every
+// command pattern matches, or "".
+func shadowingPolicy(policies []Policy, pattern string) string {
+	for _, p := range policies {
+		for _, earlier := range p.Commands {
+			if globCovers(earlier, pattern) {
+				return p.Name
+			}
+		}
+	}
+	return ""
+}
+
+// globCovers reports whether every command matching pattern also matches earlier.
+// It only recognizes the plain cases: the same pattern, a literal command, and a
+// literal prefix followed by '*'.
+func globCovers(earlier, pattern string) bool {
+	if earlier == pattern {
+		return true
+	}
+	if !strings.ContainsAny(pattern, "*?") {
+		return matchGlob(earlier, pattern)
+	}
+	prefix, ok := strings.CutSuffix(earlier, "*")
+	return ok && !strings.ContainsAny(prefix, "*?") && strings.HasPrefix(pattern, prefix)
+}
+
+// validateConfigData returns every problem found in a config document, as lines
+// anchored to the file name and line number where possible.
+func validateConfigData(name string, data []byte) []string {
+	anchor := func(line int, msg string) string {
+		if line > 0 {
+			return fmt.Sprintf("%s:%d: %s", name, line, msg)
+		}
+		return fmt.Sprintf("%s: %s", name, msg)
+	}
+	w, err := walkConfig(data)
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		var trailingErr *trailingDataError
+		switch {
+		case errors.As(err, &syntaxErr):
+			return []string{anchor(lineAt(data, syntaxErr.Offset), "invalid JSON: "+err.Error())}
+		case errors.As(err, &trailingErr):
+			return []string{anchor(lineAt(data, trailingErr.offset), "invalid JSON: "+err.Error())}
+		}
+		return []string{anchor(0, err.Error())}
+	}
+	var problems []string
+	report := func(issues []configIssue) {
+		for _, issue := range issues {
+			problems = append(problems, anchor(w.line(data, issue.path), issue.path+": "+issue.msg))
+		}
+	}
+	report(w.issues)
+	config, err := parseConfig(data)
+	if err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			problems = append(problems, anchor(lineAt(data, typeErr.Offset), fmt.Sprintf("%s: expected %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)))
+		} else {
+			problems = append(problems, anchor(0, err.Error()))
+		}
+		return problems
+	}
+	report(lintConfig(config))
+	return problems
+}
+
+// runValidateConfig implements `prompt-sudo-discord validate-config [FILE]`: it
+// strictly validates a config (by default the installed one) without contacting
+// Discord.
+func runValidateConfig(args []string) int {
+	fs := flag.NewFlagSet("validate-config", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s validate-config [FILE]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	path := configPath
+	if fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfigError
+	}
+	problems := validateConfigData(path, data)
+	for _, p := range problems {
+		fmt.Fprintln(os.Stderr, p)
+	}
+	if len(problems) > 0 {
+		return exitConfigError
+	}
+	fmt.Printf("%s is valid\n", path)
+	return 0
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfigData(t *testing.T) {
+	data := []byte(`{
+  "discord_token": "token",
+  "approver_ids": ["123"],
+  "timeout_secs": 60,
+  "policies": [
+    {"name": "systemctl", "commands": ["systemctl *"], "channel_id": "ops"},
+    {"name": "restarts", "commands": ["systemctl restart *"], "approver_ids": []},
+    {"name": "systemctl", "commands": ["ls"], "Action": "deny"}
+  ]
+}
+`)
+	got := validateConfigData("config.json", data)
+	want := []string{
+		`config.json:4: timeout_secs: unknown key "timeout_secs"`,
+		`config.json:8: policies[2].Action: unknown key "Action" (did you mean "action"?)`,
+		`config.json:6: policies[0].channel_id: "ops" is not a Discord ID`,
+		`config.json:7: policies[1].approver_ids: is empty, so the default approvers apply; leave it out or list approvers`,
+		`config.json:7: policies[1].commands[0]: "systemctl restart *" never applies: policy "systemctl" comes first and covers it`,
+		`config.json:8: policies[2].name: policy "systemctl" is defined more than once`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("problems:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestValidateConfigDataErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		data string
+		want string
+	}{
+		"valid":        {`{"discord_token": "t", "approver_ids": ["1"]}`, ""},
+		"syntax":       {"{\n  \"discord_token\": \"t\",\n}", "c.json:2: invalid JSON: invalid character ',' looking for beginning of value"},
+		"type":         {"{\n  \"discord_token\": \"t\",\n  \"approver_ids\": \"1\"\n}", "c.json:3: approver_ids: expected []string, got string"},
+		"validation":   {`{"discord_token": "t"}`, "c.json: approver_ids is required"},
+		"hosts":        {"{\"discord_token\": \"t\", \"approver_ids\": [\"1\"],\n \"hosts\": {\"web.*\": {\"channel_id\": \"web\"}}}", `c.json:2: hosts[web.*].channel_id: "web" is not a Discord ID`},
+		"nested typo":  {"{\"discord_token\": \"t\", \"approver_ids\": [\"1\"],\n \"tracing\": {\"endpoint\": \"http://x\", \"header\": {}}}", `c.json:2: tracing.header: unknown key "header"`},
+		"trailing":     {`{"discord_token": "t", "approver_ids": ["1"]} {}`, "c.json:1: invalid JSON: unexpected data after the config"},
+		"discord user": {`{"discord_token": "t", "approver_ids": ["1"], "discord_user_ids": {"alice": "@alice"}}`, `c.json:1: discord_user_ids[alice]: "@alice" is not a Discord ID`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got := strings.Join(validateConfigData("c.json", []byte(tc.data)), "\n")
+			if got != tc.want {
+				t.Errorf("problems = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGlobCovers(t *testing.T) {
+	for _, tc := range []struct {
+		earlier, pattern string
+		want             bool
+	}{
+		{"systemctl *", "systemctl restart *", true},
+		{"systemctl *", "systemctl status", true},
+		{"apt *", "apt", false},
+		{"ls", "ls", true},
+		{"a?", "a*", false},
+		{"*", "anything *", true},
+		{"systemctl restart *", "systemctl *", false},
+	} {
+		if got := globCovers(tc.earlier, tc.pattern); got != tc.want {
+			t.Errorf("globCovers(%q, %q) = %v, want %v", tc.earlier, tc.pattern, got, tc.want)
+		}
+	}
+}
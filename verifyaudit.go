@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// auditProblem is something verify-audit found wrong at a line of the audit log.
+type auditProblem struct {
+	line int
+	msg  string
+}
+
+// auditVerifyOptions says what verify-audit accepts at the start of a log.
+type auditVerifyOptions struct {
+	// protected is set when every record is expected to be numbered: a key is given, or
+	// chaining or signing is configured
+	protected bool
+	// allowUnprotectedPrefix accepts records written before signing or chaining was
+	// enabled, which cannot be checked
+	allowUnprotectedPrefix bool
+	// rotated accepts a log whose first records were rotated away
+	rotated bool
+}
+
+// auditVerification is the outcome of checking an audit log.
+type auditVerification struct {
+	records int
+	// unprotected counts the records before the first numbered one, written before
+	// signing or chaining was enabled
+	unprotected       int
+	firstSeq, lastSeq uint64
+	// head is the hash of the last record, as anchored in the audit channel
+	head string
+	// hashes are the hashes of the records by number, to check anchors against
+	hashes   map[uint64]string
+	problems []auditProblem
+}
+
+// signedPart returns the bytes the signature of a record covers, the record as written
+// without its trailing signature field, and the signature.
+func signedPart(line []byte) ([]byte, string, bool) {
+	const field = `,"signature":"`
+	idx := bytes.LastIndex(line, []byte(field))
+	if idx < 0 || !bytes.HasSuffix(line, []byte(`"}`)) {
+		return nil, "", false
+	}
+	sig := string(line[idx+len(field) : len(line)-2])
+	return append(line[:idx:idx], '}'), sig, true
+}
+
+// verifyAuditLog checks the records read from r: that their numbers follow each other
+// from 1, that each is chained to the one before, and their signatures with pub unless
+// it is nil.
+func verifyAuditLog(r io.Reader, pub ed25519.PublicKey, opts auditVerifyOptions) (auditVerification, error) {
+	v := auditVerification{hashes: map[uint64]string{}}
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			v.check(n, line, pub, opts)
+		}
+		if errors.Is(err, io.EOF) {
+			return v, nil
+		}
+		if err != nil {
+			return v, err
+		}
+	}
+}
+
+func (v *auditVerification) check(n int, line []byte, pub ed25519.PublicKey, opts auditVerifyOptions) {
+	problem := func(format string, args ...any) {
+		v.problems = append(v.problems, auditProblem{line: n, msg: fmt.Sprintf(format, args...)})
+	}
+	var rec AuditRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		problem("not an audit record: %v", err)
+		return
+	}
+	v.records++
+	if rec.Seq == 0 {
+		switch {
+		case v.lastSeq == 0 && (!opts.protected || opts.allowUnprotectedPrefix):
+			v.unprotected++
+		case pub != nil:
+			problem("unsigned record")
+		default:
+			problem("record without a number")
+		}
+		return
+	}
+
+	if pub != nil {
+		signed, sig, ok := signedPart(line)
+		switch {
+		case rec.Signature == "":
+			problem("unsigned record")
+		case rec.KeyID != publicKeyID(pub):
+			problem("signed with another key (%s)", rec.KeyID)
+		case !ok || !verifySignature(pub, signed, sig):
+			problem("invalid signature")
+		case rec.Attestation != nil && !rec.Attestation.verify(pub):
+			problem("invalid attestation")
+		}
+	}
+
+	switch {
+	case v.lastSeq == 0:
+		v.firstSeq = rec.Seq
+		switch {
+		case rec.Seq == 1 && rec.PrevHash != "":
+			problem("the first record is chained to another one")
+		case rec.Seq == 2 && !opts.rotated:
+			problem("record 1 is missing at the start of the log")
+		case rec.Seq > 2 && !opts.rotated:
+			problem("records 1 to %d are missing at the start of the log", rec.Seq-1)
+		}
+	case rec.Seq == v.lastSeq+1:
+		// An edited or replaced record breaks the chain at the next one
+		if rec.PrevHash != v.head {
+			problem("record %d is not chained to record %d", rec.Seq, v.lastSeq)
+		}
+	case rec.Seq > v.lastSeq+1:
+		if rec.Seq == v.lastSeq+2 {
+			problem("record %d is missing", v.lastSeq+1)
+		} else {
+			problem("records %d to %d are missing", v.lastSeq+1, rec.Seq-1)
+		}
+	default:
+		problem("record %d follows record %d", rec.Seq, v.lastSeq)
+		return
+	}
+	v.lastSeq = rec.Seq
+	v.head = auditLineHash(line)
+	v.hashes[rec.Seq] = v.head
+}
+
+// parseAuditAnchor parses an anchor posted to the audit channel, "SEQ:HASH".
+func parseAuditAnchor(s string) (auditAnchor, error) {
+	seq, head, ok := strings.Cut(s, ":")
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if !ok || err != nil || n == 0 || len(head) != sha256.Size*2 {
+		return auditAnchor{}, fmt.Errorf("invalid anchor %q, expected SEQ:HASH", s)
+	}
+	return auditAnchor{seq: n, head: strings.ToLower(head)}, nil
+}
+
+// checkAnchor reports a problem if the log does not hold the anchored record as it was.
+func (v auditVerification) checkAnchor(a auditAnchor) string {
+	hash, ok := v.hashes[a.seq]
+	switch {
+	case ok && hash == a.head:
+		return ""
+	case ok:
+		return fmt.Sprintf("record %d differs from the anchor", a.seq)
+	case a.seq > v.lastSeq:
+		return fmt.Sprintf("records %d to %d are missing at the end of the log", v.lastSeq+1, a.seq)
+	}
+	return fmt.Sprintf("anchored record %d is not in the log", a.seq)
+}
+
+// runVerifyAudit implements `prompt-sudo-discord verify-audit`.
+func runVerifyAudit(args []string) int {
+	fs := flag.NewFlagSet("verify-audit", flag.ContinueOnError)
+	keyPath := fs.String("key", "", "Public key verifying the records (default: the one of audit_signing_key_file or audit_signing_public_key_file)")
+	anchorFlag := fs.String("anchor", "", "SEQ:HASH of an anchor posted to the audit channel, to check the log still holds that record")
+	var opts auditVerifyOptions
+	fs.BoolVar(&opts.allowUnprotectedPrefix, "allow-unprotected-prefix", false, "Accept records written before signing or chaining was enabled at the start of the log")
+	fs.BoolVar(&opts.rotated, "rotated", false, "Accept a log that does not start at record 1, because older records were rotated away")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: prompt-sudo-discord verify-audit [--key PUBLIC_KEY] [--anchor SEQ:HASH] [--allow-unprotected-prefix] [--rotated] [AUDIT_LOG]")
+		fs.PrintDefaults()
+	}
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return exitConfigError
+	}
+	var anchor *auditAnchor
+	if *anchorFlag != "" {
+		a, err := parseAuditAnchor(*anchorFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "--anchor: %v\n", err)
+			return exitConfigError
+		}
+		anchor = &a
+	}
+
+	// Records copied off the host can be verified without its config
+	path := fs.Arg(0)
+	if *keyPath == "" || path == "" {
+		config, err := readConfig(configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfigError
+		}
+		if *keyPath == "" {
+			*keyPath = config.auditPublicKeyFile()
+		}
+		opts.protected = config.AuditHashChain || config.auditPublicKeyFile() != ""
+		if path == "" {
+			path = config.AuditLogPath
+		}
+	}
+	// Without a key, only the numbering and the hash chain are checked
+	var pub ed25519.PublicKey
+	if *keyPath != "" {
+		opts.protected = true
+		var err error
+		pub, err = readAuditPublicKey(*keyPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfigError
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitInternalError
+	}
+	defer f.Close()
+	v, err := verifyAuditLog(f, pub, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return exitInternalError
+	}
+
+	problems := len(v.problems)
+	for _, p := range v.problems {
+		fmt.Printf("%s:%d: %s\n", path, p.line, p.msg)
+	}
+	if anchor != nil {
+		if msg := v.checkAnchor(*anchor); msg != "" {
+			fmt.Printf("%s: %s\n", path, msg)
+			problems++
+		}
+	}
+	if v.unprotected > 0 {
+		fmt.Printf("%d records written before signing or chaining was enabled were not checked\n", v.unprotected)
+	}
+	checked := v.records - v.unprotected
+	switch {
+	case checked == 0:
+		fmt.Println("no numbered records")
+	case pub != nil:
+		fmt.Printf("checked %d records (%d to %d) with key %s\n", checked, v.firstSeq, v.lastSeq, publicKeyID(pub))
+	default:
+		fmt.Printf("checked the hash chain of %d records (%d to %d); signatures were not checked\n", checked, v.firstSeq, v.lastSeq)
+	}
+	if v.head != "" {
+		fmt.Printf("head: %d:%s\n", v.lastSeq, v.head)
+	}
+	if problems > 0 {
+		fmt.Printf("❌ %d problems found\n", problems)
+		return 1
+	}
+	fmt.Println("✅ no problems found")
+	return 0
+}
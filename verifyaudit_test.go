@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeSignedLog writes n signed records to a new audit log and returns its lines.
+func writeSignedLog(t *testing.T, signer *auditSigner, n int) []string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	for i := 0; i < n; i++ {
+		rec := AuditRecord{Time: time.Now(), Event: auditEventDecision, RequestID: "req", Command: []string{"reboot"}, Decision: auditDecisionApproved}
+		if i == 0 {
+			att, err := signer.attest(rec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec.Attestation = att
+		}
+		if _, _, err := appendChainedAuditRecord(path, signer, rec, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "audit.key")
+	signer, err := loadAuditSigner(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := readAuditPublicKey(keyPath + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := writeSignedLog(t, signer, 5)
+
+	verify := func(lines []string) auditVerification {
+		t.Helper()
+		v, err := verifyAuditLog(strings.NewReader(strings.Join(lines, "\n")+"\n"), pub, auditVerifyOptions{protected: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	v := verify(lines)
+	if len(v.problems) > 0 || v.records != 5 || v.firstSeq != 1 || v.lastSeq != 5 {
+		t.Fatalf("intact log: %+v", v)
+	}
+
+	// An older unsigned record before signing was enabled is only counted if accepted
+	prefixed := append([]string{`{"event":"decision","request_id":"old"}`}, lines...)
+	v, err = verifyAuditLog(strings.NewReader(strings.Join(prefixed, "\n")), pub, auditVerifyOptions{protected: true, allowUnprotectedPrefix: true})
+	if err != nil || len(v.problems) > 0 || v.unprotected != 1 {
+		t.Errorf("accepted unsigned prefix: %+v", v)
+	}
+	// A log rotated away from record 1 is only accepted if acknowledged
+	v, err = verifyAuditLog(strings.NewReader(strings.Join(lines[2:], "\n")), pub, auditVerifyOptions{protected: true, rotated: true})
+	if err != nil || len(v.problems) > 0 || v.firstSeq != 3 {
+		t.Errorf("rotated log: %+v", v)
+	}
+
+	for name, tc := range map[string]struct {
+		lines []string
+		want  string
+	}{
+		"removed record":  {append(append([]string{}, lines[:2]...), lines[3:]...), "record 3 is missing"},
+		"removed records": {append([]string{lines[0]}, lines[4]), "records 2 to 4 are missing"},
+		"reordered":       {[]string{lines[0], lines[2], lines[1]}, "record 2 follows record 3"},
+		"edited record":   {append([]string{strings.Replace(lines[0], `"reboot"`, `"true"`, 1)}, lines[1:]...), "invalid signature"},
+		"unsigned record": {append(append([]string{}, lines...), `{"event":"decision","request_id":"forged"}`), "unsigned record"},
+		"unsigned prefix": {prefixed, "unsigned record"},
+		"truncated start": {lines[2:], "records 1 to 2 are missing at the start of the log"},
+		"first removed":   {lines[1:], "record 1 is missing at the start of the log"},
+	} {
+		v := verify(tc.lines)
+		var msgs []string
+		for _, p := range v.problems {
+			msgs = append(msgs, p.msg)
+		}
+		if !strings.Contains(strings.Join(msgs, "\n"), tc.want) {
+			t.Errorf("%s: problems %q, want %q", name, msgs, tc.want)
+		}
+	}
+}
+
+func TestSignedPart(t *testing.T) {
+	line := []byte(`{"event":"exit","seq":2,"signature":"c2ln"}`)
+	signed, sig, ok := signedPart(line)
+	if !ok || string(signed) != `{"event":"exit","seq":2}` || sig != "c2ln" {
+		t.Errorf("signedPart = %s, %q, %v", signed, sig, ok)
+	}
+	// The line itself is left alone
+	if !bytes.HasSuffix(line, []byte(`"c2ln"}`)) {
+		t.Errorf("line changed: %s", line)
+	}
+	if _, _, ok := signedPart([]byte(`{"event":"exit"}`)); ok {
+		t.Error("expected an unsigned record to have no signed part")
+	}
+}
+
+func TestAuditSigningCommandChecksSignatures(t *testing.T) {
+	signer, err := loadAuditSigner(filepath.Join(t.TempDir(), "audit.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A command signing with another key, or printing garbage, is refused
+	cmdSigner := &auditSigner{command: []string{"sh", "-c", "cat >/dev/null; echo c2ln"}, pub: signer.key.Public().(ed25519.PublicKey), keyID: signer.keyID}
+	if _, err := cmdSigner.sign([]byte("data")); err == nil {
+		t.Error("expected a mismatching signature to be refused")
+	}
+}
+
+func TestAuditHashChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	start := time.Now()
+	var anchors []auditAnchor
+	for i := 0; i < 4; i++ {
+		rec := AuditRecord{Time: start.Add(time.Duration(i) * 40 * time.Minute), Event: auditEventDecision, RequestID: "req", Decision: auditDecisionApproved}
+		_, anchor, err := appendChainedAuditRecord(path, nil, rec, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if anchor != nil {
+			anchors = append(anchors, *anchor)
+			if err := markAuditAnchored(path, *anchor); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// The first record is anchored, then the first one an hour later
+	if len(anchors) != 2 || anchors[0].seq != 1 || anchors[1].seq != 3 {
+		t.Fatalf("anchors = %+v", anchors)
+	}
+	// An anchor that was not posted is offered again with the next record
+	unposted := filepath.Join(t.TempDir(), "audit.jsonl")
+	for i := 0; i < 2; i++ {
+		rec := AuditRecord{Time: start.Add(time.Duration(i) * time.Minute), Event: auditEventDecision, RequestID: "req"}
+		if _, anchor, err := appendChainedAuditRecord(unposted, nil, rec, time.Hour); err != nil || anchor == nil {
+			t.Fatalf("record %d: anchor = %v, %v", i+1, anchor, err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	verify := func(lines []string) auditVerification {
+		t.Helper()
+		v, err := verifyAuditLog(strings.NewReader(strings.Join(lines, "\n")), nil, auditVerifyOptions{protected: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	v := verify(lines)
+	if len(v.problems) > 0 || v.lastSeq != 4 {
+		t.Fatalf("intact log: %+v", v)
+	}
+	for _, a := range anchors {
+		if msg := v.checkAnchor(a); msg != "" {
+			t.Errorf("anchor %d: %s", a.seq, msg)
+		}
+	}
+
+	// Without signatures, an edit shows in the chain of the next record
+	edited := append([]string{}, lines...)
+	edited[1] = strings.Replace(edited[1], `"approved"`, `"denied"`, 1)
+	v = verify(edited)
+	if len(v.problems) != 1 || v.problems[0].msg != "record 3 is not chained to record 2" {
+		t.Errorf("edited log: %+v", v.problems)
+	}
+	// With chaining on, records without a number are not skipped
+	v = verify(append([]string{`{"event":"decision","request_id":"old"}`}, lines...))
+	if len(v.problems) != 1 || v.problems[0].msg != "record without a number" {
+		t.Errorf("unnumbered prefix: %+v", v.problems)
+	}
+	// Records removed from the end only show against an anchor
+	v = verify(lines[:2])
+	if len(v.problems) > 0 {
+		t.Errorf("truncated log: %+v", v.problems)
+	}
+	if msg := v.checkAnchor(anchors[1]); msg != "records 3 to 3 are missing at the end of the log" {
+		t.Errorf("truncated log against anchor: %q", msg)
+	}
+	if msg := verify(edited).checkAnchor(auditAnchor{seq: 2, head: auditLineHash([]byte(lines[1]))}); msg != "record 2 differs from the anchor" {
+		t.Errorf("edited log against anchor: %q", msg)
+	}
+}
+
+func TestParseAuditAnchor(t *testing.T) {
+	head := strings.Repeat("ab", 32)
+	a, err := parseAuditAnchor("42:" + strings.ToUpper(head))
+	if err != nil || a.seq != 42 || a.head != head {
+		t.Errorf("parseAuditAnchor = %+v, %v", a, err)
+	}
+	for _, s := range []string{"", "42", "x:" + head, "0:" + head, "42:abcd"} {
+		if _, err := parseAuditAnchor(s); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultWebhookTimeout = 5
+
+// webhookEventCreated is posted once a request is waiting for a decision. The other
+// events are named after audit decisions, and "exit" after the exit record.
+const webhookEventCreated = "created"
+
+var webhookEvents = []string{
+	webhookEventCreated,
+	auditDecisionApproved, auditDecisionDenied, auditDecisionTimeout, auditDecisionInterrupted, auditDecisionCancelled,
+	auditDecisionAutoApproved, auditDecisionPolicyDenied, auditDecisionCached, auditDecisionPreapproved, auditDecisionBreakGlass,
+	auditEventExit,
+}
+
+// WebhookConfig posts events of each request to URL, e.g. to feed an event bus.
+type WebhookConfig struct {
+	URL string `json:"url"`
+	// Secret signs each event with HMAC-SHA256 in the X-PSD-Signature header
+	Secret string `json:"secret"`
+	// Events limits which events are posted (default: all)
+	Events         []string `json:"events"`
+	TimeoutSeconds int      `json:"timeout_seconds"`
+}
+
+// webhookEvent is the JSON body posted to webhooks. The command is redacted as it is
+// shown in Discord.
+type webhookEvent struct {
+	Event         string    `json:"event"`
+	Time          time.Time `json:"time"`
+	RequestID     string    `json:"request_id"`
+	Command       string    `json:"command"`
+	CommandSHA256 string    `json:"command_sha256"`
+	Requester     string    `json:"requester,omitempty"`
+	SudoUser      string    `json:"sudo_user,omitempty"`
+	Host          string    `json:"host"`
+	CWD           string    `json:"cwd"`
+	Policy        string    `json:"policy,omitempty"`
+	ApproverIDs   []string  `json:"approver_ids,omitempty"`
+	ExitCode      *int      `json:"exit_code,omitempty"`
+	// Link is the request message, for requests posted to Discord
+	Link string `json:"link,omitempty"`
+}
+
+func validateWebhooks(webhooks []WebhookConfig) error {
+	for i := range webhooks {
+		w := &webhooks[i]
+		u, err := url.Parse(w.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhooks[%d].url must be an http(s) URL", i)
+		}
+		for _, event := range w.Events {
+			if !slices.Contains(webhookEvents, event) {
+				return fmt.Errorf("webhooks[%d].events: unknown event %q; events are %s", i, event, strings.Join(webhookEvents, ", "))
+			}
+		}
+		if w.TimeoutSeconds <= 0 {
+			w.TimeoutSeconds = defaultWebhookTimeout
+		}
+	}
+	return nil
+}
+
+// newWebhookEvent returns the event of an audit record redacted for mirroring.
+func newWebhookEvent(rec AuditRecord, link string) webhookEvent {
+	event := rec.Event
+	if rec.Event == auditEventDecision {
+		event = rec.Decision
+	}
+	return webhookEvent{
+		Event:         event,
+		Time:          rec.Time,
+		RequestID:     rec.RequestID,
+		Command:       rec.CommandLine,
+		CommandSHA256: rec.CommandSHA256,
+		Requester:     rec.Requester,
+		SudoUser:      rec.SudoUser,
+		Host:          rec.Host,
+		CWD:           rec.CWD,
+		Policy:        rec.Policy,
+		ApproverIDs:   rec.ApproverIDs,
+		ExitCode:      rec.ExitCode,
+		Link:          link,
+	}
+}
+
+// wants reports whether the webhook is subscribed to event.
+func (w *WebhookConfig) wants(event string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, event)
+}
+
+// post sends event to the webhook. With a secret, the body is signed as
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)), with the timestamp in
+// X-PSD-Timestamp, so receivers can reject forged and replayed events.
+func (w *WebhookConfig) post(event webhookEvent, now time.Time) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-PSD-Event", event.Event)
+	if w.Secret != "" {
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		req.Header.Set("X-PSD-Timestamp", timestamp)
+		req.Header.Set("X-PSD-Signature", "sha256="+hex.EncodeToString(hmacSHA256([]byte(w.Secret), timestamp+"."+string(body))))
+	}
+	client := &http.Client{Timeout: time.Duration(w.TimeoutSeconds) * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidateWebhooks(t *testing.T) {
+	webhooks := []WebhookConfig{{URL: "https://hooks.example/psd", Events: []string{"created", "denied", "exit"}}}
+	if err := validateWebhooks(webhooks); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if webhooks[0].TimeoutSeconds != defaultWebhookTimeout {
+		t.Errorf("timeout = %d, want default", webhooks[0].TimeoutSeconds)
+	}
+	for name, w := range map[string]WebhookConfig{
+		"no url":        {},
+		"not http":      {URL: "ftp://hooks.example"},
+		"unknown event": {URL: "https://hooks.example", Events: []string{"approve"}},
+	} {
+		if err := validateWebhooks([]WebhookConfig{w}); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestWebhookEvents(t *testing.T) {
+	type delivery struct {
+		event     webhookEvent
+		signature string
+		timestamp string
+		body      []byte
+	}
+	deliveries := make(chan delivery, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var d delivery
+		if err := json.Unmarshal(body, &d.event); err != nil {
+			t.Errorf("invalid event: %v", err)
+		}
+		if r.Header.Get("X-PSD-Event") != d.event.Event {
+			t.Errorf("X-PSD-Event = %q, event = %q", r.Header.Get("X-PSD-Event"), d.event.Event)
+		}
+		d.signature, d.timestamp, d.body = r.Header.Get("X-PSD-Signature"), r.Header.Get("X-PSD-Timestamp"), body
+		deliveries <- d
+	}))
+	defer srv.Close()
+
+	webhooks := []WebhookConfig{{URL: srv.URL, Secret: "s3cret", Events: []string{"created", "approved", "exit"}}}
+	if err := validateWebhooks(webhooks); err != nil {
+		t.Fatal(err)
+	}
+	redactions, _ := compileRedactions([]string{`^mysql -p(\S+)`})
+	a := &auditor{
+		path:       filepath.Join(t.TempDir(), "audit.jsonl"),
+		base:       AuditRecord{RequestID: "abc", Command: []string{"mysql", "-pHUNTER2"}, Host: "web1"},
+		webhooks:   webhooks,
+		redactions: redactions,
+	}
+	a.created("https://discord.com/channels/1/2/3")
+	if err := a.decision(auditDecisionApproved, []string{"10"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.exit(0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"created", "approved", "exit"} {
+		select {
+		case d := <-deliveries:
+			if d.event.Event != want {
+				t.Fatalf("event = %q, want %q", d.event.Event, want)
+			}
+			if d.event.Command != "mysql -p****" || d.event.Link != "https://discord.com/channels/1/2/3" {
+				t.Errorf("%s: event = %+v", want, d.event)
+			}
+			mac := hmacSHA256([]byte("s3cret"), d.timestamp+"."+string(d.body))
+			if !hmac.Equal([]byte(d.signature), []byte("sha256="+hex.EncodeToString(mac))) {
+				t.Errorf("%s: invalid signature %q", want, d.signature)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event", want)
+		}
+	}
+
+	// Events the webhook is not subscribed to are not posted
+	if err := a.decision(auditDecisionDenied, []string{"10"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case d := <-deliveries:
+		t.Errorf("unexpected %s event", d.event.Event)
+	default:
+	}
+}